@@ -20,6 +20,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/XiaoMi/Gaea/logging"
+	"io"
 	"net"
 	"strings"
 
@@ -521,6 +522,35 @@ func (dc *DirectConnection) Execute(sql string) (*mysql.Result, error) {
 	return dc.exec(sql)
 }
 
+// ExecuteStream send ComQuery to backend mysql and read only the column information of resultset,
+// rows are read one by one from the Stream of result. No other command can be executed on the
+// connection until the Stream is read to the end or closed. If the response is not a resultset,
+// it is the same as Execute.
+func (dc *DirectConnection) ExecuteStream(sql string) (*mysql.Result, error) {
+	if err := dc.writeComQuery(sql); err != nil {
+		return nil, err
+	}
+
+	data, err := dc.readPacket()
+	if err != nil {
+		return nil, err
+	}
+	if data[0] == mysql.OKHeader {
+		return dc.handleOKPacket(data)
+	} else if data[0] == mysql.ErrHeader {
+		return nil, dc.handleErrorPacket(data)
+	} else if data[0] == mysql.LocalInFileHeader {
+		return nil, mysql.ErrMalformPacket
+	}
+
+	result, err := dc.readResultsetHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	result.Stream = &resultRowStream{dc: dc, result: result}
+	return result, nil
+}
+
 // Begin send ComQuery with 'begin' to backend mysql to start transaction
 func (dc *DirectConnection) Begin() error {
 	_, err := dc.exec("begin")
@@ -681,6 +711,20 @@ func (dc *DirectConnection) exec(query string) (*mysql.Result, error) {
 
 // read resultset from mysql
 func (dc *DirectConnection) readResultset(data []byte, binary bool) (*mysql.Result, error) {
+	result, err := dc.readResultsetHeader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := dc.readResultRows(result, binary); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// readResultsetHeader read column count and column information of resultset
+func (dc *DirectConnection) readResultsetHeader(data []byte) (*mysql.Result, error) {
 	result := &mysql.Result{
 		Status:       0,
 		InsertID:     0,
@@ -704,10 +748,6 @@ func (dc *DirectConnection) readResultset(data []byte, binary bool) (*mysql.Resu
 		return nil, err
 	}
 
-	if err := dc.readResultRows(result, binary); err != nil {
-		return nil, err
-	}
-
 	return result, nil
 }
 
@@ -795,6 +835,59 @@ func (dc *DirectConnection) readResultRows(result *mysql.Result, isBinary bool)
 	return nil
 }
 
+// resultRowStream read text protocol rows of resultset from backend connection one by one
+type resultRowStream struct {
+	dc     *DirectConnection
+	result *mysql.Result
+	done   bool
+}
+
+// Next implement mysql.RowStream
+func (s *resultRowStream) Next() (mysql.RowData, []interface{}, error) {
+	if s.done {
+		return nil, nil, io.EOF
+	}
+
+	data, err := s.dc.readPacket()
+	if err != nil {
+		s.done = true
+		return nil, nil, err
+	}
+
+	// EOF Packet
+	if s.dc.isEOFPacket(data) {
+		if s.dc.capability&mysql.ClientProtocol41 > 0 {
+			s.result.Status = binary.LittleEndian.Uint16(data[3:])
+			s.dc.status = s.result.Status
+		}
+		s.done = true
+		return nil, nil, io.EOF
+	}
+
+	if data[0] == mysql.ErrHeader {
+		s.done = true
+		return nil, nil, s.dc.handleErrorPacket(data)
+	}
+
+	values, err := mysql.RowData(data).Parse(s.result.Fields, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, values, nil
+}
+
+// Close implement mysql.RowStream, discard the rows not read so that the connection can be reused
+func (s *resultRowStream) Close() error {
+	for {
+		if _, _, err := s.Next(); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
 func (dc *DirectConnection) isEOFPacket(data []byte) bool {
 	return data[0] == mysql.EOFHeader && len(data) <= 5
 }
@@ -813,8 +906,8 @@ func (dc *DirectConnection) handleOKPacket(data []byte) (*mysql.Result, error) {
 		pos += 2
 
 		// TODO strict_mode, check warnings as error
-		// Warnings := binary.LittleEndian.Uint16(data[pos:])
-		// pos += 2
+		r.Warnings = binary.LittleEndian.Uint16(data[pos:])
+		pos += 2
 	} else if dc.capability&mysql.ClientTransactions > 0 {
 		r.Status = binary.LittleEndian.Uint16(data[pos:])
 		dc.status = r.Status
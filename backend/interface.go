@@ -14,6 +14,7 @@ type PooledConnect interface {
 	IsClosed() bool
 	UseDB(db string) error
 	Execute(sql string) (*mysql.Result, error)
+	ExecuteStream(sql string) (*mysql.Result, error)
 	SetAutoCommit(v uint8) error
 	Begin() error
 	Commit() error
@@ -68,6 +68,29 @@ func (_m *PooledConnect) Execute(sql string) (*mysql.Result, error) {
 	return r0, r1
 }
 
+// ExecuteStream provides a mock function with given fields: sql
+func (_m *PooledConnect) ExecuteStream(sql string) (*mysql.Result, error) {
+	ret := _m.Called(sql)
+
+	var r0 *mysql.Result
+	if rf, ok := ret.Get(0).(func(string) *mysql.Result); ok {
+		r0 = rf(sql)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*mysql.Result)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(sql)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // FieldList provides a mock function with given fields: table, wildcard
 func (_m *PooledConnect) FieldList(table string, wildcard string) ([]*mysql.Field, error) {
 	ret := _m.Called(table, wildcard)
@@ -68,6 +68,11 @@ func (pc *pooledConnectImpl) Execute(sql string) (*mysql.Result, error) {
 	return pc.directConnection.Execute(sql)
 }
 
+// ExecuteStream wrapper of direct connection, execute parser and read rows by stream
+func (pc *pooledConnectImpl) ExecuteStream(sql string) (*mysql.Result, error) {
+	return pc.directConnection.ExecuteStream(sql)
+}
+
 // SetAutoCommit wrapper of direct connection, set autocommit
 func (pc *pooledConnectImpl) SetAutoCommit(v uint8) error {
 	return pc.directConnection.SetAutoCommit(v)
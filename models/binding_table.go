@@ -0,0 +1,130 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// BindingTableGroup is a group of sharding tables in the same DB with the same sharding algorithm and topology,
+// such as t_order and t_order_item both sharded by order_id. The tables in the group can be joined by their
+// sharding columns, and all of them are routed to the same table index, like the binding tables of ShardingSphere.
+type BindingTableGroup struct {
+	DB     string   `json:"db"`
+	Tables []string `json:"tables"`
+}
+
+// verifyBindingTables check the tables of binding groups are sharding tables with the same sharding algorithm and topology,
+// and each table belongs to one group at most
+func (n *Namespace) verifyBindingTables() error {
+	shards := make(map[string]map[string]*Shard)
+	for _, s := range n.ShardRules {
+		if _, ok := shards[s.DB]; !ok {
+			shards[s.DB] = make(map[string]*Shard)
+		}
+		shards[s.DB][strings.ToLower(s.Table)] = s
+	}
+
+	bound := make(map[string]bool) // key: db.table
+	for _, g := range n.BindingTables {
+		if g == nil || g.DB == "" {
+			return fmt.Errorf("db of binding tables must be set")
+		}
+		if len(g.Tables) < 2 {
+			return fmt.Errorf("binding tables %v of db %s must have at least two tables", g.Tables, g.DB)
+		}
+
+		var signature string
+		for i, table := range g.Tables {
+			s, ok := shards[g.DB][strings.ToLower(table)]
+			if !ok {
+				return fmt.Errorf("binding table %s.%s is not found in shard rules", g.DB, table)
+			}
+			switch s.Type {
+			case ShardDefault, ShardGlobal, ShardLinked:
+				return fmt.Errorf("binding table %s.%s cannot be %s table", g.DB, table, s.Type)
+			}
+			key := g.DB + "." + strings.ToLower(table)
+			if bound[key] {
+				return fmt.Errorf("binding table %s duplicate", key)
+			}
+			bound[key] = true
+
+			if i == 0 {
+				signature = BindingShardSignature(s)
+			} else if BindingShardSignature(s) != signature {
+				return fmt.Errorf("binding table %s.%s has different sharding algorithm or topology with %s.%s", g.DB, table, g.DB, g.Tables[0])
+			}
+		}
+	}
+	return nil
+}
+
+// BindingShardSignature return the config of shard without the names of table and sharding columns,
+// the shards of binding tables must have the same signature
+func BindingShardSignature(s *Shard) string {
+	var keys []string
+	if s.Key != "" {
+		keys = append(keys, s.Key)
+	}
+	keys = append(keys, s.Keys...)
+	for _, strategy := range []*ShardStrategy{s.DatabaseStrategy, s.TableStrategy} {
+		if strategy != nil && strategy.Key != "" {
+			keys = append(keys, strategy.Key)
+		}
+	}
+	normalize := func(expr string) string {
+		return normalizeBindingExpression(expr, s.Table, keys)
+	}
+
+	c := *s
+	c.Table = ""
+	c.Key = ""
+	c.Keys = make([]string, len(s.Keys))
+	c.GeneColumns = make([]string, len(s.GeneColumns))
+	c.Lookups = nil
+	c.AllowShardColumnUpdate = false
+	c.AlgorithmExpression = normalize(s.AlgorithmExpression)
+	c.ActualDataNodes = normalize(s.ActualDataNodes)
+	if s.DatabaseStrategy != nil {
+		strategy := *s.DatabaseStrategy
+		strategy.Key = ""
+		strategy.AlgorithmExpression = normalize(strategy.AlgorithmExpression)
+		c.DatabaseStrategy = &strategy
+	}
+	if s.TableStrategy != nil {
+		strategy := *s.TableStrategy
+		strategy.Key = ""
+		strategy.AlgorithmExpression = normalize(strategy.AlgorithmExpression)
+		c.TableStrategy = &strategy
+	}
+	return string(JSONEncode(&c))
+}
+
+// normalizeBindingExpression replace the table name and the sharding columns in the expression with placeholders,
+// e.g. t_order_${user_id % 4} is normalized to ${table}_${${key0} % 4}
+func normalizeBindingExpression(expr string, table string, keys []string) string {
+	if expr == "" {
+		return ""
+	}
+	expr = strings.Replace(expr, table, "${table}", -1)
+	for i, key := range keys {
+		re := regexp.MustCompile(`\b` + regexp.QuoteMeta(key) + `\b`)
+		expr = re.ReplaceAllLiteralString(expr, fmt.Sprintf("${key%d}", i))
+	}
+	return expr
+}
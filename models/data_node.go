@@ -0,0 +1,172 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/XiaoMi/Gaea/util/inline"
+)
+
+// DataNode is a physical table in a slice, written as slice.table in actual_data_nodes
+type DataNode struct {
+	Slice string
+	Table string
+}
+
+// String return the data node as slice.table
+func (n DataNode) String() string {
+	return n.Slice + "." + n.Table
+}
+
+// ParseActualDataNodes expand the actual_data_nodes of a shard, such as slice-${0..3}.t_order_${0..15},
+// several expressions or explicit nodes are separated by comma. The order of nodes is kept.
+func ParseActualDataNodes(s string) ([]DataNode, error) {
+	items, err := inline.SplitAndExpand(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("actual_data_nodes %s is empty", s)
+	}
+
+	exists := make(map[DataNode]bool, len(items))
+	nodes := make([]DataNode, 0, len(items))
+	for _, item := range items {
+		i := strings.Index(item, ".")
+		if i <= 0 || i == len(item)-1 {
+			return nil, fmt.Errorf("invalid data node %s, must be slice.table", item)
+		}
+		node := DataNode{Slice: item[:i], Table: item[i+1:]}
+		if strings.Contains(node.Table, ".") {
+			return nil, fmt.Errorf("invalid data node %s, must be slice.table", item)
+		}
+		if exists[node] {
+			return nil, fmt.Errorf("data node %s is duplicated", item)
+		}
+		exists[node] = true
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+// GroupDataNodesBySlice return the slices in the order of first appearance, and the tables in each slice
+func GroupDataNodesBySlice(nodes []DataNode) ([]string, [][]string) {
+	var slices []string
+	var tables [][]string
+	sliceIndexes := make(map[string]int)
+	for _, node := range nodes {
+		i, ok := sliceIndexes[node.Slice]
+		if !ok {
+			i = len(slices)
+			sliceIndexes[node.Slice] = i
+			slices = append(slices, node.Slice)
+			tables = append(tables, nil)
+		}
+		tables[i] = append(tables[i], node.Table)
+	}
+	return slices, tables
+}
+
+// ParseStandardDataNodes return the slices and the table names of standard shard.
+// Each slice must have the same tables, since the table strategy does not know the slice.
+func ParseStandardDataNodes(s string) ([]string, []string, error) {
+	nodes, err := ParseActualDataNodes(s)
+	if err != nil {
+		return nil, nil, err
+	}
+	slices, tables := GroupDataNodesBySlice(nodes)
+	for i := 1; i < len(slices); i++ {
+		if missing, ok := findMissingTable(tables[0], tables[i]); ok {
+			return nil, nil, fmt.Errorf("data node %s.%s is missing", slices[i], missing)
+		}
+		if missing, ok := findMissingTable(tables[i], tables[0]); ok {
+			return nil, nil, fmt.Errorf("data node %s.%s is missing", slices[0], missing)
+		}
+	}
+	return slices, tables[0], nil
+}
+
+// findMissingTable return the first table in expected but not in actual
+func findMissingTable(expected, actual []string) (string, bool) {
+	exists := make(map[string]bool, len(actual))
+	for _, table := range actual {
+		exists[table] = true
+	}
+	for _, table := range expected {
+		if !exists[table] {
+			return table, true
+		}
+	}
+	return "", false
+}
+
+// ParseUniqueTableDataNodes return the data nodes whose table names are unique,
+// used by the shard whose algorithm expression is evaluated to table name.
+func ParseUniqueTableDataNodes(s string) ([]DataNode, error) {
+	nodes, err := ParseActualDataNodes(s)
+	if err != nil {
+		return nil, err
+	}
+	tables := make(map[string]DataNode, len(nodes))
+	for _, node := range nodes {
+		if n, ok := tables[node.Table]; ok {
+			return nil, fmt.Errorf("data node %s overlaps with %s, the table name must be unique", node, n)
+		}
+		tables[node.Table] = node
+	}
+	return nodes, nil
+}
+
+// dataNodesToLocations return the slices and the count of tables in each slice
+func dataNodesToLocations(nodes []DataNode) ([]string, []int) {
+	slices, tables := GroupDataNodesBySlice(nodes)
+	locations := make([]int, 0, len(tables))
+	for _, t := range tables {
+		locations = append(locations, len(t))
+	}
+	return slices, locations
+}
+
+// verifyActualDataNodes verify the actual_data_nodes of shard and return the data nodes
+func verifyActualDataNodes(s *Shard) ([]DataNode, error) {
+	if len(s.Locations) != 0 || len(s.Slices) != 0 {
+		return nil, fmt.Errorf("shard table %s cannot have both actual_data_nodes and locations/slices", s.Table)
+	}
+	if _, ok := GetCustomShardName(s.Type); ok {
+		return ParseActualDataNodes(s.ActualDataNodes)
+	}
+	switch s.Type {
+	case ShardHash, ShardMod, ShardHashMod, ShardGene, ShardVolumeRange, ShardBoundaryRange:
+		return ParseActualDataNodes(s.ActualDataNodes)
+	case ShardInline, ShardComplex:
+		return ParseUniqueTableDataNodes(s.ActualDataNodes)
+	case ShardStandard:
+		slices, tables, err := ParseStandardDataNodes(s.ActualDataNodes)
+		if err != nil {
+			return nil, err
+		}
+		nodes := make([]DataNode, 0, len(slices)*len(tables))
+		for _, slice := range slices {
+			for _, table := range tables {
+				nodes = append(nodes, DataNode{Slice: slice, Table: table})
+			}
+		}
+		return nodes, nil
+	default:
+		return nil, fmt.Errorf("actual_data_nodes is not supported by %s shard", s.Type)
+	}
+}
@@ -26,6 +26,16 @@ import (
 	"github.com/XiaoMi/Gaea/util/crypto"
 )
 
+// 跨分片分页查询的offset超过max_scatter_offset时的处理方式
+const (
+	// ScatterOffsetPolicyReject 返回错误
+	ScatterOffsetPolicyReject = "reject"
+	// ScatterOffsetPolicyCap 把offset减小到上限
+	ScatterOffsetPolicyCap = "cap"
+	// ScatterOffsetPolicyTwoPhase 先查询各分片的排序列找到分页的起始行, 再从起始行开始查询完整的行
+	ScatterOffsetPolicyTwoPhase = "two_phase"
+)
+
 // Namespace means namespace model stored in etcd
 type Namespace struct {
 	OpenGeneralLog   bool              `json:"open_general_log"`
@@ -45,6 +55,28 @@ type Namespace struct {
 	GlobalSequences  []*GlobalSequence `json:"global_sequences"`
 	DefaultCharset   string            `json:"default_charset"`
 	DefaultCollation string            `json:"default_collation"`
+
+	// 绑定表组, 同组的分片表按分片列JOIN时路由到相同的子表下标
+	BindingTables []*BindingTableGroup `json:"binding_tables"`
+
+	// 跨分片JOIN时每个表读取的行数以及JOIN结果的行数上限, 0表示使用默认值
+	MaxJoinRows int `json:"max_join_rows"`
+
+	// 跨分片GROUP BY和ORDER BY合并结果时每个查询使用的内存上限, 单位字节, 超过后写入临时文件, 0表示不限制
+	MaxMergeMemory int64 `json:"max_merge_memory"`
+	// 合并结果时临时文件的目录, 为空时使用系统的临时目录
+	MergeSpillDir string `json:"merge_spill_dir"`
+
+	// 跨分片分页查询的offset上限, 0表示不限制
+	MaxScatterOffset int64 `json:"max_scatter_offset"`
+	// offset超过上限时的处理方式: reject (默认), cap, two_phase
+	ScatterOffsetPolicy string `json:"scatter_offset_policy"`
+
+	// INSERT ... SELECT查询结果的行数上限, 0表示使用默认值
+	MaxInsertSelectRows int `json:"max_insert_select_rows"`
+
+	// 子查询结果的行数上限, 0表示使用默认值
+	MaxSubqueryRows int `json:"max_subquery_rows"`
 }
 
 // Encode encode json
@@ -94,6 +126,30 @@ func (n *Namespace) Verify() error {
 		return err
 	}
 
+	if err := n.verifyBindingTables(); err != nil {
+		return err
+	}
+
+	if err := n.verifyMaxJoinRows(); err != nil {
+		return err
+	}
+
+	if err := n.verifyMaxMergeMemory(); err != nil {
+		return err
+	}
+
+	if err := n.verifyScatterOffset(); err != nil {
+		return err
+	}
+
+	if err := n.verifyMaxInsertSelectRows(); err != nil {
+		return err
+	}
+
+	if err := n.verifyMaxSubqueryRows(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -150,6 +206,46 @@ func (n *Namespace) isUsersEmpty() bool {
 	return len(n.Users) == 0
 }
 
+func (n *Namespace) verifyMaxJoinRows() error {
+	if n.MaxJoinRows < 0 {
+		return fmt.Errorf("invalid max_join_rows: %d", n.MaxJoinRows)
+	}
+	return nil
+}
+
+func (n *Namespace) verifyMaxMergeMemory() error {
+	if n.MaxMergeMemory < 0 {
+		return fmt.Errorf("invalid max_merge_memory: %d", n.MaxMergeMemory)
+	}
+	return nil
+}
+
+func (n *Namespace) verifyScatterOffset() error {
+	if n.MaxScatterOffset < 0 {
+		return fmt.Errorf("invalid max_scatter_offset: %d", n.MaxScatterOffset)
+	}
+	switch n.ScatterOffsetPolicy {
+	case "", ScatterOffsetPolicyReject, ScatterOffsetPolicyCap, ScatterOffsetPolicyTwoPhase:
+		return nil
+	default:
+		return fmt.Errorf("invalid scatter_offset_policy: %s", n.ScatterOffsetPolicy)
+	}
+}
+
+func (n *Namespace) verifyMaxInsertSelectRows() error {
+	if n.MaxInsertSelectRows < 0 {
+		return fmt.Errorf("invalid max_insert_select_rows: %d", n.MaxInsertSelectRows)
+	}
+	return nil
+}
+
+func (n *Namespace) verifyMaxSubqueryRows() error {
+	if n.MaxSubqueryRows < 0 {
+		return fmt.Errorf("invalid max_subquery_rows: %d", n.MaxSubqueryRows)
+	}
+	return nil
+}
+
 func (n *Namespace) verifySlowSQLTime() error {
 	if !n.isSlowSQLTimeExists() {
 		return nil
@@ -269,6 +365,7 @@ func (n *Namespace) verifyShardRules() error {
 	var sliceNames []string
 	var linkedRuleShards []*Shard
 	var rules = make(map[string]map[string]string)
+	var dataNodes = make(map[string]map[DataNode]string) // key: db, value: data node to table
 
 	for _, slice := range n.Slices {
 		sliceNames = append(sliceNames, slice.Name)
@@ -282,6 +379,13 @@ func (n *Namespace) verifyShardRules() error {
 			}
 		}
 
+		if err := verifyShardLookups(s, sliceNames); err != nil {
+			return err
+		}
+		if err := s.verifyShardColumnUpdate(); err != nil {
+			return err
+		}
+
 		switch s.Type {
 		case ShardDefault:
 			return errors.New("[default-rule] duplicate, must only one")
@@ -292,6 +396,9 @@ func (n *Namespace) verifyShardRules() error {
 			if err := s.verify(); err != nil {
 				return err
 			}
+			if err := verifyShardDataNodes(s, sliceNames, dataNodes); err != nil {
+				return err
+			}
 		}
 
 		//if the database exist in rules
@@ -320,6 +427,34 @@ func (n *Namespace) verifyShardRules() error {
 		if dbRuleType == ShardLinked {
 			return fmt.Errorf("LinkedRule cannot link to another LinkedRule")
 		}
+		if dbRuleType == ShardStandard || dbRuleType == ShardComplex {
+			return fmt.Errorf("LinkedRule cannot link to a %s rule", dbRuleType)
+		}
+	}
+	return nil
+}
+
+// verifyShardDataNodes check the slices of data nodes exist, and the data nodes do not overlap with other tables
+func verifyShardDataNodes(s *Shard, sliceNames []string, dataNodes map[string]map[DataNode]string) error {
+	if s.ActualDataNodes == "" {
+		return nil
+	}
+	nodes, err := ParseActualDataNodes(s.ActualDataNodes)
+	if err != nil {
+		return err
+	}
+	if _, ok := dataNodes[s.DB]; !ok {
+		dataNodes[s.DB] = make(map[DataNode]string)
+	}
+	for _, node := range nodes {
+		if !includeSlice(sliceNames, node.Slice) {
+			return fmt.Errorf("shard table[%s] data node[%s] not in the namespace.slices list:[%s]",
+				s.Table, node, strings.Join(sliceNames, ","))
+		}
+		if table, ok := dataNodes[s.DB][node]; ok {
+			return fmt.Errorf("shard table[%s] data node[%s] overlaps with table[%s]", s.Table, node, table)
+		}
+		dataNodes[s.DB][node] = s.Table
 	}
 	return nil
 }
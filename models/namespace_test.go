@@ -16,7 +16,10 @@ package models
 import (
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"testing"
+
+	"github.com/XiaoMi/Gaea/provider"
 )
 
 func defaultNamespace() *Namespace {
@@ -678,6 +681,401 @@ func TestVerifyShardRules_Error_ShardGlobal(t *testing.T) {
 	}
 }
 
+func TestVerifyShardRules_ActualDataNodes(t *testing.T) {
+	nf := defaultNamespace()
+	nf.Slices = []*Slice{&Slice{Name: "slice-0"}, &Slice{Name: "slice-1"}}
+	ok := []*Shard{
+		&Shard{DB: "db", Table: "t_order", Type: ShardMod, Key: "id", ActualDataNodes: "slice-${0..1}.t_order_${0..3}"},
+		&Shard{DB: "db", Table: "t_user", Type: ShardInline, Key: "id", AlgorithmExpression: "t_user_${id.substring(0, 4)}",
+			ActualDataNodes: "slice-0.t_user_2019, slice-1.t_user_${2020..2021}"},
+		&Shard{DB: "db", Table: "t_item", Type: ShardStandard, ActualDataNodes: "slice-${0..1}.t_item_${[0, 1]}",
+			DatabaseStrategy: &ShardStrategy{Type: ShardMod, Key: "id"}, TableStrategy: &ShardStrategy{Type: ShardInline, Key: "id", AlgorithmExpression: "t_item_${id % 2}"}},
+	}
+	nf.ShardRules = ok
+	if err := nf.verifyShardRules(); err != nil {
+		t.Errorf("test verifyShardRules failed, shardRule: %s, err: %v", JSONEncode(nf.ShardRules), err)
+	}
+
+	errorRules := [][]*Shard{
+		// slice not exists
+		{&Shard{DB: "db", Table: "t_order", Type: ShardMod, Key: "id", ActualDataNodes: "slice-${0..2}.t_order_${0..3}"}},
+		// duplicate data nodes
+		{&Shard{DB: "db", Table: "t_order", Type: ShardMod, Key: "id", ActualDataNodes: "slice-0.t_order_${0..3}, slice-0.t_order_3"}},
+		// overlap with other table
+		{ok[0], &Shard{DB: "db", Table: "t_order2", Type: ShardMod, Key: "id", ActualDataNodes: "slice-1.t_order_${3..4}"}},
+		// invalid data node
+		{&Shard{DB: "db", Table: "t_order", Type: ShardMod, Key: "id", ActualDataNodes: "t_order_${0..3}"}},
+		// both data nodes and locations
+		{&Shard{DB: "db", Table: "t_order", Type: ShardMod, Key: "id", ActualDataNodes: "slice-0.t_order_0", Locations: []int{1}, Slices: []string{"slice-0"}}},
+		// table name is not unique for inline expression
+		{&Shard{DB: "db", Table: "t_user", Type: ShardInline, Key: "id", AlgorithmExpression: "t_user_${id % 2}", ActualDataNodes: "slice-${0..1}.t_user_${0..1}"}},
+		// missing table in slice of standard shard
+		{&Shard{DB: "db", Table: "t_item", Type: ShardStandard, ActualDataNodes: "slice-0.t_item_${0..1}, slice-1.t_item_0",
+			DatabaseStrategy: &ShardStrategy{Type: ShardMod, Key: "id"}, TableStrategy: &ShardStrategy{Type: ShardMod, Key: "id"}}},
+		// not supported
+		{&Shard{DB: "db", Table: "t_order", Type: ShardRange, Key: "id", ActualDataNodes: "slice-0.t_order_0"}},
+	}
+	for _, rules := range errorRules {
+		nf.ShardRules = rules
+		if err := nf.verifyShardRules(); err == nil {
+			t.Errorf("test verifyShardRules should fail but pass, shardRule: %s", JSONEncode(nf.ShardRules))
+		}
+	}
+}
+
+func TestVerifyShardRules_Interval(t *testing.T) {
+	nf := defaultNamespace()
+	nf.Slices = []*Slice{&Slice{Name: "slice-0"}, &Slice{Name: "slice-1"}}
+	ok := []*Shard{
+		&Shard{DB: "db", Table: "t_order", Type: ShardInterval, Key: "create_time", Slices: []string{"slice-0", "slice-1"},
+			DatetimeLower: "2019-01-01 00:00:00", DatetimeUpper: "2020-12-31 23:59:59", DatetimeInterval: "month", SuffixPattern: "yyyyMM"},
+		&Shard{DB: "db", Table: "t_log", Type: ShardInterval, Key: "create_time", Slices: []string{"slice-0"},
+			DatetimeLower: "2019-01-01", DatetimeInterval: "quarter", SuffixPattern: "yyyy_Q"},
+		&Shard{DB: "db", Table: "t_event", Type: ShardAutoInterval, Key: "create_time", Slices: []string{"slice-0", "slice-1"},
+			DatetimeLower: "2019-01-07", DatetimeInterval: "week", DatetimeIntervalAmount: 2},
+	}
+	nf.ShardRules = ok
+	if err := nf.verifyShardRules(); err != nil {
+		t.Errorf("test verifyShardRules failed, shardRule: %s, err: %v", JSONEncode(nf.ShardRules), err)
+	}
+
+	errorRules := []*Shard{
+		// lower bound is not the start of a month
+		&Shard{DB: "db", Table: "t_order", Type: ShardInterval, Key: "create_time", Slices: []string{"slice-0"},
+			DatetimeLower: "2019-01-02", DatetimeInterval: "month", SuffixPattern: "yyyyMM"},
+		// upper bound before lower bound
+		&Shard{DB: "db", Table: "t_order", Type: ShardInterval, Key: "create_time", Slices: []string{"slice-0"},
+			DatetimeLower: "2019-01-01", DatetimeUpper: "2018-01-01", DatetimeInterval: "month", SuffixPattern: "yyyyMM"},
+		// invalid interval unit
+		&Shard{DB: "db", Table: "t_order", Type: ShardInterval, Key: "create_time", Slices: []string{"slice-0"},
+			DatetimeLower: "2019-01-01", DatetimeInterval: "minute", SuffixPattern: "yyyyMM"},
+		// missing suffix pattern
+		&Shard{DB: "db", Table: "t_order", Type: ShardInterval, Key: "create_time", Slices: []string{"slice-0"},
+			DatetimeLower: "2019-01-01", DatetimeInterval: "month"},
+		// suffix pattern cannot distinguish the months
+		&Shard{DB: "db", Table: "t_order", Type: ShardInterval, Key: "create_time", Slices: []string{"slice-0"},
+			DatetimeLower: "2019-01-01", DatetimeInterval: "month", SuffixPattern: "yyyy"},
+		// unknown pattern letters
+		&Shard{DB: "db", Table: "t_order", Type: ShardInterval, Key: "create_time", Slices: []string{"slice-0"},
+			DatetimeLower: "2019-01-01", DatetimeInterval: "day", SuffixPattern: "yyyyMMdd_mm"},
+		// suffix pattern of auto_interval
+		&Shard{DB: "db", Table: "t_order", Type: ShardAutoInterval, Key: "create_time", Slices: []string{"slice-0"},
+			DatetimeLower: "2019-01-01", DatetimeInterval: "month", SuffixPattern: "yyyyMM"},
+		// locations are computed by interval
+		&Shard{DB: "db", Table: "t_order", Type: ShardAutoInterval, Key: "create_time", Slices: []string{"slice-0"}, Locations: []int{4},
+			DatetimeLower: "2019-01-01", DatetimeInterval: "month"},
+		// no slices
+		&Shard{DB: "db", Table: "t_order", Type: ShardAutoInterval, Key: "create_time",
+			DatetimeLower: "2019-01-01", DatetimeInterval: "month"},
+	}
+	for _, rule := range errorRules {
+		nf.ShardRules = []*Shard{rule}
+		if err := nf.verifyShardRules(); err == nil {
+			t.Errorf("test verifyShardRules should fail but pass, shardRule: %s", JSONEncode(nf.ShardRules))
+		}
+	}
+}
+
+func TestVerifyShardRules_BoundaryRange(t *testing.T) {
+	nf := defaultNamespace()
+	nf.Slices = []*Slice{&Slice{Name: "slice-0"}, &Slice{Name: "slice-1"}}
+	ok := []*Shard{
+		&Shard{DB: "db", Table: "t_order", Type: ShardVolumeRange, Key: "id", Locations: []int{2, 2}, Slices: []string{"slice-0", "slice-1"},
+			RangeLower: "0", RangeUpper: "1000", ShardingVolume: "500"},
+		&Shard{DB: "db", Table: "t_user", Type: ShardBoundaryRange, Key: "name", Locations: []int{2, 1}, Slices: []string{"slice-0", "slice-1"},
+			RangeType: RangeTypeString, ShardingRanges: []string{"h", "p"}},
+		&Shard{DB: "db", Table: "t_log", Type: ShardVolumeRange, Key: "create_time", ActualDataNodes: "slice-${0..1}.t_log_${0..1}",
+			RangeType: RangeTypeDatetime, RangeLower: "2019-01-01", RangeUpper: "2019-01-03", ShardingVolume: "24h"},
+	}
+	nf.ShardRules = ok
+	if err := nf.verifyShardRules(); err != nil {
+		t.Errorf("test verifyShardRules failed, shardRule: %s, err: %v", JSONEncode(nf.ShardRules), err)
+	}
+
+	errorRules := []*Shard{
+		// count of ranges not equal to tables
+		&Shard{DB: "db", Table: "t_order", Type: ShardVolumeRange, Key: "id", Locations: []int{2, 1}, Slices: []string{"slice-0", "slice-1"},
+			RangeLower: "0", RangeUpper: "1000", ShardingVolume: "500"},
+		// upper not greater than lower
+		&Shard{DB: "db", Table: "t_order", Type: ShardVolumeRange, Key: "id", Locations: []int{2}, Slices: []string{"slice-0"},
+			RangeLower: "10", RangeUpper: "10", ShardingVolume: "5"},
+		// invalid volume
+		&Shard{DB: "db", Table: "t_order", Type: ShardVolumeRange, Key: "id", Locations: []int{3}, Slices: []string{"slice-0"},
+			RangeLower: "0", RangeUpper: "10", ShardingVolume: "-10"},
+		// too many tables
+		&Shard{DB: "db", Table: "t_order", Type: ShardVolumeRange, Key: "id", Locations: []int{3}, Slices: []string{"slice-0"},
+			RangeLower: "-9223372036854775808", RangeUpper: "9223372036854775807", ShardingVolume: "1"},
+		// string volume range
+		&Shard{DB: "db", Table: "t_order", Type: ShardVolumeRange, Key: "id", Locations: []int{3}, Slices: []string{"slice-0"},
+			RangeType: RangeTypeString, RangeLower: "a", RangeUpper: "b", ShardingVolume: "1"},
+		// boundaries not in ascending order
+		&Shard{DB: "db", Table: "t_order", Type: ShardBoundaryRange, Key: "id", Locations: []int{3}, Slices: []string{"slice-0"},
+			ShardingRanges: []string{"100", "100"}},
+		// invalid datetime boundary
+		&Shard{DB: "db", Table: "t_order", Type: ShardBoundaryRange, Key: "id", Locations: []int{2}, Slices: []string{"slice-0"},
+			RangeType: RangeTypeDatetime, ShardingRanges: []string{"2019-13-01"}},
+		// invalid range type
+		&Shard{DB: "db", Table: "t_order", Type: ShardBoundaryRange, Key: "id", Locations: []int{2}, Slices: []string{"slice-0"},
+			RangeType: "float", ShardingRanges: []string{"1.5"}},
+	}
+	for _, rule := range errorRules {
+		nf.ShardRules = []*Shard{rule}
+		if err := nf.verifyShardRules(); err == nil {
+			t.Errorf("test verifyShardRules should fail but pass, shardRule: %s", JSONEncode(nf.ShardRules))
+		}
+	}
+}
+
+func TestVerifyShardRules_HashMod(t *testing.T) {
+	nf := defaultNamespace()
+	nf.Slices = []*Slice{&Slice{Name: "slice-0"}, &Slice{Name: "slice-1"}}
+	ok := []*Shard{
+		&Shard{DB: "db", Table: "t_order", Type: ShardHashMod, Key: "order_no", Locations: []int{2, 2}, Slices: []string{"slice-0", "slice-1"},
+			HashFunction: HashFunctionMurmur3},
+		&Shard{DB: "db", Table: "t_user", Type: ShardHashMod, Key: "name", ActualDataNodes: "slice-${0..1}.t_user_${0..1}",
+			HashFunction: HashFunctionJavaHashCode},
+		&Shard{DB: "db", Table: "t_item", Type: ShardStandard, Locations: []int{2, 2}, Slices: []string{"slice-0", "slice-1"},
+			DatabaseStrategy: &ShardStrategy{Type: ShardHashMod, Key: "shop_id", HashFunction: HashFunctionCRC32},
+			TableStrategy:    &ShardStrategy{Type: ShardHashMod, Key: "item_no", HashFunction: HashFunctionXXHash64}},
+	}
+	nf.ShardRules = ok
+	if err := nf.verifyShardRules(); err != nil {
+		t.Errorf("test verifyShardRules failed, shardRule: %s, err: %v", JSONEncode(nf.ShardRules), err)
+	}
+
+	errorRules := []*Shard{
+		// empty hash function
+		&Shard{DB: "db", Table: "t_order", Type: ShardHashMod, Key: "order_no", Locations: []int{2, 2}, Slices: []string{"slice-0", "slice-1"}},
+		// unknown hash function
+		&Shard{DB: "db", Table: "t_order", Type: ShardHashMod, Key: "order_no", Locations: []int{2, 2}, Slices: []string{"slice-0", "slice-1"},
+			HashFunction: "md5"},
+		// unknown hash function of strategy
+		&Shard{DB: "db", Table: "t_item", Type: ShardStandard, Locations: []int{2, 2}, Slices: []string{"slice-0", "slice-1"},
+			DatabaseStrategy: &ShardStrategy{Type: ShardHashMod, Key: "shop_id"}},
+	}
+	for _, rule := range errorRules {
+		nf.ShardRules = []*Shard{rule}
+		if err := nf.verifyShardRules(); err == nil {
+			t.Errorf("test verifyShardRules should fail but pass, shardRule: %s", JSONEncode(nf.ShardRules))
+		}
+	}
+}
+
+// testTableCountAlgorithm requires the property table_count equal to the count of tables
+type testTableCountAlgorithm struct{}
+
+func (a *testTableCountAlgorithm) GetName() string {
+	return "test_table_count"
+}
+
+func (a *testTableCountAlgorithm) Verify(props map[string]string, tableCount int) error {
+	if props["table_count"] != strconv.Itoa(tableCount) {
+		return fmt.Errorf("table_count %s not equal to %d", props["table_count"], tableCount)
+	}
+	return nil
+}
+
+func (a *testTableCountAlgorithm) Create(props map[string]string, tableCount int) (provider.Sharding, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func TestVerifyShardRules_Custom(t *testing.T) {
+	if err := provider.RegisterShardingAlgorithm(&testTableCountAlgorithm{}); err != nil {
+		t.Fatal(err)
+	}
+	nf := defaultNamespace()
+	nf.Slices = []*Slice{&Slice{Name: "slice-0"}, &Slice{Name: "slice-1"}}
+	ok := []*Shard{
+		&Shard{DB: "db", Table: "t_order", Type: "custom:test_table_count", Key: "id", Locations: []int{2, 2}, Slices: []string{"slice-0", "slice-1"},
+			Properties: map[string]string{"table_count": "4"}},
+		&Shard{DB: "db", Table: "t_user", Type: "custom:test_table_count", Key: "id", ActualDataNodes: "slice-0.t_user_${0..2}",
+			Properties: map[string]string{"table_count": "3"}},
+	}
+	nf.ShardRules = ok
+	if err := nf.verifyShardRules(); err != nil {
+		t.Errorf("test verifyShardRules failed, shardRule: %s, err: %v", JSONEncode(nf.ShardRules), err)
+	}
+
+	errorRules := []*Shard{
+		// verify failed
+		&Shard{DB: "db", Table: "t_order", Type: "custom:test_table_count", Key: "id", Locations: []int{2, 2}, Slices: []string{"slice-0", "slice-1"},
+			Properties: map[string]string{"table_count": "2"}},
+		// not registered
+		&Shard{DB: "db", Table: "t_order", Type: "custom:not_exists", Key: "id", Locations: []int{2, 2}, Slices: []string{"slice-0", "slice-1"}},
+		// unknown type without custom prefix
+		&Shard{DB: "db", Table: "t_order", Type: "test_table_count", Key: "id", Locations: []int{2, 2}, Slices: []string{"slice-0", "slice-1"},
+			Properties: map[string]string{"table_count": "4"}},
+	}
+	for _, rule := range errorRules {
+		nf.ShardRules = []*Shard{rule}
+		if err := nf.verifyShardRules(); err == nil {
+			t.Errorf("test verifyShardRules should fail but pass, shardRule: %s", JSONEncode(nf.ShardRules))
+		}
+	}
+}
+
+func TestVerifyShardRules_Lookup(t *testing.T) {
+	nf := defaultNamespace()
+	nf.Slices = []*Slice{&Slice{Name: "slice-0"}, &Slice{Name: "slice-1"}}
+	ok := []*Shard{
+		&Shard{DB: "db", Table: "t_order", Type: "hash", Key: "user_id", Locations: []int{2, 2}, Slices: []string{"slice-0", "slice-1"},
+			Lookups: []*ShardLookup{{Column: "order_no", Table: "t_order_lookup", Slice: "slice-0"}, {Column: "trade_no", Table: "t_order_trade", Slice: "slice-1"}}},
+	}
+	nf.ShardRules = ok
+	if err := nf.verifyShardRules(); err != nil {
+		t.Errorf("test verifyShardRules failed, shardRule: %s, err: %v", JSONEncode(nf.ShardRules), err)
+	}
+
+	errorRules := []*Shard{
+		// slice not in namespace
+		&Shard{DB: "db", Table: "t_order", Type: "hash", Key: "user_id", Locations: []int{2, 2}, Slices: []string{"slice-0", "slice-1"},
+			Lookups: []*ShardLookup{{Column: "order_no", Table: "t_order_lookup", Slice: "slice-2"}}},
+		// lookup column is the key of shard
+		&Shard{DB: "db", Table: "t_order", Type: "hash", Key: "user_id", Locations: []int{2, 2}, Slices: []string{"slice-0", "slice-1"},
+			Lookups: []*ShardLookup{{Column: "USER_ID", Table: "t_order_lookup", Slice: "slice-0"}}},
+		// duplicate lookup column
+		&Shard{DB: "db", Table: "t_order", Type: "hash", Key: "user_id", Locations: []int{2, 2}, Slices: []string{"slice-0", "slice-1"},
+			Lookups: []*ShardLookup{{Column: "order_no", Table: "t_order_lookup", Slice: "slice-0"}, {Column: "order_no", Table: "t_order_lookup2", Slice: "slice-0"}}},
+		// mapping table not set
+		&Shard{DB: "db", Table: "t_order", Type: "hash", Key: "user_id", Locations: []int{2, 2}, Slices: []string{"slice-0", "slice-1"},
+			Lookups: []*ShardLookup{{Column: "order_no", Slice: "slice-0"}}},
+		// complex shard has several keys
+		&Shard{DB: "db", Table: "t_order", Type: "complex", Keys: []string{"user_id", "order_id"}, Locations: []int{2, 2}, Slices: []string{"slice-0", "slice-1"},
+			AlgorithmExpression: "t_order_${(user_id + order_id) % 4}",
+			Lookups:             []*ShardLookup{{Column: "order_no", Table: "t_order_lookup", Slice: "slice-0"}}},
+		// standard shard is routed by the columns of database strategy and table strategy
+		&Shard{DB: "db", Table: "t_order", Type: "standard", Locations: []int{2, 2}, Slices: []string{"slice-0", "slice-1"},
+			DatabaseStrategy: &ShardStrategy{Type: "mod", Key: "user_id"}, TableStrategy: &ShardStrategy{Type: "hash", Key: "order_id"},
+			Lookups: []*ShardLookup{{Column: "order_no", Table: "t_order_lookup", Slice: "slice-0"}}},
+		// global table
+		&Shard{DB: "db", Table: "t_order", Type: "global", Locations: []int{1, 1}, Slices: []string{"slice-0", "slice-1"},
+			Lookups: []*ShardLookup{{Column: "order_no", Table: "t_order_lookup", Slice: "slice-0"}}},
+	}
+	for _, rule := range errorRules {
+		nf.ShardRules = []*Shard{rule}
+		if err := nf.verifyShardRules(); err == nil {
+			t.Errorf("test verifyShardRules should fail but pass, shardRule: %s", JSONEncode(nf.ShardRules))
+		}
+	}
+}
+
+func TestVerifyShardRules_ShardColumnUpdate(t *testing.T) {
+	nf := defaultNamespace()
+	nf.Slices = []*Slice{&Slice{Name: "slice-0"}, &Slice{Name: "slice-1"}}
+	nf.ShardRules = []*Shard{
+		&Shard{DB: "db", Table: "t_order", Type: "hash", Key: "user_id", Locations: []int{2, 2}, Slices: []string{"slice-0", "slice-1"},
+			AllowShardColumnUpdate: true},
+	}
+	if err := nf.verifyShardRules(); err != nil {
+		t.Errorf("test verifyShardRules failed, shardRule: %s, err: %v", JSONEncode(nf.ShardRules), err)
+	}
+
+	errorRules := []*Shard{
+		// gene columns carry the gene of key
+		&Shard{DB: "db", Table: "t_order", Type: "gene", Key: "user_id", GeneColumns: []string{"order_id"}, GeneBits: 4, Locations: []int{2, 2}, Slices: []string{"slice-0", "slice-1"},
+			AllowShardColumnUpdate: true},
+		// complex shard has several keys
+		&Shard{DB: "db", Table: "t_order", Type: "complex", Keys: []string{"user_id", "order_id"}, Locations: []int{2, 2}, Slices: []string{"slice-0", "slice-1"},
+			AlgorithmExpression: "t_order_${(user_id + order_id) % 4}", AllowShardColumnUpdate: true},
+		// standard shard is routed by the columns of database strategy and table strategy
+		&Shard{DB: "db", Table: "t_order", Type: "standard", Locations: []int{2, 2}, Slices: []string{"slice-0", "slice-1"},
+			DatabaseStrategy: &ShardStrategy{Type: "mod", Key: "user_id"}, TableStrategy: &ShardStrategy{Type: "hash", Key: "order_id"},
+			AllowShardColumnUpdate: true},
+		// global table
+		&Shard{DB: "db", Table: "t_order", Type: "global", Locations: []int{1, 1}, Slices: []string{"slice-0", "slice-1"},
+			AllowShardColumnUpdate: true},
+	}
+	for _, rule := range errorRules {
+		nf.ShardRules = []*Shard{rule}
+		if err := nf.verifyShardRules(); err == nil {
+			t.Errorf("test verifyShardRules should fail but pass, shardRule: %s", JSONEncode(nf.ShardRules))
+		}
+	}
+}
+
+func TestVerifyShardRules_Gene(t *testing.T) {
+	nf := defaultNamespace()
+	nf.Slices = []*Slice{&Slice{Name: "slice-0"}, &Slice{Name: "slice-1"}}
+	ok := []*Shard{
+		&Shard{DB: "db", Table: "t_order", Type: "gene", Key: "user_id", GeneColumns: []string{"order_id"}, GeneBits: 4, Locations: []int{2, 2}, Slices: []string{"slice-0", "slice-1"}},
+		&Shard{DB: "db", Table: "t_item", Type: "linked", Key: "order_id", ParentTable: "t_order"},
+	}
+	nf.ShardRules = ok
+	if err := nf.verifyShardRules(); err != nil {
+		t.Errorf("test verifyShardRules failed, shardRule: %s, err: %v", JSONEncode(nf.ShardRules), err)
+	}
+
+	errorRules := []*Shard{
+		// gene bits not set
+		&Shard{DB: "db", Table: "t_order", Type: "gene", Key: "user_id", GeneColumns: []string{"order_id"}, Locations: []int{2, 2}, Slices: []string{"slice-0", "slice-1"}},
+		// too many tables for gene bits
+		&Shard{DB: "db", Table: "t_order", Type: "gene", Key: "user_id", GeneColumns: []string{"order_id"}, GeneBits: 2, Locations: []int{4, 4}, Slices: []string{"slice-0", "slice-1"}},
+		// gene bits out of range
+		&Shard{DB: "db", Table: "t_order", Type: "gene", Key: "user_id", GeneColumns: []string{"order_id"}, GeneBits: 32, Locations: []int{2, 2}, Slices: []string{"slice-0", "slice-1"}},
+		// gene columns not set
+		&Shard{DB: "db", Table: "t_order", Type: "gene", Key: "user_id", GeneBits: 4, Locations: []int{2, 2}, Slices: []string{"slice-0", "slice-1"}},
+		// gene column is the key of shard
+		&Shard{DB: "db", Table: "t_order", Type: "gene", Key: "user_id", GeneColumns: []string{"USER_ID"}, GeneBits: 4, Locations: []int{2, 2}, Slices: []string{"slice-0", "slice-1"}},
+		// lookup column is a gene column
+		&Shard{DB: "db", Table: "t_order", Type: "gene", Key: "user_id", GeneColumns: []string{"order_id"}, GeneBits: 4, Locations: []int{2, 2}, Slices: []string{"slice-0", "slice-1"},
+			Lookups: []*ShardLookup{{Column: "order_id", Table: "t_order_lookup", Slice: "slice-0"}}},
+	}
+	for _, rule := range errorRules {
+		nf.ShardRules = []*Shard{rule}
+		if err := nf.verifyShardRules(); err == nil {
+			t.Errorf("test verifyShardRules should fail but pass, shardRule: %s", JSONEncode(nf.ShardRules))
+		}
+	}
+}
+
+func TestVerifyBindingTables(t *testing.T) {
+	nf := defaultNamespace()
+	nf.Slices = []*Slice{&Slice{Name: "slice-0"}, &Slice{Name: "slice-1"}}
+	nf.ShardRules = []*Shard{
+		&Shard{DB: "db", Table: "t_order", Type: "inline", Key: "order_id", AlgorithmExpression: "t_order_${order_id % 4}",
+			ActualDataNodes: "slice-0.t_order_${0..1}, slice-1.t_order_${2..3}"},
+		&Shard{DB: "db", Table: "t_order_item", Type: "inline", Key: "oid", AlgorithmExpression: "t_order_item_${oid % 4}",
+			ActualDataNodes: "slice-0.t_order_item_${0..1}, slice-1.t_order_item_${2..3}"},
+		&Shard{DB: "db", Table: "t_order_payment", Type: "inline", Key: "order_id", AlgorithmExpression: "t_order_payment_${order_id % 8}",
+			ActualDataNodes: "slice-0.t_order_payment_${0..1}, slice-1.t_order_payment_${2..3}"},
+		&Shard{DB: "db", Table: "t_user", Type: "hash", Key: "user_id", Locations: []int{2, 2}, Slices: []string{"slice-0", "slice-1"}},
+		&Shard{DB: "db", Table: "t_user_ext", Type: "hash", Key: "user_id", Locations: []int{2, 2}, Slices: []string{"slice-0", "slice-1"}},
+		&Shard{DB: "db", Table: "t_user_log", Type: "hash", Key: "user_id", Locations: []int{1, 3}, Slices: []string{"slice-0", "slice-1"}},
+		&Shard{DB: "db", Table: "t_user_child", Type: "linked", Key: "user_id", ParentTable: "t_user"},
+	}
+
+	nf.BindingTables = []*BindingTableGroup{
+		{DB: "db", Tables: []string{"t_order", "T_ORDER_ITEM"}},
+		{DB: "db", Tables: []string{"t_user", "t_user_ext"}},
+	}
+	if err := nf.verifyBindingTables(); err != nil {
+		t.Errorf("test verifyBindingTables failed, bindingTables: %s, err: %v", JSONEncode(nf.BindingTables), err)
+	}
+
+	errorGroups := [][]*BindingTableGroup{
+		// different algorithm expression
+		{{DB: "db", Tables: []string{"t_order", "t_order_payment"}}},
+		// different locations
+		{{DB: "db", Tables: []string{"t_user", "t_user_log"}}},
+		// different type
+		{{DB: "db", Tables: []string{"t_user", "t_order"}}},
+		// linked table
+		{{DB: "db", Tables: []string{"t_user", "t_user_child"}}},
+		// table not found
+		{{DB: "db", Tables: []string{"t_user", "t_user_not_found"}}},
+		// only one table
+		{{DB: "db", Tables: []string{"t_user"}}},
+		// table in several groups
+		{{DB: "db", Tables: []string{"t_user", "t_user_ext"}}, {DB: "db", Tables: []string{"t_user_ext", "t_user"}}},
+	}
+	for _, groups := range errorGroups {
+		nf.BindingTables = groups
+		if err := nf.verifyBindingTables(); err == nil {
+			t.Errorf("test verifyBindingTables should fail but pass, bindingTables: %s", JSONEncode(nf.BindingTables))
+		}
+	}
+}
+
 func TestNamespace_Verify(t *testing.T) {
 	nsStr := `
 {
@@ -980,3 +1378,79 @@ func TestNamespace_Verify(t *testing.T) {
 		t.Errorf("namespace verify failed, err: %v", err)
 	}
 }
+
+func TestVerifyMaxJoinRows(t *testing.T) {
+	nf := defaultNamespace()
+	for _, rows := range []int{0, 1000} {
+		nf.MaxJoinRows = rows
+		if err := nf.verifyMaxJoinRows(); err != nil {
+			t.Errorf("test verifyMaxJoinRows failed, max_join_rows: %d, err: %v", rows, err)
+		}
+	}
+	nf.MaxJoinRows = -1
+	if err := nf.verifyMaxJoinRows(); err == nil {
+		t.Errorf("test verifyMaxJoinRows should fail, max_join_rows: %d", nf.MaxJoinRows)
+	}
+}
+
+func TestVerifyMaxMergeMemory(t *testing.T) {
+	nf := defaultNamespace()
+	for _, memory := range []int64{0, 64 << 20} {
+		nf.MaxMergeMemory = memory
+		if err := nf.verifyMaxMergeMemory(); err != nil {
+			t.Errorf("test verifyMaxMergeMemory failed, max_merge_memory: %d, err: %v", memory, err)
+		}
+	}
+	nf.MaxMergeMemory = -1
+	if err := nf.verifyMaxMergeMemory(); err == nil {
+		t.Errorf("test verifyMaxMergeMemory should fail, max_merge_memory: %d", nf.MaxMergeMemory)
+	}
+}
+
+func TestVerifyMaxInsertSelectRows(t *testing.T) {
+	nf := defaultNamespace()
+	for _, rows := range []int{0, 1000} {
+		nf.MaxInsertSelectRows = rows
+		if err := nf.verifyMaxInsertSelectRows(); err != nil {
+			t.Errorf("test verifyMaxInsertSelectRows failed, max_insert_select_rows: %d, err: %v", rows, err)
+		}
+	}
+	nf.MaxInsertSelectRows = -1
+	if err := nf.verifyMaxInsertSelectRows(); err == nil {
+		t.Errorf("test verifyMaxInsertSelectRows should fail, max_insert_select_rows: %d", nf.MaxInsertSelectRows)
+	}
+}
+
+func TestVerifyMaxSubqueryRows(t *testing.T) {
+	nf := defaultNamespace()
+	for _, rows := range []int{0, 1000} {
+		nf.MaxSubqueryRows = rows
+		if err := nf.verifyMaxSubqueryRows(); err != nil {
+			t.Errorf("test verifyMaxSubqueryRows failed, max_subquery_rows: %d, err: %v", rows, err)
+		}
+	}
+	nf.MaxSubqueryRows = -1
+	if err := nf.verifyMaxSubqueryRows(); err == nil {
+		t.Errorf("test verifyMaxSubqueryRows should fail, max_subquery_rows: %d", nf.MaxSubqueryRows)
+	}
+}
+
+func TestVerifyScatterOffset(t *testing.T) {
+	nf := defaultNamespace()
+	nf.MaxScatterOffset = 10000
+	for _, policy := range []string{"", ScatterOffsetPolicyReject, ScatterOffsetPolicyCap, ScatterOffsetPolicyTwoPhase} {
+		nf.ScatterOffsetPolicy = policy
+		if err := nf.verifyScatterOffset(); err != nil {
+			t.Errorf("test verifyScatterOffset failed, scatter_offset_policy: %s, err: %v", policy, err)
+		}
+	}
+	nf.ScatterOffsetPolicy = "skip"
+	if err := nf.verifyScatterOffset(); err == nil {
+		t.Errorf("test verifyScatterOffset should fail, scatter_offset_policy: %s", nf.ScatterOffsetPolicy)
+	}
+	nf.ScatterOffsetPolicy = ""
+	nf.MaxScatterOffset = -1
+	if err := nf.verifyScatterOffset(); err == nil {
+		t.Errorf("test verifyScatterOffset should fail, max_scatter_offset: %d", nf.MaxScatterOffset)
+	}
+}
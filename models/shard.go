@@ -19,6 +19,7 @@ import (
 	"github.com/XiaoMi/Gaea/core/errors"
 	"regexp"
 	"strconv"
+	"strings"
 )
 
 // constants of shard type
@@ -37,6 +38,19 @@ const (
 	ShardMycatString     = "mycat_string"
 	ShardMycatMURMUR     = "mycat_murmur"
 	ShardMycatPaddingMod = "mycat_padding_mod"
+	ShardInline          = "inline"
+	ShardStandard        = "standard"
+	ShardComplex         = "complex"
+	ShardInterval        = "interval"
+	ShardAutoInterval    = "auto_interval"
+	ShardVolumeRange     = "volume_range"
+	ShardBoundaryRange   = "boundary_range"
+	ShardHashMod         = "hash_mod"
+	ShardGene            = "gene"
+
+	// ShardCustomPrefix is the prefix of custom shard type, such as custom:cell_map,
+	// the sharding algorithm is registered to provider by the name after prefix.
+	ShardCustomPrefix = "custom:"
 
 	// PartitionLength length of partition
 	PartitionLength = 1024
@@ -50,6 +64,17 @@ const (
 	PaddingModDefaultModBegin  = 10
 	PaddingModDefaultModEnd    = 16
 	PaddingModDefaultMod       = 2
+
+	// MaxGeneBits is the max count of gene bits of gene shard, the ids generated by sequence are shifted by the bits
+	MaxGeneBits = 31
+)
+
+// constants of hash function of hash_mod shard, the hash is computed on the string of key
+const (
+	HashFunctionCRC32        = "crc32"          // java.util.zip.CRC32
+	HashFunctionMurmur3      = "murmur3_32"     // guava Hashing.murmur3_32() with seed 0, hashBytes of UTF-8 string
+	HashFunctionXXHash64     = "xxhash64"       // XXH64 with seed 0 of UTF-8 string
+	HashFunctionJavaHashCode = "java_hash_code" // java.lang.String.hashCode()
 )
 
 // Shard means shard model in etcd
@@ -59,11 +84,53 @@ type Shard struct {
 	ParentTable   string   `json:"parent_table"`
 	Type          string   `json:"type"` // 表类型: 包括分表如hash/range/data,关联表如: linked 全局表如: global等
 	Key           string   `json:"key"`
+	Keys          []string `json:"keys"` // used in complex shard, which is sharded by several columns
 	Locations     []int    `json:"locations"`
 	Slices        []string `json:"slices"`
 	DateRange     []string `json:"date_range"`
 	TableRowLimit int      `json:"table_row_limit"`
 
+	// used in hash_mod shard, the hash function of the string of key: crc32/murmur3_32/xxhash64/java_hash_code
+	HashFunction string `json:"hash_function"`
+
+	// used in gene shard, the gene is the low gene_bits bits of the key, and the values of gene columns carry the same gene
+	GeneColumns []string `json:"gene_columns"`
+	GeneBits    int      `json:"gene_bits"`
+
+	// used in inline and complex shard, such as t_order_${user_id % 16}
+	AlgorithmExpression string `json:"algorithm_expression"`
+
+	// physical tables of the shard such as slice-${0..3}.t_order_${0..15}, replace locations and slices if set
+	ActualDataNodes string `json:"actual_data_nodes"`
+
+	// used in standard shard, the slice and the table in slice are computed by different strategies
+	DatabaseStrategy *ShardStrategy `json:"database_strategy"`
+	TableStrategy    *ShardStrategy `json:"table_strategy"`
+
+	// used in interval and auto_interval shard, the table index is the count of intervals from datetime_lower
+	DatetimeLower          string `json:"datetime_lower"`
+	DatetimeUpper          string `json:"datetime_upper"`           // optional, the tables grow over time if empty
+	DatetimeInterval       string `json:"datetime_interval"`        // hour/day/week/month/quarter/year
+	DatetimeIntervalAmount int    `json:"datetime_interval_amount"` // count of units in each interval, default 1
+	SuffixPattern          string `json:"suffix_pattern"`           // only used in interval shard, such as yyyyMM
+
+	// used in volume_range and boundary_range shard, the first table holds the keys less than the lowest boundary,
+	// and the last table holds the keys not less than the highest boundary
+	RangeType      string   `json:"range_type"`      // int/string/datetime, default int
+	RangeLower     string   `json:"range_lower"`     // used in volume_range shard
+	RangeUpper     string   `json:"range_upper"`     // used in volume_range shard
+	ShardingVolume string   `json:"sharding_volume"` // used in volume_range shard, integer or duration such as 24h for datetime
+	ShardingRanges []string `json:"sharding_ranges"` // used in boundary_range shard, boundaries in ascending order
+
+	// used in custom shard, passed to the custom sharding algorithm
+	Properties map[string]string `json:"properties"`
+
+	// mapping tables of secondary unique columns, the statements filtered by these columns are routed by lookup
+	Lookups []*ShardLookup `json:"lookups"`
+
+	// allow UPDATE to assign the key, the rows are moved to the new shard by read-delete-insert in a transaction
+	AllowShardColumnUpdate bool `json:"allow_shard_column_update"`
+
 	// only used in mycat logic database (schema)
 	Databases []string `json:"databases"`
 
@@ -85,14 +152,49 @@ type Shard struct {
 	ModEnd    string `json:"mod_end"`
 }
 
+// ShardStrategy is the database or table strategy of standard shard, each strategy has its own column and algorithm
+type ShardStrategy struct {
+	Type                string `json:"type"` // algorithm of the strategy: hash/mod/hash_mod/inline
+	Key                 string `json:"key"`
+	AlgorithmExpression string `json:"algorithm_expression"`
+	HashFunction        string `json:"hash_function"`
+}
+
 func (s *Shard) verify() error {
-	if err := s.verifyRuleSliceInfos(); err != nil {
-		return err
+	if s.ActualDataNodes == "" {
+		return s.verifyRuleSliceInfos()
+	}
+
+	nodes, err := verifyActualDataNodes(s)
+	if err != nil {
+		return fmt.Errorf("invalid actual_data_nodes of table %s: %v", s.Table, err)
+	}
+	// verify the rule with the slices and locations expanded from data nodes
+	shard := *s
+	shard.Slices, shard.Locations = dataNodesToLocations(nodes)
+	return shard.verifyRuleSliceInfos()
+}
+
+// verifyShardColumnUpdate check allow_shard_column_update, only the shard by a single key can move rows between shards
+func (s *Shard) verifyShardColumnUpdate() error {
+	if !s.AllowShardColumnUpdate {
+		return nil
+	}
+
+	switch s.Type {
+	case ShardDefault, ShardGlobal, ShardLinked, ShardStandard, ShardComplex, ShardGene:
+		return fmt.Errorf("allow_shard_column_update is not supported in %s shard table %s", s.Type, s.Table)
+	}
+	if s.Key == "" {
+		return fmt.Errorf("allow_shard_column_update of shard table %s need the key of shard", s.Table)
 	}
 	return nil
 }
 
 func (s *Shard) verifyRuleSliceInfos() error {
+	if _, ok := GetCustomShardName(s.Type); ok {
+		return verifyCustomRule(s)
+	}
 	f, ok := ruleVerifyFuncMapping[s.Type]
 	if !ok {
 		return errors.ErrUnknownRuleType
@@ -105,6 +207,14 @@ func (s *Shard) Encode() []byte {
 	return JSONEncode(s)
 }
 
+// GetCustomShardName return the name of custom sharding algorithm if the shard type is custom:<name>
+func GetCustomShardName(shardType string) (string, bool) {
+	if !strings.HasPrefix(shardType, ShardCustomPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(shardType, ShardCustomPrefix), true
+}
+
 func IsMycatShardingRule(ruleType string) bool {
 	return ruleType == ShardMod || ruleType == ShardMycatLong || ruleType == ShardMycatMURMUR || ruleType == ShardMycatPaddingMod || ruleType == ShardMycatString
 }
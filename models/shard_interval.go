@@ -0,0 +1,230 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// constants of datetime interval unit
+const (
+	IntervalHour    = "hour"
+	IntervalDay     = "day"
+	IntervalWeek    = "week"
+	IntervalMonth   = "month"
+	IntervalQuarter = "quarter"
+	IntervalYear    = "year"
+)
+
+// datetimeLayouts are the accepted formats of datetime string, in local time zone
+var datetimeLayouts = []string{
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04:05.999999",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+	"20060102150405",
+	"20060102",
+}
+
+// DatetimeInterval is the interval config of interval and auto_interval shard.
+// The table index of a datetime is the count of intervals from the lower bound.
+type DatetimeInterval struct {
+	Lower         time.Time
+	Upper         time.Time // zero means no upper bound
+	Unit          string
+	Amount        int
+	SuffixPattern string // only used in interval shard
+}
+
+// ParseDatetime parse datetime string in local time zone, such as 2019-01-02 15:04:05 or 2019-01-02
+func ParseDatetime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range datetimeLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid datetime %s", s)
+}
+
+// ParseDatetimeInterval parse and verify the interval config of shard
+func ParseDatetimeInterval(s *Shard) (*DatetimeInterval, error) {
+	if s.DatetimeLower == "" {
+		return nil, fmt.Errorf("datetime_lower of %s shard table %s is empty", s.Type, s.Table)
+	}
+	lower, err := ParseDatetime(s.DatetimeLower)
+	if err != nil {
+		return nil, fmt.Errorf("invalid datetime_lower: %v", err)
+	}
+	ret := &DatetimeInterval{
+		Lower:         lower,
+		Unit:          strings.ToLower(s.DatetimeInterval),
+		Amount:        s.DatetimeIntervalAmount,
+		SuffixPattern: s.SuffixPattern,
+	}
+	if ret.Amount == 0 {
+		ret.Amount = 1
+	}
+	if ret.Amount < 0 {
+		return nil, fmt.Errorf("invalid datetime_interval_amount %d", ret.Amount)
+	}
+	if s.DatetimeUpper != "" {
+		if ret.Upper, err = ParseDatetime(s.DatetimeUpper); err != nil {
+			return nil, fmt.Errorf("invalid datetime_upper: %v", err)
+		}
+		if ret.Upper.Before(ret.Lower) {
+			return nil, fmt.Errorf("datetime_upper %s is before datetime_lower %s", s.DatetimeUpper, s.DatetimeLower)
+		}
+	}
+	switch ret.Unit {
+	case IntervalHour, IntervalDay, IntervalWeek, IntervalMonth, IntervalQuarter, IntervalYear:
+	default:
+		return nil, fmt.Errorf("invalid datetime_interval %s, must be one of hour/day/week/month/quarter/year", s.DatetimeInterval)
+	}
+	if err := verifyIntervalLowerBound(ret.Unit, lower); err != nil {
+		return nil, err
+	}
+
+	switch s.Type {
+	case ShardInterval:
+		if err := verifySuffixPattern(ret.Unit, ret.SuffixPattern); err != nil {
+			return nil, err
+		}
+	case ShardAutoInterval:
+		if ret.SuffixPattern != "" {
+			return nil, fmt.Errorf("suffix_pattern is not used by auto_interval shard")
+		}
+	}
+	return ret, nil
+}
+
+// verifyIntervalLowerBound check the lower bound is the start of a period, e.g. the first day of a month
+func verifyIntervalLowerBound(unit string, lower time.Time) error {
+	if lower.Nanosecond() != 0 || lower.Second() != 0 || lower.Minute() != 0 {
+		return fmt.Errorf("datetime_lower %s is not the start of an hour", lower.Format(datetimeLayouts[0]))
+	}
+	if unit == IntervalHour {
+		return nil
+	}
+	if lower.Hour() != 0 {
+		return fmt.Errorf("datetime_lower %s is not the start of a day", lower.Format(datetimeLayouts[0]))
+	}
+
+	switch unit {
+	case IntervalMonth:
+		if lower.Day() != 1 {
+			return fmt.Errorf("datetime_lower %s is not the start of a month", lower.Format(datetimeLayouts[0]))
+		}
+	case IntervalQuarter:
+		if lower.Day() != 1 || (lower.Month()-1)%3 != 0 {
+			return fmt.Errorf("datetime_lower %s is not the start of a quarter", lower.Format(datetimeLayouts[0]))
+		}
+	case IntervalYear:
+		if lower.Day() != 1 || lower.Month() != time.January {
+			return fmt.Errorf("datetime_lower %s is not the start of a year", lower.Format(datetimeLayouts[0]))
+		}
+	}
+	return nil
+}
+
+// the pattern letters of suffix, and the interval units they can distinguish
+var suffixPatternLetters = map[string][]string{
+	"yyyy": {IntervalYear},
+	"yy":   {IntervalYear},
+	"Q":    {IntervalQuarter},
+	"MM":   {IntervalMonth, IntervalQuarter},
+	"dd":   {IntervalDay, IntervalWeek},
+	"HH":   {IntervalHour},
+}
+
+// verifySuffixPattern check the pattern is valid, and the tables of different periods have different suffixes
+func verifySuffixPattern(unit string, pattern string) error {
+	if pattern == "" {
+		return fmt.Errorf("suffix_pattern of interval shard is empty")
+	}
+	units := make(map[string]bool)
+	for _, letters := range splitSuffixPattern(pattern) {
+		if !isPatternLetter(letters[0]) {
+			continue
+		}
+		u, ok := suffixPatternLetters[letters]
+		if !ok {
+			return fmt.Errorf("invalid suffix_pattern %s, unknown pattern letters %s", pattern, letters)
+		}
+		for _, v := range u {
+			units[v] = true
+		}
+	}
+
+	// the suffix must contain the year, and the field of interval unit
+	required := []string{IntervalYear, unit}
+	if unit == IntervalDay || unit == IntervalWeek || unit == IntervalHour {
+		required = append(required, IntervalMonth, IntervalDay)
+	}
+	for _, u := range required {
+		if !units[u] {
+			return fmt.Errorf("suffix_pattern %s cannot distinguish the tables of each %s", pattern, unit)
+		}
+	}
+	return nil
+}
+
+// FormatSuffix format the datetime by suffix pattern such as yyyyMM, the pattern letters are the same as java DateTimeFormatter:
+// yyyy and yy for year, Q for quarter, MM for month, dd for day and HH for hour, other characters are kept.
+func FormatSuffix(pattern string, t time.Time) string {
+	sb := &strings.Builder{}
+	for _, letters := range splitSuffixPattern(pattern) {
+		switch letters {
+		case "yyyy":
+			sb.WriteString(fmt.Sprintf("%04d", t.Year()))
+		case "yy":
+			sb.WriteString(fmt.Sprintf("%02d", t.Year()%100))
+		case "Q":
+			sb.WriteString(strconv.Itoa((int(t.Month())-1)/3 + 1))
+		case "MM":
+			sb.WriteString(fmt.Sprintf("%02d", t.Month()))
+		case "dd":
+			sb.WriteString(fmt.Sprintf("%02d", t.Day()))
+		case "HH":
+			sb.WriteString(fmt.Sprintf("%02d", t.Hour()))
+		default:
+			sb.WriteString(letters)
+		}
+	}
+	return sb.String()
+}
+
+// splitSuffixPattern split the pattern into runs of the same pattern letter and single other characters
+func splitSuffixPattern(pattern string) []string {
+	var ret []string
+	for i := 0; i < len(pattern); {
+		j := i + 1
+		if isPatternLetter(pattern[i]) {
+			for j < len(pattern) && pattern[j] == pattern[i] {
+				j++
+			}
+		}
+		ret = append(ret, pattern[i:j])
+		i = j
+	}
+	return ret
+}
+
+func isPatternLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
@@ -0,0 +1,63 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ShardLookup is a lookup table mapping a secondary unique column of the sharding table to the key of shard.
+// The mapping table has two columns named after Column and the key of shard, it is stored in the DB of the shard
+// on Slice, and is maintained by proxy when the rows are inserted, updated or deleted.
+type ShardLookup struct {
+	Column string `json:"column"`
+	Table  string `json:"table"`
+	Slice  string `json:"slice"`
+}
+
+// verifyShardLookups check the lookups of shard, the slices of mapping tables must be in the namespace
+func verifyShardLookups(s *Shard, sliceNames []string) error {
+	if len(s.Lookups) == 0 {
+		return nil
+	}
+
+	switch s.Type {
+	case ShardDefault, ShardGlobal, ShardLinked, ShardStandard, ShardComplex:
+		return fmt.Errorf("lookups is not supported in %s shard table %s", s.Type, s.Table)
+	}
+	if s.Key == "" {
+		return fmt.Errorf("lookups of shard table %s need the key of shard", s.Table)
+	}
+
+	columns := make(map[string]bool, len(s.Lookups))
+	for _, l := range s.Lookups {
+		if l == nil || l.Column == "" || l.Table == "" || l.Slice == "" {
+			return fmt.Errorf("column, table and slice of lookup in shard table %s must be set", s.Table)
+		}
+		column := strings.ToLower(l.Column)
+		if column == strings.ToLower(s.Key) || includeColumn(s.GeneColumns, column) {
+			return fmt.Errorf("lookup column %s of shard table %s is a sharding column", l.Column, s.Table)
+		}
+		if columns[column] {
+			return fmt.Errorf("lookup column %s of shard table %s duplicate", l.Column, s.Table)
+		}
+		columns[column] = true
+		if !includeSlice(sliceNames, l.Slice) {
+			return fmt.Errorf("lookup table %s slice[%s] not in the namespace.slices list", l.Table, l.Slice)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// constants of the key type of volume_range and boundary_range shard
+const (
+	RangeTypeInt      = "int"
+	RangeTypeString   = "string"
+	RangeTypeDatetime = "datetime"
+)
+
+// maxRangePartitions is the max count of tables of volume_range shard, to avoid expanding a huge range by mistake
+const maxRangePartitions = 10000
+
+// RangeValue is a boundary or a key of range shard. Int and datetime (unix nano) values are stored in Num,
+// and string values are stored in Str, so the values of the same type can be compared by Compare.
+type RangeValue struct {
+	Num int64
+	Str string
+}
+
+// Compare return -1, 0, 1 if v is less than, equal to, greater than o
+func (v RangeValue) Compare(o RangeValue) int {
+	if v.Num < o.Num {
+		return -1
+	}
+	if v.Num > o.Num {
+		return 1
+	}
+	return strings.Compare(v.Str, o.Str)
+}
+
+// RangeBoundaries is the boundaries of volume_range and boundary_range shard in ascending order.
+// Table 0 is the underflow table holding the keys less than the first boundary,
+// table i holds the keys in [Values[i-1], Values[i]), and the last table is the overflow table.
+type RangeBoundaries struct {
+	Type   string
+	Values []RangeValue
+}
+
+// PartitionCount return the count of tables, including the underflow and the overflow table
+func (b *RangeBoundaries) PartitionCount() int {
+	return len(b.Values) + 1
+}
+
+// ParseRangeValue parse the boundary in config by the key type of range shard
+func ParseRangeValue(typ string, s string) (RangeValue, error) {
+	switch typ {
+	case RangeTypeInt:
+		v, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		if err != nil {
+			return RangeValue{}, fmt.Errorf("invalid int value %s", s)
+		}
+		return RangeValue{Num: v}, nil
+	case RangeTypeString:
+		return RangeValue{Str: s}, nil
+	case RangeTypeDatetime:
+		t, err := ParseDatetime(s)
+		if err != nil {
+			return RangeValue{}, err
+		}
+		return RangeValue{Num: t.UnixNano()}, nil
+	default:
+		return RangeValue{}, fmt.Errorf("invalid range_type %s, must be one of int/string/datetime", typ)
+	}
+}
+
+// GetRangeType return the key type of range shard, default int
+func GetRangeType(s *Shard) string {
+	if s.RangeType == "" {
+		return RangeTypeInt
+	}
+	return strings.ToLower(s.RangeType)
+}
+
+// ParseRangeBoundaries parse and verify the boundaries of volume_range and boundary_range shard
+func ParseRangeBoundaries(s *Shard) (*RangeBoundaries, error) {
+	typ := GetRangeType(s)
+	switch s.Type {
+	case ShardVolumeRange:
+		values, err := parseVolumeRange(typ, s.RangeLower, s.RangeUpper, s.ShardingVolume)
+		if err != nil {
+			return nil, err
+		}
+		return &RangeBoundaries{Type: typ, Values: values}, nil
+	case ShardBoundaryRange:
+		if len(s.ShardingRanges) == 0 {
+			return nil, fmt.Errorf("sharding_ranges of boundary_range shard table %s is empty", s.Table)
+		}
+		values := make([]RangeValue, 0, len(s.ShardingRanges))
+		for i, r := range s.ShardingRanges {
+			v, err := ParseRangeValue(typ, r)
+			if err != nil {
+				return nil, fmt.Errorf("invalid sharding_ranges: %v", err)
+			}
+			if i > 0 && v.Compare(values[i-1]) <= 0 {
+				return nil, fmt.Errorf("sharding_ranges must be in strictly ascending order, but %s is not greater than %s", r, s.ShardingRanges[i-1])
+			}
+			values = append(values, v)
+		}
+		return &RangeBoundaries{Type: typ, Values: values}, nil
+	default:
+		return nil, fmt.Errorf("%s shard is not a range shard", s.Type)
+	}
+}
+
+// parseVolumeRange return the boundaries lower, lower+volume, lower+2*volume, ... and upper,
+// the range between the last two boundaries may be smaller than the volume.
+func parseVolumeRange(typ, lowerStr, upperStr, volumeStr string) ([]RangeValue, error) {
+	if typ == RangeTypeString {
+		return nil, fmt.Errorf("volume_range shard does not support string range_type")
+	}
+	if lowerStr == "" || upperStr == "" || volumeStr == "" {
+		return nil, fmt.Errorf("range_lower, range_upper and sharding_volume of volume_range shard must be set")
+	}
+	lower, err := ParseRangeValue(typ, lowerStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid range_lower: %v", err)
+	}
+	upper, err := ParseRangeValue(typ, upperStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid range_upper: %v", err)
+	}
+	if upper.Compare(lower) <= 0 {
+		return nil, fmt.Errorf("range_upper %s must be greater than range_lower %s", upperStr, lowerStr)
+	}
+
+	var volume int64
+	if typ == RangeTypeDatetime {
+		d, err := time.ParseDuration(volumeStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sharding_volume %s, must be a duration such as 24h", volumeStr)
+		}
+		volume = int64(d)
+	} else {
+		if volume, err = strconv.ParseInt(volumeStr, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid sharding_volume %s", volumeStr)
+		}
+	}
+	if volume <= 0 {
+		return nil, fmt.Errorf("sharding_volume %s must be positive", volumeStr)
+	}
+
+	// the difference overflows if the range is too large
+	diff := upper.Num - lower.Num
+	if diff <= 0 {
+		return nil, fmt.Errorf("range [%s, %s) is too large", lowerStr, upperStr)
+	}
+	count := (diff-1)/volume + 1
+	if count > maxRangePartitions-2 {
+		return nil, fmt.Errorf("too many tables in range [%s, %s) with sharding_volume %s", lowerStr, upperStr, volumeStr)
+	}
+	values := make([]RangeValue, 0, count+1)
+	for i := int64(0); i < count; i++ {
+		values = append(values, RangeValue{Num: lower.Num + i*volume})
+	}
+	return append(values, upper), nil
+}
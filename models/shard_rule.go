@@ -20,6 +20,8 @@ import (
 	"strings"
 
 	"github.com/XiaoMi/Gaea/core/errors"
+	"github.com/XiaoMi/Gaea/provider"
+	"github.com/XiaoMi/Gaea/util/inline"
 )
 
 var ruleVerifyFuncMapping = map[string]func(shard *Shard) error{
@@ -35,6 +37,15 @@ var ruleVerifyFuncMapping = map[string]func(shard *Shard) error{
 	ShardMycatMURMUR:     verifyMycatMURMURRule,
 	ShardMycatPaddingMod: verifyMycatPaddingRule,
 	ShardGlobal:          verifyGlobalRule,
+	ShardInline:          verifyInlineRule,
+	ShardStandard:        verifyStandardRule,
+	ShardComplex:         verifyComplexRule,
+	ShardInterval:        verifyIntervalRule,
+	ShardAutoInterval:    verifyIntervalRule,
+	ShardVolumeRange:     verifyBoundaryRangeRule,
+	ShardBoundaryRange:   verifyBoundaryRangeRule,
+	ShardHashMod:         verifyHashModRule,
+	ShardGene:            verifyGeneRule,
 }
 
 func verifyHashRule(s *Shard) error {
@@ -51,6 +62,54 @@ func verifyModRule(s *Shard) error {
 	return nil
 }
 
+func verifyHashModRule(s *Shard) error {
+	if _, err := verifyHashRuleSliceInfos(s.Locations, s.Slices); err != nil {
+		return err
+	}
+	return VerifyHashFunction(s.HashFunction)
+}
+
+func verifyGeneRule(s *Shard) error {
+	tableToSlice, err := verifyHashRuleSliceInfos(s.Locations, s.Slices)
+	if err != nil {
+		return err
+	}
+	if s.Key == "" {
+		return fmt.Errorf("gene shard table %s must have key", s.Table)
+	}
+	if s.GeneBits <= 0 || s.GeneBits > MaxGeneBits {
+		return fmt.Errorf("gene_bits %d of gene shard table %s must be in [1, %d]", s.GeneBits, s.Table, MaxGeneBits)
+	}
+	// every table must be reachable by the gene
+	if len(tableToSlice) > 1<<uint(s.GeneBits) {
+		return fmt.Errorf("gene shard table %s has %d tables, more than the count of genes of %d bits", s.Table, len(tableToSlice), s.GeneBits)
+	}
+	if len(s.GeneColumns) == 0 {
+		return fmt.Errorf("gene shard table %s must have gene_columns", s.Table)
+	}
+	for i, column := range s.GeneColumns {
+		if column == "" {
+			return fmt.Errorf("gene shard table %s has empty gene column", s.Table)
+		}
+		if strings.EqualFold(column, s.Key) || includeColumn(s.GeneColumns[:i], column) {
+			return fmt.Errorf("gene shard table %s has duplicate gene column %s", s.Table, column)
+		}
+	}
+	return nil
+}
+
+// VerifyHashFunction check the hash function of hash_mod shard
+func VerifyHashFunction(f string) error {
+	switch f {
+	case HashFunctionCRC32, HashFunctionMurmur3, HashFunctionXXHash64, HashFunctionJavaHashCode:
+		return nil
+	case "":
+		return fmt.Errorf("hash_function of hash_mod shard is empty")
+	default:
+		return fmt.Errorf("invalid hash_function %s, must be one of crc32/murmur3_32/xxhash64/java_hash_code", f)
+	}
+}
+
 func verifyRangeRule(s *Shard) error {
 	tableToSlice, err := verifyHashRuleSliceInfos(s.Locations, s.Slices)
 	if err != nil {
@@ -148,6 +207,166 @@ func verifyGlobalRule(s *Shard) error {
 	return nil
 }
 
+func verifyInlineRule(s *Shard) error {
+	if _, err := verifyHashRuleSliceInfos(s.Locations, s.Slices); err != nil {
+		return err
+	}
+	if _, err := ParseInlineExpression(s.AlgorithmExpression, s.Key); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ParseInlineExpression parse the algorithm expression of inline shard,
+// the expression must have exactly one placeholder and reference the shard keys only
+func ParseInlineExpression(expr string, keys ...string) (*inline.Expression, error) {
+	if expr == "" {
+		return nil, fmt.Errorf("algorithm_expression of inline shard is empty")
+	}
+	e, err := inline.Parse(expr)
+	if err != nil {
+		return nil, err
+	}
+	if e.Placeholders() != 1 {
+		return nil, fmt.Errorf("algorithm_expression %s must have exactly one placeholder", expr)
+	}
+	for _, v := range e.Variables() {
+		if !includeColumn(keys, v) {
+			return nil, fmt.Errorf("algorithm_expression %s references %s, which is not in the shard keys [%s]", expr, v, strings.Join(keys, ","))
+		}
+	}
+	return e, nil
+}
+
+func verifyComplexRule(s *Shard) error {
+	if _, err := verifyHashRuleSliceInfos(s.Locations, s.Slices); err != nil {
+		return err
+	}
+	if len(s.Keys) < 2 {
+		return fmt.Errorf("complex shard table %s must have at least two keys", s.Table)
+	}
+	for i, key := range s.Keys {
+		if key == "" {
+			return fmt.Errorf("complex shard table %s has empty key", s.Table)
+		}
+		if includeColumn(s.Keys[:i], key) {
+			return fmt.Errorf("complex shard table %s has duplicate key %s", s.Table, key)
+		}
+	}
+	if _, err := ParseInlineExpression(s.AlgorithmExpression, s.Keys...); err != nil {
+		return err
+	}
+	return nil
+}
+
+func verifyIntervalRule(s *Shard) error {
+	if len(s.Slices) == 0 {
+		return fmt.Errorf("%s shard table %s must have at least one slice", s.Type, s.Table)
+	}
+	if len(s.Locations) != 0 {
+		return fmt.Errorf("%s shard table %s does not use locations, the tables are distributed to slices in turn", s.Type, s.Table)
+	}
+	if _, err := ParseDatetimeInterval(s); err != nil {
+		return fmt.Errorf("invalid %s shard table %s: %v", s.Type, s.Table, err)
+	}
+	return nil
+}
+
+func verifyBoundaryRangeRule(s *Shard) error {
+	tableToSlice, err := verifyHashRuleSliceInfos(s.Locations, s.Slices)
+	if err != nil {
+		return err
+	}
+	boundaries, err := ParseRangeBoundaries(s)
+	if err != nil {
+		return fmt.Errorf("invalid %s shard table %s: %v", s.Type, s.Table, err)
+	}
+	if boundaries.PartitionCount() != len(tableToSlice) {
+		return fmt.Errorf("%s shard table %s has %d ranges including underflow and overflow, not equal tables %d",
+			s.Type, s.Table, boundaries.PartitionCount(), len(tableToSlice))
+	}
+	return nil
+}
+
+func verifyCustomRule(s *Shard) error {
+	tableToSlice, err := verifyHashRuleSliceInfos(s.Locations, s.Slices)
+	if err != nil {
+		return err
+	}
+	name, _ := GetCustomShardName(s.Type)
+	alg, err := provider.LoadShardingAlgorithm(name)
+	if err != nil {
+		return err
+	}
+	if err := alg.Verify(s.Properties, len(tableToSlice)); err != nil {
+		return fmt.Errorf("invalid %s shard table %s: %v", s.Type, s.Table, err)
+	}
+	return nil
+}
+
+// includeColumn check if column is in columns, ignore case
+func includeColumn(columns []string, column string) bool {
+	for _, c := range columns {
+		if strings.EqualFold(c, column) {
+			return true
+		}
+	}
+	return false
+}
+
+func verifyStandardRule(s *Shard) error {
+	tableToSlice, err := verifyHashRuleSliceInfos(s.Locations, s.Slices)
+	if err != nil {
+		return err
+	}
+	if len(tableToSlice) == 0 {
+		return errors.ErrLocationsCount
+	}
+	if s.DatabaseStrategy == nil && s.TableStrategy == nil {
+		return fmt.Errorf("standard shard table %s must have database_strategy or table_strategy", s.Table)
+	}
+
+	if s.DatabaseStrategy == nil {
+		if len(s.Slices) != 1 {
+			return fmt.Errorf("standard shard table %s without database_strategy must have only one slice", s.Table)
+		}
+	} else if err := verifyShardStrategy(s.DatabaseStrategy); err != nil {
+		return fmt.Errorf("invalid database_strategy of table %s: %v", s.Table, err)
+	}
+
+	for _, l := range s.Locations {
+		if s.TableStrategy == nil && l != 1 {
+			return fmt.Errorf("standard shard table %s without table_strategy must have only one table in each slice", s.Table)
+		}
+		if l != s.Locations[0] {
+			return fmt.Errorf("standard shard table %s must have the same table count in each slice", s.Table)
+		}
+	}
+	if s.TableStrategy != nil {
+		if err := verifyShardStrategy(s.TableStrategy); err != nil {
+			return fmt.Errorf("invalid table_strategy of table %s: %v", s.Table, err)
+		}
+	}
+	return nil
+}
+
+func verifyShardStrategy(s *ShardStrategy) error {
+	if s.Key == "" {
+		return fmt.Errorf("key is empty")
+	}
+	switch s.Type {
+	case ShardHash, ShardMod:
+		return nil
+	case ShardHashMod:
+		return VerifyHashFunction(s.HashFunction)
+	case ShardInline:
+		_, err := ParseInlineExpression(s.AlgorithmExpression, s.Key)
+		return err
+	default:
+		return errors.ErrUnknownRuleType
+	}
+}
+
 func verifyHashRuleSliceInfos(locations []int, slices []string) (map[int]int, error) {
 	var sumTables int
 	tableToSlice := make(map[int]int, 0)
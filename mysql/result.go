@@ -96,6 +96,21 @@ func (p RowData) ParseText(f []*Field) ([]interface{}, error) {
 	return data, nil
 }
 
+// GetTextColumn get the raw bytes of column in text format data, returns true if the column is NULL
+func (p RowData) GetTextColumn(index int) ([]byte, bool, error) {
+	var v []byte
+	var isNull bool
+	var ok bool
+	var pos = 0
+	for i := 0; i <= index; i++ {
+		v, pos, isNull, ok = ReadLenEncStringAsBytes(p, pos)
+		if !ok {
+			return nil, false, fmt.Errorf("ReadLenEncStringAsBytes in GetTextColumn failed")
+		}
+	}
+	return v, isNull, nil
+}
+
 // ParseBinary parse binary format data
 func (p RowData) ParseBinary(f []*Field) ([]interface{}, error) {
 	data := make([]interface{}, len(f))
@@ -276,6 +291,7 @@ type Result struct {
 
 	InsertID     uint64
 	AffectedRows uint64
+	Warnings     uint16
 
 	*Resultset
 }
@@ -287,6 +303,17 @@ type Resultset struct {
 	Values     [][]interface{} // values after parser handled
 
 	RowDatas []RowData // data will returned
+
+	// Stream 不为nil时结果集的行通过Stream逐行读取, Values和RowDatas为空
+	Stream RowStream
+}
+
+// RowStream 逐行读取的结果集, 用于流式合并和返回结果
+type RowStream interface {
+	// Next 返回下一行的原始数据和解析后的值, 没有更多的行时返回io.EOF
+	Next() (RowData, []interface{}, error)
+	// Close 停止读取并释放资源, 可以重复调用
+	Close() error
 }
 
 // RowNumber return row number of results
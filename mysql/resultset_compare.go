@@ -0,0 +1,355 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mysql
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pingcap/tidb/types"
+
+	"github.com/XiaoMi/Gaea/util/hack"
+)
+
+// binaryCollationID collation id of binary charset
+const binaryCollationID = 63
+
+// CompareFieldValue 按照列的类型和collation比较结果集中同一列的两个值, 与MySQL的排序规则保持一致:
+// NULL小于任何值, 数值按大小比较, DECIMAL精确比较, 时间类型按时间先后比较,
+// 字符串按列的collation比较. field为nil时按值本身的类型比较.
+// 返回值小于0表示v1 < v2, 等于0表示相等, 大于0表示v1 > v2.
+func CompareFieldValue(field *Field, v1, v2 interface{}) int {
+	if v1 == nil || v2 == nil {
+		return compareNull(v1, v2)
+	}
+
+	if field != nil {
+		switch field.Type {
+		case TypeDecimal, TypeNewDecimal:
+			if c, ok := compareDecimalValue(v1, v2); ok {
+				return c
+			}
+		case TypeDate, TypeNewDate, TypeDatetime, TypeTimestamp:
+			if isStringValue(v1) && isStringValue(v2) {
+				return bytes.Compare(trimFractionZeros(toValueBytes(v1)), trimFractionZeros(toValueBytes(v2)))
+			}
+		case TypeDuration:
+			if c, ok := compareDurationValue(v1, v2); ok {
+				return c
+			}
+		case TypeVarchar, TypeVarString, TypeString, TypeEnum, TypeSet,
+			TypeTinyBlob, TypeMediumBlob, TypeLongBlob, TypeBlob, TypeJSON:
+			if isStringValue(v1) && isStringValue(v2) {
+				return CompareCollationString(CollationID(field.Charset), toValueBytes(v1), toValueBytes(v2))
+			}
+		}
+	}
+
+	return compareValue(v1, v2)
+}
+
+// CompareCollationString 按collation比较两个字符串.
+// binary和_bin按字节比较, _ci不区分大小写, utf8和utf8mb4的_ci按utf8_general_ci的规则忽略拉丁字母的重音;
+// 除了binary和8.0的_0900_ collation, 比较时忽略末尾空格 (PAD SPACE).
+// 未知的collation按字节比较.
+func CompareCollationString(id CollationID, s1, s2 []byte) int {
+	if id == binaryCollationID {
+		return bytes.Compare(s1, s2)
+	}
+	name, ok := Collations[id]
+	if !ok {
+		return bytes.Compare(s1, s2)
+	}
+
+	if !strings.Contains(name, "_0900_") {
+		s1 = bytes.TrimRight(s1, " ")
+		s2 = bytes.TrimRight(s2, " ")
+	}
+
+	if !strings.HasSuffix(name, "_ci") {
+		return bytes.Compare(s1, s2)
+	}
+	if strings.HasPrefix(name, "utf8") {
+		return compareGeneralCI(s1, s2)
+	}
+	return compareASCIICI(s1, s2)
+}
+
+func compareNull(v1, v2 interface{}) int {
+	if v1 == nil && v2 == nil {
+		return 0
+	} else if v1 == nil {
+		return -1
+	}
+	return 1
+}
+
+// compareValue 按值本身的类型比较, 不同的数值类型按数值比较, 其他无法比较的类型按文本比较
+func compareValue(v1, v2 interface{}) int {
+	if isStringValue(v1) && isStringValue(v2) {
+		return bytes.Compare(toValueBytes(v1), toValueBytes(v2))
+	}
+
+	switch v := v1.(type) {
+	case int64:
+		switch s := v2.(type) {
+		case int64:
+			return compareInt64(v, s)
+		case uint64:
+			if v < 0 {
+				return -1
+			}
+			return compareUint64(uint64(v), s)
+		case float64:
+			return compareFloat64(float64(v), s)
+		}
+	case uint64:
+		switch s := v2.(type) {
+		case int64:
+			if s < 0 {
+				return 1
+			}
+			return compareUint64(v, uint64(s))
+		case uint64:
+			return compareUint64(v, s)
+		case float64:
+			return compareFloat64(float64(v), s)
+		}
+	case float64:
+		switch s := v2.(type) {
+		case int64:
+			return compareFloat64(v, float64(s))
+		case uint64:
+			return compareFloat64(v, float64(s))
+		case float64:
+			return compareFloat64(v, s)
+		}
+	}
+
+	if c, ok := compareDecimalValue(v1, v2); ok {
+		return c
+	}
+	return bytes.Compare(toValueBytes(v1), toValueBytes(v2))
+}
+
+func compareInt64(v1, v2 int64) int {
+	if v1 < v2 {
+		return -1
+	} else if v1 > v2 {
+		return 1
+	}
+	return 0
+}
+
+func compareUint64(v1, v2 uint64) int {
+	if v1 < v2 {
+		return -1
+	} else if v1 > v2 {
+		return 1
+	}
+	return 0
+}
+
+func compareFloat64(v1, v2 float64) int {
+	if v1 < v2 {
+		return -1
+	} else if v1 > v2 {
+		return 1
+	}
+	return 0
+}
+
+func isStringValue(v interface{}) bool {
+	switch v.(type) {
+	case string, []byte:
+		return true
+	}
+	return false
+}
+
+func toValueBytes(v interface{}) []byte {
+	switch value := v.(type) {
+	case string:
+		return hack.Slice(value)
+	case []byte:
+		return value
+	case *types.MyDecimal:
+		return value.ToString()
+	default:
+		return hack.Slice(fmt.Sprintf("%v", value))
+	}
+}
+
+// compareDecimalValue 把两个值都转换为MyDecimal后比较, 有值无法转换时返回false
+func compareDecimalValue(v1, v2 interface{}) (int, bool) {
+	d1, ok := toDecimalValue(v1)
+	if !ok {
+		return 0, false
+	}
+	d2, ok := toDecimalValue(v2)
+	if !ok {
+		return 0, false
+	}
+	return d1.Compare(d2), true
+}
+
+func toDecimalValue(v interface{}) (*types.MyDecimal, bool) {
+	d := new(types.MyDecimal)
+	switch value := v.(type) {
+	case *types.MyDecimal:
+		return value, true
+	case int64:
+		return d.FromInt(value), true
+	case uint64:
+		return d.FromUint(value), true
+	case float64:
+		if err := d.FromFloat64(value); err != nil {
+			return nil, false
+		}
+		return d, true
+	case string, []byte:
+		if err := d.FromString(toValueBytes(value)); err != nil {
+			return nil, false
+		}
+		return d, true
+	}
+	return nil, false
+}
+
+// trimFractionZeros 去掉时间值小数部分末尾的0, 使不同精度的相同时间比较结果相等
+func trimFractionZeros(v []byte) []byte {
+	if bytes.IndexByte(v, '.') < 0 {
+		return v
+	}
+	v = bytes.TrimRight(v, "0")
+	return bytes.TrimSuffix(v, []byte("."))
+}
+
+// compareDurationValue 比较TIME类型的值, 小时部分可能超过两位数, 也可能是负数, 需要转换为微秒后比较
+func compareDurationValue(v1, v2 interface{}) (int, bool) {
+	if !isStringValue(v1) || !isStringValue(v2) {
+		return 0, false
+	}
+	d1, err := parseDurationMicroseconds(toValueBytes(v1))
+	if err != nil {
+		return 0, false
+	}
+	d2, err := parseDurationMicroseconds(toValueBytes(v2))
+	if err != nil {
+		return 0, false
+	}
+	return compareInt64(d1, d2), true
+}
+
+// parseDurationMicroseconds 解析[-]HHH:MM:SS[.ffffff]格式的TIME值
+func parseDurationMicroseconds(v []byte) (int64, error) {
+	s := string(v)
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+
+	var fraction string
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		s, fraction = s[:i], s[i+1:]
+	}
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 || len(fraction) > 6 {
+		return 0, fmt.Errorf("invalid time value: %s", v)
+	}
+
+	var ret int64
+	for _, part := range parts {
+		n, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid time value: %s", v)
+		}
+		ret = ret*60 + n
+	}
+	ret *= 1000000
+	if fraction != "" {
+		n, err := strconv.ParseInt(fraction+strings.Repeat("0", 6-len(fraction)), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid time value: %s", v)
+		}
+		ret += n
+	}
+	if negative {
+		ret = -ret
+	}
+	return ret, nil
+}
+
+// generalCILatin1Weights utf8_general_ci中0xC0-0xFF字符的权重, 带重音的拉丁字母与不带重音的大写字母相同
+var generalCILatin1Weights = [64]rune{
+	'A', 'A', 'A', 'A', 'A', 'A', 0xC6, 'C', 'E', 'E', 'E', 'E', 'I', 'I', 'I', 'I',
+	0xD0, 'N', 'O', 'O', 'O', 'O', 'O', 0xD7, 0xD8, 'U', 'U', 'U', 'U', 'Y', 0xDE, 'S',
+	'A', 'A', 'A', 'A', 'A', 'A', 0xC6, 'C', 'E', 'E', 'E', 'E', 'I', 'I', 'I', 'I',
+	0xD0, 'N', 'O', 'O', 'O', 'O', 'O', 0xF7, 0xD8, 'U', 'U', 'U', 'U', 'Y', 0xDE, 'Y',
+}
+
+// generalCIWeight 返回字符在utf8_general_ci中的权重
+func generalCIWeight(r rune) rune {
+	switch {
+	case r > 0xFFFF:
+		// utf8mb4_general_ci中补充平面的字符都相等
+		return 0xFFFD
+	case r >= 0xC0 && r <= 0xFF:
+		return generalCILatin1Weights[r-0xC0]
+	case r == 0xB5:
+		return 0x39C
+	default:
+		return unicode.ToUpper(r)
+	}
+}
+
+func compareGeneralCI(s1, s2 []byte) int {
+	for len(s1) > 0 && len(s2) > 0 {
+		r1, size1 := utf8.DecodeRune(s1)
+		r2, size2 := utf8.DecodeRune(s2)
+		if w1, w2 := generalCIWeight(r1), generalCIWeight(r2); w1 != w2 {
+			if w1 < w2 {
+				return -1
+			}
+			return 1
+		}
+		s1 = s1[size1:]
+		s2 = s2[size2:]
+	}
+	return compareInt64(int64(len(s1)), int64(len(s2)))
+}
+
+// compareASCIICI 非utf8字符集的_ci collation, 只忽略ASCII字母的大小写
+func compareASCIICI(s1, s2 []byte) int {
+	for i := 0; i < len(s1) && i < len(s2); i++ {
+		c1, c2 := toUpperASCII(s1[i]), toUpperASCII(s2[i])
+		if c1 != c2 {
+			if c1 < c2 {
+				return -1
+			}
+			return 1
+		}
+	}
+	return compareInt64(int64(len(s1)), int64(len(s2)))
+}
+
+func toUpperASCII(c byte) byte {
+	if c >= 'a' && c <= 'z' {
+		return c - 'a' + 'A'
+	}
+	return c
+}
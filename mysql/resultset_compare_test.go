@@ -0,0 +1,97 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mysql
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompareFieldValue(t *testing.T) {
+	generalCI := &Field{Type: TypeVarString, Charset: uint16(CollationIds["utf8mb4_general_ci"])}
+	bin := &Field{Type: TypeVarString, Charset: uint16(CollationIds["utf8mb4_bin"])}
+	latin1CI := &Field{Type: TypeString, Charset: uint16(CollationIds["latin1_swedish_ci"])}
+	blob := &Field{Type: TypeBlob, Charset: binaryCollationID}
+	decimal := &Field{Type: TypeNewDecimal, Decimal: 2}
+	datetime := &Field{Type: TypeDatetime}
+	duration := &Field{Type: TypeDuration}
+
+	tests := []struct {
+		field  *Field
+		v1, v2 interface{}
+		expect int
+	}{
+		{nil, nil, nil, 0},
+		{nil, nil, int64(1), -1},
+		{generalCI, "a", nil, 1},
+		{nil, int64(-1), uint64(1), -1},
+		{nil, uint64(18446744073709551615), int64(1), 1},
+		{nil, float64(1.5), int64(1), 1},
+		{nil, "b", []byte("a"), 1},
+		{generalCI, "a", "B", -1},
+		{generalCI, "abc", []byte("ABC"), 0},
+		{generalCI, "abc ", "ABC", 0},
+		{generalCI, "Ä", "a", 0},
+		{generalCI, "straße", "STRASSE", -1},
+		{generalCI, "ß", "s", 0},
+		{generalCI, "😀", "😁", 0},
+		{bin, "a", "B", 1},
+		{bin, "a ", "a", 0},
+		{latin1CI, "a", "B", -1},
+		{blob, []byte("a "), []byte("a"), 1},
+		{decimal, []byte("10.00"), []byte("9.99"), 1},
+		{decimal, float64(0.1), []byte("0.10"), 0},
+		{decimal, "-0.01", int64(0), -1},
+		{datetime, "2020-01-01 00:00:00.500", "2020-01-01 00:00:00.5", 0},
+		{datetime, "2020-01-01 00:00:00", "2020-01-01 00:00:00.1", -1},
+		{duration, "100:00:00", "99:59:59", 1},
+		{duration, "-01:00:00", "00:00:00", -1},
+		{duration, "00:00:00.5", "00:00:00.49", 1},
+	}
+
+	for _, test := range tests {
+		if c := CompareFieldValue(test.field, test.v1, test.v2); c != test.expect {
+			t.Errorf("compare %v and %v with field %v, expect: %d, actual: %d", test.v1, test.v2, test.field, test.expect, c)
+		}
+	}
+}
+
+func TestResultsetSortWithCollation(t *testing.T) {
+	r := &Resultset{
+		Fields: []*Field{
+			{Type: TypeVarString, Charset: uint16(CollationIds["utf8mb4_general_ci"])},
+			{Type: TypeNewDecimal},
+		},
+		Values: [][]interface{}{
+			{"b", []byte("10.00")},
+			{"B", []byte("9.00")},
+			{nil, []byte("1.00")},
+			{"a", nil},
+		},
+	}
+
+	if err := r.SortWithoutColumnName([]SortKey{{Column: 0, Direction: SortAsc}, {Column: 1, Direction: SortAsc}}); err != nil {
+		t.Fatalf("sort error: %v", err)
+	}
+	expect := [][]interface{}{
+		{nil, []byte("1.00")},
+		{"a", nil},
+		{"B", []byte("9.00")},
+		{"b", []byte("10.00")},
+	}
+	if !reflect.DeepEqual(r.Values, expect) {
+		t.Errorf("sort result not equal, expect: %v, actual: %v", expect, r.Values)
+	}
+}
@@ -15,11 +15,8 @@
 package mysql
 
 import (
-	"bytes"
 	"fmt"
 	"sort"
-
-	"github.com/XiaoMi/Gaea/util/hack"
 )
 
 const (
@@ -81,7 +78,12 @@ func (r *ResultsetSorter) Less(i, j int) bool {
 	v2 := r.Values[j]
 
 	for _, k := range r.sk {
-		v := cmpValue(v1[k.Column], v2[k.Column])
+		// 有列信息时按列的类型和collation比较
+		var field *Field
+		if k.Column < len(r.Fields) {
+			field = r.Fields[k.Column]
+		}
+		v := CompareFieldValue(field, v1[k.Column], v2[k.Column])
 
 		if k.Direction == SortDesc {
 			v = -v
@@ -99,56 +101,6 @@ func (r *ResultsetSorter) Less(i, j int) bool {
 	return false
 }
 
-//compare value using asc
-func cmpValue(v1 interface{}, v2 interface{}) int {
-	if v1 == nil && v2 == nil {
-		return 0
-	} else if v1 == nil {
-		return -1
-	} else if v2 == nil {
-		return 1
-	}
-
-	switch v := v1.(type) {
-	case string:
-		s := v2.(string)
-		return bytes.Compare(hack.Slice(v), hack.Slice(s))
-	case []byte:
-		s := v2.([]byte)
-		return bytes.Compare(v, s)
-	case int64:
-		s := v2.(int64)
-		if v < s {
-			return -1
-		} else if v > s {
-			return 1
-		} else {
-			return 0
-		}
-	case uint64:
-		s := v2.(uint64)
-		if v < s {
-			return -1
-		} else if v > s {
-			return 1
-		} else {
-			return 0
-		}
-	case float64:
-		s := v2.(float64)
-		if v < s {
-			return -1
-		} else if v > s {
-			return 1
-		} else {
-			return 0
-		}
-	default:
-		//can not go here
-		panic(fmt.Sprintf("invalid type %T", v))
-	}
-}
-
 func (r *ResultsetSorter) Swap(i, j int) {
 	r.Values[i], r.Values[j] = r.Values[j], r.Values[i]
 
@@ -29,6 +29,7 @@ const (
 	StmtSavepoint
 	StmtRelease
 	StmtSRollback
+	StmtLoad
 )
 
 // Preview analyzes the beginning of the query using a simpler and faster
@@ -63,6 +64,8 @@ func PreviewSql(sql string) StatementType {
 		return StmtDelete
 	case "savepoint":
 		return StmtSavepoint
+	case "load":
+		return StmtLoad
 	}
 	// For the following statements it is not sufficient to rely
 	// on loweredFirstWord. This is because they are not statements
@@ -141,6 +144,8 @@ func (s StatementType) String() string {
 		return "SAVEPOINT_ROLLBACK"
 	case StmtRelease:
 		return "RELEASE"
+	case StmtLoad:
+		return "LOAD"
 	default:
 		return "UNKNOWN"
 	}
@@ -4,4 +4,5 @@ type Type int
 
 const (
 	ConfigSource Type = iota
+	ShardingAlgorithm
 )
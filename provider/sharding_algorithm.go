@@ -0,0 +1,49 @@
+package provider
+
+import "fmt"
+
+// ShardingAlgorithmProvider is a custom sharding algorithm registered with type ShardingAlgorithm,
+// a shard rule uses it by type custom:<name>, where name is the result of GetName.
+type ShardingAlgorithmProvider interface {
+	Provider
+	// Verify check the properties of shard rule, tableCount is the count of tables of the rule
+	Verify(props map[string]string, tableCount int) error
+	// Create create the sharding of a shard rule, the properties have been verified
+	Create(props map[string]string, tableCount int) (Sharding, error)
+}
+
+// Sharding compute the table indexes of a shard rule, the table index is in [0, tableCount).
+// The key may be int64, uint64, float64, string or []byte, depending on the value in sql.
+type Sharding interface {
+	// FindForKey return the table index of key
+	FindForKey(key interface{}) (int, error)
+	// FindForRange return the table indexes which may contain the keys in range
+	FindForRange(r KeyRange) ([]int, error)
+}
+
+// KeyRange is the range of keys in condition, such as id > 10 or id BETWEEN 10 AND 20
+type KeyRange struct {
+	Lower          interface{} // nil means no lower bound
+	LowerInclusive bool
+	Upper          interface{} // nil means no upper bound
+	UpperInclusive bool
+}
+
+// RegisterShardingAlgorithm register the custom sharding algorithm to default registry,
+// it is usually called in init function of the package of algorithm.
+func RegisterShardingAlgorithm(p ShardingAlgorithmProvider) error {
+	return DefaultRegistry().Register(ShardingAlgorithm, p)
+}
+
+// LoadShardingAlgorithm return the custom sharding algorithm registered with name
+func LoadShardingAlgorithm(name string) (ShardingAlgorithmProvider, error) {
+	p, ok := DefaultRegistry().TryLoad(ShardingAlgorithm, name)
+	if !ok {
+		return nil, fmt.Errorf("sharding algorithm %s is not registered", name)
+	}
+	alg, ok := p.(ShardingAlgorithmProvider)
+	if !ok {
+		return nil, fmt.Errorf("provider %s is not a sharding algorithm", name)
+	}
+	return alg, nil
+}
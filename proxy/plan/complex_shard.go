@@ -0,0 +1,192 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plan
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/XiaoMi/Gaea/proxy/router"
+	"github.com/XiaoMi/Gaea/util"
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/opcode"
+	driver "github.com/pingcap/tidb/types/parser_driver"
+)
+
+// 多列分片条件中值的组合数上限, 超过后不再计算路由, 直接走全路由
+const maxComplexShardingValueCombinations = 1024
+
+// complexShardingValues 记录一个多列分片规则在WHERE条件中绑定的分片列的值
+type complexShardingValues struct {
+	shard  router.ComplexShard
+	values map[string][]interface{} // key: column name
+}
+
+// handleComplexShardingCondition 计算多列分片(complex)规则的路由
+// 必须在handleComparisonExpr改写WHERE条件之前调用.
+// 只有AND连接的等值条件或IN条件绑定了规则的所有分片列时才能计算路由, 否则返回false, 即走全路由.
+func handleComplexShardingCondition(p *TableAliasStmtInfo, where ast.ExprNode) (bool, []int, error) {
+	shardingValues := make(map[router.Rule]*complexShardingValues)
+	for _, expr := range splitAndConditions(where, nil) {
+		column, values, ok := getShardingConditionValues(expr)
+		if !ok {
+			continue
+		}
+		db, table, columnName := getColumnInfoFromColumnName(column.Name)
+		rule, need, _, err := p.GetSettedRuleFromColumnInfo(db, table, columnName)
+		if err != nil || !need {
+			// 出错时交给后续的条件处理逻辑报错
+			continue
+		}
+		shard, ok := rule.GetShard().(router.ComplexShard)
+		if !ok {
+			continue
+		}
+		sv, ok := shardingValues[rule]
+		if !ok {
+			sv = &complexShardingValues{shard: shard, values: make(map[string][]interface{})}
+			shardingValues[rule] = sv
+		}
+		// 同一列出现多次时取并集, 得到的路由是实际路由的超集
+		sv.values[columnName] = append(sv.values[columnName], values...)
+	}
+
+	var has bool
+	var result []int
+	for _, sv := range shardingValues {
+		indexes, ok, err := sv.findTableIndexes()
+		if err != nil {
+			return false, nil, err
+		}
+		if !ok {
+			continue
+		}
+		if has {
+			result = interList(result, indexes)
+		} else {
+			has, result = true, indexes
+		}
+	}
+	return has, result, nil
+}
+
+// findTableIndexes 根据所有分片列的值的组合计算路由, 如果有分片列没有绑定值, 返回false
+func (s *complexShardingValues) findTableIndexes() ([]int, bool, error) {
+	columns := s.shard.GetShardingColumns()
+	combinations := 1
+	for _, column := range columns {
+		values, ok := s.values[column]
+		if !ok {
+			return nil, false, nil
+		}
+		combinations *= len(values)
+		if combinations > maxComplexShardingValueCombinations {
+			return nil, false, nil
+		}
+	}
+
+	indexSet := make(map[int]bool)
+	keys := make(map[string]interface{}, len(columns))
+	var walk func(i int) error
+	walk = func(i int) error {
+		if i == len(columns) {
+			index, err := s.shard.FindForKeys(keys)
+			if err != nil {
+				return err
+			}
+			indexSet[index] = true
+			return nil
+		}
+		for _, v := range s.values[columns[i]] {
+			keys[columns[i]] = v
+			if err := walk(i + 1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(0); err != nil {
+		return nil, false, fmt.Errorf("find table index error: %v", err)
+	}
+
+	indexes := make([]int, 0, len(indexSet))
+	for index := range indexSet {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	return indexes, true, nil
+}
+
+// 将AND连接的条件拆分为条件列表
+func splitAndConditions(expr ast.ExprNode, conditions []ast.ExprNode) []ast.ExprNode {
+	switch e := expr.(type) {
+	case *ast.BinaryOperationExpr:
+		if e.Op == opcode.LogicAnd {
+			conditions = splitAndConditions(e.L, conditions)
+			return splitAndConditions(e.R, conditions)
+		}
+	case *ast.ParenthesesExpr:
+		return splitAndConditions(e.Expr, conditions)
+	}
+	return append(conditions, expr)
+}
+
+// 获取 col = value, value = col, col IN (value, ...) 条件中的列名和值
+func getShardingConditionValues(expr ast.ExprNode) (*ast.ColumnNameExpr, []interface{}, bool) {
+	switch e := expr.(type) {
+	case *ast.BinaryOperationExpr:
+		if e.Op != opcode.EQ {
+			return nil, nil, false
+		}
+		column, ok := e.L.(*ast.ColumnNameExpr)
+		valueExpr, vok := foldSignedValueExpr(e.R).(*driver.ValueExpr)
+		if !ok || !vok {
+			column, ok = e.R.(*ast.ColumnNameExpr)
+			valueExpr, vok = foldSignedValueExpr(e.L).(*driver.ValueExpr)
+		}
+		if !ok || !vok {
+			return nil, nil, false
+		}
+		values, ok := getNotNullValues([]ast.ExprNode{valueExpr})
+		return column, values, ok
+	case *ast.PatternInExpr:
+		if e.Not || e.Sel != nil {
+			return nil, nil, false
+		}
+		column, ok := e.Expr.(*ast.ColumnNameExpr)
+		if !ok {
+			return nil, nil, false
+		}
+		values, ok := getNotNullValues(e.List)
+		return column, values, ok
+	}
+	return nil, nil, false
+}
+
+func getNotNullValues(exprs []ast.ExprNode) ([]interface{}, bool) {
+	var values []interface{}
+	for _, expr := range exprs {
+		valueExpr, ok := foldSignedValueExpr(expr).(*driver.ValueExpr)
+		if !ok {
+			return nil, false
+		}
+		v, err := util.GetValueExprResult(valueExpr)
+		if err != nil || v == nil {
+			return nil, false
+		}
+		values = append(values, v)
+	}
+	return values, true
+}
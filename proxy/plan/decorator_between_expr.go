@@ -19,6 +19,7 @@ import (
 	"github.com/pingcap/parser/ast"
 	"github.com/pingcap/parser/format"
 
+	"github.com/XiaoMi/Gaea/provider"
 	"github.com/XiaoMi/Gaea/proxy/router"
 	"github.com/XiaoMi/Gaea/util"
 	driver "github.com/pingcap/tidb/types/parser_driver"
@@ -112,11 +113,15 @@ func getBetweenExprRouteResult(rule router.Rule, n *ast.BetweenExpr) ([]int, err
 	columnNameExpr := n.Expr.(*ast.ColumnNameExpr)
 	_, _, column := getColumnInfoFromColumnName(columnNameExpr.Name)
 
-	if rule.GetShardingColumn() != column {
+	if !rule.IsShardingColumn(column) {
 		indexes := rule.GetSubTableIndexes()
 		return indexes, nil
 	}
 
+	if s, ok := rule.GetShard().(router.RangeFinderShard); ok {
+		return getRangeFinderBetweenExprRouteResult(s, n)
+	}
+
 	if _, ok := rule.GetShard().(router.RangeShard); ok {
 		return getShardBetweenExprRouteResult(rule, n)
 	}
@@ -125,33 +130,62 @@ func getBetweenExprRouteResult(rule router.Rule, n *ast.BetweenExpr) ([]int, err
 	return indexes, nil
 }
 
-// copy from origin PlanBuilder.getRangeShardTableIndex
-func getShardBetweenExprRouteResult(rule router.Rule, n *ast.BetweenExpr) ([]int, error) {
-	rangeShard := rule.GetShard().(router.RangeShard)
-
+func getBetweenExprValues(n *ast.BetweenExpr) (interface{}, interface{}, error) {
 	leftValueExpr, ok := n.Left.(*driver.ValueExpr)
 	if !ok {
-		return nil, fmt.Errorf("n.Left is not a ValueExpr, type: %T", n.Left)
+		return nil, nil, fmt.Errorf("n.Left is not a ValueExpr, type: %T", n.Left)
 	}
 	leftValue, err := util.GetValueExprResult(leftValueExpr)
 	if err != nil {
-		return nil, fmt.Errorf("get value from n.Left error: %v", err)
+		return nil, nil, fmt.Errorf("get value from n.Left error: %v", err)
 	}
 
 	rightValueExpr, ok := n.Right.(*driver.ValueExpr)
 	if !ok {
-		return nil, fmt.Errorf("n.Left is not a ValueExpr, type: %T", n.Right)
+		return nil, nil, fmt.Errorf("n.Left is not a ValueExpr, type: %T", n.Right)
 	}
 	rightValue, err := util.GetValueExprResult(rightValueExpr)
 	if err != nil {
-		return nil, fmt.Errorf("get value from n.Right error: %v", err)
+		return nil, nil, fmt.Errorf("get value from n.Right error: %v", err)
+	}
+	return leftValue, rightValue, nil
+}
+
+// 由分片算法计算BETWEEN的路由, NOT BETWEEN为两个范围的并集
+func getRangeFinderBetweenExprRouteResult(s router.RangeFinderShard, n *ast.BetweenExpr) ([]int, error) {
+	leftValue, rightValue, err := getBetweenExprValues(n)
+	if err != nil {
+		return nil, err
+	}
+	if !n.Not {
+		return s.FindForRange(provider.KeyRange{Lower: leftValue, LowerInclusive: true, Upper: rightValue, UpperInclusive: true})
+	}
+
+	l1, err := s.FindForRange(provider.KeyRange{Upper: leftValue})
+	if err != nil {
+		return nil, err
+	}
+	l2, err := s.FindForRange(provider.KeyRange{Lower: rightValue})
+	if err != nil {
+		return nil, err
+	}
+	return unionList(l1, l2), nil
+}
+
+// copy from origin PlanBuilder.getRangeShardTableIndex
+func getShardBetweenExprRouteResult(rule router.Rule, n *ast.BetweenExpr) ([]int, error) {
+	rangeShard := rule.GetShard().(router.RangeShard)
+
+	leftValue, rightValue, err := getBetweenExprValues(n)
+	if err != nil {
+		return nil, err
 	}
 
-	start, err := rule.FindTableIndex(leftValue)
+	start, err := findRangeTableIndex(rule, leftValue)
 	if err != nil {
 		return nil, fmt.Errorf("FindTableIndex for n.Left error: %v", err)
 	}
-	last, err := rule.FindTableIndex(rightValue)
+	last, err := findRangeTableIndex(rule, rightValue)
 	if err != nil {
 		return nil, fmt.Errorf("FindTableIndex for n.Right error: %v", err)
 	}
@@ -15,7 +15,11 @@
 package plan
 
 import (
+	"math"
+
 	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/opcode"
+	"github.com/pingcap/tidb/types"
 	driver "github.com/pingcap/tidb/types/parser_driver"
 )
 
@@ -42,3 +46,33 @@ func getExprNodeTypeInBinaryOperation(n ast.ExprNode) BinaryOperationFieldtype {
 		return UnsupportExpr
 	}
 }
+
+// 将负数常量 (parser解析为 UnaryOperationExpr(Minus, ValueExpr)) 折叠为带符号的ValueExpr, 其他表达式原样返回
+func foldSignedValueExpr(n ast.ExprNode) ast.ExprNode {
+	u, ok := n.(*ast.UnaryOperationExpr)
+	if !ok || u.Op != opcode.Minus {
+		return n
+	}
+	v, ok := foldSignedValueExpr(u.V).(*driver.ValueExpr)
+	if !ok {
+		return n
+	}
+	switch v.Kind() {
+	case types.KindInt64:
+		if v.GetInt64() == math.MinInt64 {
+			return n
+		}
+		return ast.NewValueExpr(-v.GetInt64(), "", "")
+	case types.KindUint64:
+		if v.GetUint64() > 1<<63 {
+			return n
+		}
+		return ast.NewValueExpr(int64(-v.GetUint64()), "", "")
+	case types.KindFloat64:
+		return ast.NewValueExpr(-v.GetFloat64(), "", "")
+	case types.KindMysqlDecimal:
+		return ast.NewValueExpr(types.DecimalNeg(v.GetMysqlDecimal()), "", "")
+	default:
+		return n
+	}
+}
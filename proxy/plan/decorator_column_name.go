@@ -138,6 +138,9 @@ func (c *ColumnNameDecorator) Restore(ctx *format.RestoreCtx) error {
 			if c.isAlias {
 				ctx.WriteName(c.origin.Table.String())
 				ctx.WritePlain(".")
+			} else if actualTable, ok := c.rule.GetActualTableName(tableIndex); ok {
+				ctx.WriteName(actualTable)
+				ctx.WritePlain(".")
 			} else {
 				ctx.WriteName(fmt.Sprintf("%s_%04d", c.origin.Table.String(), tableIndex))
 				ctx.WritePlain(".")
@@ -121,7 +121,7 @@ func getPatternInRouteResult(n *ast.ColumnName, isNotIn bool, rule router.Rule,
 		valueMap := getBroadcastValueMap(indexes, values)
 		return indexes, valueMap, nil
 	}
-	if rule.GetShardingColumn() != column {
+	if !rule.IsShardingColumn(column) {
 		indexes := rule.GetSubTableIndexes()
 		valueMap := getBroadcastValueMap(indexes, values)
 		return indexes, valueMap, nil
@@ -135,14 +135,16 @@ func getPatternInRouteResult(n *ast.ColumnName, isNotIn bool, rule router.Rule,
 		if err != nil {
 			return nil, nil, err
 		}
-		idx, err := rule.FindTableIndex(value)
+		idxs, err := rule.FindTableIndexes(column, value)
 		if err != nil {
 			return nil, nil, err
 		}
-		if _, ok := valueMap[idx]; !ok {
-			indexes = append(indexes, idx)
+		for _, idx := range idxs {
+			if _, ok := valueMap[idx]; !ok {
+				indexes = append(indexes, idx)
+			}
+			valueMap[idx] = append(valueMap[idx], vi)
 		}
-		valueMap[idx] = append(valueMap[idx], vi)
 	}
 	sort.Ints(indexes)
 	return indexes, valueMap, nil
@@ -108,6 +108,8 @@ func (t *TableNameDecorator) Restore(ctx *format.RestoreCtx) error {
 		ctx.WriteName(t.origin.Name.String())
 	} else if router.IsMycatShardingRule(ruleType) {
 		ctx.WriteName(t.origin.Name.String())
+	} else if actualTable, ok := t.rule.GetActualTableName(tableIndex); ok {
+		ctx.WriteName(actualTable)
 	} else {
 		ctx.WriteName(fmt.Sprintf("%s_%04d", t.origin.Name.String(), tableIndex))
 	}
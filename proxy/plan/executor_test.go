@@ -0,0 +1,319 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plan
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/opcode"
+	driver "github.com/pingcap/tidb/types/parser_driver"
+
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/util"
+)
+
+// testTable 测试Executor中一个表的列和行
+type testTable struct {
+	columns []string
+	rows    [][]interface{}
+}
+
+// testExecutor 测试用的Executor, 记录执行的SQL, 与SessionExecutor相同按slice名和db名的顺序返回分片结果.
+// 设置handle时由handle返回每条SQL的结果, 否则按SQL中的表查询tables:
+// 支持查询列和通配符, WHERE中列与常量的比较, IN, IS NULL以及AND, OR, 支持ORDER BY列名和LIMIT, 非SELECT语句返回影响1行.
+// 各功能的测试嵌入testExecutor, 实现各自的可选接口.
+type testExecutor struct {
+	tables   map[string]*testTable // key: 表名, 不同db中的同名表使用db.表名
+	handle   func(slice, db, sql string) (*mysql.Result, error)
+	fail     string // 执行以fail开头的SQL时返回错误
+	recordDB bool   // 按db:sql记录执行的SQL, 默认为slice:sql
+
+	lock     sync.Mutex
+	executed []string
+}
+
+func (e *testExecutor) ExecuteSQL(ctx *util.RequestContext, slice, db, sql string) (*mysql.Result, error) {
+	rs, err := e.ExecuteSQLs(ctx, map[string]map[string][]string{slice: {db: {sql}}})
+	if err != nil {
+		return nil, err
+	}
+	return rs[0], nil
+}
+
+func (e *testExecutor) ExecuteSQLs(ctx *util.RequestContext, sqls map[string]map[string][]string) ([]*mysql.Result, error) {
+	var executed []string
+	var rs []*mysql.Result
+	var slices []string
+	for slice := range sqls {
+		slices = append(slices, slice)
+	}
+	sort.Strings(slices)
+	for _, slice := range slices {
+		var dbs []string
+		for db := range sqls[slice] {
+			dbs = append(dbs, db)
+		}
+		sort.Strings(dbs)
+		for _, db := range dbs {
+			for _, sql := range sqls[slice][db] {
+				if e.recordDB {
+					executed = append(executed, db+":"+sql)
+				} else {
+					executed = append(executed, slice+":"+sql)
+				}
+				r, err := e.execute(slice, db, sql)
+				if err != nil {
+					return nil, err
+				}
+				rs = append(rs, r)
+			}
+		}
+	}
+	sort.Strings(executed)
+	e.record(executed...)
+	for _, sql := range executed {
+		if e.fail != "" && strings.HasPrefix(sql[strings.Index(sql, ":")+1:], e.fail) {
+			return nil, fmt.Errorf("execute sql error: %s", sql)
+		}
+	}
+	return rs, nil
+}
+
+func (e *testExecutor) record(executed ...string) {
+	e.lock.Lock()
+	e.executed = append(e.executed, executed...)
+	e.lock.Unlock()
+}
+
+func (e *testExecutor) SetLastInsertID(uint64) {}
+
+func (e *testExecutor) GetLastInsertID() uint64 {
+	return 0
+}
+
+func (e *testExecutor) execute(slice, db, sql string) (*mysql.Result, error) {
+	if e.handle != nil {
+		return e.handle(slice, db, sql)
+	}
+	n, err := parseSQL(sql)
+	if err != nil {
+		return nil, err
+	}
+	stmt, ok := n.(*ast.SelectStmt)
+	if !ok {
+		return &mysql.Result{AffectedRows: 1}, nil
+	}
+	name := stmt.From.TableRefs.Left.(*ast.TableSource).Source.(*ast.TableName).Name.O
+	table, ok := e.tables[db+"."+name]
+	if !ok {
+		table = e.tables[name]
+	}
+	if table == nil {
+		table = &testTable{}
+	}
+	return table.query(stmt)
+}
+
+func (t *testTable) query(stmt *ast.SelectStmt) (*mysql.Result, error) {
+	r := &mysql.Resultset{FieldNames: make(map[string]int)}
+	var indexes []int
+	for _, f := range stmt.Fields.Fields {
+		var names []string
+		if f.WildCard != nil {
+			names = t.columns
+		} else if c, ok := f.Expr.(*ast.ColumnNameExpr); ok {
+			names = []string{c.Name.Name.L}
+		} else {
+			return nil, fmt.Errorf("unsupported field %T", f.Expr)
+		}
+		for _, name := range names {
+			index, err := t.columnIndex(name)
+			if err != nil {
+				return nil, err
+			}
+			indexes = append(indexes, index)
+			field := &mysql.Field{Name: []byte(name), Type: mysql.TypeLonglong}
+			for _, row := range t.rows {
+				if _, ok := row[index].(string); ok {
+					field.Type = mysql.TypeVarString
+				}
+			}
+			r.FieldNames[name] = len(r.Fields)
+			r.Fields = append(r.Fields, field)
+		}
+	}
+
+	var rows [][]interface{}
+	for _, row := range t.rows {
+		if stmt.Where == nil {
+			rows = append(rows, row)
+			continue
+		}
+		v, err := t.eval(stmt.Where, row)
+		if err != nil {
+			return nil, err
+		}
+		if isTestTrue(v) {
+			rows = append(rows, row)
+		}
+	}
+	if stmt.OrderBy != nil {
+		var orderBy []int
+		for _, item := range stmt.OrderBy.Items {
+			c, ok := item.Expr.(*ast.ColumnNameExpr)
+			if !ok {
+				return nil, fmt.Errorf("unsupported order by %T", item.Expr)
+			}
+			index, err := t.columnIndex(c.Name.Name.L)
+			if err != nil {
+				return nil, err
+			}
+			orderBy = append(orderBy, index)
+		}
+		sort.SliceStable(rows, func(i, j int) bool {
+			for k, index := range orderBy {
+				c := mysql.CompareFieldValue(nil, rows[i][index], rows[j][index])
+				if stmt.OrderBy.Items[k].Desc {
+					c = -c
+				}
+				if c != 0 {
+					return c < 0
+				}
+			}
+			return false
+		})
+	}
+	if stmt.Limit != nil {
+		if stmt.Limit.Offset != nil {
+			offset := int(stmt.Limit.Offset.(*driver.ValueExpr).GetInt64())
+			if offset > len(rows) {
+				offset = len(rows)
+			}
+			rows = rows[offset:]
+		}
+		if count := int(stmt.Limit.Count.(*driver.ValueExpr).GetInt64()); count < len(rows) {
+			rows = rows[:count]
+		}
+	}
+
+	for _, row := range rows {
+		var values []interface{}
+		for _, index := range indexes {
+			values = append(values, row[index])
+		}
+		r.Values = append(r.Values, values)
+	}
+	return &mysql.Result{Resultset: r}, nil
+}
+
+// columnIndex 返回列的下标, 没有配置的表没有行, 任意列都返回-1
+func (t *testTable) columnIndex(name string) (int, error) {
+	if len(t.columns) == 0 {
+		return -1, nil
+	}
+	for i, c := range t.columns {
+		if c == name {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("unknown column %s", name)
+}
+
+// eval 计算WHERE条件的值, 比较时任意一边为NULL都返回false
+func (t *testTable) eval(expr ast.ExprNode, row []interface{}) (interface{}, error) {
+	switch x := expr.(type) {
+	case *ast.ParenthesesExpr:
+		return t.eval(x.Expr, row)
+	case *ast.ColumnNameExpr:
+		index, err := t.columnIndex(x.Name.Name.L)
+		if err != nil {
+			return nil, err
+		}
+		return row[index], nil
+	case *driver.ValueExpr:
+		return x.GetValue(), nil
+	case *ast.IsNullExpr:
+		v, err := t.eval(x.Expr, row)
+		if err != nil {
+			return nil, err
+		}
+		return (v == nil) != x.Not, nil
+	case *ast.PatternInExpr:
+		v, err := t.eval(x.Expr, row)
+		if err != nil || v == nil {
+			return false, err
+		}
+		found := false
+		for _, item := range x.List {
+			value, err := t.eval(item, row)
+			if err != nil {
+				return nil, err
+			}
+			found = found || value != nil && mysql.CompareFieldValue(nil, v, value) == 0
+		}
+		return found != x.Not, nil
+	case *ast.BinaryOperationExpr:
+		l, err := t.eval(x.L, row)
+		if err != nil {
+			return nil, err
+		}
+		r, err := t.eval(x.R, row)
+		if err != nil {
+			return nil, err
+		}
+		switch x.Op {
+		case opcode.LogicAnd:
+			return isTestTrue(l) && isTestTrue(r), nil
+		case opcode.LogicOr:
+			return isTestTrue(l) || isTestTrue(r), nil
+		}
+		if l == nil || r == nil {
+			return false, nil
+		}
+		c := mysql.CompareFieldValue(nil, l, r)
+		switch x.Op {
+		case opcode.EQ:
+			return c == 0, nil
+		case opcode.NE:
+			return c != 0, nil
+		case opcode.GT:
+			return c > 0, nil
+		case opcode.GE:
+			return c >= 0, nil
+		case opcode.LT:
+			return c < 0, nil
+		case opcode.LE:
+			return c <= 0, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported expr %T", expr)
+}
+
+// isTestTrue 判断条件的值是否为真, 数值不为0时为真
+func isTestTrue(v interface{}) bool {
+	switch x := v.(type) {
+	case bool:
+		return x
+	case int64:
+		return x != 0
+	case uint64:
+		return x != 0
+	}
+	return false
+}
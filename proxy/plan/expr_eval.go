@@ -0,0 +1,769 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plan
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/opcode"
+	"github.com/pingcap/tidb/types"
+	driver "github.com/pingcap/tidb/types/parser_driver"
+
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/util/hack"
+)
+
+// evalExpr 在合并后的结果行上计算的表达式, 用于HAVING和包含聚合函数的查询列.
+// 表达式中的聚合函数和列都会补列, 计算时从结果行中取值.
+// 值的类型与结果集中的值相同: nil (NULL), int64, uint64, float64, string, []byte, 以及合并后还没有格式化的DECIMAL聚合结果*types.MyDecimal.
+type evalExpr interface {
+	eval(row ResultRow) (interface{}, error)
+}
+
+// evalColumn 结果行中的一列
+type evalColumn struct {
+	index int
+}
+
+// evalConst 常量
+type evalConst struct {
+	value interface{}
+}
+
+// evalBinary 二元运算
+type evalBinary struct {
+	op    opcode.Op
+	left  evalExpr
+	right evalExpr
+}
+
+// evalUnary 一元运算
+type evalUnary struct {
+	op    opcode.Op
+	value evalExpr
+}
+
+// evalIsNull IS [NOT] NULL
+type evalIsNull struct {
+	value evalExpr
+	not   bool
+}
+
+// evalIn [NOT] IN (list)
+type evalIn struct {
+	value evalExpr
+	list  []evalExpr
+	not   bool
+}
+
+// evalFunc 函数调用, 只支持少量常用函数
+type evalFunc struct {
+	name string
+	args []evalExpr
+}
+
+func (e *evalColumn) eval(row ResultRow) (interface{}, error) {
+	if e.index >= len(row) {
+		return nil, fmt.Errorf("field index out of bound: %d", e.index)
+	}
+	return row.GetValue(e.index), nil
+}
+
+func (e *evalConst) eval(row ResultRow) (interface{}, error) {
+	return e.value, nil
+}
+
+func (e *evalBinary) eval(row ResultRow) (interface{}, error) {
+	l, err := e.left.eval(row)
+	if err != nil {
+		return nil, err
+	}
+	r, err := e.right.eval(row)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.op {
+	case opcode.LogicAnd, opcode.LogicOr, opcode.LogicXor:
+		return evalLogic(e.op, l, r), nil
+	case opcode.NullEQ:
+		if l == nil || r == nil {
+			return boolToInt(l == nil && r == nil), nil
+		}
+		return boolToInt(compareEvalValue(l, r) == 0), nil
+	}
+
+	// 其他运算中有NULL时结果为NULL
+	if l == nil || r == nil {
+		return nil, nil
+	}
+
+	switch e.op {
+	case opcode.EQ:
+		return boolToInt(compareEvalValue(l, r) == 0), nil
+	case opcode.NE:
+		return boolToInt(compareEvalValue(l, r) != 0), nil
+	case opcode.LT:
+		return boolToInt(compareEvalValue(l, r) < 0), nil
+	case opcode.LE:
+		return boolToInt(compareEvalValue(l, r) <= 0), nil
+	case opcode.GT:
+		return boolToInt(compareEvalValue(l, r) > 0), nil
+	case opcode.GE:
+		return boolToInt(compareEvalValue(l, r) >= 0), nil
+	default:
+		return evalArithmetic(e.op, toEvalNumber(l), toEvalNumber(r))
+	}
+}
+
+func (e *evalUnary) eval(row ResultRow) (interface{}, error) {
+	v, err := e.value.eval(row)
+	if err != nil || v == nil {
+		return nil, err
+	}
+
+	switch e.op {
+	case opcode.Not:
+		return boolToInt(!isEvalTrue(v)), nil
+	case opcode.Minus:
+		switch n := toEvalNumber(v).(type) {
+		case int64:
+			return -n, nil
+		case float64:
+			return -n, nil
+		}
+	}
+	return toEvalNumber(v), nil
+}
+
+func (e *evalIsNull) eval(row ResultRow) (interface{}, error) {
+	v, err := e.value.eval(row)
+	if err != nil {
+		return nil, err
+	}
+	return boolToInt((v == nil) != e.not), nil
+}
+
+func (e *evalIn) eval(row ResultRow) (interface{}, error) {
+	v, err := e.value.eval(row)
+	if err != nil || v == nil {
+		return nil, err
+	}
+
+	hasNull := false
+	for _, item := range e.list {
+		iv, err := item.eval(row)
+		if err != nil {
+			return nil, err
+		}
+		if iv == nil {
+			hasNull = true
+			continue
+		}
+		if compareEvalValue(v, iv) == 0 {
+			return boolToInt(!e.not), nil
+		}
+	}
+	// 列表中有NULL且没有匹配时结果为NULL
+	if hasNull {
+		return nil, nil
+	}
+	return boolToInt(e.not), nil
+}
+
+func (e *evalFunc) eval(row ResultRow) (interface{}, error) {
+	var args []interface{}
+	for _, arg := range e.args {
+		v, err := arg.eval(row)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+	}
+
+	switch e.name {
+	case ast.Ifnull:
+		if args[0] != nil {
+			return args[0], nil
+		}
+		return args[1], nil
+	case ast.Coalesce:
+		for _, v := range args {
+			if v != nil {
+				return v, nil
+			}
+		}
+		return nil, nil
+	}
+
+	if args[0] == nil {
+		return nil, nil
+	}
+	n := toEvalNumber(args[0])
+	switch e.name {
+	case ast.Abs:
+		if i, ok := n.(int64); ok {
+			if i < 0 {
+				return -i, nil
+			}
+			return i, nil
+		}
+		return math.Abs(n.(float64)), nil
+	case ast.Round:
+		var d int64
+		if len(args) > 1 {
+			if args[1] == nil {
+				return nil, nil
+			}
+			d = toEvalInt(args[1])
+		}
+		if i, ok := n.(int64); ok && d >= 0 {
+			return i, nil
+		}
+		shift := math.Pow(10, float64(d))
+		return math.Round(toEvalFloat(n)*shift) / shift, nil
+	}
+	return nil, fmt.Errorf("function is not supported: %s", e.name)
+}
+
+// evalLogic 三值逻辑运算
+func evalLogic(op opcode.Op, l, r interface{}) interface{} {
+	switch op {
+	case opcode.LogicAnd:
+		if (l != nil && !isEvalTrue(l)) || (r != nil && !isEvalTrue(r)) {
+			return int64(0)
+		}
+		if l == nil || r == nil {
+			return nil
+		}
+		return int64(1)
+	case opcode.LogicOr:
+		if (l != nil && isEvalTrue(l)) || (r != nil && isEvalTrue(r)) {
+			return int64(1)
+		}
+		if l == nil || r == nil {
+			return nil
+		}
+		return int64(0)
+	default: // opcode.LogicXor
+		if l == nil || r == nil {
+			return nil
+		}
+		return boolToInt(isEvalTrue(l) != isEvalTrue(r))
+	}
+}
+
+// evalArithmetic 算术运算, 整数运算结果为int64, 除法和浮点数运算结果为float64, 除数为0时结果为NULL
+func evalArithmetic(op opcode.Op, l, r interface{}) (interface{}, error) {
+	li, lIsInt := l.(int64)
+	ri, rIsInt := r.(int64)
+	if lIsInt && rIsInt {
+		switch op {
+		case opcode.Plus:
+			return li + ri, nil
+		case opcode.Minus:
+			return li - ri, nil
+		case opcode.Mul:
+			return li * ri, nil
+		case opcode.IntDiv:
+			if ri == 0 {
+				return nil, nil
+			}
+			return li / ri, nil
+		case opcode.Mod:
+			if ri == 0 {
+				return nil, nil
+			}
+			return li % ri, nil
+		}
+	}
+
+	lf, rf := toEvalFloat(l), toEvalFloat(r)
+	switch op {
+	case opcode.Plus:
+		return lf + rf, nil
+	case opcode.Minus:
+		return lf - rf, nil
+	case opcode.Mul:
+		return lf * rf, nil
+	case opcode.Div:
+		if rf == 0 {
+			return nil, nil
+		}
+		return lf / rf, nil
+	case opcode.IntDiv:
+		if rf == 0 {
+			return nil, nil
+		}
+		return int64(lf / rf), nil
+	case opcode.Mod:
+		if rf == 0 {
+			return nil, nil
+		}
+		return math.Mod(lf, rf), nil
+	default:
+		return nil, fmt.Errorf("operator is not supported: %s", op.String())
+	}
+}
+
+// compareEvalValue 比较两个非NULL值, 都是字符串时按字节比较, DECIMAL与DECIMAL或整数按精确值比较, 否则按数值比较
+func compareEvalValue(l, r interface{}) int {
+	lb, lIsString := toEvalBytes(l)
+	rb, rIsString := toEvalBytes(r)
+	if lIsString && rIsString {
+		return bytes.Compare(lb, rb)
+	}
+
+	if ld, rd, ok := toEvalDecimals(l, r); ok {
+		return ld.Compare(rd)
+	}
+
+	ln, rn := toEvalNumber(l), toEvalNumber(r)
+	li, lIsInt := ln.(int64)
+	ri, rIsInt := rn.(int64)
+	if lIsInt && rIsInt {
+		switch {
+		case li < ri:
+			return -1
+		case li > ri:
+			return 1
+		default:
+			return 0
+		}
+	}
+	lf, rf := toEvalFloat(ln), toEvalFloat(rn)
+	switch {
+	case lf < rf:
+		return -1
+	case lf > rf:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// toEvalDecimals 至少有一个值是DECIMAL, 另一个值是DECIMAL或整数时, 都转换为MyDecimal
+func toEvalDecimals(l, r interface{}) (*types.MyDecimal, *types.MyDecimal, bool) {
+	_, lIsDecimal := l.(*types.MyDecimal)
+	_, rIsDecimal := r.(*types.MyDecimal)
+	if !lIsDecimal && !rIsDecimal {
+		return nil, nil, false
+	}
+	ld, lok := toEvalDecimal(l)
+	rd, rok := toEvalDecimal(r)
+	return ld, rd, lok && rok
+}
+
+func toEvalDecimal(v interface{}) (*types.MyDecimal, bool) {
+	switch n := v.(type) {
+	case *types.MyDecimal:
+		return n, true
+	case int64:
+		return types.NewDecFromInt(n), true
+	case uint64:
+		return types.NewDecFromUint(n), true
+	}
+	return nil, false
+}
+
+func isEvalTrue(v interface{}) bool {
+	return toEvalFloat(toEvalNumber(v)) != 0
+}
+
+func toEvalBytes(v interface{}) ([]byte, bool) {
+	switch s := v.(type) {
+	case string:
+		return hack.Slice(s), true
+	case []byte:
+		return s, true
+	}
+	return nil, false
+}
+
+// toEvalNumber 把非NULL值转换为int64或float64, 字符串按数值前缀转换, 与MySQL一致
+func toEvalNumber(v interface{}) interface{} {
+	switch n := v.(type) {
+	case int64:
+		return n
+	case uint64:
+		if n <= math.MaxInt64 {
+			return int64(n)
+		}
+		return float64(n)
+	case float64:
+		return n
+	case *types.MyDecimal:
+		f, _ := n.ToFloat64()
+		return f
+	}
+
+	b, ok := toEvalBytes(v)
+	if !ok {
+		return float64(0)
+	}
+	s := strings.TrimSpace(string(b))
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i
+	}
+	// 取最长的可以解析为浮点数的前缀
+	for end := len(s); end > 0; end-- {
+		if f, err := strconv.ParseFloat(s[:end], 64); err == nil {
+			return f
+		}
+	}
+	return float64(0)
+}
+
+func toEvalFloat(v interface{}) float64 {
+	switch n := toEvalNumber(v).(type) {
+	case int64:
+		return float64(n)
+	case float64:
+		return n
+	}
+	return 0
+}
+
+func toEvalInt(v interface{}) int64 {
+	switch n := toEvalNumber(v).(type) {
+	case int64:
+		return n
+	case float64:
+		return int64(math.Round(n))
+	}
+	return 0
+}
+
+// aggregateFuncVisitor 查找表达式中的聚合函数, 不进入子查询
+type aggregateFuncVisitor struct {
+	found bool
+}
+
+// Enter implement ast.Visitor
+func (v *aggregateFuncVisitor) Enter(n ast.Node) (ast.Node, bool) {
+	switch n.(type) {
+	case *ast.AggregateFuncExpr:
+		v.found = true
+		return n, true
+	case *ast.SubqueryExpr:
+		return n, true
+	}
+	return n, v.found
+}
+
+// Leave implement ast.Visitor
+func (v *aggregateFuncVisitor) Leave(n ast.Node) (ast.Node, bool) {
+	return n, true
+}
+
+// hasAggregateFunc 表达式中是否包含聚合函数
+func hasAggregateFunc(expr ast.ExprNode) bool {
+	// 通配符列的Expr为nil
+	if expr == nil {
+		return false
+	}
+	v := &aggregateFuncVisitor{}
+	expr.Accept(v)
+	return v.found
+}
+
+// evalExprBuilder 把表达式转换为evalExpr, 并为表达式中的聚合函数和列补列
+type evalExprBuilder struct {
+	p          *SelectPlan
+	stmt       *ast.SelectStmt
+	countIndex int // 补充的COUNT(1)列位置, 多个GROUP_CONCAT()共用
+}
+
+func newEvalExprBuilder(p *SelectPlan, stmt *ast.SelectStmt) *evalExprBuilder {
+	return &evalExprBuilder{
+		p:          p,
+		stmt:       stmt,
+		countIndex: -1,
+	}
+}
+
+// setAggregateFuncMerger 生成fieldIndex列的聚合函数装饰器
+func (b *evalExprBuilder) setAggregateFuncMerger(field *ast.AggregateFuncExpr, fieldIndex int) error {
+	merger, err := createAggregateFuncMerger(b.p, b.stmt, field, fieldIndex, &b.countIndex)
+	if err != nil {
+		return fmt.Errorf("create aggregate function merger error, column index: %d, err: %v", fieldIndex, err)
+	}
+	if err := b.p.setAggregateFuncMerger(fieldIndex, merger); err != nil {
+		return fmt.Errorf("set aggregate function merger error, column index: %d, err: %v", fieldIndex, err)
+	}
+	return nil
+}
+
+func (b *evalExprBuilder) build(expr ast.ExprNode) (evalExpr, error) {
+	switch x := expr.(type) {
+	case *ast.AggregateFuncExpr:
+		// 已经有相同的聚合列时使用已有的列, 否则补列, 并生成补充列的聚合函数装饰器
+		for i, field := range b.stmt.Fields.Fields {
+			if f, ok := field.Expr.(*ast.AggregateFuncExpr); ok && b.p.aggregateFuncs[i] != nil && isSameAggregateFunc(f, x) {
+				return &evalColumn{index: i}, nil
+			}
+		}
+		index := appendExtraField(b.stmt, x)
+		if err := b.setAggregateFuncMerger(x, index); err != nil {
+			return nil, err
+		}
+		return &evalColumn{index: index}, nil
+	case *ast.ColumnNameExpr:
+		return b.buildColumn(x, x.Name)
+	case *ColumnNameExprDecorator:
+		return b.buildColumn(x, x.ColumnNameExpr.Name)
+	case *driver.ValueExpr:
+		return buildEvalConst(x)
+	case *ast.ParenthesesExpr:
+		return b.build(x.Expr)
+	case *ast.BinaryOperationExpr:
+		switch x.Op {
+		case opcode.LogicAnd, opcode.LogicOr, opcode.LogicXor, opcode.NullEQ,
+			opcode.EQ, opcode.NE, opcode.LT, opcode.LE, opcode.GT, opcode.GE,
+			opcode.Plus, opcode.Minus, opcode.Mul, opcode.Div, opcode.IntDiv, opcode.Mod:
+		default:
+			return nil, fmt.Errorf("operator is not supported: %s", x.Op.String())
+		}
+		l, err := b.build(x.L)
+		if err != nil {
+			return nil, err
+		}
+		r, err := b.build(x.R)
+		if err != nil {
+			return nil, err
+		}
+		return &evalBinary{op: x.Op, left: l, right: r}, nil
+	case *ast.UnaryOperationExpr:
+		switch x.Op {
+		case opcode.Not, opcode.Minus, opcode.Plus:
+		default:
+			return nil, fmt.Errorf("operator is not supported: %s", x.Op.String())
+		}
+		v, err := b.build(x.V)
+		if err != nil {
+			return nil, err
+		}
+		return &evalUnary{op: x.Op, value: v}, nil
+	case *ast.IsNullExpr:
+		v, err := b.build(x.Expr)
+		if err != nil {
+			return nil, err
+		}
+		return &evalIsNull{value: v, not: x.Not}, nil
+	case *ast.PatternInExpr:
+		if x.Sel != nil {
+			return nil, fmt.Errorf("subquery is not supported")
+		}
+		v, err := b.build(x.Expr)
+		if err != nil {
+			return nil, err
+		}
+		ret := &evalIn{value: v, not: x.Not}
+		for _, item := range x.List {
+			iv, err := b.build(item)
+			if err != nil {
+				return nil, err
+			}
+			ret.list = append(ret.list, iv)
+		}
+		return ret, nil
+	case *ast.BetweenExpr:
+		// a BETWEEN l AND r 等价于 a >= l AND a <= r
+		v, err := b.build(x.Expr)
+		if err != nil {
+			return nil, err
+		}
+		l, err := b.build(x.Left)
+		if err != nil {
+			return nil, err
+		}
+		r, err := b.build(x.Right)
+		if err != nil {
+			return nil, err
+		}
+		var ret evalExpr = &evalBinary{
+			op:    opcode.LogicAnd,
+			left:  &evalBinary{op: opcode.GE, left: v, right: l},
+			right: &evalBinary{op: opcode.LE, left: v, right: r},
+		}
+		if x.Not {
+			ret = &evalUnary{op: opcode.Not, value: ret}
+		}
+		return ret, nil
+	case *ast.FuncCallExpr:
+		return b.buildFunc(x)
+	default:
+		return nil, fmt.Errorf("expression is not supported: %T", expr)
+	}
+}
+
+// buildColumn 列名或别名与查询列匹配时使用查询列, 否则补列
+func (b *evalExprBuilder) buildColumn(expr ast.ExprNode, name *ast.ColumnName) (evalExpr, error) {
+	for i := 0; i < b.p.originColumnCount; i++ {
+		field := b.stmt.Fields.Fields[i]
+		if field.AsName.L != "" {
+			if name.Table.L == "" && field.AsName.L == name.Name.L {
+				return &evalColumn{index: i}, nil
+			}
+			continue
+		}
+		var fieldName *ast.ColumnName
+		switch f := field.Expr.(type) {
+		case *ast.ColumnNameExpr:
+			fieldName = f.Name
+		case *ColumnNameExprDecorator:
+			fieldName = f.ColumnNameExpr.Name
+		default:
+			continue
+		}
+		if fieldName.Name.L == name.Name.L && (name.Table.L == "" || fieldName.Table.L == "" || fieldName.Table.L == name.Table.L) {
+			return &evalColumn{index: i}, nil
+		}
+	}
+	return &evalColumn{index: appendExtraField(b.stmt, expr)}, nil
+}
+
+func (b *evalExprBuilder) buildFunc(x *ast.FuncCallExpr) (evalExpr, error) {
+	name := x.FnName.L
+	switch name {
+	case ast.Ifnull:
+		if len(x.Args) != 2 {
+			return nil, fmt.Errorf("incorrect parameter count in the call to %s", name)
+		}
+	case ast.Coalesce:
+		if len(x.Args) == 0 {
+			return nil, fmt.Errorf("incorrect parameter count in the call to %s", name)
+		}
+	case ast.Abs:
+		if len(x.Args) != 1 {
+			return nil, fmt.Errorf("incorrect parameter count in the call to %s", name)
+		}
+	case ast.Round:
+		if len(x.Args) != 1 && len(x.Args) != 2 {
+			return nil, fmt.Errorf("incorrect parameter count in the call to %s", name)
+		}
+	default:
+		return nil, fmt.Errorf("function is not supported: %s", name)
+	}
+
+	ret := &evalFunc{name: name}
+	for _, arg := range x.Args {
+		v, err := b.build(arg)
+		if err != nil {
+			return nil, err
+		}
+		ret.args = append(ret.args, v)
+	}
+	return ret, nil
+}
+
+// isSameAggregateFunc 只比较参数为列名或常量的聚合函数
+func isSameAggregateFunc(a, b *ast.AggregateFuncExpr) bool {
+	if !strings.EqualFold(a.F, b.F) || a.Distinct != b.Distinct || a.Order != nil || b.Order != nil || len(a.Args) != len(b.Args) {
+		return false
+	}
+	for i := range a.Args {
+		if !isSameSimpleExpr(a.Args[i], b.Args[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isSameSimpleExpr(a, b ast.ExprNode) bool {
+	getColumnName := func(expr ast.ExprNode) *ast.ColumnName {
+		switch x := expr.(type) {
+		case *ast.ColumnNameExpr:
+			return x.Name
+		case *ColumnNameExprDecorator:
+			return x.ColumnNameExpr.Name
+		}
+		return nil
+	}
+	if an, bn := getColumnName(a), getColumnName(b); an != nil && bn != nil {
+		return an.Schema.L == bn.Schema.L && an.Table.L == bn.Table.L && an.Name.L == bn.Name.L
+	}
+	av, ok := a.(*driver.ValueExpr)
+	if !ok {
+		return false
+	}
+	bv, ok := b.(*driver.ValueExpr)
+	if !ok {
+		return false
+	}
+	return av.Kind() == bv.Kind() && av.GetDatumString() == bv.GetDatumString()
+}
+
+func buildEvalConst(x *driver.ValueExpr) (evalExpr, error) {
+	switch v := x.GetValue().(type) {
+	case nil, int64, uint64, float64, string, []byte:
+		return &evalConst{value: v}, nil
+	case *types.MyDecimal:
+		f, err := v.ToFloat64()
+		if err != nil {
+			return nil, fmt.Errorf("convert decimal error: %v", err)
+		}
+		return &evalConst{value: f}, nil
+	default:
+		return nil, fmt.Errorf("value type is not supported: %T", v)
+	}
+}
+
+// evalSelectExprs 计算包含聚合函数的查询列
+func evalSelectExprs(p *SelectPlan, r *mysql.Result) error {
+	if len(p.selectExprs) == 0 {
+		return nil
+	}
+	for _, v := range r.Values {
+		row := ResultRow(v)
+		for index, expr := range p.selectExprs {
+			value, err := expr.eval(row)
+			if err != nil {
+				return fmt.Errorf("eval column %d error: %v", index, err)
+			}
+			// 表达式的值直接是DECIMAL聚合结果时, 没有对应的列定义, 按原始精度转换为文本
+			if d, ok := value.(*types.MyDecimal); ok {
+				value = d.ToString()
+			}
+			row.SetValue(index, value)
+		}
+	}
+	return nil
+}
+
+// filterHavingRows 去掉不满足HAVING条件的行
+func filterHavingRows(p *SelectPlan, r *mysql.Result) error {
+	if p.having == nil {
+		return nil
+	}
+	values := r.Values[:0]
+	for _, v := range r.Values {
+		ret, err := p.having.eval(v)
+		if err != nil {
+			return fmt.Errorf("eval having error: %v", err)
+		}
+		if ret != nil && isEvalTrue(ret) {
+			values = append(values, v)
+		}
+	}
+	r.Values = values
+	r.RowDatas = nil
+	return nil
+}
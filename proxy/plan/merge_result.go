@@ -17,6 +17,7 @@ package plan
 import (
 	"fmt"
 	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/tidb/types"
 	"strconv"
 	"strings"
 
@@ -39,6 +40,13 @@ func (r ResultRow) GetInt(column int) (int64, error) {
 		return v, nil
 	case float64:
 		return int64(v), nil
+	case *types.MyDecimal:
+		// 与float64一样截断小数部分
+		i, err := v.ToInt()
+		if types.ErrTruncated.Equal(err) {
+			err = nil
+		}
+		return i, err
 	case string:
 		return strconv.ParseInt(v, 10, 64)
 	case []byte:
@@ -60,6 +68,12 @@ func (r ResultRow) GetUint(column int) (uint64, error) {
 		return uint64(v), nil
 	case float64:
 		return uint64(v), nil
+	case *types.MyDecimal:
+		i, err := v.ToUint()
+		if types.ErrTruncated.Equal(err) {
+			err = nil
+		}
+		return i, err
 	case string:
 		return strconv.ParseUint(v, 10, 64)
 	case []byte:
@@ -81,6 +95,8 @@ func (r ResultRow) GetFloat(column int) (float64, error) {
 		return float64(v), nil
 	case int64:
 		return float64(v), nil
+	case *types.MyDecimal:
+		return v.ToFloat64()
 	case string:
 		return strconv.ParseFloat(v, 64)
 	case []byte:
@@ -92,6 +108,36 @@ func (r ResultRow) GetFloat(column int) (float64, error) {
 	}
 }
 
+// GetDecimal get decimal value from column, NULL is treated as 0
+func (r ResultRow) GetDecimal(column int) (*types.MyDecimal, error) {
+	d := r[column]
+	ret := new(types.MyDecimal)
+	switch v := d.(type) {
+	case *types.MyDecimal:
+		*ret = *v
+	case int64:
+		ret.FromInt(v)
+	case uint64:
+		ret.FromUint(v)
+	case float64:
+		if err := ret.FromFloat64(v); err != nil {
+			return nil, err
+		}
+	case string:
+		if err := ret.FromString([]byte(v)); err != nil {
+			return nil, err
+		}
+	case []byte:
+		if err := ret.FromString(v); err != nil {
+			return nil, err
+		}
+	case nil:
+	default:
+		return nil, fmt.Errorf("data type is %T", v)
+	}
+	return ret, nil
+}
+
 // SetValue set value to column
 func (r ResultRow) SetValue(column int, value interface{}) {
 	r[column] = value
@@ -180,7 +226,14 @@ func (a *AggregateFuncSumMerger) MergeTo(from, to ResultRow) error {
 		return nil
 	}
 
+	// DECIMAL列在合并前已经转换为MyDecimal
+	if _, ok := fromValueI.(*types.MyDecimal); ok {
+		return a.sumToDecimal(from, to)
+	}
+
 	switch to.GetValue(idx).(type) {
+	case *types.MyDecimal:
+		return a.sumToDecimal(from, to)
 	case int64:
 		return a.sumToInt64(from, to)
 	case uint64:
@@ -236,6 +289,28 @@ func (a *AggregateFuncSumMerger) sumToFloat64(from, to ResultRow) error {
 	return nil
 }
 
+func (a *AggregateFuncSumMerger) sumToDecimal(from, to ResultRow) error {
+	idx := a.fieldIndex // does not need to check
+	valueToMerge, err := from.GetDecimal(idx)
+	if err != nil {
+		return fmt.Errorf("get from decimal value error: %v", err)
+	}
+	originValue, err := to.GetDecimal(idx)
+	if err != nil {
+		return fmt.Errorf("get to decimal value error: %v", err)
+	}
+	sum := new(types.MyDecimal)
+	if err := types.DecimalAdd(originValue, valueToMerge, sum); err != nil {
+		return fmt.Errorf("add decimal value error: %v", err)
+	}
+	to.SetValue(idx, sum)
+	return nil
+}
+
+func (a *AggregateFuncSumMerger) decimalColumns() []int {
+	return []int{a.fieldIndex}
+}
+
 // AggregateFuncMaxMerger merge MAX() column in result
 type AggregateFuncMaxMerger struct {
 	aggregateFuncBaseMerger
@@ -243,56 +318,145 @@ type AggregateFuncMaxMerger struct {
 
 // MergeTo implement AggregateFuncMerger
 func (a *AggregateFuncMaxMerger) MergeTo(from, to ResultRow) error {
-	idx := a.fieldIndex
+	return a.mergeFieldsTo(nil, from, to)
+}
+
+// mergeFieldsTo implement aggregateFuncFieldsMerger
+func (a *AggregateFuncMaxMerger) mergeFieldsTo(fields []*mysql.Field, from, to ResultRow) error {
+	return mergeCompareValue(a.fieldIndex, fields, from, to, 1)
+}
+
+// decimalColumns implement aggregateFuncDecimalColumns
+func (a *AggregateFuncMaxMerger) decimalColumns() []int {
+	return []int{a.fieldIndex}
+}
+
+// AggregateFuncMinMerger merge MIN() column in result
+type AggregateFuncMinMerger struct {
+	aggregateFuncBaseMerger
+}
+
+// MergeTo implement AggregateFuncMerger
+func (a *AggregateFuncMinMerger) MergeTo(from, to ResultRow) error {
+	return a.mergeFieldsTo(nil, from, to)
+}
+
+// mergeFieldsTo implement aggregateFuncFieldsMerger
+func (a *AggregateFuncMinMerger) mergeFieldsTo(fields []*mysql.Field, from, to ResultRow) error {
+	return mergeCompareValue(a.fieldIndex, fields, from, to, -1)
+}
+
+// decimalColumns implement aggregateFuncDecimalColumns
+func (a *AggregateFuncMinMerger) decimalColumns() []int {
+	return []int{a.fieldIndex}
+}
+
+// mergeCompareValue 合并MAX()和MIN()列, 按列的类型和collation比较, from中的值与to比较的结果与sign相同时替换to中的值
+func mergeCompareValue(idx int, fields []*mysql.Field, from, to ResultRow, sign int) error {
 	if idx >= len(from) || idx >= len(to) {
-		return fmt.Errorf("field index out of bound: %d", a.fieldIndex)
+		return fmt.Errorf("field index out of bound: %d", idx)
 	}
 
-	fromValueI := from.GetValue(idx)
-	toValueI := to.GetValue(idx)
+	fromValue := from.GetValue(idx)
+	toValue := to.GetValue(idx)
 
 	// nil对应NULL, NULL不参与比较
-	if fromValueI == nil {
+	if fromValue == nil {
 		return nil
 	}
+	if toValue == nil || mysql.CompareFieldValue(getResultField(fields, idx), fromValue, toValue)*sign > 0 {
+		to.SetValue(idx, fromValue)
+	}
+	return nil
+}
+
+func getResultField(fields []*mysql.Field, idx int) *mysql.Field {
+	if idx < len(fields) {
+		return fields[idx]
+	}
+	return nil
+}
 
-	switch toValue := toValueI.(type) {
-	case nil:
-		to.SetValue(idx, fromValueI)
-		return nil
-	case int64:
-		if fromValueI.(int64) > toValue {
-			to.SetValue(idx, fromValueI)
-		}
-		return nil
-	case uint64:
-		if fromValueI.(uint64) > toValue {
-			to.SetValue(idx, fromValueI)
-		}
-		return nil
-	case float64:
-		if fromValueI.(float64) > toValue {
-			to.SetValue(idx, fromValueI)
-		}
+// AggregateFuncAvgMerger merge AVG() column in result
+// AVG()下推时补充SUM()和COUNT()两列, 合并补充列后重新计算平均值
+type AggregateFuncAvgMerger struct {
+	aggregateFuncBaseMerger
+	sumMerger   *AggregateFuncSumMerger
+	countMerger *AggregateFuncCountMerger
+}
+
+// NewAggregateFuncAvgMerger create AggregateFuncAvgMerger, sumIndex和countIndex为补充的SUM()和COUNT()列位置
+func NewAggregateFuncAvgMerger(fieldIndex, sumIndex, countIndex int) *AggregateFuncAvgMerger {
+	ret := &AggregateFuncAvgMerger{
+		sumMerger:   new(AggregateFuncSumMerger),
+		countMerger: new(AggregateFuncCountMerger),
+	}
+	ret.fieldIndex = fieldIndex
+	ret.sumMerger.fieldIndex = sumIndex
+	ret.countMerger.fieldIndex = countIndex
+	return ret
+}
+
+// MergeTo implement AggregateFuncMerger
+func (a *AggregateFuncAvgMerger) MergeTo(from, to ResultRow) error {
+	idx := a.fieldIndex
+	if idx >= len(from) || idx >= len(to) {
+		return fmt.Errorf("field index out of bound: %d", a.fieldIndex)
+	}
+
+	if err := a.sumMerger.MergeTo(from, to); err != nil {
+		return fmt.Errorf("merge sum of avg error: %v", err)
+	}
+	if err := a.countMerger.MergeTo(from, to); err != nil {
+		return fmt.Errorf("merge count of avg error: %v", err)
+	}
+
+	count, err := to.GetInt(a.countMerger.fieldIndex)
+	if err != nil {
+		return fmt.Errorf("get count of avg error: %v", err)
+	}
+	// 没有非NULL值时AVG()为NULL
+	if count == 0 {
+		to.SetValue(idx, nil)
 		return nil
-	case string:
-		if fromValueI.(string) > toValue {
-			to.SetValue(idx, fromValueI)
+	}
+	// DECIMAL的平均值与MySQL一样, 比SUM()多保留4位小数
+	if sum, ok := to.GetValue(a.sumMerger.fieldIndex).(*types.MyDecimal); ok {
+		avg := new(types.MyDecimal)
+		if err := types.DecimalDiv(sum, new(types.MyDecimal).FromInt(count), avg, types.DivFracIncr); err != nil {
+			return fmt.Errorf("div decimal value error: %v", err)
 		}
+		to.SetValue(idx, avg)
 		return nil
-	// does not handle []byte
-	default:
-		return fmt.Errorf("cannot compare value %v (%T) to %v (%T)", fromValueI, fromValueI, toValueI, toValueI)
 	}
+	sum, err := to.GetFloat(a.sumMerger.fieldIndex)
+	if err != nil {
+		return fmt.Errorf("get sum of avg error: %v", err)
+	}
+	to.SetValue(idx, sum/float64(count))
+	return nil
 }
 
-// AggregateFuncMinMerger merge MIN() column in result
-type AggregateFuncMinMerger struct {
+func (a *AggregateFuncAvgMerger) decimalColumns() []int {
+	return []int{a.fieldIndex, a.sumMerger.fieldIndex}
+}
+
+// AggregateFuncGroupConcatMerger merge GROUP_CONCAT() column without DISTINCT and ORDER BY in result
+// 用分隔符连接各个分片的结果
+type AggregateFuncGroupConcatMerger struct {
 	aggregateFuncBaseMerger
+	separator string
+}
+
+// NewAggregateFuncGroupConcatMerger create AggregateFuncGroupConcatMerger
+func NewAggregateFuncGroupConcatMerger(fieldIndex int, separator string) *AggregateFuncGroupConcatMerger {
+	ret := &AggregateFuncGroupConcatMerger{separator: separator}
+	ret.fieldIndex = fieldIndex
+	return ret
 }
 
 // MergeTo implement AggregateFuncMerger
-func (a *AggregateFuncMinMerger) MergeTo(from, to ResultRow) error {
+func (a *AggregateFuncGroupConcatMerger) MergeTo(from, to ResultRow) error {
 	idx := a.fieldIndex
 	if idx >= len(from) || idx >= len(to) {
 		return fmt.Errorf("field index out of bound: %d", a.fieldIndex)
@@ -301,39 +465,311 @@ func (a *AggregateFuncMinMerger) MergeTo(from, to ResultRow) error {
 	fromValueI := from.GetValue(idx)
 	toValueI := to.GetValue(idx)
 
-	// nil对应NULL, NULL不参与比较
+	// nil对应NULL, NULL不参与连接
 	if fromValueI == nil {
 		return nil
 	}
-
-	switch toValue := toValueI.(type) {
-	case nil:
-		to.SetValue(idx, fromValueI)
+	fromValue, err := formatValue(fromValueI)
+	if err != nil {
+		return fmt.Errorf("format from value error: %v", err)
+	}
+	if toValueI == nil {
+		to.SetValue(idx, string(fromValue))
 		return nil
-	case int64:
-		if fromValueI.(int64) < toValue {
-			to.SetValue(idx, fromValueI)
+	}
+	toValue, err := formatValue(toValueI)
+	if err != nil {
+		return fmt.Errorf("format to value error: %v", err)
+	}
+	to.SetValue(idx, string(toValue)+a.separator+string(fromValue))
+	return nil
+}
+
+// aggregateFuncDecimalColumns 合并时按DECIMAL精确计算的聚合函数
+type aggregateFuncDecimalColumns interface {
+	// decimalColumns 返回需要精确计算的列, 只有类型为DECIMAL的列才会转换为MyDecimal
+	decimalColumns() []int
+}
+
+// aggregateFuncFinalizer 合并完成后还需要计算最终结果的聚合函数
+type aggregateFuncFinalizer interface {
+	// Finalize 计算聚合行中聚合列的最终结果, fields为结果集的列信息
+	Finalize(fields []*mysql.Field, row ResultRow) error
+}
+
+// aggregateFuncFieldsMerger 合并时需要按列的类型和collation比较的聚合函数
+type aggregateFuncFieldsMerger interface {
+	mergeFieldsTo(fields []*mysql.Field, from, to ResultRow) error
+}
+
+// mergeAggregateFunc 合并聚合列, 需要比较值的聚合函数使用结果集的列信息
+func mergeAggregateFunc(mfunc AggregateFuncMerger, fields []*mysql.Field, from, to ResultRow) error {
+	if m, ok := mfunc.(aggregateFuncFieldsMerger); ok {
+		return m.mergeFieldsTo(fields, from, to)
+	}
+	return mfunc.MergeTo(from, to)
+}
+
+// aggregateFuncValues 合并过程中暂存在聚合列中的下推值, 每一行为: 参数值, ORDER BY值, 行数
+type aggregateFuncValues struct {
+	rows [][]interface{}
+}
+
+// AggregateFuncValuesMerger merge COUNT(DISTINCT), SUM(DISTINCT), AVG(DISTINCT)
+// and GROUP_CONCAT() with DISTINCT or ORDER BY column in result.
+// 这些聚合函数的参数和ORDER BY列作为补充列下推, 并且加到GROUP BY中, 由每个分组的参数值计算聚合结果.
+// 只有一个分组时后端返回的聚合结果就是最终结果, 否则合并时在聚合列中暂存所有分组的值, 合并完成后再计算.
+type AggregateFuncValuesMerger struct {
+	aggregateFuncBaseMerger
+	funcType       string
+	distinct       bool
+	separator      string // GROUP_CONCAT()的分隔符
+	argIndexes     []int  // 参数列位置
+	orderByIndexes []int  // GROUP_CONCAT() ORDER BY列位置
+	orderByDescs   []bool // GROUP_CONCAT() ORDER BY方向, true: DESC
+	countIndex     int    // 每个分组的行数列位置, 小于0表示每个分组按一行处理
+}
+
+// NewAggregateFuncValuesMerger create AggregateFuncValuesMerger
+// currently support: "count", "sum", "avg", "group_concat"
+func NewAggregateFuncValuesMerger(funcType string, fieldIndex int, distinct bool, argIndexes []int) (*AggregateFuncValuesMerger, error) {
+	funcType = strings.ToLower(funcType)
+	switch funcType {
+	case "count", "sum", "avg", "group_concat":
+	default:
+		return nil, fmt.Errorf("aggregate function type is not support: %s", funcType)
+	}
+	if len(argIndexes) == 0 {
+		return nil, fmt.Errorf("aggregate function %s has no argument", funcType)
+	}
+	ret := &AggregateFuncValuesMerger{
+		funcType:   funcType,
+		distinct:   distinct,
+		argIndexes: argIndexes,
+		countIndex: -1,
+	}
+	ret.fieldIndex = fieldIndex
+	return ret, nil
+}
+
+// MergeTo implement AggregateFuncMerger
+func (a *AggregateFuncValuesMerger) MergeTo(from, to ResultRow) error {
+	idx := a.fieldIndex
+	if idx >= len(from) || idx >= len(to) {
+		return fmt.Errorf("field index out of bound: %d", a.fieldIndex)
+	}
+
+	values, ok := to.GetValue(idx).(*aggregateFuncValues)
+	if !ok {
+		values = new(aggregateFuncValues)
+		if err := a.addValues(values, to); err != nil {
+			return err
 		}
-		return nil
-	case uint64:
-		if fromValueI.(uint64) < toValue {
-			to.SetValue(idx, fromValueI)
+		to.SetValue(idx, values)
+	}
+	return a.addValues(values, from)
+}
+
+func (a *AggregateFuncValuesMerger) addValues(values *aggregateFuncValues, row ResultRow) error {
+	var value []interface{}
+	for _, indexes := range [][]int{a.argIndexes, a.orderByIndexes} {
+		for _, index := range indexes {
+			if index >= len(row) {
+				return fmt.Errorf("field index out of bound: %d", index)
+			}
+			value = append(value, row.GetValue(index))
 		}
-		return nil
-	case float64:
-		if fromValueI.(float64) < toValue {
-			to.SetValue(idx, fromValueI)
+	}
+	count := int64(1)
+	if a.countIndex >= 0 {
+		var err error
+		if count, err = row.GetInt(a.countIndex); err != nil {
+			return fmt.Errorf("get row count error: %v", err)
 		}
+	}
+	values.rows = append(values.rows, append(value, count))
+	return nil
+}
+
+// Finalize implement aggregateFuncFinalizer
+func (a *AggregateFuncValuesMerger) Finalize(fields []*mysql.Field, row ResultRow) error {
+	values, ok := row.GetValue(a.fieldIndex).(*aggregateFuncValues)
+	if !ok {
 		return nil
-	case string:
-		if fromValueI.(string) < toValue {
-			to.SetValue(idx, fromValueI)
+	}
+
+	rows, err := a.getAggregateRows(values)
+	if err != nil {
+		return err
+	}
+
+	var value interface{}
+	switch a.funcType {
+	case "count":
+		value = int64(len(rows))
+	case "sum":
+		value, err = sumAggregateRows(rows)
+	case "avg":
+		value, err = sumAggregateRows(rows)
+		if sum, ok := value.(*types.MyDecimal); ok {
+			avg := new(types.MyDecimal)
+			err = types.DecimalDiv(sum, new(types.MyDecimal).FromInt(int64(len(rows))), avg, types.DivFracIncr)
+			value = avg
+		} else if value != nil {
+			var sum float64
+			sum, err = ResultRow{value}.GetFloat(0)
+			value = sum / float64(len(rows))
 		}
-		return nil
-		// does not handle []byte
-	default:
-		return fmt.Errorf("cannot compare value %v (%T) to %v (%T)", fromValueI, fromValueI, toValueI, toValueI)
+	case "group_concat":
+		value, err = a.concatAggregateRows(fields, rows)
+	}
+	if err != nil {
+		return fmt.Errorf("finalize %s error: %v", a.funcType, err)
+	}
+	row.SetValue(a.fieldIndex, value)
+	return nil
+}
+
+// getAggregateRows 去掉参数中有NULL的行, DISTINCT时按参数值去重
+func (a *AggregateFuncValuesMerger) getAggregateRows(values *aggregateFuncValues) ([][]interface{}, error) {
+	argCount := len(a.argIndexes)
+	keys := make(map[string]bool)
+	var rows [][]interface{}
+	for _, r := range values.rows {
+		hasNull := false
+		for _, v := range r[:argCount] {
+			if v == nil {
+				hasNull = true
+				break
+			}
+		}
+		if hasNull {
+			continue
+		}
+		if a.distinct {
+			mk, err := generateMapKey(r[:argCount])
+			if err != nil {
+				return nil, err
+			}
+			if keys[mk] {
+				continue
+			}
+			keys[mk] = true
+		}
+		rows = append(rows, r)
 	}
+	return rows, nil
+}
+
+func (a *AggregateFuncValuesMerger) concatAggregateRows(fields []*mysql.Field, rows [][]interface{}) (interface{}, error) {
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	if len(a.orderByIndexes) != 0 {
+		var sortKeys []mysql.SortKey
+		for i, desc := range a.orderByDescs {
+			sortKey := mysql.SortKey{Column: len(a.argIndexes) + i, Direction: mysql.SortAsc}
+			if desc {
+				sortKey.Direction = mysql.SortDesc
+			}
+			sortKeys = append(sortKeys, sortKey)
+		}
+		// 暂存的每一行依次为参数和ORDER BY列, 排序时使用这些列在结果集中的列信息
+		var valueFields []*mysql.Field
+		for _, indexes := range [][]int{a.argIndexes, a.orderByIndexes} {
+			for _, index := range indexes {
+				valueFields = append(valueFields, getResultField(fields, index))
+			}
+		}
+		r := &mysql.Resultset{Fields: valueFields, Values: rows}
+		if err := r.SortWithoutColumnName(sortKeys); err != nil {
+			return nil, err
+		}
+	}
+
+	var items []string
+	for _, r := range rows {
+		var item []byte
+		for _, v := range r[:len(a.argIndexes)] {
+			b, err := formatValue(v)
+			if err != nil {
+				return nil, err
+			}
+			item = append(item, b...)
+		}
+		// 不去重时, 每个分组的值按分组的行数重复
+		count := r[len(r)-1].(int64)
+		for i := int64(0); i < count; i++ {
+			items = append(items, string(item))
+		}
+	}
+	return strings.Join(items, a.separator), nil
+}
+
+func (a *AggregateFuncValuesMerger) decimalColumns() []int {
+	switch a.funcType {
+	case "sum", "avg":
+		return []int{a.fieldIndex, a.argIndexes[0]}
+	}
+	return nil
+}
+
+// sumAggregateRows 计算第一个参数的和, 参数是DECIMAL时返回MyDecimal, 都是整数时返回int64, 否则返回float64, 没有值时返回nil
+func sumAggregateRows(rows [][]interface{}) (interface{}, error) {
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	if _, ok := rows[0][0].(*types.MyDecimal); ok {
+		sum := new(types.MyDecimal)
+		for _, r := range rows {
+			v, err := ResultRow(r).GetDecimal(0)
+			if err != nil {
+				return nil, err
+			}
+			if err := types.DecimalAdd(sum, v, sum); err != nil {
+				return nil, err
+			}
+		}
+		return sum, nil
+	}
+
+	var intSum int64
+	var floatSum float64
+	isInt := true
+	for _, r := range rows {
+		if v, ok := r[0].(int64); ok && isInt {
+			intSum += v
+			continue
+		}
+		if isInt {
+			isInt = false
+			floatSum = float64(intSum)
+		}
+		v, err := ResultRow(r).GetFloat(0)
+		if err != nil {
+			return nil, err
+		}
+		floatSum += v
+	}
+	if isInt {
+		return intSum, nil
+	}
+	return floatSum, nil
+}
+
+// getEmptyAggregateValue 没有任何分组时聚合函数的结果, COUNT()为0, 其他为NULL
+func getEmptyAggregateValue(merger AggregateFuncMerger) interface{} {
+	switch m := merger.(type) {
+	case *AggregateFuncCountMerger:
+		return int64(0)
+	case *AggregateFuncValuesMerger:
+		if m.funcType == "count" {
+			return int64(0)
+		}
+	}
+	return nil
 }
 
 // MergeExecResult merge execution results, like UPDATE, INSERT, DELETE, ...
@@ -342,6 +778,7 @@ func MergeExecResult(rs []*mysql.Result) (*mysql.Result, error) {
 	for _, v := range rs {
 		r.Status |= v.Status
 		r.AffectedRows += v.AffectedRows
+		r.Warnings = addWarnings(r.Warnings, v.Warnings)
 		if r.InsertID == 0 {
 			r.InsertID = v.InsertID
 		} else if v.InsertID != 0 && r.InsertID > v.InsertID {
@@ -354,22 +791,30 @@ func MergeExecResult(rs []*mysql.Result) (*mysql.Result, error) {
 	return r, nil
 }
 
+// addWarnings 累加警告数, 超过uint16上限时取上限
+func addWarnings(a, b uint16) uint16 {
+	if sum := uint32(a) + uint32(b); sum < uint32(^uint16(0)) {
+		return uint16(sum)
+	}
+	return ^uint16(0)
+}
+
 // MergeSelectResult merge select results
 func MergeSelectResult(p *SelectPlan, stmt *ast.SelectStmt, rs []*mysql.Result) (*mysql.Result, error) {
 	ret := mergeMultiResultSet(rs)
 
-	if p.distinct {
-		if err := removeDistinctRowInResult(p, ret); err != nil {
-			return nil, err
-		}
+	decimalColumns := getDecimalColumns(p, ret)
+	if err := convertDecimalColumns(ret, decimalColumns); err != nil {
+		return nil, err
 	}
 
-	if stmt.GroupBy != nil {
-		if err := buildSelectGroupByResult(p, ret); err != nil {
-			return nil, err
-		}
-	} else {
-		if err := buildSelectOnlyResult(p, ret); err != nil {
+	if err := aggregateSelectResult(p, ret, decimalColumns); err != nil {
+		return nil, err
+	}
+
+	// DISTINCT在聚合之后处理, 否则会去掉各分片中聚合值相同的行
+	if p.distinct {
+		if err := removeDistinctRowInResult(p, ret); err != nil {
 			return nil, err
 		}
 	}
@@ -393,6 +838,36 @@ func MergeSelectResult(p *SelectPlan, stmt *ast.SelectStmt, rs []*mysql.Result)
 	return ret, nil
 }
 
+// aggregateSelectResult 合并聚合列, 计算聚合结果, 查询列表达式和HAVING.
+// 分组的所有行都需要在同一个结果集中, decimalColumns中的列已经转换为MyDecimal
+func aggregateSelectResult(p *SelectPlan, ret *mysql.Result, decimalColumns []int) error {
+	// 聚合函数下推的GROUP BY列不参与分组, 只按原始的GROUP BY列分组
+	if p.HasGroupBy() {
+		if err := buildSelectGroupByResult(p, ret); err != nil {
+			return err
+		}
+	} else {
+		if err := buildSelectOnlyResult(p, ret); err != nil {
+			return err
+		}
+	}
+
+	if err := finalizeAggregateFuncs(p, ret); err != nil {
+		return err
+	}
+
+	// 用合并后的聚合结果计算查询列表达式和HAVING, 在格式化DECIMAL之前计算, 按精确值比较
+	if err := evalSelectExprs(p, ret); err != nil {
+		return err
+	}
+
+	if err := filterHavingRows(p, ret); err != nil {
+		return err
+	}
+
+	return formatDecimalColumns(ret, decimalColumns)
+}
+
 // 合并结果集, 返回一个Result
 func mergeMultiResultSet(rs []*mysql.Result) *mysql.Result {
 	if len(rs) == 1 {
@@ -493,7 +968,7 @@ func buildSelectGroupByResult(p *SelectPlan, r *mysql.Result) error {
 		// 如果存在聚合函数, 则对聚合列进行结果聚合, 非聚合列不处理
 		retToMerge := ResultRow(r.Values[i])
 		for _, mfunc := range p.aggregateFuncs {
-			if err := mfunc.MergeTo(retToMerge, resultMap[mk]); err != nil {
+			if err := mergeAggregateFunc(mfunc, r.Fields, retToMerge, resultMap[mk]); err != nil {
 				return fmt.Errorf("MergeTo error, func: %v, value: %v, err: %v", mfunc, retToMerge, err)
 			}
 		}
@@ -515,6 +990,15 @@ func buildSelectOnlyResult(p *SelectPlan, rs *mysql.Result) error {
 	}
 
 	// 存在聚合函数, 需要改写聚合列的值, 然后返回 (应该只有一行记录)
+	if len(r.Values) == 0 {
+		// 聚合函数下推了GROUP BY时, 各分片可能都没有返回结果, 这时需要补一行空的聚合结果
+		if p.aggregateGroupBy {
+			r.Values = append(r.Values, newEmptyAggregateRow(p, len(r.Fields)))
+			r.RowDatas = nil
+		}
+		return nil
+	}
+
 	isSet := false
 	var currRet ResultRow
 	for i, v := range r.Values {
@@ -526,7 +1010,7 @@ func buildSelectOnlyResult(p *SelectPlan, rs *mysql.Result) error {
 
 		retToMerge := ResultRow(r.Values[i])
 		for _, mfunc := range p.aggregateFuncs {
-			if err := mfunc.MergeTo(retToMerge, currRet); err != nil {
+			if err := mergeAggregateFunc(mfunc, r.Fields, retToMerge, currRet); err != nil {
 				return fmt.Errorf("MergeTo error, func: %v, value: %v, err: %v", mfunc, retToMerge, err)
 			}
 		}
@@ -539,6 +1023,109 @@ func buildSelectOnlyResult(p *SelectPlan, rs *mysql.Result) error {
 	return nil
 }
 
+// getDecimalColumns 返回类型为DECIMAL的聚合列和聚合函数使用的补充列
+func getDecimalColumns(p *SelectPlan, r *mysql.Result) []int {
+	var columns []int
+	for _, mfunc := range p.aggregateFuncs {
+		d, ok := mfunc.(aggregateFuncDecimalColumns)
+		if !ok {
+			continue
+		}
+		for _, c := range d.decimalColumns() {
+			if c < len(r.Fields) && r.Fields[c].Type == mysql.TypeNewDecimal {
+				columns = append(columns, c)
+			}
+		}
+	}
+	return columns
+}
+
+// convertDecimalColumns 合并前把DECIMAL列转换为MyDecimal.
+// text协议的DECIMAL值已经解析为float64, 需要从原始的行数据中重新解析, 避免丢失精度
+func convertDecimalColumns(r *mysql.Result, columns []int) error {
+	hasRowData := len(r.RowDatas) == len(r.Values)
+	for i, v := range r.Values {
+		var rowData mysql.RowData
+		if hasRowData {
+			rowData = r.RowDatas[i]
+		}
+		if err := convertDecimalRow(v, rowData, columns); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// convertDecimalRow 把一行中的DECIMAL列转换为MyDecimal, rowData为nil时使用解析后的值
+func convertDecimalRow(row ResultRow, rowData mysql.RowData, columns []int) error {
+	for _, c := range columns {
+		value := row.GetValue(c)
+		if value == nil {
+			continue
+		}
+		if _, ok := value.(float64); ok && rowData != nil {
+			text, isNull, err := rowData.GetTextColumn(c)
+			if err != nil {
+				return err
+			}
+			if !isNull {
+				value = text
+			}
+		}
+		d, err := ResultRow{value}.GetDecimal(0)
+		if err != nil {
+			return fmt.Errorf("convert column %d to decimal error: %v", c, err)
+		}
+		row.SetValue(c, d)
+	}
+	return nil
+}
+
+// formatDecimalColumns 合并后把MyDecimal按列定义的小数位数转换为文本
+func formatDecimalColumns(r *mysql.Result, columns []int) error {
+	for _, v := range r.Values {
+		row := ResultRow(v)
+		for _, c := range columns {
+			d, ok := row.GetValue(c).(*types.MyDecimal)
+			if !ok {
+				continue
+			}
+			rounded := new(types.MyDecimal)
+			if err := d.Round(rounded, int(r.Fields[c].Decimal), types.ModeHalfEven); err != nil {
+				return fmt.Errorf("round decimal column %d error: %v", c, err)
+			}
+			row.SetValue(c, rounded.ToString())
+		}
+	}
+	return nil
+}
+
+func newEmptyAggregateRow(p *SelectPlan, columnCount int) ResultRow {
+	row := make(ResultRow, columnCount)
+	for i, mfunc := range p.aggregateFuncs {
+		if i < columnCount {
+			row.SetValue(i, getEmptyAggregateValue(mfunc))
+		}
+	}
+	return row
+}
+
+// 计算合并完成后还需要计算最终结果的聚合列
+func finalizeAggregateFuncs(p *SelectPlan, r *mysql.Result) error {
+	for _, mfunc := range p.aggregateFuncs {
+		finalizer, ok := mfunc.(aggregateFuncFinalizer)
+		if !ok {
+			continue
+		}
+		for _, v := range r.Values {
+			if err := finalizer.Finalize(r.Fields, v); err != nil {
+				return fmt.Errorf("Finalize error, func: %v, err: %v", mfunc, err)
+			}
+		}
+	}
+	return nil
+}
+
 // this function modifies the first value of origin results
 func buildResultFromResultMap(r *mysql.Result, resultMap map[string]ResultRow) error {
 	// no group by result means the result row count is 0, so return the first result
@@ -578,7 +1165,11 @@ func sortSelectResult(p *SelectPlan, stmt *ast.SelectStmt, ret *mysql.Result) er
 		return nil
 	}
 
-	resultFieldLength := len(ret.Fields)
+	return ret.SortWithoutColumnName(getOrderBySortKeys(p, len(ret.Fields)))
+}
+
+// getOrderBySortKeys 根据结果集的列数计算ORDER BY列的位置
+func getOrderBySortKeys(p *SelectPlan, resultFieldLength int) []mysql.SortKey {
 	originColumnCount := p.GetColumnCount()
 	deltaColumnCount := resultFieldLength - originColumnCount
 
@@ -594,8 +1185,7 @@ func sortSelectResult(p *SelectPlan, stmt *ast.SelectStmt, ret *mysql.Result) er
 		}
 		sortKeys = append(sortKeys, sortKey)
 	}
-
-	return ret.SortWithoutColumnName(sortKeys)
+	return sortKeys
 }
 
 // the result from backend is aggregated and offset = 0, count = (originOffset + originCount)
@@ -629,18 +1219,9 @@ func GenerateSelectResultRowData(r *mysql.Result) error {
 			return fmt.Errorf("row %d has %d column not equal %d", i, len(vs), len(r.Fields))
 		}
 
-		var row []byte
-		for _, value := range vs {
-			// build row values
-			if value == nil {
-				row = append(row, 0xfb)
-			} else {
-				b, err := formatValue(value)
-				if err != nil {
-					return err
-				}
-				row = mysql.AppendLenEncStringBytes(row, b)
-			}
+		row, err := generateRowData(vs)
+		if err != nil {
+			return err
 		}
 
 		r.RowDatas = append(r.RowDatas, row)
@@ -649,6 +1230,24 @@ func GenerateSelectResultRowData(r *mysql.Result) error {
 	return nil
 }
 
+// generateRowData 根据一行的值构造text协议的RowData
+func generateRowData(vs []interface{}) (mysql.RowData, error) {
+	var row []byte
+	for _, value := range vs {
+		// build row values
+		if value == nil {
+			row = append(row, 0xfb)
+		} else {
+			b, err := formatValue(value)
+			if err != nil {
+				return nil, err
+			}
+			row = mysql.AppendLenEncStringBytes(row, b)
+		}
+	}
+	return row, nil
+}
+
 // copy from server.generateMapKey()
 func generateMapKey(groupColumns []interface{}) (string, error) {
 	bk := make([]byte, 0, 8)
@@ -704,6 +1303,8 @@ func formatValue(value interface{}) ([]byte, error) {
 		return v, nil
 	case string:
 		return hack.Slice(v), nil
+	case *types.MyDecimal:
+		return v.ToString(), nil
 	default:
 		return nil, fmt.Errorf("invalid type %T", value)
 	}
@@ -16,9 +16,11 @@ package plan
 
 import (
 	"fmt"
+	"reflect"
 	"testing"
 
 	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/parser"
 )
 
 func TestLimitSelectResult(t *testing.T) {
@@ -63,3 +65,388 @@ func TestLimitSelectResult(t *testing.T) {
 		})
 	}
 }
+
+func TestMergeAggregateFuncExtraFields(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	tests := []struct {
+		sql     string
+		results [][][]interface{} // 每个分片返回的行, 包含补充的列
+		values  [][]interface{}
+	}{
+		{
+			sql: "select avg(id) from tbl_mycat",
+			results: [][][]interface{}{
+				{{float64(2), float64(4), int64(2)}},
+				{{float64(6), float64(6), int64(1)}},
+				{{nil, nil, int64(0)}},
+			},
+			values: [][]interface{}{{float64(10) / 3}},
+		},
+		{
+			sql: "select user, avg(id) from tbl_mycat group by user order by user",
+			results: [][][]interface{}{
+				{{"a", float64(1), float64(1), int64(1)}, {"b", float64(3), float64(6), int64(2)}},
+				{{"a", float64(3), float64(3), int64(1)}},
+			},
+			values: [][]interface{}{{"a", float64(2)}, {"b", float64(3)}},
+		},
+		{
+			// 按user, id分组下推, 各分片中相同的user只计一次
+			sql: "select count(distinct user), sum(distinct id), avg(distinct id) from tbl_mycat",
+			results: [][][]interface{}{
+				{{int64(1), float64(1), float64(1), "a", int64(1), int64(1)}, {int64(1), float64(2), float64(2), "b", int64(2), int64(2)}},
+				{{int64(1), float64(1), float64(1), "a", int64(1), int64(1)}, {int64(0), float64(3), float64(3), nil, int64(3), int64(3)}},
+			},
+			values: [][]interface{}{{int64(2), int64(6), float64(2)}},
+		},
+		{
+			sql:     "select count(distinct user), sum(distinct id), group_concat(user) from tbl_mycat",
+			results: [][][]interface{}{{}, {}},
+			values:  [][]interface{}{{int64(0), nil, nil}},
+		},
+		{
+			sql: "select id, count(distinct user) from tbl_mycat group by id order by id limit 1",
+			results: [][][]interface{}{
+				{{int64(1), int64(1), "a"}, {int64(1), int64(1), "b"}, {int64(2), int64(1), "a"}},
+				{{int64(1), int64(1), "a"}},
+			},
+			values: [][]interface{}{{int64(1), int64(2)}},
+		},
+		{
+			sql: "select group_concat(user separator ';') from tbl_mycat",
+			results: [][][]interface{}{
+				{{"a;b"}},
+				{{nil}},
+				{{"c"}},
+			},
+			values: [][]interface{}{{"a;b;c"}},
+		},
+		{
+			// 每个(user, id)分组的行数由补充的COUNT(1)列给出
+			sql: "select group_concat(user order by id desc separator '|') from tbl_mycat",
+			results: [][][]interface{}{
+				{{"a|a", "a", int64(1), int64(2)}, {"c", "c", int64(3), int64(1)}},
+				{{"b", "b", int64(2), int64(1)}, {nil, nil, int64(4), int64(1)}},
+			},
+			values: [][]interface{}{{"c|b|a|a"}},
+		},
+		{
+			sql: "select group_concat(distinct user order by user) from tbl_mycat",
+			results: [][][]interface{}{
+				{{"b", "b", "b"}},
+				{{"a", "a", "a"}, {"b", "b", "b"}},
+			},
+			values: [][]interface{}{{"a,b"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.sql, func(t *testing.T) {
+			stmt, err := parser.ParseSQL(test.sql)
+			if err != nil {
+				t.Fatalf("parse sql error: %v", err)
+			}
+			p, err := BuildPlan(stmt, nil, "db_mycat", test.sql, ns.rt, ns.seqs)
+			if err != nil {
+				t.Fatalf("BuildPlan error: %v", err)
+			}
+			sp, ok := p.(*SelectPlan)
+			if !ok {
+				t.Fatalf("plan is not SelectPlan: %T", p)
+			}
+
+			var rs []*mysql.Result
+			for _, rows := range test.results {
+				r := &mysql.Resultset{}
+				for i := 0; i < sp.GetColumnCount(); i++ {
+					r.Fields = append(r.Fields, &mysql.Field{Name: []byte(fmt.Sprintf("c%d", i))})
+				}
+				r.Values = rows
+				rs = append(rs, &mysql.Result{Resultset: r})
+			}
+
+			ret, err := MergeSelectResult(sp, sp.GetStmt(), rs)
+			if err != nil {
+				t.Fatalf("MergeSelectResult error: %v", err)
+			}
+			if !reflect.DeepEqual(ret.Values, test.values) {
+				t.Errorf("values not equal, expect: %v, actual: %v", test.values, ret.Values)
+			}
+		})
+	}
+}
+
+func TestMergeHavingAndSelectExprs(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	tests := []struct {
+		sql      string
+		decimals []int             // DECIMAL类型的列
+		results  [][][]interface{} // 每个分片返回的行, 包含补充的列
+		values   [][]interface{}
+	}{
+		{
+			// DECIMAL聚合结果按精确值比较, 不按格式化后的文本比较
+			sql:      "select id, sum(a) as x, sum(b) as y from tbl_mycat group by id having x > y order by id",
+			decimals: []int{1, 2},
+			results: [][][]interface{}{
+				{{int64(1), "4.75", "5.00"}, {int64(2), "10.00", "9.99"}},
+				{{int64(1), "4.75", "5.25"}},
+			},
+			values: [][]interface{}{{int64(2), []byte("10.00"), []byte("9.99")}},
+		},
+		{
+			// 每个分片上都不满足HAVING, 合并后满足
+			sql: "select id, count(user) as c from tbl_mycat group by id having c > 2 order by id",
+			results: [][][]interface{}{
+				{{int64(1), int64(2)}, {int64(2), int64(1)}},
+				{{int64(1), int64(2)}, {int64(2), int64(1)}},
+			},
+			values: [][]interface{}{{int64(1), int64(4)}},
+		},
+		{
+			sql: "select id, sum(user) / count(user), max(user) - min(user) from tbl_mycat group by id having count(user) between 2 and 3 or id is null order by id",
+			results: [][][]interface{}{
+				{{int64(1), float64(5), int64(0), float64(5), int64(1), int64(5), int64(5)}, {int64(2), float64(2), int64(0), float64(2), int64(1), int64(2), int64(2)}},
+				{{int64(1), float64(4), int64(1), float64(8), int64(2), int64(5), int64(3)}},
+			},
+			values: [][]interface{}{{int64(1), float64(13) / 3, int64(2)}},
+		},
+		{
+			sql: "select round(avg(id), 1), ifnull(max(user), 'none') from tbl_mycat having sum(id) in (6, null)",
+			results: [][][]interface{}{
+				{{float64(2), "none", float64(1.5), float64(3), int64(2), nil, float64(3)}},
+				{{float64(3), "none", float64(3), float64(3), int64(1), nil, float64(3)}},
+			},
+			values: [][]interface{}{{float64(2), "none"}},
+		},
+		{
+			sql: "select count(id) from tbl_mycat having count(id) = 0",
+			results: [][][]interface{}{
+				{{int64(1)}},
+				{{int64(0)}},
+			},
+			values: [][]interface{}{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.sql, func(t *testing.T) {
+			stmt, err := parser.ParseSQL(test.sql)
+			if err != nil {
+				t.Fatalf("parse sql error: %v", err)
+			}
+			p, err := BuildPlan(stmt, nil, "db_mycat", test.sql, ns.rt, ns.seqs)
+			if err != nil {
+				t.Fatalf("BuildPlan error: %v", err)
+			}
+			sp := p.(*SelectPlan)
+
+			var rs []*mysql.Result
+			for _, rows := range test.results {
+				r := &mysql.Resultset{}
+				for i := 0; i < sp.GetColumnCount(); i++ {
+					r.Fields = append(r.Fields, &mysql.Field{Name: []byte(fmt.Sprintf("c%d", i))})
+				}
+				for _, i := range test.decimals {
+					r.Fields[i].Type = mysql.TypeNewDecimal
+					r.Fields[i].Decimal = 2
+				}
+				r.Values = rows
+				rs = append(rs, &mysql.Result{Resultset: r})
+			}
+
+			ret, err := MergeSelectResult(sp, sp.GetStmt(), rs)
+			if err != nil {
+				t.Fatalf("MergeSelectResult error: %v", err)
+			}
+			if !reflect.DeepEqual(ret.Values, test.values) {
+				t.Errorf("values not equal, expect: %v, actual: %v", test.values, ret.Values)
+			}
+		})
+	}
+}
+
+func TestMergeDecimalSum(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	decimalField := func(decimal uint8) *mysql.Field {
+		return &mysql.Field{Type: mysql.TypeNewDecimal, Decimal: decimal}
+	}
+
+	tests := []struct {
+		sql     string
+		fields  []*mysql.Field
+		results [][][]interface{} // 每个分片返回的行, text协议的DECIMAL值会从RowData中重新解析
+		values  [][]interface{}
+	}{
+		{
+			sql:    "select sum(amount) from tbl_mycat",
+			fields: []*mysql.Field{decimalField(2)},
+			results: [][][]interface{}{
+				{{"0.10"}},
+				{{"0.20"}},
+				{{nil}},
+				{{"12345678901234567.89"}},
+			},
+			values: [][]interface{}{{[]byte("12345678901234568.19")}},
+		},
+		{
+			sql:    "select user, sum(amount), avg(amount) from tbl_mycat group by user order by user",
+			fields: []*mysql.Field{{Type: mysql.TypeVarString}, decimalField(2), decimalField(6), decimalField(2), {Type: mysql.TypeLonglong}},
+			results: [][][]interface{}{
+				{{"a", "1.00", "1.000000", "1.00", "1"}, {"b", "0.10", "0.100000", "0.10", "1"}},
+				{{"a", "2.05", "1.025000", "2.05", "2"}},
+			},
+			values: [][]interface{}{
+				{"a", []byte("3.05"), []byte("1.016667")},
+				{"b", []byte("0.10"), []byte("0.100000")},
+			},
+		},
+		{
+			sql:    "select sum(distinct amount) from tbl_mycat",
+			fields: []*mysql.Field{decimalField(2), decimalField(2)},
+			results: [][][]interface{}{
+				{{"0.10", "0.10"}, {"0.20", "0.20"}},
+				{{"0.10", "0.10"}},
+			},
+			values: [][]interface{}{{[]byte("0.30")}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.sql, func(t *testing.T) {
+			stmt, err := parser.ParseSQL(test.sql)
+			if err != nil {
+				t.Fatalf("parse sql error: %v", err)
+			}
+			p, err := BuildPlan(stmt, nil, "db_mycat", test.sql, ns.rt, ns.seqs)
+			if err != nil {
+				t.Fatalf("BuildPlan error: %v", err)
+			}
+			sp := p.(*SelectPlan)
+
+			var rs []*mysql.Result
+			for _, rows := range test.results {
+				r := &mysql.Resultset{Fields: test.fields}
+				for _, row := range rows {
+					var rowData []byte
+					for _, v := range row {
+						if v == nil {
+							rowData = append(rowData, 0xfb)
+						} else {
+							rowData = mysql.AppendLenEncStringBytes(rowData, []byte(v.(string)))
+						}
+					}
+					values, err := mysql.RowData(rowData).ParseText(test.fields)
+					if err != nil {
+						t.Fatalf("parse row data error: %v", err)
+					}
+					r.RowDatas = append(r.RowDatas, rowData)
+					r.Values = append(r.Values, values)
+				}
+				rs = append(rs, &mysql.Result{Resultset: r})
+			}
+
+			ret, err := MergeSelectResult(sp, sp.GetStmt(), rs)
+			if err != nil {
+				t.Fatalf("MergeSelectResult error: %v", err)
+			}
+			if !reflect.DeepEqual(ret.Values, test.values) {
+				t.Errorf("values not equal, expect: %v, actual: %v", test.values, ret.Values)
+			}
+		})
+	}
+}
+
+func TestMergeCompareWithFieldType(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	generalCI := &mysql.Field{Type: mysql.TypeVarString, Charset: uint16(mysql.CollationIds["utf8mb4_general_ci"])}
+
+	tests := []struct {
+		sql     string
+		fields  []*mysql.Field
+		results [][][]interface{}
+		values  [][]interface{}
+	}{
+		{
+			sql:    "select user from tbl_mycat order by user",
+			fields: []*mysql.Field{generalCI},
+			results: [][][]interface{}{
+				{{"b"}, {"D"}},
+				{{"a"}, {"C"}},
+			},
+			values: [][]interface{}{{"a"}, {"b"}, {"C"}, {"D"}},
+		},
+		{
+			sql:    "select max(user), min(user) from tbl_mycat",
+			fields: []*mysql.Field{generalCI, generalCI},
+			results: [][][]interface{}{
+				{{"b", "b"}},
+				{{"C", "a"}},
+				{{nil, nil}},
+			},
+			values: [][]interface{}{{"C", "a"}},
+		},
+		{
+			sql:    "select max(amount), min(amount) from tbl_mycat",
+			fields: []*mysql.Field{{Type: mysql.TypeNewDecimal, Decimal: 2}, {Type: mysql.TypeNewDecimal, Decimal: 2}},
+			results: [][][]interface{}{
+				{{[]byte("9.99"), []byte("9.99")}},
+				{{[]byte("10.00"), []byte("-0.01")}},
+			},
+			values: [][]interface{}{{[]byte("10.00"), []byte("-0.01")}},
+		},
+		{
+			sql:    "select create_time from tbl_mycat order by create_time desc",
+			fields: []*mysql.Field{{Type: mysql.TypeDatetime}},
+			results: [][][]interface{}{
+				{{"2020-01-01 00:00:00.5"}},
+				{{"2020-01-01 00:00:00.10"}, {"2020-01-01 00:00:00"}},
+			},
+			values: [][]interface{}{{"2020-01-01 00:00:00.5"}, {"2020-01-01 00:00:00.10"}, {"2020-01-01 00:00:00"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.sql, func(t *testing.T) {
+			stmt, err := parser.ParseSQL(test.sql)
+			if err != nil {
+				t.Fatalf("parse sql error: %v", err)
+			}
+			p, err := BuildPlan(stmt, nil, "db_mycat", test.sql, ns.rt, ns.seqs)
+			if err != nil {
+				t.Fatalf("BuildPlan error: %v", err)
+			}
+			sp := p.(*SelectPlan)
+
+			var rs []*mysql.Result
+			for _, rows := range test.results {
+				r := &mysql.Resultset{Fields: test.fields, Values: rows}
+				rs = append(rs, &mysql.Result{Resultset: r})
+			}
+
+			ret, err := MergeSelectResult(sp, sp.GetStmt(), rs)
+			if err != nil {
+				t.Fatalf("MergeSelectResult error: %v", err)
+			}
+			if !reflect.DeepEqual(ret.Values, test.values) {
+				t.Errorf("values not equal, expect: %v, actual: %v", test.values, ret.Values)
+			}
+		})
+	}
+}
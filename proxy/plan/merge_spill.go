@@ -0,0 +1,608 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plan
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"unsafe"
+
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/tidb/types"
+
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/util/hack"
+)
+
+// spillPartitionCount GROUP BY结果超过内存上限时按分组列哈希分区的数量
+const spillPartitionCount = 32
+
+// spillMaxSplitLevel 一个分区聚合时超过内存上限则继续拆分, 拆分的最大层数, 超过后返回错误
+const spillMaxSplitLevel = 3
+
+// 临时文件中值的类型
+const (
+	spillValueNull byte = iota
+	spillValueInt64
+	spillValueUint64
+	spillValueFloat64
+	spillValueString
+	spillValueBytes
+	spillValueDecimal
+)
+
+// MergeSpiller 由Executor实现, 返回合并跨分片结果时每个查询的内存上限和临时文件目录, 并记录写入临时文件的字节数.
+// 未实现或者内存上限不大于0时在内存中合并全部结果
+type MergeSpiller interface {
+	GetMaxMergeMemory() int64
+	GetMergeSpillDir() string
+	RecordMergeSpill(byteCount int64)
+}
+
+func getMaxMergeMemory(sess Executor) int64 {
+	if s, ok := sess.(MergeSpiller); ok {
+		return s.GetMaxMergeMemory()
+	}
+	return 0
+}
+
+// canSpillMerge 是否可以在合并GROUP BY结果超过内存上限时按分组列分区写入临时文件.
+// SELECT DISTINCT需要对全部分组去重, 不能分区处理
+func (s *SelectPlan) canSpillMerge() bool {
+	return s.HasGroupBy() && !s.distinct
+}
+
+// MergeSelectResultSpill 逐行读取各分片的GROUP BY结果, 占用的内存不超过上限时与MergeSelectResult相同.
+// 超过上限后按分组列把各分片的行哈希到临时文件中, 逐个分区聚合并排序后写入临时文件,
+// 最后按ORDER BY归并各分区的结果, 返回的结果集通过Stream逐行读取, 关闭Stream时关闭所有临时文件
+func MergeSelectResultSpill(p *SelectPlan, stmt *ast.SelectStmt, rs []*mysql.Result, spiller MergeSpiller) (*mysql.Result, error) {
+	m := &spillMerger{
+		p:         p,
+		stmt:      stmt,
+		maxMemory: spiller.GetMaxMergeMemory(),
+		dir:       spiller.GetMergeSpillDir(),
+	}
+
+	ret, err := m.merge(rs)
+	if closeErr := closeResultStreams(rs); closeErr != nil && err == nil {
+		closeResultStreams([]*mysql.Result{ret})
+		err = closeErr
+	}
+	if m.spillBytes > 0 {
+		spiller.RecordMergeSpill(m.spillBytes)
+	}
+	if err != nil {
+		m.closeFiles()
+		return nil, err
+	}
+	return ret, nil
+}
+
+// spillMerger 合并GROUP BY结果时使用的临时文件
+type spillMerger struct {
+	p         *SelectPlan
+	stmt      *ast.SelectStmt
+	maxMemory int64
+	dir       string
+
+	fields         []*mysql.Field
+	decimalColumns []int
+	partitions     []*spillFile
+	files          []*spillFile // 创建的所有临时文件, 出错时关闭
+	spillBytes     int64
+}
+
+func (m *spillMerger) merge(rs []*mysql.Result) (*mysql.Result, error) {
+	var results []*mysql.Result
+	for _, r := range rs {
+		if r != nil && r.Resultset != nil {
+			results = append(results, r)
+		}
+	}
+	if len(results) == 0 {
+		return nil, fmt.Errorf("no resultset to merge")
+	}
+
+	m.fields = results[0].Fields
+	buffered := &mysql.Result{
+		Resultset: &mysql.Resultset{
+			Fields:     m.fields,
+			FieldNames: results[0].FieldNames,
+		},
+	}
+	m.decimalColumns = getDecimalColumns(m.p, buffered)
+
+	var memory int64
+	for i, r := range results {
+		if len(r.Fields) != len(m.fields) {
+			return nil, fmt.Errorf("column count of results not equal: %d, %d", len(r.Fields), len(m.fields))
+		}
+		c := &mergeCursor{index: i, result: r}
+		for {
+			if err := c.next(); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+
+			if m.partitions != nil {
+				if err := m.spillRow(c.rowData, c.values); err != nil {
+					return nil, err
+				}
+				c.release()
+				continue
+			}
+
+			buffered.Values = append(buffered.Values, c.values)
+			buffered.RowDatas = append(buffered.RowDatas, c.rowData)
+			memory += estimateRowMemory(c.rowData, c.values)
+			// 非流式的分片结果读取后释放引用, 写入临时文件后的行不再占用内存
+			c.release()
+			if memory > m.maxMemory {
+				if err := m.spillBuffered(buffered); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	var status uint16
+	for _, r := range results {
+		status |= r.Status
+	}
+
+	// 没有超过内存上限, 在内存中合并
+	if m.partitions == nil {
+		buffered.Status = status
+		return MergeSelectResult(m.p, m.stmt, []*mysql.Result{buffered})
+	}
+
+	runs, err := m.buildRuns()
+	if err != nil {
+		return nil, err
+	}
+	ret, err := MergeSelectResultStream(m.p, runs)
+	if err != nil {
+		return nil, err
+	}
+	ret.Status = status
+	return ret, nil
+}
+
+// spillBuffered 创建分区文件, 把内存中的行写入分区
+func (m *spillMerger) spillBuffered(buffered *mysql.Result) error {
+	partitions, err := m.newPartitions()
+	if err != nil {
+		return err
+	}
+	m.partitions = partitions
+
+	for i, v := range buffered.Values {
+		if err := m.spillRow(buffered.RowDatas[i], v); err != nil {
+			return err
+		}
+	}
+	buffered.Values = nil
+	buffered.RowDatas = nil
+	return nil
+}
+
+func (m *spillMerger) newPartitions() ([]*spillFile, error) {
+	partitions := make([]*spillFile, 0, spillPartitionCount)
+	for i := 0; i < spillPartitionCount; i++ {
+		f, err := m.newSpillFile()
+		if err != nil {
+			return nil, err
+		}
+		partitions = append(partitions, f)
+	}
+	return partitions, nil
+}
+
+// spillRow 按分组列的哈希值把一行写入对应的分区, 同一分组的行都在同一个分区中
+func (m *spillMerger) spillRow(rowData mysql.RowData, values []interface{}) error {
+	if len(values) != len(m.fields) {
+		return fmt.Errorf("row has %d column not equal %d", len(values), len(m.fields))
+	}
+	if err := convertDecimalRow(values, rowData, m.decimalColumns); err != nil {
+		return err
+	}
+	index, err := m.partitionIndex(values, 0)
+	if err != nil {
+		return err
+	}
+	return m.partitions[index].writeRow(values)
+}
+
+// partitionIndex 计算一行所在的分区, 每层拆分使用不同的哈希种子, 使拆分前在同一分区的行能分散到不同的子分区
+func (m *spillMerger) partitionIndex(values []interface{}, level int) (int, error) {
+	deltaColumnCount := len(m.fields) - m.p.GetColumnCount()
+	keySlice := make([]interface{}, 0, len(m.p.GetGroupByColumnInfo()))
+	for _, index := range m.p.GetGroupByColumnInfo() {
+		keySlice = append(keySlice, values[index+deltaColumnCount])
+	}
+	mk, err := generateMapKey(keySlice)
+	if err != nil {
+		return 0, err
+	}
+	h := fnv.New64a()
+	h.Write([]byte{byte(level)})
+	h.Write(hack.Slice(mk))
+	return int(mixHash(h.Sum64()) % spillPartitionCount), nil
+}
+
+// mixHash 即murmur3的fmix64, fnv哈希值的低位只受输入字节低位的影响, 混合后再取模
+func mixHash(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// buildRuns 逐个分区在内存中聚合并排序, 结果写入新的临时文件, 返回按ORDER BY有序的各分区结果
+func (m *spillMerger) buildRuns() ([]*mysql.Result, error) {
+	var runs []*mysql.Result
+	for _, partition := range m.partitions {
+		var err error
+		if runs, err = m.buildPartitionRuns(runs, partition, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	// 所有分组都被HAVING过滤时返回空结果
+	if len(runs) == 0 {
+		runs = append(runs, &mysql.Result{Resultset: &mysql.Resultset{Fields: m.fields}})
+	}
+	return runs, nil
+}
+
+// buildPartitionRuns 聚合一个分区, 结果写入新的临时文件后追加到runs.
+// 分区的行超过内存上限时拆分为子分区后逐个处理
+func (m *spillMerger) buildPartitionRuns(runs []*mysql.Result, partition *spillFile, level int) ([]*mysql.Result, error) {
+	r, subPartitions, err := m.aggregatePartition(partition, level)
+	if err != nil {
+		return nil, err
+	}
+	for _, sub := range subPartitions {
+		if runs, err = m.buildPartitionRuns(runs, sub, level+1); err != nil {
+			return nil, err
+		}
+	}
+	if r == nil || len(r.Values) == 0 {
+		return runs, nil
+	}
+
+	run, err := m.newSpillFile()
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range r.Values {
+		if err := run.writeRow(v); err != nil {
+			return nil, err
+		}
+	}
+	stream, err := run.rows()
+	if err != nil {
+		return nil, err
+	}
+	return append(runs, &mysql.Result{Resultset: &mysql.Resultset{Fields: m.fields, Stream: stream}}), nil
+}
+
+// aggregatePartition 读取一个分区的全部行, 聚合并排序后关闭分区文件.
+// 读取的行超过内存上限时把分区拆分为子分区返回, 拆分超过spillMaxSplitLevel层时说明单个分组的行过多, 返回错误
+func (m *spillMerger) aggregatePartition(partition *spillFile, level int) (*mysql.Result, []*spillFile, error) {
+	defer partition.Close()
+
+	r := &mysql.Result{Resultset: &mysql.Resultset{Fields: m.fields}}
+	if partition.rowCount != 0 {
+		stream, err := partition.rows()
+		if err != nil {
+			return nil, nil, err
+		}
+		var memory int64
+		for {
+			_, values, err := stream.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, nil, err
+			}
+			r.Values = append(r.Values, values)
+			memory += estimateRowMemory(nil, values)
+			if memory > m.maxMemory {
+				subPartitions, err := m.splitPartition(stream, r.Values, level)
+				return nil, subPartitions, err
+			}
+		}
+	}
+	if err := partition.Close(); err != nil {
+		return nil, nil, err
+	}
+	if len(r.Values) == 0 {
+		return r, nil, nil
+	}
+
+	if err := aggregateSelectResult(m.p, r, m.decimalColumns); err != nil {
+		return nil, nil, err
+	}
+	if err := sortSelectResult(m.p, m.stmt, r); err != nil {
+		return nil, nil, err
+	}
+	return r, nil, nil
+}
+
+// splitPartition 把已读取的行和分区中剩余的行按下一层的哈希值写入子分区
+func (m *spillMerger) splitPartition(stream *spillRowStream, read [][]interface{}, level int) ([]*spillFile, error) {
+	if level >= spillMaxSplitLevel {
+		return nil, fmt.Errorf("rows of one group exceed max merge memory %d", m.maxMemory)
+	}
+	subPartitions, err := m.newPartitions()
+	if err != nil {
+		return nil, err
+	}
+	writeRow := func(values []interface{}) error {
+		index, err := m.partitionIndex(values, level+1)
+		if err != nil {
+			return err
+		}
+		return subPartitions[index].writeRow(values)
+	}
+
+	for _, values := range read {
+		if err := writeRow(values); err != nil {
+			return nil, err
+		}
+	}
+	for {
+		_, values, err := stream.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if err := writeRow(values); err != nil {
+			return nil, err
+		}
+	}
+	return subPartitions, nil
+}
+
+func (m *spillMerger) newSpillFile() (*spillFile, error) {
+	f, err := newSpillFile(m.dir, &m.spillBytes)
+	if err != nil {
+		return nil, err
+	}
+	m.files = append(m.files, f)
+	return f, nil
+}
+
+func (m *spillMerger) closeFiles() {
+	for _, f := range m.files {
+		f.Close()
+	}
+}
+
+// estimateRowMemory 估算一行结果占用的内存
+func estimateRowMemory(rowData mysql.RowData, values []interface{}) int64 {
+	// 每个值按interface和切片头的大小估算
+	size := int64(len(rowData)) + int64(len(values))*24
+	for _, v := range values {
+		switch value := v.(type) {
+		case string:
+			size += int64(len(value))
+		case []byte:
+			size += int64(len(value))
+		case *types.MyDecimal:
+			size += int64(unsafe.Sizeof(*value))
+		}
+	}
+	return size
+}
+
+// spillFile 合并结果时使用的临时文件, 创建后立即删除文件名, 关闭文件或者进程退出后由操作系统回收空间
+type spillFile struct {
+	file     *os.File
+	writer   *bufio.Writer
+	buf      []byte
+	rowCount int64
+	written  *int64 // 写入的字节数, 同一个查询的临时文件共用
+	closed   bool
+}
+
+func newSpillFile(dir string, written *int64) (*spillFile, error) {
+	file, err := ioutil.TempFile(dir, "gaea-spill-")
+	if err != nil {
+		return nil, fmt.Errorf("create spill file error: %v", err)
+	}
+	if err := os.Remove(file.Name()); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("remove spill file error: %v", err)
+	}
+	return &spillFile{
+		file:    file,
+		writer:  bufio.NewWriter(file),
+		written: written,
+	}, nil
+}
+
+// writeRow 写入一行, 格式为列数和每列的类型及值
+func (f *spillFile) writeRow(values []interface{}) error {
+	buf := appendUvarint(f.buf[:0], uint64(len(values)))
+	for _, v := range values {
+		var err error
+		if buf, err = appendSpillValue(buf, v); err != nil {
+			return err
+		}
+	}
+	f.buf = buf
+
+	n, err := f.writer.Write(buf)
+	*f.written += int64(n)
+	if err != nil {
+		return fmt.Errorf("write spill file error: %v", err)
+	}
+	f.rowCount++
+	return nil
+}
+
+// rows 写入完成后从头读取临时文件, 关闭返回的Stream时关闭临时文件
+func (f *spillFile) rows() (*spillRowStream, error) {
+	if err := f.writer.Flush(); err != nil {
+		return nil, fmt.Errorf("flush spill file error: %v", err)
+	}
+	if _, err := f.file.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("seek spill file error: %v", err)
+	}
+	return &spillRowStream{file: f, reader: bufio.NewReader(f.file)}, nil
+}
+
+// Close close the spill file
+func (f *spillFile) Close() error {
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	return f.file.Close()
+}
+
+// spillRowStream 逐行读取临时文件, 实现mysql.RowStream, 返回的行数据为nil
+type spillRowStream struct {
+	file   *spillFile
+	reader *bufio.Reader
+}
+
+// Next implement mysql.RowStream
+func (s *spillRowStream) Next() (mysql.RowData, []interface{}, error) {
+	columnCount, err := binary.ReadUvarint(s.reader)
+	if err == io.EOF {
+		return nil, nil, io.EOF
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("read spill file error: %v", err)
+	}
+
+	values := make([]interface{}, columnCount)
+	for i := range values {
+		if values[i], err = readSpillValue(s.reader); err != nil {
+			return nil, nil, fmt.Errorf("read spill file error: %v", err)
+		}
+	}
+	return nil, values, nil
+}
+
+// Close implement mysql.RowStream
+func (s *spillRowStream) Close() error {
+	return s.file.Close()
+}
+
+func appendSpillValue(buf []byte, value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case nil:
+		return append(buf, spillValueNull), nil
+	case int64:
+		return appendVarint(append(buf, spillValueInt64), v), nil
+	case uint64:
+		return appendUvarint(append(buf, spillValueUint64), v), nil
+	case float64:
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], math.Float64bits(v))
+		return append(append(buf, spillValueFloat64), b[:]...), nil
+	case string:
+		buf = appendUvarint(append(buf, spillValueString), uint64(len(v)))
+		return append(buf, v...), nil
+	case []byte:
+		buf = appendUvarint(append(buf, spillValueBytes), uint64(len(v)))
+		return append(buf, v...), nil
+	case *types.MyDecimal:
+		s := v.ToString()
+		buf = appendUvarint(append(buf, spillValueDecimal), uint64(len(s)))
+		return append(buf, s...), nil
+	default:
+		return nil, fmt.Errorf("invalid type %T of spill value", value)
+	}
+}
+
+func readSpillValue(r *bufio.Reader) (interface{}, error) {
+	tp, err := r.ReadByte()
+	if err != nil {
+		return nil, noEOF(err)
+	}
+
+	switch tp {
+	case spillValueNull:
+		return nil, nil
+	case spillValueInt64:
+		v, err := binary.ReadVarint(r)
+		return v, noEOF(err)
+	case spillValueUint64:
+		v, err := binary.ReadUvarint(r)
+		return v, noEOF(err)
+	case spillValueFloat64:
+		var b [8]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return nil, noEOF(err)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b[:])), nil
+	case spillValueString, spillValueBytes, spillValueDecimal:
+		length, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, noEOF(err)
+		}
+		b := make([]byte, length)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, noEOF(err)
+		}
+		switch tp {
+		case spillValueString:
+			return string(b), nil
+		case spillValueBytes:
+			return b, nil
+		}
+		d := new(types.MyDecimal)
+		if err := d.FromString(b); err != nil {
+			return nil, err
+		}
+		return d, nil
+	default:
+		return nil, fmt.Errorf("invalid type %d of spill value", tp)
+	}
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	return append(buf, b[:n]...)
+}
+
+func appendVarint(buf []byte, v int64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(b[:], v)
+	return append(buf, b[:n]...)
+}
+
+// noEOF 一行没有读完时遇到文件结束说明文件不完整
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,321 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plan
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/pingcap/tidb/types"
+
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/parser"
+	"github.com/XiaoMi/Gaea/util"
+)
+
+type testMergeSpiller struct {
+	maxMemory  int64
+	dir        string
+	spillBytes int64
+	spillCount int
+}
+
+func (s *testMergeSpiller) GetMaxMergeMemory() int64 {
+	return s.maxMemory
+}
+
+func (s *testMergeSpiller) GetMergeSpillDir() string {
+	return s.dir
+}
+
+func (s *testMergeSpiller) RecordMergeSpill(byteCount int64) {
+	s.spillBytes += byteCount
+	s.spillCount++
+}
+
+// spillTestExecutor 在testExecutor的基础上设置合并结果的内存上限和临时文件目录
+type spillTestExecutor struct {
+	testExecutor
+	testMergeSpiller
+}
+
+// prepareSpillShards 返回各分片按user排序的分组结果, 各分片的分组有重叠
+func prepareSpillShards(t *testing.T) ([]*mysql.Field, [][][]interface{}) {
+	decimalField := func(decimal uint8) *mysql.Field {
+		return &mysql.Field{Type: mysql.TypeNewDecimal, Decimal: decimal}
+	}
+	fields := []*mysql.Field{{Type: mysql.TypeVarString}, decimalField(2), decimalField(6), decimalField(2), {Type: mysql.TypeLonglong}}
+
+	var shards [][][]interface{}
+	for s := 0; s < 3; s++ {
+		var rows [][]interface{}
+		for i := s * 50; i < 200+s*50; i++ {
+			amount := fmt.Sprintf("%d.%02d", i, s)
+			text := []string{fmt.Sprintf("user_%04d", i), amount, amount, amount, "1"}
+			var rowData mysql.RowData
+			for _, v := range text {
+				rowData = mysql.AppendLenEncStringBytes(rowData, []byte(v))
+			}
+			values, err := rowData.ParseText(fields)
+			if err != nil {
+				t.Fatalf("parse row data error: %v", err)
+			}
+			rows = append(rows, values)
+		}
+		shards = append(shards, rows)
+	}
+	return fields, shards
+}
+
+// bufferedSpillResults 复制各分片的行, 返回非流式的分片结果
+func bufferedSpillResults(t *testing.T, fields []*mysql.Field, shards [][][]interface{}) []*mysql.Result {
+	var rs []*mysql.Result
+	for _, rows := range shards {
+		r := &mysql.Resultset{Fields: fields}
+		for _, row := range rows {
+			values := append([]interface{}{}, row...)
+			rowData, err := generateRowData(values)
+			if err != nil {
+				t.Fatalf("generate row data error: %v", err)
+			}
+			r.Values = append(r.Values, values)
+			r.RowDatas = append(r.RowDatas, rowData)
+		}
+		rs = append(rs, &mysql.Result{Resultset: r})
+	}
+	return rs
+}
+
+func TestMergeSelectResultSpill(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+	fields, shards := prepareSpillShards(t)
+
+	tests := []struct {
+		sql       string
+		maxMemory int64
+		spilled   bool
+		hasErr    bool
+	}{
+		{"select user, sum(amount), avg(amount) from tbl_mycat group by user order by user", 2048, true, false},
+		{"select user, sum(amount), avg(amount) from tbl_mycat group by user order by user desc limit 10, 20", 2048, true, false},
+		{"select user, sum(amount), avg(amount) from tbl_mycat group by user having sum(amount) > 100 order by user limit 5", 4096, true, false},
+		{"select user, sum(amount), avg(amount) from tbl_mycat group by user having sum(amount) < 0", 2048, true, false},
+		{"select user, sum(amount), avg(amount) from tbl_mycat group by user order by user", 1 << 30, false, false},
+		// 分区超过内存上限时拆分为子分区, 每个子分区的行仍然超过内存上限
+		{"select user, sum(amount), avg(amount) from tbl_mycat group by user order by user", 1024, true, false},
+		// 同一分组的多行超过内存上限, 无法继续拆分
+		{"select user, sum(amount), avg(amount) from tbl_mycat group by user order by user", 1, true, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.sql, func(t *testing.T) {
+			stmt, err := parser.ParseSQL(test.sql)
+			if err != nil {
+				t.Fatalf("parse sql error: %v", err)
+			}
+			p, err := BuildPlan(stmt, nil, "db_mycat", test.sql, ns.rt, ns.seqs)
+			if err != nil {
+				t.Fatalf("BuildPlan error: %v", err)
+			}
+			sp := p.(*SelectPlan)
+			if !sp.canSpillMerge() {
+				t.Fatalf("plan can not merge with spill")
+			}
+
+			expect, err := MergeSelectResult(sp, sp.GetStmt(), bufferedSpillResults(t, fields, shards))
+			if err != nil {
+				t.Fatalf("MergeSelectResult error: %v", err)
+			}
+
+			var rs []*mysql.Result
+			var streams []*testRowStream
+			for _, rows := range shards {
+				var copied [][]interface{}
+				for _, row := range rows {
+					copied = append(copied, append([]interface{}{}, row...))
+				}
+				s := &testRowStream{rows: copied}
+				streams = append(streams, s)
+				rs = append(rs, &mysql.Result{Resultset: &mysql.Resultset{Fields: fields, Stream: s}})
+			}
+
+			spiller := &testMergeSpiller{maxMemory: test.maxMemory, dir: t.TempDir()}
+			ret, err := MergeSelectResultSpill(sp, sp.GetStmt(), rs, spiller)
+			if test.hasErr {
+				if err == nil {
+					t.Fatalf("MergeSelectResultSpill should fail")
+				}
+			} else if err != nil {
+				t.Fatalf("MergeSelectResultSpill error: %v", err)
+			}
+			for i, s := range streams {
+				if !s.closed {
+					t.Errorf("stream %d not closed", i)
+				}
+			}
+			if (spiller.spillCount != 0) != test.spilled || (spiller.spillBytes > 0) != test.spilled {
+				t.Errorf("spill not expected, spill count: %d, spill bytes: %d", spiller.spillCount, spiller.spillBytes)
+			}
+			// 临时文件创建后立即删除, 目录中不会留下文件
+			if files, err := ioutil.ReadDir(spiller.dir); err != nil || len(files) != 0 {
+				t.Errorf("spill dir not empty: %v, %v", files, err)
+			}
+			if test.hasErr {
+				return
+			}
+
+			ret, err = fetchStreamResult(ret)
+			if err != nil {
+				t.Fatalf("fetch stream result error: %v", err)
+			}
+			if len(ret.Fields) != len(expect.Fields) {
+				t.Errorf("field count not equal, expect: %d, actual: %d", len(expect.Fields), len(ret.Fields))
+			}
+			if !sp.HasOrderBy() {
+				sortKeys := []mysql.SortKey{{Column: 0, Direction: mysql.SortAsc}}
+				expect.SortWithoutColumnName(sortKeys)
+				ret.SortWithoutColumnName(sortKeys)
+			}
+			if len(ret.Values) == 0 && len(expect.Values) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(ret.Values, expect.Values) {
+				t.Errorf("values not equal, expect: %v, actual: %v", expect.Values, ret.Values)
+			}
+			if !reflect.DeepEqual(ret.RowDatas, expect.RowDatas) {
+				t.Errorf("row data not equal")
+			}
+		})
+	}
+}
+
+func TestSelectPlanExecuteInSpill(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+	fields, shards := prepareSpillShards(t)
+
+	sql := "select user, sum(amount), avg(amount) from tbl_mycat group by user order by user limit 10, 20"
+	stmt, err := parser.ParseSQL(sql)
+	if err != nil {
+		t.Fatalf("parse sql error: %v", err)
+	}
+	p, err := BuildPlan(stmt, nil, "db_mycat", sql, ns.rt, ns.seqs)
+	if err != nil {
+		t.Fatalf("BuildPlan error: %v", err)
+	}
+	sp := p.(*SelectPlan)
+	expect, err := MergeSelectResult(sp, sp.GetStmt(), bufferedSpillResults(t, fields, shards))
+	if err != nil {
+		t.Fatalf("MergeSelectResult error: %v", err)
+	}
+
+	// db_mycat_0 - db_mycat_2返回各分片的GROUP BY结果, db_mycat_3没有数据
+	results := bufferedSpillResults(t, fields, shards)
+	e := &spillTestExecutor{
+		testExecutor: testExecutor{handle: func(slice, db, sql string) (*mysql.Result, error) {
+			if index := int(db[len(db)-1] - '0'); index < len(results) {
+				return results[index], nil
+			}
+			return &mysql.Result{Resultset: &mysql.Resultset{Fields: fields}}, nil
+		}},
+		testMergeSpiller: testMergeSpiller{maxMemory: 2048, dir: t.TempDir()},
+	}
+	ret, err := sp.ExecuteIn(util.NewRequestContext(), e)
+	if err != nil {
+		t.Fatalf("ExecuteIn error: %v", err)
+	}
+	if e.spillCount == 0 {
+		t.Errorf("result not spilled")
+	}
+	// 写入临时文件后释放分片结果的行
+	for i, r := range results {
+		for j, v := range r.Values {
+			if v != nil || r.RowDatas[j] != nil {
+				t.Fatalf("row %d of result %d not released", j, i)
+			}
+		}
+	}
+	if ret.Stream != nil {
+		t.Errorf("result of ExecuteIn should not be stream")
+	}
+	if !reflect.DeepEqual(ret.Values, expect.Values) {
+		t.Errorf("values not equal, expect: %v, actual: %v", expect.Values, ret.Values)
+	}
+	if !reflect.DeepEqual(ret.RowDatas, expect.RowDatas) {
+		t.Errorf("row data not equal")
+	}
+}
+
+func TestSpillFileRows(t *testing.T) {
+	var written int64
+	f, err := newSpillFile(t.TempDir(), &written)
+	if err != nil {
+		t.Fatalf("create spill file error: %v", err)
+	}
+	defer f.Close()
+
+	d := new(types.MyDecimal)
+	if err := d.FromString([]byte("-12345678901234567890.123456789")); err != nil {
+		t.Fatalf("parse decimal error: %v", err)
+	}
+	rows := [][]interface{}{
+		{nil, int64(-1), uint64(18446744073709551615), float64(0.1), "abc", []byte("\x00\xff"), d},
+		{},
+		{"", []byte{}, int64(0)},
+	}
+	for _, row := range rows {
+		if err := f.writeRow(row); err != nil {
+			t.Fatalf("write row error: %v", err)
+		}
+	}
+	if err := f.writeRow([]interface{}{int32(1)}); err == nil {
+		t.Errorf("write row with invalid type should fail")
+	}
+
+	stream, err := f.rows()
+	if err != nil {
+		t.Fatalf("read spill file error: %v", err)
+	}
+	var actual [][]interface{}
+	for {
+		rowData, values, err := stream.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("read row error: %v", err)
+		}
+		if rowData != nil {
+			t.Errorf("row data should be nil")
+		}
+		actual = append(actual, values)
+	}
+	if !reflect.DeepEqual(actual, rows) {
+		t.Errorf("rows not equal, expect: %v, actual: %v", rows, actual)
+	}
+	if written == 0 {
+		t.Errorf("written bytes not recorded")
+	}
+	if err := stream.Close(); err != nil {
+		t.Errorf("close spill file error: %v", err)
+	}
+}
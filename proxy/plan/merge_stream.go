@@ -0,0 +1,288 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plan
+
+import (
+	"container/heap"
+	"fmt"
+	"io"
+
+	"github.com/XiaoMi/Gaea/mysql"
+)
+
+// MergeSelectResultStream 按ORDER BY归并各分片已经排好序的结果, 返回的结果集通过Stream逐行读取.
+// 分片结果可以通过Stream逐行读取, 也可以是已经读取的全部结果. 出错时关闭所有分片结果的Stream
+func MergeSelectResultStream(p *SelectPlan, rs []*mysql.Result) (*mysql.Result, error) {
+	s, err := newOrderedMergeStream(p, rs)
+	if err != nil {
+		closeResultStreams(rs)
+		return nil, err
+	}
+
+	r := &mysql.Resultset{
+		Fields:     s.fields[:s.columnCount],
+		FieldNames: make(map[string]int, s.columnCount),
+		Stream:     s,
+	}
+	for i, f := range r.Fields {
+		r.FieldNames[string(f.Name)] = i
+	}
+
+	ret := &mysql.Result{Resultset: r}
+	for _, result := range s.results {
+		ret.Status |= result.Status
+	}
+	return ret, nil
+}
+
+// mergeCursor 一个分片结果的当前行
+type mergeCursor struct {
+	index   int // 分片结果的序号, 排序值相同时按序号返回
+	result  *mysql.Result
+	row     int // 已经读取的全部结果中下一行的位置
+	rowData mysql.RowData
+	values  []interface{}
+}
+
+// next 读取分片结果的下一行, 没有更多的行时返回io.EOF
+func (c *mergeCursor) next() error {
+	if c.result.Stream != nil {
+		rowData, values, err := c.result.Stream.Next()
+		if err != nil {
+			return err
+		}
+		c.rowData, c.values = rowData, values
+		return nil
+	}
+
+	if c.row >= len(c.result.Values) {
+		return io.EOF
+	}
+	c.values = c.result.Values[c.row]
+	c.rowData = nil
+	if len(c.result.RowDatas) == len(c.result.Values) {
+		c.rowData = c.result.RowDatas[c.row]
+	}
+	c.row++
+	return nil
+}
+
+// release 释放非流式结果中已经读取的行的引用
+func (c *mergeCursor) release() {
+	if c.result.Stream != nil || c.row == 0 {
+		return
+	}
+	c.result.Values[c.row-1] = nil
+	if len(c.result.RowDatas) == len(c.result.Values) {
+		c.result.RowDatas[c.row-1] = nil
+	}
+}
+
+// mergeHeap 以各分片结果的当前行为元素的最小堆, 堆顶为按ORDER BY排序的下一行
+type mergeHeap struct {
+	cursors  []*mergeCursor
+	fields   []*mysql.Field
+	sortKeys []mysql.SortKey
+}
+
+func (h *mergeHeap) Len() int {
+	return len(h.cursors)
+}
+
+func (h *mergeHeap) Less(i, j int) bool {
+	c1, c2 := h.cursors[i], h.cursors[j]
+	if v := h.compareRows(c1.values, c2.values); v != 0 {
+		return v < 0
+	}
+	return c1.index < c2.index
+}
+
+// compareRows 按ORDER BY比较两行, 返回值小于0表示v1排在v2前面
+func (h *mergeHeap) compareRows(v1, v2 []interface{}) int {
+	for _, k := range h.sortKeys {
+		v := mysql.CompareFieldValue(getResultField(h.fields, k.Column), v1[k.Column], v2[k.Column])
+		if k.Direction == mysql.SortDesc {
+			v = -v
+		}
+		if v != 0 {
+			return v
+		}
+	}
+	return 0
+}
+
+// advance 堆顶的分片结果读取下一行, 没有更多的行时从堆中移除
+func (h *mergeHeap) advance() error {
+	if err := h.cursors[0].next(); err == io.EOF {
+		heap.Pop(h)
+	} else if err != nil {
+		return err
+	} else {
+		heap.Fix(h, 0)
+	}
+	return nil
+}
+
+func (h *mergeHeap) Swap(i, j int) {
+	h.cursors[i], h.cursors[j] = h.cursors[j], h.cursors[i]
+}
+
+func (h *mergeHeap) Push(x interface{}) {
+	h.cursors = append(h.cursors, x.(*mergeCursor))
+}
+
+func (h *mergeHeap) Pop() interface{} {
+	n := len(h.cursors)
+	c := h.cursors[n-1]
+	h.cursors = h.cursors[:n-1]
+	return c
+}
+
+// orderedMergeStream 按ORDER BY归并各分片结果的mysql.RowStream, 处理LIMIT并去掉补充列.
+// 返回offset+count行之后不再读取分片结果
+type orderedMergeStream struct {
+	results     []*mysql.Result
+	heap        *mergeHeap
+	fields      []*mysql.Field
+	columnCount int   // 返回的列数, 不包含补充列
+	offset      int64 // 还需要跳过的行数
+	count       int64 // 还可以返回的行数, 小于0表示没有LIMIT
+	err         error
+}
+
+func newOrderedMergeStream(p *SelectPlan, rs []*mysql.Result) (*orderedMergeStream, error) {
+	var results []*mysql.Result
+	for _, r := range rs {
+		if r != nil && r.Resultset != nil {
+			results = append(results, r)
+		}
+	}
+	if len(results) == 0 {
+		return nil, fmt.Errorf("no resultset to merge")
+	}
+
+	fields := results[0].Fields
+	for _, r := range results {
+		if len(r.Fields) != len(fields) {
+			return nil, fmt.Errorf("column count of results not equal: %d, %d", len(r.Fields), len(fields))
+		}
+	}
+
+	deltaColumnCount := len(fields) - p.GetColumnCount()
+	s := &orderedMergeStream{
+		results:     results,
+		fields:      fields,
+		columnCount: deltaColumnCount + p.GetOriginColumnCount(),
+		count:       -1,
+		heap: &mergeHeap{
+			fields:   fields,
+			sortKeys: getOrderBySortKeys(p, len(fields)),
+		},
+	}
+	if s.columnCount < 0 || s.columnCount > len(fields) {
+		return nil, fmt.Errorf("invalid column count %d of results with %d fields", s.columnCount, len(fields))
+	}
+	if p.HasLimit() {
+		s.offset, s.count = p.GetLimitValue()
+	}
+
+	for i, r := range results {
+		c := &mergeCursor{index: i, result: r}
+		if err := c.next(); err == io.EOF {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		s.heap.cursors = append(s.heap.cursors, c)
+	}
+	heap.Init(s.heap)
+	return s, nil
+}
+
+// Next implement mysql.RowStream
+func (s *orderedMergeStream) Next() (mysql.RowData, []interface{}, error) {
+	if s.err != nil {
+		return nil, nil, s.err
+	}
+
+	for s.count != 0 && s.heap.Len() != 0 {
+		c := s.heap.cursors[0]
+		rowData, values := c.rowData, c.values
+		if err := s.heap.advance(); err != nil {
+			s.err = err
+			return nil, nil, err
+		}
+
+		if s.offset > 0 {
+			s.offset--
+			continue
+		}
+		if s.count > 0 {
+			s.count--
+		}
+
+		rowData, values, err := s.trimExtraColumns(rowData, values)
+		if err != nil {
+			s.err = err
+			return nil, nil, err
+		}
+		return rowData, values, nil
+	}
+	return nil, nil, io.EOF
+}
+
+// Close implement mysql.RowStream
+func (s *orderedMergeStream) Close() error {
+	return closeResultStreams(s.results)
+}
+
+// trimExtraColumns 去掉补充列, 分片返回的text协议行数据直接截取, 没有行数据时根据值重新生成
+func (s *orderedMergeStream) trimExtraColumns(rowData mysql.RowData, values []interface{}) (mysql.RowData, []interface{}, error) {
+	if len(values) != len(s.fields) {
+		return nil, nil, fmt.Errorf("row has %d column not equal %d", len(values), len(s.fields))
+	}
+	values = values[:s.columnCount]
+
+	if rowData == nil {
+		ret, err := generateRowData(values)
+		return ret, values, err
+	}
+
+	if s.columnCount < len(s.fields) {
+		pos := 0
+		for i := 0; i < s.columnCount; i++ {
+			var ok bool
+			if _, pos, _, ok = mysql.ReadLenEncStringAsBytes(rowData, pos); !ok {
+				return nil, nil, fmt.Errorf("read column %d of row data failed", i)
+			}
+		}
+		rowData = rowData[:pos]
+	}
+	return rowData, values, nil
+}
+
+// closeResultStreams 关闭结果集中的Stream, 返回第一个错误
+func closeResultStreams(rs []*mysql.Result) error {
+	var ret error
+	for _, r := range rs {
+		if r == nil || r.Resultset == nil || r.Stream == nil {
+			continue
+		}
+		if err := r.Stream.Close(); err != nil && ret == nil {
+			ret = err
+		}
+	}
+	return ret
+}
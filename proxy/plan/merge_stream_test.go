@@ -0,0 +1,165 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plan
+
+import (
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/parser"
+)
+
+type testRowStream struct {
+	rows   [][]interface{}
+	reads  int
+	closed bool
+}
+
+func (s *testRowStream) Next() (mysql.RowData, []interface{}, error) {
+	if s.reads >= len(s.rows) {
+		return nil, nil, io.EOF
+	}
+	values := s.rows[s.reads]
+	s.reads++
+	rowData, err := generateRowData(values)
+	return rowData, values, err
+}
+
+func (s *testRowStream) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestMergeSelectResultStream(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	fields := []*mysql.Field{
+		{Name: []byte("user"), Type: mysql.TypeVarString},
+		{Name: []byte("id"), Type: mysql.TypeLonglong},
+	}
+
+	tests := []struct {
+		sql     string
+		streams [][][]interface{} // 流式读取的分片结果
+		results [][][]interface{} // 已经读取全部行的分片结果
+		values  [][]interface{}
+		reads   []int // 每个流式结果读取的行数
+	}{
+		{
+			sql: "select user from tbl_mycat order by id desc limit 1, 2",
+			streams: [][][]interface{}{
+				{{"c", int64(5)}, {"a", int64(3)}, {"x", int64(1)}},
+				{{"e", int64(9)}, {"f", int64(8)}, {"g", int64(7)}, {"h", int64(6)}},
+			},
+			results: [][][]interface{}{
+				{{"b", int64(4)}, {"d", int64(2)}},
+			},
+			values: [][]interface{}{{"f"}, {"g"}},
+			reads:  []int{1, 4},
+		},
+		{
+			sql: "select user, id from tbl_mycat order by user, id",
+			streams: [][][]interface{}{
+				{{"a", int64(2)}, {"c", int64(1)}},
+				{},
+			},
+			results: [][][]interface{}{
+				{{nil, int64(3)}, {"a", int64(1)}, {"b", int64(0)}},
+			},
+			values: [][]interface{}{{nil, int64(3)}, {"a", int64(1)}, {"a", int64(2)}, {"b", int64(0)}, {"c", int64(1)}},
+			reads:  []int{2, 0},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.sql, func(t *testing.T) {
+			stmt, err := parser.ParseSQL(test.sql)
+			if err != nil {
+				t.Fatalf("parse sql error: %v", err)
+			}
+			p, err := BuildPlan(stmt, nil, "db_mycat", test.sql, ns.rt, ns.seqs)
+			if err != nil {
+				t.Fatalf("BuildPlan error: %v", err)
+			}
+			sp := p.(*SelectPlan)
+			if !sp.canStreamMerge() {
+				t.Fatalf("plan can not merge by stream")
+			}
+
+			var rs []*mysql.Result
+			var streams []*testRowStream
+			for _, rows := range test.streams {
+				s := &testRowStream{rows: rows}
+				streams = append(streams, s)
+				rs = append(rs, &mysql.Result{Resultset: &mysql.Resultset{Fields: fields, Stream: s}})
+			}
+			for _, rows := range test.results {
+				r := &mysql.Resultset{Fields: fields, Values: rows}
+				for _, row := range rows {
+					rowData, err := generateRowData(row)
+					if err != nil {
+						t.Fatalf("generate row data error: %v", err)
+					}
+					r.RowDatas = append(r.RowDatas, rowData)
+				}
+				rs = append(rs, &mysql.Result{Resultset: r})
+			}
+
+			ret, err := MergeSelectResultStream(sp, rs)
+			if err != nil {
+				t.Fatalf("MergeSelectResultStream error: %v", err)
+			}
+
+			values := [][]interface{}{}
+			for {
+				rowData, v, err := ret.Stream.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("read stream error: %v", err)
+				}
+				expectRowData, _ := generateRowData(v)
+				if !reflect.DeepEqual(rowData, expectRowData) {
+					t.Errorf("row data not equal, expect: %v, actual: %v", expectRowData, rowData)
+				}
+				values = append(values, v)
+			}
+			if err := ret.Stream.Close(); err != nil {
+				t.Fatalf("close stream error: %v", err)
+			}
+
+			if len(ret.Fields) != len(test.values[0]) {
+				t.Errorf("field count not equal, expect: %d, actual: %d", len(test.values[0]), len(ret.Fields))
+			}
+			if !reflect.DeepEqual(values, test.values) {
+				t.Errorf("values not equal, expect: %v, actual: %v", test.values, values)
+			}
+			for i, s := range streams {
+				if !s.closed {
+					t.Errorf("stream %d not closed", i)
+				}
+				if s.reads != test.reads[i] {
+					t.Errorf("stream %d read rows not equal, expect: %d, actual: %d", i, test.reads[i], s.reads)
+				}
+			}
+		})
+	}
+}
@@ -35,6 +35,10 @@ var _ Plan = &DeletePlan{}
 var _ Plan = &UpdatePlan{}
 var _ Plan = &InsertPlan{}
 var _ Plan = &SelectLastInsertIDPlan{}
+var _ Plan = &JoinPlan{}
+var _ Plan = &SubqueryPlan{}
+var _ Plan = &UnionPlan{}
+var _ StreamPlan = &SelectPlan{}
 
 // Plan is a interface for select/insert etc.
 type Plan interface {
@@ -44,6 +48,18 @@ type Plan interface {
 	Size() int
 }
 
+// StreamPlan 由可以流式返回结果的执行计划实现
+type StreamPlan interface {
+	// ExecuteStreamIn 与ExecuteIn相同, 但是返回的结果集可能通过Resultset.Stream逐行读取,
+	// 调用方需要读取完成或者关闭Stream以释放后端连接
+	ExecuteStreamIn(*util.RequestContext, Executor) (*mysql.Result, error)
+}
+
+// StreamExecutor 由Executor实现, 执行分片SQL, 返回的结果集可能通过Resultset.Stream逐行读取
+type StreamExecutor interface {
+	ExecuteSQLsStream(*util.RequestContext, map[string]map[string][]string) ([]*mysql.Result, error)
+}
+
 // Executor TODO: move to package executor
 type Executor interface {
 
@@ -186,6 +202,18 @@ func BuildPlan(stmt ast.StmtNode, phyDBs map[string]string, db, sql string, rout
 	}
 
 	if checker.IsShard() {
+		if union, ok := stmt.(*ast.UnionStmt); ok {
+			return buildUnionPlan(union, phyDBs, db, router, seq)
+		}
+		if insert, ok := stmt.(*ast.InsertStmt); ok && insert.Select != nil {
+			return buildInsertSelectPlan(insert, phyDBs, db, sql, router, seq)
+		}
+		if load, ok := stmt.(*ast.LoadDataStmt); ok {
+			return buildLoadDataPlan(load, db, sql, router, seq)
+		}
+		if subqueries := getWhereSubqueries(stmt); len(subqueries) != 0 {
+			return buildSubqueryPlan(stmt, subqueries, phyDBs, db, sql, router, seq)
+		}
 		return buildShardPlan(stmt, db, sql, router, seq)
 	}
 	return CreateUnshardPlan(stmt, phyDBs, db, checker.GetUnshardTableNames())
@@ -194,6 +222,13 @@ func BuildPlan(stmt ast.StmtNode, phyDBs map[string]string, db, sql string, rout
 func buildShardPlan(stmt ast.StmtNode, db string, sql string, router *router.Router, seq *sequence.SequenceManager) (Plan, error) {
 	switch s := stmt.(type) {
 	case *ast.SelectStmt:
+		joinPlan, err := buildJoinPlan(s, db, router)
+		if err != nil {
+			return nil, err
+		}
+		if joinPlan != nil {
+			return joinPlan, nil
+		}
 		plan := NewSelectPlan(db, sql, router)
 		if err := HandleSelectStmt(plan, s); err != nil {
 			return nil, err
@@ -298,13 +333,9 @@ func (s *StmtInfo) checkStmtRouteResult(rule router.Rule) error {
 		return nil
 	}
 
+	// 关联表使用父表, 绑定表使用绑定表组的第一个表
 	db := rule.GetDB()
-	var table string
-	if linkedRule, ok := rule.(*router.LinkedRule); ok {
-		table = linkedRule.GetParentTable()
-	} else {
-		table = rule.GetTable()
-	}
+	table := rule.GetRouteTable()
 
 	if s.result.db == "" && s.result.table == "" {
 		s.result.db = db
@@ -324,7 +355,7 @@ func (s *StmtInfo) getSettedRuleByColumnName(column string) (router.Rule, bool,
 	var columnExistsInShardingTables int // 记录分片表名出现在分片表中分片列的次数
 	var ret router.Rule
 	for _, r := range s.tableRules {
-		if r.GetShardingColumn() == column {
+		if r.IsShardingColumn(column) {
 			columnExistsInShardingTables++
 			ret = r
 		}
@@ -418,7 +449,7 @@ func (t *TableAliasStmtInfo) getSettedRuleByColumnName(column string) (router.Ru
 	var columnExistsInShardingTables int // 记录分片表名出现在分片表中分片列的次数
 	var ret router.Rule
 	for _, r := range t.tableRules {
-		if r.GetShardingColumn() == column {
+		if r.IsShardingColumn(column) {
 			columnExistsInShardingTables++
 			ret = r
 		}
@@ -558,7 +589,7 @@ func newEmptyResultset(info *SelectPlan, stmt *ast.SelectStmt) *mysql.Resultset
 	fieldLen -= info.columnCount - info.originColumnCount
 
 	r.Fields = make([]*mysql.Field, fieldLen)
-	for i, expr := range stmt.Fields.Fields {
+	for i, expr := range stmt.Fields.Fields[:fieldLen] {
 		r.Fields[i] = &mysql.Field{}
 		if expr.WildCard != nil {
 			r.Fields[i].Name = []byte("*")
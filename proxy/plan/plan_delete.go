@@ -28,8 +28,9 @@ type DeletePlan struct {
 	basePlan
 	*TableAliasStmtInfo
 
-	stmt *ast.DeleteStmt
-	sqls map[string]map[string][]string
+	stmt   *ast.DeleteStmt
+	sqls   map[string]map[string][]string
+	lookup *lookupPlan // 通过映射表计算路由或者需要维护映射表时不为nil
 }
 
 // NewDeletePlan constructor of DeletePlan
@@ -47,6 +48,14 @@ func (p *DeletePlan) ExecuteIn(reqCtx *util.RequestContext, sess Executor) (*mys
 		return nil, fmt.Errorf("SQL has not generated")
 	}
 
+	if p.lookup != nil {
+		r, err := p.lookup.execute(reqCtx, sess)
+		if err != nil {
+			return nil, fmt.Errorf("execute lookup in DeletePlan error: %v", err)
+		}
+		return r, nil
+	}
+
 	if len(sqls) == 0 {
 		return nil, nil
 	}
@@ -71,6 +80,12 @@ func HandleDeletePlan(p *DeletePlan) error {
 		return fmt.Errorf("handle From error: %v", err)
 	}
 
+	// lookup条件需要在改写WHERE条件之前查找
+	route, err := findLookupRoute(p.TableAliasStmtInfo, p.stmt.Where)
+	if err != nil {
+		return fmt.Errorf("handle lookup error: %v", err)
+	}
+
 	if err := handleDeleteWhere(p); err != nil {
 		return fmt.Errorf("handle Where error: %v", err)
 	}
@@ -92,6 +107,15 @@ func HandleDeletePlan(p *DeletePlan) error {
 	}
 
 	p.sqls = sqls
+
+	// 删除的行需要同时删除映射记录
+	var maintenance *lookupMaintenance
+	if rule, ok := getSingleShardRule(p.TableAliasStmtInfo); ok && len(rule.GetLookups()) != 0 {
+		maintenance = newLookupMaintenance(rule, rule.GetLookups(), nil, p.stmt.TableRefs, p.stmt.Where, p.stmt.Order, p.stmt.Limit)
+	}
+	if p.lookup, err = buildLookupPlan(p.TableAliasStmtInfo, p.stmt, route, maintenance); err != nil {
+		return fmt.Errorf("build lookup plan error: %v", err)
+	}
 	return nil
 }
 
@@ -107,7 +131,13 @@ func handleDeleteTableRefs(p *DeletePlan) error {
 	}
 
 	if join.Right != nil {
-		return fmt.Errorf("does not support update multiple tables in sharding")
+		tables, err := checkMultiTableModify(p.TableAliasStmtInfo, join, p.stmt.Where)
+		if err != nil {
+			return fmt.Errorf("check multi-table delete error: %v", err)
+		}
+		if err := checkMultiTableDeleteTargets(p, tables); err != nil {
+			return fmt.Errorf("check multi-table delete error: %v", err)
+		}
 	}
 
 	return handleJoin(p.TableAliasStmtInfo, join)
@@ -119,6 +149,12 @@ func handleDeleteWhere(p *DeletePlan) error {
 		return nil
 	}
 
+	// 多列分片的路由需要在改写WHERE条件之前计算
+	complexHas, complexResult, err := handleComplexShardingCondition(p.TableAliasStmtInfo, stmt.Where)
+	if err != nil {
+		return fmt.Errorf("handle complex sharding condition error: %v", err)
+	}
+
 	has, result, decorator, err := handleComparisonExpr(p.TableAliasStmtInfo, stmt.Where)
 	if err != nil {
 		return fmt.Errorf("rewrite Where error: %v", err)
@@ -126,6 +162,9 @@ func handleDeleteWhere(p *DeletePlan) error {
 	if has {
 		p.GetRouteResult().Inter(result)
 	}
+	if complexHas {
+		p.GetRouteResult().Inter(complexResult)
+	}
 	stmt.Where = decorator
 	return nil
 
@@ -248,3 +248,86 @@ func TestMycatShardDeleteWithLimit(t *testing.T) {
 		t.Run(test.sql, getTestFunc(ns, test))
 	}
 }
+
+func TestKingshardDeleteComplex(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	tests := []SQLTestcase{
+		{
+			db:  "db_ks",
+			sql: "delete from tbl_ks_complex where region_id = 0 and user_id = 2",
+			sqls: map[string]map[string][]string{
+				"slice-1": {
+					"db_ks": {"DELETE FROM `tbl_ks_complex_0002` WHERE `region_id`=0 AND `user_id`=2"},
+				},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.sql, getTestFunc(ns, test))
+	}
+}
+
+func TestKingshardMultiTableDelete(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	tests := []SQLTestcase{
+		{
+			db:  "db_ks",
+			sql: "delete a, b from tbl_ks a join tbl_ks_child b on a.id = b.id where a.id = 5",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {"DELETE `a`,`b` FROM `tbl_ks_0001` AS `a` JOIN `tbl_ks_child_0001` AS `b` ON `a`.`id`=`b`.`id` WHERE `a`.`id`=5"},
+				},
+			},
+		},
+		{
+			db:  "db_ks",
+			sql: "delete from b using tbl_ks a join tbl_ks_user_child b on a.id = b.user_id join tbl_ks_global_one g on b.name = g.name where b.user_id = 2",
+			sqls: map[string]map[string][]string{
+				"slice-1": {
+					"db_ks": {"DELETE FROM `b` USING (`tbl_ks_0002` AS `a` JOIN `tbl_ks_user_child_0002` AS `b` ON `a`.`id`=`b`.`user_id`) JOIN `tbl_ks_global_one` AS `g` ON `b`.`name`=`g`.`name` WHERE `b`.`user_id`=2"},
+				},
+			},
+		},
+		{
+			db:     "db_ks",
+			sql:    "delete b from tbl_ks a join tbl_ks_child b on a.id > b.id",
+			hasErr: true, // not joined on sharding columns
+		},
+		{
+			db:     "db_ks",
+			sql:    "delete b from tbl_ks_complex a join tbl_ks_complex_item b on a.region_id = b.uid and a.user_id = b.area_id",
+			hasErr: true, // sharding columns at different positions are not co-located
+		},
+		{
+			db:     "db_ks",
+			sql:    "delete g from tbl_ks a join tbl_ks_global_one g on a.name = g.name",
+			hasErr: true, // cannot modify global table joined with sharding tables
+		},
+		{
+			db:     "db_ks",
+			sql:    "delete tbl_ks_child from tbl_ks a join tbl_ks_child on a.id = tbl_ks_child.id",
+			hasErr: true, // table must be referenced by alias
+		},
+		{
+			db:     "db_ks",
+			sql:    "delete a from tbl_ks_lookup a join tbl_ks_global_one g on a.name = g.name",
+			hasErr: true, // cannot delete from table with lookups
+		},
+		{
+			db:     "db_ks",
+			sql:    "delete a from tbl_ks a join (select id from tbl_ks_child) b on a.id = b.id",
+			hasErr: true, // does not support subquery as table
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.sql, getTestFunc(ns, test))
+	}
+}
@@ -28,6 +28,7 @@ import (
 const (
 	ShardTypeUnshard = "unshard"
 	ShardTypeShard   = "shard"
+	ShardTypeJoin    = "join"
 )
 
 // ExplainPlan is the plan for explain statement
@@ -54,6 +55,10 @@ func buildExplainPlan(stmt *ast.ExplainStmt, phyDBs map[string]string, db, sql s
 		ep.shardType = ShardTypeShard
 		ep.sqls = pl.sqls
 		return ep, nil
+	case *JoinPlan:
+		ep.shardType = ShardTypeJoin
+		ep.sqls = pl.GetSQLs()
+		return ep, nil
 	case *DeletePlan:
 		ep.shardType = ShardTypeShard
 		ep.sqls = pl.sqls
@@ -33,21 +33,21 @@ type InsertPlan struct {
 
 	stmt *ast.InsertStmt
 
-	table               string
-	isAssignmentMode    bool
-	shardingColumnIndex int
+	table                 string
+	isAssignmentMode      bool
+	shardingColumnIndexes []int // index of each sharding column in column list or set list
 
 	sequences *sequence.SequenceManager
 
-	sqls map[string]map[string][]string
+	sqls       map[string]map[string][]string
+	lookupSQLs []*lookupSQL // 插入映射记录的SQL, 在插入分片表之前执行
 }
 
 // NewInsertPlan constructor of InsertPlan
 func NewInsertPlan(db string, sql string, r *router.Router, seq *sequence.SequenceManager) *InsertPlan {
 	return &InsertPlan{
-		StmtInfo:            NewStmtInfo(db, sql, r),
-		shardingColumnIndex: -1,
-		sequences:           seq,
+		StmtInfo:  NewStmtInfo(db, sql, r),
+		sequences: seq,
 	}
 }
 
@@ -89,6 +89,10 @@ func HandleInsertStmt(p *InsertPlan, stmt *ast.InsertStmt) error {
 		return fmt.Errorf("handleInsertValues error: %v", err)
 	}
 
+	if err := handleInsertLookups(p); err != nil {
+		return fmt.Errorf("handleInsertLookups error: %v", err)
+	}
+
 	sqls, err := generateShardingSQLs(p.stmt, p.result, p.router)
 	if err != nil {
 		logging.DefaultLogger.Warnf("generate insert parser failed, %v", err)
@@ -170,29 +174,42 @@ func handleInsertTableRefs(p *InsertPlan) (fastReturn bool, err error) {
 }
 
 func handleInsertColumnNames(p *InsertPlan) error {
+	var columnNames []string
 	if p.isAssignmentMode {
 		// INSERT INTO tbl SET col = val, ...
-		for i, assignment := range p.stmt.Setlist {
+		for _, assignment := range p.stmt.Setlist {
 			col := assignment.Column
 			removeSchemaAndTableInfoInColumnName(col)
-			columnName := col.Name.L
-			rule := p.tableRules[p.table]
-			if columnName == rule.GetShardingColumn() {
-				p.shardingColumnIndex = i
-			}
+			columnNames = append(columnNames, col.Name.L)
 		}
 	} else {
 		// INSERT INTO tbl (col, ...) VALUES (val, ...)
-		for i, col := range p.stmt.Columns {
+		for _, col := range p.stmt.Columns {
 			removeSchemaAndTableInfoInColumnName(col)
-			columnName := col.Name.L
-			rule := p.tableRules[p.table]
-			if columnName == rule.GetShardingColumn() {
-				p.shardingColumnIndex = i
+			columnNames = append(columnNames, col.Name.L)
+		}
+	}
+
+	// 每个分片列都必须出现在插入列中, 基因分片的分片列和基因列只需要出现一个
+	rule := p.tableRules[p.table]
+	_, isGene := rule.GetShard().(*router.GeneShard)
+	found := false
+	for _, shardingColumn := range rule.GetShardingColumns() {
+		index := -1
+		for i, columnName := range columnNames {
+			if columnName == shardingColumn {
+				index = i
 			}
 		}
+		if index == -1 && !isGene {
+			return fmt.Errorf("sharding column not found")
+		}
+		if index != -1 {
+			found = true
+		}
+		p.shardingColumnIndexes = append(p.shardingColumnIndexes, index)
 	}
-	if p.shardingColumnIndex == -1 {
+	if isGene && !found {
 		return fmt.Errorf("sharding column not found")
 	}
 	return nil
@@ -210,50 +227,161 @@ func removeSchemaAndTableInfoInColumnName(column *ast.ColumnName) {
 func handleInsertValues(p *InsertPlan) error {
 	// assignment mode
 	if p.isAssignmentMode {
-		valueItem := p.stmt.Setlist[p.shardingColumnIndex].Expr
-		switch x := valueItem.(type) {
-		case *driver.ValueExpr:
-			v, err := util.GetValueExprResult(x)
-			if err != nil {
-				return fmt.Errorf("get value expr result failed, %v", err)
-			}
-			if v == nil {
-				return fmt.Errorf("sharding value cannot be null")
+		var valueItems []ast.ExprNode
+		for _, index := range p.shardingColumnIndexes {
+			if index == -1 {
+				valueItems = append(valueItems, nil)
+				continue
 			}
-			routeIdx, err := p.tableRules[p.table].FindTableIndex(v)
-			if err != nil {
-				return fmt.Errorf("find table index error: %v", err)
-			}
-			p.result.Inter([]int{routeIdx})
+			valueItems = append(valueItems, p.stmt.Setlist[index].Expr)
 		}
-		return nil
+		return interInsertRouteResult(p, valueItems)
 	}
 
 	// not assignment mode
 	for _, valueList := range p.stmt.Lists {
-		valueItem := valueList[p.shardingColumnIndex]
-		switch x := valueItem.(type) {
-		case *driver.ValueExpr:
-			v, err := util.GetValueExprResult(x)
+		var valueItems []ast.ExprNode
+		for _, index := range p.shardingColumnIndexes {
+			if index == -1 {
+				valueItems = append(valueItems, nil)
+				continue
+			}
+			valueItems = append(valueItems, valueList[index])
+		}
+		if err := interInsertRouteResult(p, valueItems); err != nil {
+			return err
+		}
+	}
+	if len(p.result.GetShardIndexes()) == 0 {
+		return fmt.Errorf("batch insert has cross slice values or no route found")
+	}
+	return nil
+}
+
+// 根据一行数据中各分片列的值计算路由, 并与已有的路由结果求交集
+// valueItems与rule.GetShardingColumns()一一对应, 没有出现在插入列中的分片列为nil
+func interInsertRouteResult(p *InsertPlan, valueItems []ast.ExprNode) error {
+	rule := p.tableRules[p.table]
+	columns := rule.GetShardingColumns()
+	keys := make(map[string]interface{}, len(columns))
+	for i, valueItem := range valueItems {
+		x, ok := foldSignedValueExpr(valueItem).(*driver.ValueExpr)
+		if !ok {
+			continue
+		}
+		v, err := util.GetValueExprResult(x)
+		if err != nil {
+			return fmt.Errorf("get value expr result failed, %v", err)
+		}
+		if v == nil {
+			return fmt.Errorf("sharding value cannot be null")
+		}
+		keys[columns[i]] = v
+		routeIdxs, err := rule.FindTableIndexes(columns[i], v)
+		if err != nil {
+			return fmt.Errorf("find table index error: %v", err)
+		}
+		p.result.Inter(routeIdxs)
+	}
+
+	// 多列分片需要所有分片列的值才能计算路由
+	if shard, ok := rule.GetShard().(router.ComplexShard); ok && len(keys) == len(columns) {
+		routeIdx, err := shard.FindForKeys(keys)
+		if err != nil {
+			return fmt.Errorf("find table index error: %v", err)
+		}
+		p.result.Inter([]int{routeIdx})
+	}
+	return nil
+}
+
+// 生成插入映射记录的SQL, 没有出现在插入列中或者值为NULL的lookup列不插入映射记录
+func handleInsertLookups(p *InsertPlan) error {
+	rule := p.tableRules[p.table]
+	if len(rule.GetLookups()) == 0 {
+		return nil
+	}
+
+	var valueLists [][]ast.ExprNode
+	if p.isAssignmentMode {
+		var valueList []ast.ExprNode
+		for _, assignment := range p.stmt.Setlist {
+			valueList = append(valueList, assignment.Expr)
+		}
+		valueLists = append(valueLists, valueList)
+	} else {
+		valueLists = p.stmt.Lists
+	}
+
+	if err := checkInsertLookupDuplicate(rule, p.stmt); err != nil {
+		return err
+	}
+
+	shardingColumnIndex := p.shardingColumnIndexes[0]
+	for _, lookup := range rule.GetLookups() {
+		index := getInsertColumnIndex(p, lookup.Column)
+		if index == -1 {
+			continue
+		}
+		if shardingColumnIndex == -1 {
+			return fmt.Errorf("sharding column %s not found for lookup column %s", rule.GetShardingColumn(), lookup.Column)
+		}
+		var rows [][]interface{}
+		for _, valueList := range valueLists {
+			v, err := getLookupValue(lookup.Column, valueList[index])
 			if err != nil {
-				return fmt.Errorf("get value expr result failed, %v", err)
+				return err
 			}
 			if v == nil {
-				return fmt.Errorf("sharding value cannot be null")
+				continue
 			}
-			routeIdx, err := p.tableRules[p.table].FindTableIndex(v)
+			key, err := getLookupValue(rule.GetShardingColumn(), valueList[shardingColumnIndex])
 			if err != nil {
-				return fmt.Errorf("find table index error: %v", err)
+				return err
 			}
-			p.result.Inter([]int{routeIdx})
+			rows = append(rows, []interface{}{v, key})
+		}
+		if len(rows) == 0 {
+			continue
 		}
+		sql, err := buildLookupInsertSQL(rule, lookup, rows)
+		if err != nil {
+			return err
+		}
+		p.lookupSQLs = append(p.lookupSQLs, sql)
 	}
-	if len(p.result.GetShardIndexes()) == 0 {
-		return fmt.Errorf("batch insert has cross slice values or no route found")
+	return nil
+}
+
+// checkInsertLookupDuplicate INSERT IGNORE, REPLACE和ON DUPLICATE KEY UPDATE遇到重复键时会跳过, 删除或修改已有的行,
+// 无法确定需要维护哪些映射记录, 有lookup列的表不支持
+func checkInsertLookupDuplicate(rule router.Rule, stmt *ast.InsertStmt) error {
+	if len(rule.GetLookups()) == 0 {
+		return nil
+	}
+	if stmt.IsReplace || stmt.IgnoreErr || stmt.OnDuplicate != nil {
+		return fmt.Errorf("INSERT IGNORE, REPLACE and ON DUPLICATE KEY UPDATE are not supported in table %s with lookups", rule.GetTable())
 	}
 	return nil
 }
 
+func getInsertColumnIndex(p *InsertPlan, column string) int {
+	if p.isAssignmentMode {
+		for i, assignment := range p.stmt.Setlist {
+			if assignment.Column.Name.L == column {
+				return i
+			}
+		}
+		return -1
+	}
+	for i, col := range p.stmt.Columns {
+		if col.Name.L == column {
+			return i
+		}
+	}
+	return -1
+}
+
 // check on duplicate key
 // 不管分片表的配置信息, 只要在OnDuplicate出现分片列, 就返回错误
 // 去掉ColumnName中的DB名和表名
@@ -262,11 +390,14 @@ func handleInsertOnDuplicate(p *InsertPlan) error {
 		return nil
 	}
 
-	shardingColumnName := p.tableRules[p.table].GetShardingColumn()
+	rule := p.tableRules[p.table]
 	for _, a := range p.stmt.OnDuplicate {
-		if a.Column.Name.L == shardingColumnName {
+		if rule.IsShardingColumn(a.Column.Name.L) {
 			return errors.ErrUpdateKey
 		}
+		if _, ok := rule.GetLookup(a.Column.Name.L); ok {
+			return fmt.Errorf("cannot update lookup column %s on duplicate key", a.Column.Name.O)
+		}
 		removeSchemaAndTableInfoInColumnName(a.Column)
 	}
 
@@ -283,12 +414,18 @@ func handleInsertGlobalSequenceValue(p *InsertPlan) error {
 
 	// not assignment mode
 	if p.isAssignmentMode {
+		var columns []*ast.ColumnName
+		var values []ast.ExprNode
+		for _, assignment := range p.stmt.Setlist {
+			columns = append(columns, assignment.Column)
+			values = append(values, assignment.Expr)
+		}
 		for _, assignment := range p.stmt.Setlist {
 			columnName := assignment.Column.Name.L
 			if columnName == pkName {
 				if x, ok := assignment.Expr.(*ast.FuncCallExpr); ok {
 					if x.FnName.L == "nextval" {
-						id, err := seq.NextSeq()
+						id, err := nextInsertSequenceValue(p, seq, columns, values)
 						if err != nil {
 							return fmt.Errorf("get next seq error: %v", err)
 						}
@@ -319,7 +456,7 @@ func handleInsertGlobalSequenceValue(p *InsertPlan) error {
 	for _, valueList := range p.stmt.Lists {
 		if x, ok := valueList[seqIndex].(*ast.FuncCallExpr); ok {
 			if x.FnName.L == "nextval" {
-				id, err := seq.NextSeq()
+				id, err := nextInsertSequenceValue(p, seq, p.stmt.Columns, valueList)
 				if err != nil {
 					return fmt.Errorf("get next seq error: %v", err)
 				}
@@ -331,8 +468,60 @@ func handleInsertGlobalSequenceValue(p *InsertPlan) error {
 	return nil
 }
 
+// 获取全局序列号, 如果序列号列是基因分片的基因列, 生成的ID中嵌入同一行中分片列的基因
+func nextInsertSequenceValue(p *InsertPlan, seq sequence.Sequence, columns []*ast.ColumnName, values []ast.ExprNode) (int64, error) {
+	rule := p.tableRules[p.table]
+	shard, ok := rule.GetShard().(*router.GeneShard)
+	if !ok || !shard.IsGeneColumn(seq.GetPKName()) {
+		return seq.NextSeq()
+	}
+
+	key := rule.GetShardingColumn()
+	for i, column := range columns {
+		if column.Name.L != key {
+			continue
+		}
+		x, ok := foldSignedValueExpr(values[i]).(*driver.ValueExpr)
+		if !ok {
+			return 0, fmt.Errorf("value of sharding column %s must be a constant to generate gene sequence", key)
+		}
+		v, err := util.GetValueExprResult(x)
+		if err != nil {
+			return 0, fmt.Errorf("get value expr result failed, %v", err)
+		}
+		if v == nil {
+			return 0, fmt.Errorf("sharding value cannot be null")
+		}
+		gene, err := shard.GetGene(v)
+		if err != nil {
+			return 0, err
+		}
+		return sequence.NextGeneSeq(seq, gene, shard.GetGeneBits())
+	}
+	return 0, fmt.Errorf("sharding column %s not found to generate gene sequence", key)
+}
+
 // ExecuteIn implement Plan
 func (s *InsertPlan) ExecuteIn(reqCtx *util.RequestContext, sess Executor) (*mysql.Result, error) {
+	if len(s.lookupSQLs) == 0 {
+		return s.execute(reqCtx, sess)
+	}
+
+	// 映射记录和分片表的行在同一个事务中插入, 出错时回滚
+	tx, ok := sess.(TransactionExecutor)
+	if !ok {
+		return nil, fmt.Errorf("insert into table with lookups need transaction")
+	}
+	return executeInTransaction(tx, func() (*mysql.Result, error) {
+		return s.execute(reqCtx, sess)
+	})
+}
+
+func (s *InsertPlan) execute(reqCtx *util.RequestContext, sess Executor) (*mysql.Result, error) {
+	if err := executeLookupSQLs(reqCtx, sess, s.lookupSQLs); err != nil {
+		return nil, fmt.Errorf("execute in InsertPlan error: %v", err)
+	}
+
 	rs, err := sess.ExecuteSQLs(reqCtx, s.sqls)
 	if err != nil {
 		return nil, fmt.Errorf("execute in InsertPlan error: %v", err)
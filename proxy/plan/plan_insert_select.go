@@ -0,0 +1,470 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plan
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/format"
+	"github.com/pingcap/parser/model"
+	driver "github.com/pingcap/tidb/types/parser_driver"
+
+	"github.com/XiaoMi/Gaea/core/errors"
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/proxy/router"
+	"github.com/XiaoMi/Gaea/proxy/sequence"
+	"github.com/XiaoMi/Gaea/util"
+)
+
+const (
+	// DefaultMaxInsertSelectRows INSERT ... SELECT查询结果的行数上限
+	DefaultMaxInsertSelectRows = 100000
+
+	// 每条INSERT语句中插入的行数
+	insertSelectBatchSize = 500
+)
+
+// InsertSelectRowsLimiter 由Executor实现, 返回INSERT ... SELECT的行数上限, 未实现或者返回值不大于0时使用DefaultMaxInsertSelectRows
+type InsertSelectRowsLimiter interface {
+	GetMaxInsertSelectRows() int
+}
+
+// InsertSelectPlan 插入分片表或全局表的INSERT ... SELECT语句.
+// 执行时先执行SELECT (可以是跨分片的), 再按目标表的路由规则计算每一行所在的子表,
+// 按子表分批生成多行INSERT语句写入. 不在事务中时由proxy开启事务, 出错时回滚.
+type InsertSelectPlan struct {
+	basePlan
+
+	db     string
+	sql    string
+	phyDBs map[string]string
+	router *router.Router
+	seq    *sequence.SequenceManager
+}
+
+// insertSelect 从INSERT ... SELECT语句中解析出的目标表和查询
+type insertSelect struct {
+	rule   router.Rule
+	stmt   *ast.InsertStmt // 去掉SELECT的INSERT语句, 生成SQL时替换目标表名和插入的行
+	source *ast.TableSource
+	table  *ast.TableName
+	query  ast.ResultSetNode
+	route  *InsertPlan // 用于计算每一行的路由
+
+	columnCount   int // 插入的列数, 不包含proxy生成序列号的列
+	sequenceIndex int // proxy生成序列号的列在插入列中的位置, 不生成时为-1
+}
+
+// insertSelectValue 查询结果中的一个值, 按字段类型还原为常量
+type insertSelectValue struct {
+	driver.ValueExpr
+	field *mysql.Field
+	value interface{}
+	text  []byte
+}
+
+// Restore implement ast.Node
+func (v *insertSelectValue) Restore(ctx *format.RestoreCtx) error {
+	sb := &strings.Builder{}
+	if err := restoreMovedValue(sb, v.field, v.value, v.text); err != nil {
+		return err
+	}
+	ctx.WritePlain(sb.String())
+	return nil
+}
+
+// buildInsertSelectPlan 检查INSERT ... SELECT语句并构建InsertSelectPlan
+func buildInsertSelectPlan(stmt *ast.InsertStmt, phyDBs map[string]string, db, sql string, r *router.Router, seq *sequence.SequenceManager) (*InsertSelectPlan, error) {
+	if _, err := newInsertSelect(stmt, db, sql, r, seq); err != nil {
+		return nil, err
+	}
+	return &InsertSelectPlan{
+		db:     db,
+		sql:    sql,
+		phyDBs: phyDBs,
+		router: r,
+		seq:    seq,
+	}, nil
+}
+
+func newInsertSelect(stmt *ast.InsertStmt, db, sql string, r *router.Router, seq *sequence.SequenceManager) (*insertSelect, error) {
+	query := stmt.Select
+	if _, err := restoreNode(query); err != nil {
+		return nil, fmt.Errorf("restore select error: %v", err)
+	}
+	stmt.Select = nil
+	ret, err := newInsertTarget(stmt, db, sql, r, seq)
+	if err != nil {
+		return nil, err
+	}
+	ret.query = query
+	return ret, nil
+}
+
+// newInsertTarget 检查不带VALUES和SELECT的INSERT语句的目标表, 用于计算插入行的路由和生成INSERT语句
+func newInsertTarget(stmt *ast.InsertStmt, db, sql string, r *router.Router, seq *sequence.SequenceManager) (*insertSelect, error) {
+	if len(stmt.Columns) == 0 {
+		return nil, errors.ErrIRNoColumns
+	}
+	if stmt.Table.TableRefs.Right != nil {
+		return nil, fmt.Errorf("have multi tables in insert")
+	}
+	source, ok := stmt.Table.TableRefs.Left.(*ast.TableSource)
+	if !ok {
+		return nil, fmt.Errorf("not a table source")
+	}
+	table, ok := source.Source.(*ast.TableName)
+	if !ok {
+		return nil, fmt.Errorf("not a table name")
+	}
+
+	route := NewInsertPlan(db, sql, r, seq)
+	rule, err := route.RecordShardTable(table.Schema.O, table.Name.L)
+	if err != nil {
+		return nil, fmt.Errorf("not a sharding table: %v", err)
+	}
+
+	ret := &insertSelect{
+		rule:          rule,
+		stmt:          stmt,
+		source:        source,
+		table:         table,
+		route:         route,
+		columnCount:   len(stmt.Columns),
+		sequenceIndex: -1,
+	}
+	route.stmt = stmt
+	route.table = table.Name.L
+	if rule.GetType() == router.GlobalTableRuleType {
+		for _, col := range stmt.Columns {
+			removeSchemaAndTableInfoInColumnName(col)
+		}
+		return ret, nil
+	}
+
+	// 插入的行由proxy生成, 无法在行中使用nextval(), 插入列中没有全局序列号列时由proxy为每一行生成
+	if seq, ok := route.sequences.GetSequence(route.db, route.table); ok {
+		found := false
+		for _, col := range stmt.Columns {
+			found = found || col.Name.L == seq.GetPKName()
+		}
+		if !found {
+			stmt.Columns = append(stmt.Columns, &ast.ColumnName{Name: model.NewCIStr(seq.GetPKName())})
+			ret.sequenceIndex = len(stmt.Columns) - 1
+		}
+	}
+
+	if err := handleInsertColumnNames(route); err != nil {
+		return nil, fmt.Errorf("handleInsertColumnNames error: %v", err)
+	}
+	if err := handleInsertOnDuplicate(route); err != nil {
+		return nil, fmt.Errorf("handleInsertOnDuplicate error: %v", err)
+	}
+	if err := checkInsertLookupDuplicate(rule, stmt); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// ExecuteIn implement Plan
+func (p *InsertSelectPlan) ExecuteIn(reqCtx *util.RequestContext, sess Executor) (*mysql.Result, error) {
+	tx, ok := sess.(TransactionExecutor)
+	if !ok {
+		return nil, fmt.Errorf("insert select need transaction")
+	}
+
+	// 每次执行重新解析, 避免修改缓存的计划中的AST
+	stmt, err := parseSQL(p.sql)
+	if err != nil {
+		return nil, fmt.Errorf("parse sql in InsertSelectPlan error: %v", err)
+	}
+	insertStmt, ok := stmt.(*ast.InsertStmt)
+	if !ok {
+		return nil, fmt.Errorf("not an insert statement: %s", p.sql)
+	}
+	s, err := newInsertSelect(insertStmt, p.db, p.sql, p.router, p.seq)
+	if err != nil {
+		return nil, err
+	}
+
+	maxRows := DefaultMaxInsertSelectRows
+	if l, ok := sess.(InsertSelectRowsLimiter); ok && l.GetMaxInsertSelectRows() > 0 {
+		maxRows = l.GetMaxInsertSelectRows()
+	}
+
+	r, err := executeInTransaction(tx, func() (*mysql.Result, error) {
+		return p.execute(reqCtx, sess, s, maxRows)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("execute in InsertSelectPlan error: %v", err)
+	}
+	if r.InsertID != 0 {
+		sess.SetLastInsertID(r.InsertID)
+	}
+	return r, nil
+}
+
+func (p *InsertSelectPlan) execute(reqCtx *util.RequestContext, sess Executor, s *insertSelect, maxRows int) (*mysql.Result, error) {
+	rs, err := p.executeSelect(reqCtx, sess, s, maxRows)
+	if err != nil {
+		return nil, err
+	}
+	if len(rs.Values) == 0 {
+		return &mysql.Result{}, nil
+	}
+
+	rows := make([][]ast.ExprNode, len(rs.Values))
+	for i, values := range rs.Values {
+		rows[i] = make([]ast.ExprNode, len(values))
+		for j, v := range values {
+			text, err := getInsertSelectText(rs, i, j)
+			if err != nil {
+				return nil, err
+			}
+			rows[i][j] = &insertSelectValue{field: rs.Fields[j], value: v, text: text}
+		}
+	}
+	return s.insertRows(reqCtx, sess, rows, rs.Values)
+}
+
+// insertRows 按目标表的路由规则插入多行, values是每一行的值, 用于计算路由和插入lookup映射记录
+func (s *insertSelect) insertRows(reqCtx *util.RequestContext, sess Executor, rows [][]ast.ExprNode, values [][]interface{}) (*mysql.Result, error) {
+	if err := s.fillSequenceValues(rows, values); err != nil {
+		return nil, err
+	}
+	tableRows, err := s.getTableRows(rows, values)
+	if err != nil {
+		return nil, err
+	}
+
+	lookupSQLs, err := s.buildLookupSQLs(values)
+	if err != nil {
+		return nil, fmt.Errorf("build lookup sql error: %v", err)
+	}
+	if err := executeLookupSQLs(reqCtx, sess, lookupSQLs); err != nil {
+		return nil, err
+	}
+
+	// 每一轮每个子表最多插入一批
+	ret := &mysql.Result{}
+	for len(tableRows) != 0 {
+		sqls := make(map[int]string, len(tableRows))
+		var indexes []int
+		for index, rows := range tableRows {
+			n := len(rows)
+			if n > insertSelectBatchSize {
+				n = insertSelectBatchSize
+			}
+			sql, err := s.buildInsertSQL(index, rows[:n])
+			if err != nil {
+				return nil, fmt.Errorf("build insert sql error: %v", err)
+			}
+			sqls[index] = sql
+			indexes = append(indexes, index)
+			if n == len(rows) {
+				delete(tableRows, index)
+			} else {
+				tableRows[index] = rows[n:]
+			}
+		}
+
+		results, err := sess.ExecuteSQLs(reqCtx, getTableIndexesSQLs(s.rule, sqls, indexes))
+		if err != nil {
+			return nil, fmt.Errorf("insert rows error: %v", err)
+		}
+		r, err := MergeExecResult(results)
+		if err != nil {
+			return nil, err
+		}
+		ret.AffectedRows += r.AffectedRows
+		ret.Warnings = addWarnings(ret.Warnings, r.Warnings)
+		if ret.InsertID == 0 {
+			ret.InsertID = r.InsertID
+		}
+	}
+	return ret, nil
+}
+
+// executeSelect 执行SELECT, 最多查询上限加一行, 超过上限时返回错误
+func (p *InsertSelectPlan) executeSelect(reqCtx *util.RequestContext, sess Executor, s *insertSelect, maxRows int) (*mysql.Resultset, error) {
+	limitQueryRows(s.query, maxRows+1)
+
+	sql, err := restoreNode(s.query)
+	if err != nil {
+		return nil, fmt.Errorf("restore select error: %v", err)
+	}
+	stmt, err := parseSQL(sql)
+	if err != nil {
+		return nil, fmt.Errorf("parse select error: %v", err)
+	}
+	plan, err := BuildPlan(stmt, p.phyDBs, p.db, sql, p.router, p.seq)
+	if err != nil {
+		return nil, fmt.Errorf("build plan of select error: %v", err)
+	}
+	r, err := plan.ExecuteIn(reqCtx, sess)
+	if err != nil {
+		return nil, fmt.Errorf("execute select error: %v", err)
+	}
+	if r == nil || r.Resultset == nil {
+		return nil, fmt.Errorf("select has no result set: %s", sql)
+	}
+	if len(r.Values) > maxRows {
+		return nil, fmt.Errorf("rows of insert select exceed the limit %d", maxRows)
+	}
+	if len(r.Fields) != s.columnCount {
+		return nil, fmt.Errorf("column count doesn't match value count")
+	}
+	return r.Resultset, nil
+}
+
+// fillSequenceValues 在每一行的最后加上全局序列号, 与INSERT VALUES中的nextval()相同由handleInsertGlobalSequenceValue生成,
+// 序列号列是基因列时嵌入同一行中分片列的基因
+func (s *insertSelect) fillSequenceValues(rows [][]ast.ExprNode, values [][]interface{}) error {
+	if s.sequenceIndex == -1 {
+		return nil
+	}
+
+	lists := make([][]ast.ExprNode, len(values))
+	for i, v := range values {
+		list := make([]ast.ExprNode, 0, len(v)+1)
+		for _, value := range v {
+			list = append(list, ast.NewValueExpr(value, "", ""))
+		}
+		lists[i] = append(list, &ast.FuncCallExpr{FnName: model.NewCIStr("nextval")})
+	}
+	stmt := *s.stmt
+	stmt.Lists = lists
+	s.route.stmt = &stmt
+	err := handleInsertGlobalSequenceValue(s.route)
+	s.route.stmt = s.stmt
+	if err != nil {
+		return fmt.Errorf("handleInsertGlobalSequenceValue error: %v", err)
+	}
+
+	for i, list := range lists {
+		v, ok := list[s.sequenceIndex].(*driver.ValueExpr)
+		if !ok {
+			return fmt.Errorf("sequence value of row %d is not generated", i+1)
+		}
+		rows[i] = append(rows[i], v)
+		values[i] = append(values[i], v.GetValue())
+	}
+	return nil
+}
+
+// getTableRows 计算每一行所在的子表, 全局表的每个子表都插入全部的行
+func (s *insertSelect) getTableRows(rows [][]ast.ExprNode, values [][]interface{}) (map[int][][]ast.ExprNode, error) {
+	tableRows := make(map[int][][]ast.ExprNode)
+	for i, row := range rows {
+		if s.rule.GetType() == router.GlobalTableRuleType {
+			for _, index := range s.rule.GetSubTableIndexes() {
+				tableRows[index] = append(tableRows[index], row)
+			}
+			continue
+		}
+
+		var valueItems []ast.ExprNode
+		for _, index := range s.route.shardingColumnIndexes {
+			if index == -1 {
+				valueItems = append(valueItems, nil)
+				continue
+			}
+			valueItems = append(valueItems, ast.NewValueExpr(values[i][index], "", ""))
+		}
+		s.route.result = NewRouteResult(s.rule.GetDB(), s.rule.GetTable(), s.rule.GetSubTableIndexes())
+		if err := interInsertRouteResult(s.route, valueItems); err != nil {
+			return nil, err
+		}
+		indexes := s.route.result.GetShardIndexes()
+		if len(indexes) != 1 {
+			return nil, fmt.Errorf("row %d is not routed to one table", i+1)
+		}
+		tableRows[indexes[0]] = append(tableRows[indexes[0]], row)
+	}
+	return tableRows, nil
+}
+
+// getInsertSelectText 返回查询结果中一个值的文本, 有原始的行数据时使用原始文本, 否则格式化解析后的值
+func getInsertSelectText(rs *mysql.Resultset, row, column int) ([]byte, error) {
+	if len(rs.RowDatas) == len(rs.Values) && rs.RowDatas[row] != nil {
+		text, _, err := rs.RowDatas[row].GetTextColumn(column)
+		return text, err
+	}
+	switch v := rs.Values[row][column].(type) {
+	case nil:
+		return nil, nil
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	case float64:
+		return []byte(strconv.FormatFloat(v, 'g', -1, 64)), nil
+	default:
+		return []byte(fmt.Sprintf("%v", v)), nil
+	}
+}
+
+// buildInsertSQL 生成插入一个子表的多行INSERT语句
+func (s *insertSelect) buildInsertSQL(index int, rows [][]ast.ExprNode) (string, error) {
+	decorator, err := CreateTableNameDecorator(s.table, s.rule, NewRouteResult(s.rule.GetDB(), s.rule.GetTable(), []int{index}))
+	if err != nil {
+		return "", err
+	}
+	s.source.Source = decorator
+	s.stmt.Lists = rows
+	return restoreNode(s.stmt)
+}
+
+// buildLookupSQLs 插入lookup列的映射记录, 值为NULL的lookup列不插入映射记录
+func (s *insertSelect) buildLookupSQLs(values [][]interface{}) ([]*lookupSQL, error) {
+	if len(s.rule.GetLookups()) == 0 {
+		return nil, nil
+	}
+
+	shardingColumnIndex := s.route.shardingColumnIndexes[0]
+	var ret []*lookupSQL
+	for _, lookup := range s.rule.GetLookups() {
+		index := getInsertColumnIndex(s.route, lookup.Column)
+		if index == -1 {
+			continue
+		}
+		if shardingColumnIndex == -1 {
+			return nil, fmt.Errorf("sharding column %s not found for lookup column %s", s.rule.GetShardingColumn(), lookup.Column)
+		}
+		var rows [][]interface{}
+		for _, v := range values {
+			if v[index] == nil {
+				continue
+			}
+			rows = append(rows, []interface{}{v[index], v[shardingColumnIndex]})
+		}
+		for len(rows) != 0 {
+			n := len(rows)
+			if n > insertSelectBatchSize {
+				n = insertSelectBatchSize
+			}
+			sql, err := buildLookupInsertSQL(s.rule, lookup, rows[:n])
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, sql)
+			rows = rows[n:]
+		}
+	}
+	return ret, nil
+}
@@ -0,0 +1,305 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plan
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/parser"
+	"github.com/XiaoMi/Gaea/util"
+)
+
+// insertSelectTestExecutor 在lookupTestExecutor的基础上设置INSERT ... SELECT的行数上限
+type insertSelectTestExecutor struct {
+	lookupTestExecutor
+	maxRows int
+}
+
+func (e *insertSelectTestExecutor) GetMaxInsertSelectRows() int {
+	return e.maxRows
+}
+
+func TestInsertSelect(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	moveFields := []*mysql.Field{
+		{Name: []byte("id"), Type: mysql.TypeLonglong},
+		{Name: []byte("code"), Type: mysql.TypeVarString, Charset: 33},
+		{Name: []byte("name"), Type: mysql.TypeVarString, Charset: 33},
+		{Name: []byte("data"), Type: mysql.TypeBlob, Charset: 63},
+	}
+	globalFields := []*mysql.Field{
+		{Name: []byte("id"), Type: mysql.TypeLonglong},
+		{Name: []byte("name"), Type: mysql.TypeVarString, Charset: 33},
+	}
+	tests := []struct {
+		sql           string
+		fields        []*mysql.Field
+		tables        map[string][][]interface{}
+		maxRows       int
+		inTransaction bool
+		affectedRows  uint64
+		hasErr        bool
+		executed      []string
+	}{
+		{
+			// 查询结果按目标表的分片列路由到不同的子表, 同时插入映射记录
+			sql:    "insert into tbl_ks_move (user_id, order_no, name, data) select id, code, name, data from tbl_ks_child where id in (1, 6)",
+			fields: moveFields,
+			tables: map[string][][]interface{}{
+				"tbl_ks_child_0001": {{int64(1), "a", "x", []byte{0, 1}}},
+				"tbl_ks_child_0002": {{int64(6), nil, "it's", nil}},
+			},
+			affectedRows: 2,
+			executed: []string{
+				"BEGIN",
+				"slice-0:SELECT `id`,`code`,`name`,`data` FROM `tbl_ks_child_0001` WHERE `id` IN (1) LIMIT 100001",
+				"slice-1:SELECT `id`,`code`,`name`,`data` FROM `tbl_ks_child_0002` WHERE `id` IN (6) LIMIT 100001",
+				"slice-1:INSERT INTO `tbl_ks_move_order_no` (`order_no`,`user_id`) VALUES ('a',1)",
+				"slice-0:INSERT INTO `tbl_ks_move_0001` (`user_id`,`order_no`,`name`,`data`) VALUES (1,'a','x',X'0001')",
+				"slice-1:INSERT INTO `tbl_ks_move_0002` (`user_id`,`order_no`,`name`,`data`) VALUES (6,NULL,'it''s',NULL)",
+				"COMMIT",
+			},
+		},
+		{
+			// standard规则按分库和分表两列的值路由
+			sql:    "insert into tbl_ks_standard (tenant_id, order_id, a) select id, code, name from tbl_ks_child where id in (1, 6)",
+			fields: []*mysql.Field{moveFields[0], {Name: []byte("code"), Type: mysql.TypeLonglong}, moveFields[2]},
+			tables: map[string][][]interface{}{
+				"tbl_ks_child_0001": {{int64(1), int64(2), "x"}},
+				"tbl_ks_child_0002": {{int64(6), int64(3), "y"}},
+			},
+			affectedRows: 2,
+			executed: []string{
+				"BEGIN",
+				"slice-0:SELECT `id`,`code`,`name` FROM `tbl_ks_child_0001` WHERE `id` IN (1) LIMIT 100001",
+				"slice-1:SELECT `id`,`code`,`name` FROM `tbl_ks_child_0002` WHERE `id` IN (6) LIMIT 100001",
+				"slice-0:INSERT INTO `tbl_ks_standard_0001` (`tenant_id`,`order_id`,`a`) VALUES (6,3,'y')",
+				"slice-1:INSERT INTO `tbl_ks_standard_0002` (`tenant_id`,`order_id`,`a`) VALUES (1,2,'x')",
+				"COMMIT",
+			},
+		},
+		{
+			// 在客户端的事务中执行, 同一个子表的行合并为一条INSERT. 查询的LIMIT超过上限时只查询上限加一行,
+			// 插入列中没有全局序列号列user_id, 由proxy为每一行生成
+			sql:    "insert ignore into tbl_ks (id, name) select id, name from tbl_ks_child where id = 1 limit 1000000",
+			fields: globalFields,
+			tables: map[string][][]interface{}{
+				"tbl_ks_child_0001": {{int64(1), "x"}, {int64(5), "y"}},
+			},
+			inTransaction: true,
+			affectedRows:  1,
+			executed: []string{
+				"slice-0:SELECT `id`,`name` FROM `tbl_ks_child_0001` WHERE `id`=1 LIMIT 100001",
+				"slice-0:INSERT IGNORE INTO `tbl_ks_0001` (`id`,`name`,`user_id`) VALUES (1,'x',1),(5,'y',2)",
+			},
+		},
+		{
+			// 查询的LIMIT小于上限时不变
+			sql:    "insert into tbl_ks_move (user_id, name) select id, name from tbl_ks_child where id = 1 limit 10",
+			fields: globalFields,
+			tables: map[string][][]interface{}{
+				"tbl_ks_child_0001": {{int64(1), "x"}},
+			},
+			affectedRows: 1,
+			executed: []string{
+				"BEGIN",
+				"slice-0:SELECT `id`,`name` FROM `tbl_ks_child_0001` WHERE `id`=1 LIMIT 10",
+				"slice-0:INSERT INTO `tbl_ks_move_0001` (`user_id`,`name`) VALUES (1,'x')",
+				"COMMIT",
+			},
+		},
+		{
+			// 基因分片的基因列order_id由proxy生成, 嵌入同一行中分片列user_id的基因
+			sql:    "insert into tbl_ks_gene (user_id, a) select id, name from tbl_ks_child where id = 1",
+			fields: globalFields,
+			tables: map[string][][]interface{}{
+				"tbl_ks_child_0001": {{int64(33), "x"}, {int64(30), "y"}},
+			},
+			affectedRows: 2,
+			executed: []string{
+				"BEGIN",
+				"slice-0:SELECT `id`,`name` FROM `tbl_ks_child_0001` WHERE `id`=1 LIMIT 100001",
+				"slice-0:INSERT INTO `tbl_ks_gene_0001` (`user_id`,`a`,`order_id`) VALUES (33,'x',17)", // 1<<4 | 33&15
+				"slice-1:INSERT INTO `tbl_ks_gene_0002` (`user_id`,`a`,`order_id`) VALUES (30,'y',46)", // 2<<4 | 30&15
+				"COMMIT",
+			},
+		},
+		{
+			// 没有分片列时不能生成带基因的序列号
+			sql:    "insert into tbl_ks_gene (a) select name from tbl_ks_child where id = 1",
+			fields: globalFields[1:],
+			tables: map[string][][]interface{}{
+				"tbl_ks_child_0001": {{"x"}},
+			},
+			hasErr: true,
+			executed: []string{
+				"BEGIN",
+				"slice-0:SELECT `name` FROM `tbl_ks_child_0001` WHERE `id`=1 LIMIT 100001",
+				"ROLLBACK",
+			},
+		},
+		{
+			// 全局表的每个子表都插入全部的行
+			sql:    "insert into tbl_ks_global_one (id, name) select id, name from tbl_ks_child where id = 1",
+			fields: globalFields,
+			tables: map[string][][]interface{}{
+				"tbl_ks_child_0001": {{int64(1), "x"}},
+			},
+			affectedRows: 4,
+			executed: []string{
+				"BEGIN",
+				"slice-0:SELECT `id`,`name` FROM `tbl_ks_child_0001` WHERE `id`=1 LIMIT 100001",
+				"slice-0:INSERT INTO `tbl_ks_global_one` (`id`,`name`) VALUES (1,'x')",
+				"slice-0:INSERT INTO `tbl_ks_global_one` (`id`,`name`) VALUES (1,'x')",
+				"slice-1:INSERT INTO `tbl_ks_global_one` (`id`,`name`) VALUES (1,'x')",
+				"slice-1:INSERT INTO `tbl_ks_global_one` (`id`,`name`) VALUES (1,'x')",
+				"COMMIT",
+			},
+		},
+		{
+			// 没有查询到行
+			sql:    "insert into tbl_ks_move (user_id, name) select id, name from tbl_ks_child where id = 1",
+			fields: globalFields,
+			executed: []string{
+				"BEGIN",
+				"slice-0:SELECT `id`,`name` FROM `tbl_ks_child_0001` WHERE `id`=1 LIMIT 100001",
+				"COMMIT",
+			},
+		},
+		{
+			// 超过行数上限时回滚
+			sql:    "insert into tbl_ks_move (user_id, name) select id, name from tbl_ks_child where id = 1",
+			fields: globalFields,
+			tables: map[string][][]interface{}{
+				"tbl_ks_child_0001": {{int64(1), "x"}, {int64(5), "y"}},
+			},
+			maxRows: 1,
+			hasErr:  true,
+			executed: []string{
+				"BEGIN",
+				"slice-0:SELECT `id`,`name` FROM `tbl_ks_child_0001` WHERE `id`=1 LIMIT 2",
+				"ROLLBACK",
+			},
+		},
+		{
+			// 分片列的值为NULL
+			sql:    "insert into tbl_ks_move (user_id, name) select id, name from tbl_ks_child where id = 1",
+			fields: globalFields,
+			tables: map[string][][]interface{}{
+				"tbl_ks_child_0001": {{nil, "x"}},
+			},
+			hasErr: true,
+			executed: []string{
+				"BEGIN",
+				"slice-0:SELECT `id`,`name` FROM `tbl_ks_child_0001` WHERE `id`=1 LIMIT 100001",
+				"ROLLBACK",
+			},
+		},
+		{
+			// 查询的列数与插入的列数不一致
+			sql:    "insert into tbl_ks_move (user_id) select id, name from tbl_ks_child where id = 1",
+			fields: globalFields,
+			tables: map[string][][]interface{}{
+				"tbl_ks_child_0001": {{int64(1), "x"}},
+			},
+			hasErr: true,
+			executed: []string{
+				"BEGIN",
+				"slice-0:SELECT `id`,`name` FROM `tbl_ks_child_0001` WHERE `id`=1 LIMIT 100001",
+				"ROLLBACK",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.sql, func(t *testing.T) {
+			stmt, err := parser.ParseSQL(test.sql)
+			if err != nil {
+				t.Fatalf("parse sql error: %v", err)
+			}
+			p, err := BuildPlan(stmt, ns.phyDBs, "db_ks", test.sql, ns.rt, ns.seqs)
+			if err != nil {
+				t.Fatalf("build plan error: %v", err)
+			}
+			e := &insertSelectTestExecutor{
+				lookupTestExecutor: lookupTestExecutor{
+					testExecutor:  testExecutor{handle: moveResults(nil, test.fields, test.tables, 0)},
+					inTransaction: test.inTransaction,
+				},
+				maxRows: test.maxRows,
+			}
+			r, err := p.ExecuteIn(util.NewRequestContext(), e)
+			if test.hasErr {
+				if err == nil {
+					t.Errorf("execute should fail")
+				}
+			} else if err != nil {
+				t.Fatalf("execute error: %v", err)
+			} else if r.AffectedRows != test.affectedRows {
+				t.Errorf("affected rows not equal, expect: %d, actual: %d", test.affectedRows, r.AffectedRows)
+			}
+			if !reflect.DeepEqual(e.executed, test.executed) {
+				t.Errorf("executed sqls not equal, expect: %v, actual: %v", test.executed, e.executed)
+			}
+			if e.inTransaction != test.inTransaction {
+				t.Errorf("transaction status not restored")
+			}
+		})
+	}
+}
+
+func TestInsertSelectError(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	buildErrors := []string{
+		"insert into tbl_ks_move select * from tbl_ks_child",                                                             // 没有指定插入的列
+		"insert into tbl_ks_move (name) select name from tbl_ks_child",                                                   // 没有分片列
+		"insert into tbl_ks_move (user_id, name) select id, name from tbl_ks_child on duplicate key update user_id = 1",  // 修改分片列
+		"insert into tbl_ks_move (user_id, name) select id, name from tbl_ks_child on duplicate key update order_no = 1", // 修改lookup列
+		"insert into tbl_unshard (id) select id from tbl_ks_child",                                                       // 目标表不是分片表
+	}
+	for _, sql := range buildErrors {
+		stmt, err := parser.ParseSQL(sql)
+		if err != nil {
+			t.Fatalf("parse sql error: %v", err)
+		}
+		if _, err := BuildPlan(stmt, ns.phyDBs, "db_ks", sql, ns.rt, ns.seqs); err == nil {
+			t.Errorf("build plan should fail: %s", sql)
+		}
+	}
+
+	// 不支持事务的Executor
+	sql := "insert into tbl_ks_move (user_id, name) select id, name from tbl_ks_child"
+	stmt, err := parser.ParseSQL(sql)
+	if err != nil {
+		t.Fatalf("parse sql error: %v", err)
+	}
+	p, err := BuildPlan(stmt, ns.phyDBs, "db_ks", sql, ns.rt, ns.seqs)
+	if err != nil {
+		t.Fatalf("build plan error: %v", err)
+	}
+	if _, err := p.ExecuteIn(util.NewRequestContext(), noTransactionExecutor{&lookupTestExecutor{}}); err == nil {
+		t.Errorf("execute without transaction should fail")
+	}
+}
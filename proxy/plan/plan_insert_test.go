@@ -14,7 +14,14 @@
 
 package plan
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+
+	"github.com/XiaoMi/Gaea/parser"
+	"github.com/XiaoMi/Gaea/proxy/router"
+	"github.com/XiaoMi/Gaea/proxy/sequence"
+)
 
 func TestMycatShardSimpleInsert(t *testing.T) {
 	ns, err := preparePlanInfo()
@@ -149,7 +156,7 @@ func TestMycatShardSimpleInsert(t *testing.T) {
 		{
 			db:     "db_mycat",
 			sql:    "insert into tbl_mycat select * from tbl_mycat_child",
-			hasErr: true, // select in insert not allowed
+			hasErr: true, // insert or replace must specify columns
 		},
 		{
 			db:     "db_mycat",
@@ -819,3 +826,228 @@ func TestInserTableNameColumnCaseInsensitive(t *testing.T) {
 		t.Run(test.sql, getTestFunc(ns, test))
 	}
 }
+
+func TestKingshardInsertStandard(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	tests := []SQLTestcase{
+		{
+			db:  "db_ks",
+			sql: "insert into tbl_ks_standard (tenant_id, order_id, a) values (3, 4, 'hi')",
+			sqls: map[string]map[string][]string{
+				"slice-1": {
+					"db_ks": {"INSERT INTO `tbl_ks_standard_0002` (`tenant_id`,`order_id`,`a`) VALUES (3,4,'hi')"},
+				},
+			},
+		},
+		{
+			db:  "db_ks",
+			sql: "insert into tbl_ks_standard set order_id = 1, tenant_id = 2, a = 'hi'",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {"INSERT INTO `tbl_ks_standard_0001` SET `order_id`=1,`tenant_id`=2,`a`='hi'"},
+				},
+			},
+		},
+		{
+			db:  "db_ks",
+			sql: "insert into tbl_ks_standard (tenant_id, order_id, a) values (3, 4, 'hi'), (5, 6, 'hi')",
+			sqls: map[string]map[string][]string{
+				"slice-1": {
+					"db_ks": {"INSERT INTO `tbl_ks_standard_0002` (`tenant_id`,`order_id`,`a`) VALUES (3,4,'hi'),(5,6,'hi')"},
+				},
+			},
+		},
+		{
+			db:     "db_ks",
+			sql:    "insert into tbl_ks_standard (tenant_id, order_id, a) values (3, 4, 'hi'), (3, 5, 'hi')",
+			hasErr: true, // batch insert has cross table values
+		},
+		{
+			db:     "db_ks",
+			sql:    "insert into tbl_ks_standard (order_id, a) values (4, 'hi')",
+			hasErr: true, // database sharding column not found
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.sql, getTestFunc(ns, test))
+	}
+}
+
+func TestKingshardInsertComplex(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	tests := []SQLTestcase{
+		{
+			db:  "db_ks",
+			sql: "insert into tbl_ks_complex (region_id, user_id, a) values (1, 3, 'hi'), (2, 1, 'hi')",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {"INSERT INTO `tbl_ks_complex_0001` (`region_id`,`user_id`,`a`) VALUES (1,3,'hi'),(2,1,'hi')"},
+				},
+			},
+		},
+		{
+			db:  "db_ks",
+			sql: "insert into tbl_ks_complex set user_id = 2, region_id = 0, a = 'hi'",
+			sqls: map[string]map[string][]string{
+				"slice-1": {
+					"db_ks": {"INSERT INTO `tbl_ks_complex_0002` SET `user_id`=2,`region_id`=0,`a`='hi'"},
+				},
+			},
+		},
+		{
+			db:     "db_ks",
+			sql:    "insert into tbl_ks_complex (region_id, user_id, a) values (1, 3, 'hi'), (1, 2, 'hi')",
+			hasErr: true, // batch insert has cross slice values
+		},
+		{
+			db:     "db_ks",
+			sql:    "insert into tbl_ks_complex (region_id, a) values (1, 'hi')",
+			hasErr: true, // sharding column not found
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.sql, getTestFunc(ns, test))
+	}
+}
+
+func TestKingshardInsertBoundaryRange(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	tests := []SQLTestcase{
+		{
+			db:  "db_ks",
+			sql: "insert into tbl_ks_volume (id, a) values (-10, 'hi')",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {"INSERT INTO `tbl_ks_volume_0000` (`id`,`a`) VALUES (-10,'hi')"},
+				},
+			},
+		},
+		{
+			db:     "db_ks",
+			sql:    "insert into tbl_ks_volume (id, a) values (-10, 'hi'), (250, 'hi')",
+			hasErr: true, // batch insert has cross slice values
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.sql, getTestFunc(ns, test))
+	}
+}
+
+func TestKingshardInsertGene(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	tests := []SQLTestcase{
+		{
+			db:  "db_ks",
+			sql: "insert into tbl_ks_gene (order_id, a) values (51, 'hi')",
+			sqls: map[string]map[string][]string{
+				"slice-1": {
+					"db_ks": {"INSERT INTO `tbl_ks_gene_0003` (`order_id`,`a`) VALUES (51,'hi')"},
+				},
+			},
+		},
+		{
+			db:  "db_ks",
+			sql: "insert into tbl_ks_gene set user_id = 33, order_id = 17, a = 'hi'",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {"INSERT INTO `tbl_ks_gene_0001` SET `user_id`=33,`order_id`=17,`a`='hi'"},
+				},
+			},
+		},
+		{
+			db:     "db_ks",
+			sql:    "insert into tbl_ks_gene (a) values ('hi')",
+			hasErr: true, // sharding column not found
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.sql, getTestFunc(ns, test))
+	}
+}
+
+// 注意这一组各个测试用例之前有关联, 因为都用到了同一个全局序列号
+func TestKingshardInsertGeneSequence(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	tests := []SQLTestcase{
+		{
+			db:  "db_ks",
+			sql: "insert into tbl_ks_gene (order_id, user_id, a) values (nextval(tbl_ks_gene), 33, 'hi')",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {"INSERT INTO `tbl_ks_gene_0001` (`order_id`,`user_id`,`a`) VALUES (17,33,'hi')"}, // 1<<4 | 33&15
+				},
+			},
+		},
+		{
+			db:  "db_ks",
+			sql: "insert into tbl_ks_gene set user_id = 30, order_id = next value for tbl_ks_gene, a = 'hi'",
+			sqls: map[string]map[string][]string{
+				"slice-1": {
+					"db_ks": {"INSERT INTO `tbl_ks_gene_0002` SET `user_id`=30,`order_id`=46,`a`='hi'"}, // 2<<4 | 30&15
+				},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.sql, getTestFunc(ns, test))
+	}
+
+	// 没有分片列时不能生成带基因的ID, 不消耗序列号
+	sql := "insert into tbl_ks_gene (order_id, a) values (nextval(tbl_ks_gene), 'hi')"
+	stmt, err := parser.ParseSQL(sql)
+	if err != nil {
+		t.Fatalf("parse sql error: %v", err)
+	}
+	if _, err := BuildPlan(stmt, ns.phyDBs, "db_ks", sql, ns.rt, ns.seqs); err == nil {
+		t.Errorf("build plan should fail: %s", sql)
+	}
+
+	// 生成的ID与分片列的值路由到同一个子表
+	rule := ns.rt.GetRule("db_ks", "tbl_ks_gene")
+	shard := rule.GetShard().(*router.GeneShard)
+	seq, _ := ns.seqs.GetSequence("db_ks", "tbl_ks_gene")
+	for i, userID := range []int64{7, 30, 33} {
+		gene, err := shard.GetGene(userID)
+		if err != nil {
+			t.Fatalf("get gene error: %v", err)
+		}
+		id, err := sequence.NextGeneSeq(seq, gene, shard.GetGeneBits())
+		if err != nil {
+			t.Fatalf("next gene seq error: %v", err)
+		}
+		if expect := int64(i+3)<<4 | userID&15; id != expect {
+			t.Errorf("gene seq not equal, expect: %d, actual: %d", expect, id)
+		}
+		idIndexes, err := rule.FindTableIndexes("order_id", id)
+		if err != nil {
+			t.Fatalf("find table index of id error: %v", err)
+		}
+		keyIndexes, err := rule.FindTableIndexes("user_id", userID)
+		if err != nil {
+			t.Fatalf("find table index of key error: %v", err)
+		}
+		if len(idIndexes) != 1 || !reflect.DeepEqual(idIndexes, keyIndexes) {
+			t.Errorf("id %d and key %d not routed to the same table: %v, %v", id, userID, idIndexes, keyIndexes)
+		}
+	}
+}
@@ -0,0 +1,922 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plan
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/proxy/router"
+	"github.com/XiaoMi/Gaea/util"
+	"github.com/XiaoMi/Gaea/util/hack"
+	"github.com/pingcap/parser"
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/format"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/parser/opcode"
+)
+
+const (
+	// DefaultMaxJoinRows 跨分片JOIN时每一侧读取的行数以及JOIN结果的行数上限
+	DefaultMaxJoinRows = 100000
+
+	// 嵌套循环JOIN时每次下推到被驱动表的IN条件中值的个数
+	joinBatchSize = 500
+)
+
+// JoinRowsLimiter 由Executor实现, 返回跨分片JOIN的行数上限, 未实现或者返回值不大于0时使用DefaultMaxJoinRows
+type JoinRowsLimiter interface {
+	GetMaxJoinRows() int
+}
+
+// JoinPlan 两个不在同一路由上的分片表的JOIN, 在proxy中执行.
+// 每个表分别作为单表查询下发到各分片, 下推只涉及该表的条件, 然后在proxy中按JOIN条件合并结果.
+// 支持INNER JOIN和LEFT JOIN (RIGHT JOIN转换为LEFT JOIN), 被驱动表的JOIN列是分片列时,
+// 使用嵌套循环JOIN, 把驱动表的JOIN列的值分批作为IN条件下推到被驱动表, 否则使用哈希JOIN.
+type JoinPlan struct {
+	basePlan
+
+	db     string
+	router *router.Router
+
+	isLeftJoin bool
+	left       *joinSide
+	right      *joinSide
+	drive      *joinSide // 驱动表, LEFT JOIN时为左表
+	probe      *joinSide // 被驱动表
+	keys       []*joinKey
+	pushKey    *joinKey // 嵌套循环JOIN时下推到被驱动表的JOIN列, 哈希JOIN时为nil
+
+	fields            []*joinField // 输出列, 后面是ORDER BY补充的列
+	originColumnCount int          // 补列前的输出列数, 通配符按一列计算
+	orderByDirections []bool       // ORDER BY 方向, true: DESC
+	offset            int64        // LIMIT offset
+	count             int64        // LIMIT count, 未设置则为-1
+}
+
+// joinSide JOIN中的一个表, 作为单表查询执行
+type joinSide struct {
+	name     string // 别名或表名, 用于匹配列名中的表名
+	rule     router.Rule
+	from     string   // 改写前的表名和别名
+	wildcard bool     // 是否查询所有列, 通配符在查询列的最前面
+	columns  []string // 查询的列, 已经加上表名
+	names    []string // 查询的列名, 与columns一一对应
+	conds    []string // 下推的条件
+	plan     *SelectPlan
+}
+
+// joinKey JOIN条件中的一组等值列, 值为驱动表和被驱动表中查询的列的下标
+type joinKey struct {
+	drive int
+	probe int
+}
+
+// joinField 输出列, wildcard为true时输出表的所有列
+type joinField struct {
+	side     *joinSide
+	wildcard bool
+	column   int // 在side.columns中的下标
+	alias    string
+}
+
+// joinSideResult 一个表的查询结果, width为通配符展开后的列数
+type joinSideResult struct {
+	fields []*mysql.Field
+	width  int
+	rows   [][]interface{}
+}
+
+// buildJoinPlan 两个分片表不在同一个路由上时构建JoinPlan, 不是跨分片JOIN时返回nil
+func buildJoinPlan(stmt *ast.SelectStmt, db string, r *router.Router) (*JoinPlan, error) {
+	join, leftSource, rightSource, ok := getCrossShardJoin(stmt, db, r)
+	if !ok {
+		return nil, nil
+	}
+
+	if err := precheckCrossShardJoin(stmt, join); err != nil {
+		return nil, fmt.Errorf("cross shard join error: %v", err)
+	}
+
+	p := &JoinPlan{
+		db:         db,
+		router:     r,
+		isLeftJoin: join.Tp == ast.LeftJoin || join.Tp == ast.RightJoin,
+		offset:     -1,
+		count:      -1,
+	}
+	var err error
+	if p.left, err = newJoinSide(leftSource, db, r); err != nil {
+		return nil, err
+	}
+	if p.right, err = newJoinSide(rightSource, db, r); err != nil {
+		return nil, err
+	}
+	if p.left.name == p.right.name {
+		return nil, fmt.Errorf("cross shard join error: not unique table/alias: %s", p.left.name)
+	}
+
+	if err := p.handleJoinFields(stmt.Fields.Fields); err != nil {
+		return nil, fmt.Errorf("cross shard join error: %v", err)
+	}
+	p.originColumnCount = len(p.fields)
+
+	// RIGHT JOIN交换左右表后按LEFT JOIN处理, 输出列的顺序不变
+	if join.Tp == ast.RightJoin {
+		p.left, p.right = p.right, p.left
+	}
+
+	var keys [][2]int
+	for _, c := range join.Using {
+		keys = append(keys, [2]int{p.left.addColumn(c.Name.O), p.right.addColumn(c.Name.O)})
+	}
+	if join.On != nil {
+		k, err := p.handleJoinConditions(join.On.Expr, true)
+		if err != nil {
+			return nil, fmt.Errorf("cross shard join error: %v", err)
+		}
+		keys = append(keys, k...)
+	}
+	if stmt.Where != nil {
+		k, err := p.handleJoinConditions(stmt.Where, false)
+		if err != nil {
+			return nil, fmt.Errorf("cross shard join error: %v", err)
+		}
+		keys = append(keys, k...)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("cross shard join error: no equal condition of columns in the two tables")
+	}
+
+	if stmt.OrderBy != nil {
+		if err := p.handleJoinOrderBy(stmt.OrderBy.Items); err != nil {
+			return nil, fmt.Errorf("cross shard join error: %v", err)
+		}
+	}
+	_, p.offset, p.count, _ = NeedRewriteLimitOrCreateRewrite(stmt)
+
+	for _, side := range []*joinSide{p.left, p.right} {
+		if side.plan, err = side.buildPlan(db, r, "", -1); err != nil {
+			return nil, fmt.Errorf("cross shard join error: build plan of %s error: %v", side.name, err)
+		}
+	}
+
+	p.chooseJoinStrategy(keys)
+	return p, nil
+}
+
+// getCrossShardJoin 判断是否为两个不在同一路由上的分片表的JOIN
+func getCrossShardJoin(stmt *ast.SelectStmt, db string, r *router.Router) (*ast.Join, *ast.TableSource, *ast.TableSource, bool) {
+	if stmt.From == nil || stmt.From.TableRefs == nil {
+		return nil, nil, nil, false
+	}
+	join := stmt.From.TableRefs
+	// 逗号分隔的表, 左表是只有Left的Join
+	leftNode := join.Left
+	if j, ok := leftNode.(*ast.Join); ok && j.Right == nil {
+		leftNode = j.Left
+	}
+	left, ok := leftNode.(*ast.TableSource)
+	if !ok {
+		return nil, nil, nil, false
+	}
+	right, ok := join.Right.(*ast.TableSource)
+	if !ok {
+		return nil, nil, nil, false
+	}
+	leftRule, ok := getTableSourceShardRule(left, db, r)
+	if !ok {
+		return nil, nil, nil, false
+	}
+	rightRule, ok := getTableSourceShardRule(right, db, r)
+	if !ok {
+		return nil, nil, nil, false
+	}
+	// 同一路由上的表 (同表, 关联表或绑定表) 通过分片列JOIN时下推到各分片, 否则也在proxy中JOIN
+	if leftRule.GetDB() == rightRule.GetDB() && leftRule.GetRouteTable() == rightRule.GetRouteTable() &&
+		checkColocatedJoin(join, stmt.Where, db, r) == nil {
+		return nil, nil, nil, false
+	}
+	return join, left, right, true
+}
+
+// checkColocatedJoin 检查JOIN查询能否下推到各分片执行, 规则与多表UPDATE和DELETE相同:
+// 分片表必须在同一个路由上, 并且通过相同位置的分片列的等值条件连接. 包含子查询的表不检查.
+// 关联表与父表之间的JOIN保持原有的行为, 由业务保证按分片列连接.
+func checkColocatedJoin(join *ast.Join, where ast.ExprNode, db string, r *router.Router) error {
+	var tables []*modifyTable
+	var conditions []ast.ExprNode
+	if !collectJoinTables(join, db, r, &tables, &conditions) || isLinkedTableJoin(tables) {
+		return nil
+	}
+	if where != nil {
+		conditions = splitAndConditions(where, conditions)
+	}
+	return checkShardTablesJoined(tables, conditions)
+}
+
+// collectJoinTables 收集JOIN中的分片表和全局表以及ON条件, USING和NATURAL JOIN转换为分片列的等值条件.
+// 存在子查询时返回false
+func collectJoinTables(node ast.ResultSetNode, db string, r *router.Router, tables *[]*modifyTable, conditions *[]ast.ExprNode) bool {
+	switch n := node.(type) {
+	case *ast.Join:
+		if !collectJoinTables(n.Left, db, r, tables, conditions) {
+			return false
+		}
+		if n.Right == nil {
+			return true
+		}
+		mid := len(*tables)
+		if !collectJoinTables(n.Right, db, r, tables, conditions) {
+			return false
+		}
+		if n.On != nil {
+			*conditions = splitAndConditions(n.On.Expr, *conditions)
+		}
+		if len(n.Using) != 0 || n.NaturalJoin {
+			for _, left := range (*tables)[:mid] {
+				for _, right := range (*tables)[mid:] {
+					*conditions = append(*conditions, usingJoinConditions(n, left, right)...)
+				}
+			}
+		}
+		return true
+	case *ast.TableSource:
+		tableName, ok := n.Source.(*ast.TableName)
+		if !ok {
+			return false
+		}
+		if tableName.Schema.O != "" {
+			db = tableName.Schema.O
+		}
+		rule, ok := r.GetShardRule(db, tableName.Name.L)
+		if !ok {
+			return true
+		}
+		t := &modifyTable{name: tableName.Name.L, rule: rule}
+		if n.AsName.L != "" {
+			t.name = n.AsName.L
+			t.isAlias = true
+		}
+		*tables = append(*tables, t)
+		return true
+	default:
+		return false
+	}
+}
+
+// isLinkedTableJoin 判断JOIN的分片表是否为同一个父表及其关联表, 同一个表的自连接不算
+func isLinkedTableJoin(tables []*modifyTable) bool {
+	var parent string
+	var linked bool
+	for _, t := range tables {
+		if t.rule.GetType() == router.GlobalTableRuleType {
+			continue
+		}
+		table := t.rule.GetTable()
+		if l, ok := t.rule.(*router.LinkedRule); ok {
+			table = l.GetParentTable()
+			linked = true
+		}
+		if parent == "" {
+			parent = table
+		} else if parent != table {
+			return false
+		}
+	}
+	return linked
+}
+
+// usingJoinConditions 返回USING或NATURAL JOIN中两个表的分片列的等值条件,
+// NATURAL JOIN按两个表同名的分片列连接
+func usingJoinConditions(join *ast.Join, left, right *modifyTable) []ast.ExprNode {
+	var columns []string
+	if join.NaturalJoin {
+		for _, c := range left.rule.GetShardingColumns() {
+			if right.rule.IsShardingColumn(c) {
+				columns = append(columns, c)
+			}
+		}
+	} else {
+		for _, c := range join.Using {
+			columns = append(columns, c.Name.L)
+		}
+	}
+
+	var conditions []ast.ExprNode
+	for _, c := range columns {
+		conditions = append(conditions, &ast.BinaryOperationExpr{
+			Op: opcode.EQ,
+			L:  &ast.ColumnNameExpr{Name: &ast.ColumnName{Table: model.NewCIStr(left.name), Name: model.NewCIStr(c)}},
+			R:  &ast.ColumnNameExpr{Name: &ast.ColumnName{Table: model.NewCIStr(right.name), Name: model.NewCIStr(c)}},
+		})
+	}
+	return conditions
+}
+
+// getTableSourceShardRule 返回TableSource中分片表的路由规则, 全局表和非分片表返回false
+func getTableSourceShardRule(source *ast.TableSource, db string, r *router.Router) (router.Rule, bool) {
+	tableName, ok := source.Source.(*ast.TableName)
+	if !ok {
+		return nil, false
+	}
+	if tableName.Schema.O != "" {
+		db = tableName.Schema.O
+	}
+	rule, ok := r.GetShardRule(db, tableName.Name.L)
+	if !ok || rule.GetType() == router.GlobalTableRuleType {
+		return nil, false
+	}
+	return rule, true
+}
+
+// 检查跨分片JOIN不支持的语法
+func precheckCrossShardJoin(stmt *ast.SelectStmt, join *ast.Join) error {
+	if join.NaturalJoin {
+		return fmt.Errorf("NATURAL JOIN is not supported")
+	}
+	if stmt.Distinct {
+		return fmt.Errorf("DISTINCT is not supported")
+	}
+	if stmt.GroupBy != nil {
+		return fmt.Errorf("GROUP BY is not supported")
+	}
+	if stmt.Having != nil {
+		return fmt.Errorf("HAVING is not supported")
+	}
+	if stmt.LockTp != ast.SelectLockNone {
+		return fmt.Errorf("locking read is not supported")
+	}
+	if stmt.Fields == nil {
+		return fmt.Errorf("field list is empty")
+	}
+	return nil
+}
+
+func newJoinSide(source *ast.TableSource, db string, r *router.Router) (*joinSide, error) {
+	rule, _ := getTableSourceShardRule(source, db, r)
+	from, err := restoreNode(source)
+	if err != nil {
+		return nil, fmt.Errorf("restore table error: %v", err)
+	}
+	name := source.AsName.L
+	if name == "" {
+		name = source.Source.(*ast.TableName).Name.L
+	}
+	return &joinSide{name: name, rule: rule, from: from}, nil
+}
+
+// addColumn 添加查询的列, 返回列在side.columns中的下标
+func (s *joinSide) addColumn(column string) int {
+	column = strings.ToLower(column)
+	for i, c := range s.names {
+		if c == column {
+			return i
+		}
+	}
+	s.names = append(s.names, column)
+	s.columns = append(s.columns, quoteLookupName(s.name)+"."+quoteLookupName(column))
+	return len(s.columns) - 1
+}
+
+// buildSQL 生成单表查询的SQL, cond为额外的条件, limit小于0时不加LIMIT
+func (s *joinSide) buildSQL(cond string, limit int) string {
+	var fields []string
+	if s.wildcard {
+		fields = append(fields, "*")
+	}
+	fields = append(fields, s.columns...)
+
+	conds := s.conds
+	if cond != "" {
+		conds = append(conds[:len(conds):len(conds)], cond)
+	}
+
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "SELECT %s FROM %s", strings.Join(fields, ","), s.from)
+	if len(conds) != 0 {
+		fmt.Fprintf(sb, " WHERE %s", strings.Join(conds, " AND "))
+	}
+	if limit >= 0 {
+		fmt.Fprintf(sb, " LIMIT %d", limit)
+	}
+	return sb.String()
+}
+
+func (s *joinSide) buildPlan(db string, r *router.Router, cond string, limit int) (*SelectPlan, error) {
+	return buildJoinSidePlan(db, r, s.buildSQL(cond, limit))
+}
+
+func buildJoinSidePlan(db string, r *router.Router, sql string) (*SelectPlan, error) {
+	stmt, err := parseSQL(sql)
+	if err != nil {
+		return nil, err
+	}
+	selectStmt, ok := stmt.(*ast.SelectStmt)
+	if !ok {
+		return nil, fmt.Errorf("not select statement: %s", sql)
+	}
+	plan := NewSelectPlan(db, sql, r)
+	if err := HandleSelectStmt(plan, selectStmt); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// getJoinColumnSide 返回列所在的表, 列名必须带有表名或别名
+func (p *JoinPlan) getJoinColumnSide(c *ast.ColumnName) (*joinSide, error) {
+	if c.Table.L == "" {
+		return nil, fmt.Errorf("column %s must be qualified with table name", c.Name.O)
+	}
+	for _, side := range []*joinSide{p.left, p.right} {
+		if side.name == c.Table.L {
+			return side, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown table %s of column %s", c.Table.O, c.Name.O)
+}
+
+func (p *JoinPlan) handleJoinFields(fields []*ast.SelectField) error {
+	for _, f := range fields {
+		if f.WildCard != nil {
+			sides := []*joinSide{p.left, p.right}
+			if f.WildCard.Table.L != "" {
+				side, err := p.getJoinColumnSide(&ast.ColumnName{Table: f.WildCard.Table, Name: f.WildCard.Table})
+				if err != nil {
+					return err
+				}
+				sides = []*joinSide{side}
+			}
+			for _, side := range sides {
+				side.wildcard = true
+				p.fields = append(p.fields, &joinField{side: side, wildcard: true})
+			}
+			continue
+		}
+
+		columnExpr, ok := f.Expr.(*ast.ColumnNameExpr)
+		if !ok {
+			return fmt.Errorf("only column is supported in field list")
+		}
+		side, err := p.getJoinColumnSide(columnExpr.Name)
+		if err != nil {
+			return err
+		}
+		p.fields = append(p.fields, &joinField{side: side, column: side.addColumn(columnExpr.Name.Name.O), alias: f.AsName.O})
+	}
+	return nil
+}
+
+// handleJoinConditions 处理ON或WHERE中AND连接的条件, 返回两个表的列的等值条件.
+// 只涉及一个表的条件下推到该表. LEFT JOIN时ON中只能有右表的条件, WHERE中只能有左表的条件.
+func (p *JoinPlan) handleJoinConditions(expr ast.ExprNode, isOn bool) ([][2]int, error) {
+	var keys [][2]int
+	for _, cond := range splitAndConditions(expr, nil) {
+		if l, r, ok := p.getJoinKey(cond); ok {
+			if p.isLeftJoin && !isOn {
+				return nil, fmt.Errorf("condition of both tables in WHERE of LEFT JOIN is not supported")
+			}
+			keys = append(keys, [2]int{l, r})
+			continue
+		}
+
+		side, err := p.getConditionSide(cond)
+		if err != nil {
+			return nil, err
+		}
+		if p.isLeftJoin && (side == p.right) != isOn {
+			return nil, fmt.Errorf("condition of %s in %s of LEFT JOIN is not supported", side.name, map[bool]string{true: "ON", false: "WHERE"}[isOn])
+		}
+		s, err := restoreNode(cond)
+		if err != nil {
+			return nil, fmt.Errorf("restore condition error: %v", err)
+		}
+		side.conds = append(side.conds, "("+s+")")
+	}
+	return keys, nil
+}
+
+// getJoinKey 判断条件是否为两个表的列的等值条件, 返回左表和右表的列的下标
+func (p *JoinPlan) getJoinKey(cond ast.ExprNode) (int, int, bool) {
+	e, ok := cond.(*ast.BinaryOperationExpr)
+	if !ok || e.Op != opcode.EQ {
+		return 0, 0, false
+	}
+	lc, ok := e.L.(*ast.ColumnNameExpr)
+	if !ok {
+		return 0, 0, false
+	}
+	rc, ok := e.R.(*ast.ColumnNameExpr)
+	if !ok {
+		return 0, 0, false
+	}
+	ls, err := p.getJoinColumnSide(lc.Name)
+	if err != nil {
+		return 0, 0, false
+	}
+	rs, err := p.getJoinColumnSide(rc.Name)
+	if err != nil || ls == rs {
+		return 0, 0, false
+	}
+	if ls == p.right {
+		lc, rc = rc, lc
+	}
+	return p.left.addColumn(lc.Name.Name.O), p.right.addColumn(rc.Name.Name.O), true
+}
+
+// getConditionSide 返回条件中的列所在的表, 条件必须只涉及一个表, 且不能包含子查询
+func (p *JoinPlan) getConditionSide(cond ast.ExprNode) (*joinSide, error) {
+	v := &joinColumnVisitor{}
+	cond.Accept(v)
+	if v.hasSubquery {
+		return nil, fmt.Errorf("subquery in condition is not supported")
+	}
+	if len(v.columns) == 0 {
+		return nil, fmt.Errorf("condition without column is not supported")
+	}
+
+	var side *joinSide
+	for _, c := range v.columns {
+		s, err := p.getJoinColumnSide(c)
+		if err != nil {
+			return nil, err
+		}
+		if side != nil && side != s {
+			return nil, fmt.Errorf("condition of both tables must be equal condition of columns")
+		}
+		side = s
+	}
+	return side, nil
+}
+
+func (p *JoinPlan) handleJoinOrderBy(items []*ast.ByItem) error {
+	for _, item := range items {
+		columnExpr, ok := item.Expr.(*ast.ColumnNameExpr)
+		if !ok {
+			return fmt.Errorf("only column is supported in ORDER BY")
+		}
+		side, err := p.getJoinColumnSide(columnExpr.Name)
+		if err != nil {
+			return err
+		}
+		p.fields = append(p.fields, &joinField{side: side, column: side.addColumn(columnExpr.Name.Name.O)})
+		p.orderByDirections = append(p.orderByDirections, item.Desc)
+	}
+	return nil
+}
+
+// chooseJoinStrategy 选择驱动表和JOIN算法.
+// INNER JOIN时路由到的子表较少的表作为驱动表, 被驱动表的JOIN列中有分片列时使用嵌套循环JOIN.
+func (p *JoinPlan) chooseJoinStrategy(keys [][2]int) {
+	p.drive, p.probe = p.left, p.right
+	if !p.isLeftJoin && countShardingSQLs(p.right.plan.GetSQLs()) < countShardingSQLs(p.left.plan.GetSQLs()) {
+		p.drive, p.probe = p.right, p.left
+	}
+
+	for _, k := range keys {
+		key := &joinKey{drive: k[0], probe: k[1]}
+		if p.drive == p.right {
+			key.drive, key.probe = k[1], k[0]
+		}
+		p.keys = append(p.keys, key)
+	}
+
+	for _, key := range p.keys {
+		if p.probe.rule.IsShardingColumn(p.probe.names[key.probe]) {
+			p.pushKey = key
+			return
+		}
+	}
+}
+
+func countShardingSQLs(sqls map[string]map[string][]string) int {
+	var n int
+	for _, dbSQLs := range sqls {
+		for _, ss := range dbSQLs {
+			n += len(ss)
+		}
+	}
+	return n
+}
+
+// ExecuteIn implement Plan
+func (p *JoinPlan) ExecuteIn(reqCtx *util.RequestContext, sess Executor) (*mysql.Result, error) {
+	maxRows := DefaultMaxJoinRows
+	if l, ok := sess.(JoinRowsLimiter); ok && l.GetMaxJoinRows() > 0 {
+		maxRows = l.GetMaxJoinRows()
+	}
+
+	driveResult, err := p.executeSide(reqCtx, sess, p.drive, "", maxRows)
+	if err != nil {
+		return nil, err
+	}
+
+	var probeResult *joinSideResult
+	if p.pushKey == nil {
+		probeResult, err = p.executeSide(reqCtx, sess, p.probe, "", maxRows)
+	} else {
+		probeResult, err = p.executeNestedLoop(reqCtx, sess, driveResult, maxRows)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return p.joinResults(driveResult, probeResult, maxRows)
+}
+
+// executeSide 执行单表查询, 在SQL中下推LIMIT maxRows+1, 结果超过maxRows行时直接报错
+func (p *JoinPlan) executeSide(reqCtx *util.RequestContext, sess Executor, side *joinSide, cond string, maxRows int) (*joinSideResult, error) {
+	return p.executeSidePlan(reqCtx, sess, side, cond, maxRows+1, maxRows)
+}
+
+// executeSidePlan 执行带LIMIT limit的单表查询, 返回的行数达到limit时说明超过了maxRows
+func (p *JoinPlan) executeSidePlan(reqCtx *util.RequestContext, sess Executor, side *joinSide, cond string, limit, maxRows int) (*joinSideResult, error) {
+	plan, err := side.buildPlan(p.db, p.router, cond, limit)
+	if err != nil {
+		return nil, fmt.Errorf("build plan of %s error: %v", side.name, err)
+	}
+	r, err := plan.ExecuteIn(reqCtx, sess)
+	if err != nil {
+		return nil, fmt.Errorf("execute %s in JoinPlan error: %v", side.name, err)
+	}
+	if limit > 0 && len(r.Values) >= limit {
+		return nil, fmt.Errorf("rows of %s in cross shard join exceed the limit %d", side.name, maxRows)
+	}
+	width := len(r.Fields) - len(side.columns)
+	if width < 0 {
+		return nil, fmt.Errorf("column count of %s in cross shard join not match, fields: %d", side.name, len(r.Fields))
+	}
+	return &joinSideResult{fields: r.Fields, width: width, rows: r.Values}, nil
+}
+
+// executeNestedLoop 把驱动表JOIN列的值分批作为IN条件下推到被驱动表执行
+func (p *JoinPlan) executeNestedLoop(reqCtx *util.RequestContext, sess Executor, drive *joinSideResult, maxRows int) (*joinSideResult, error) {
+	var values []interface{}
+	seen := make(map[string]bool)
+	for _, row := range drive.rows {
+		v := row[drive.width+p.pushKey.drive]
+		if v == nil {
+			continue
+		}
+		key, err := generateMapKey([]interface{}{v})
+		if err != nil {
+			return nil, err
+		}
+		if !seen[key] {
+			seen[key] = true
+			values = append(values, v)
+		}
+	}
+
+	// 驱动表没有结果时只查询被驱动表的列信息
+	if len(values) == 0 {
+		return p.executeSidePlan(reqCtx, sess, p.probe, "", 0, maxRows)
+	}
+
+	var ret *joinSideResult
+	for start := 0; start < len(values); start += joinBatchSize {
+		end := start + joinBatchSize
+		if end > len(values) {
+			end = len(values)
+		}
+		list, err := restoreLookupValues(values[start:end])
+		if err != nil {
+			return nil, fmt.Errorf("restore join values error: %v", err)
+		}
+		// 每批只多查询一行剩余的行数, 超过时直接报错
+		limit := maxRows + 1
+		if ret != nil {
+			limit -= len(ret.rows)
+		}
+		r, err := p.executeSidePlan(reqCtx, sess, p.probe, fmt.Sprintf("%s IN (%s)", p.probe.columns[p.pushKey.probe], list), limit, maxRows)
+		if err != nil {
+			return nil, err
+		}
+		if ret == nil {
+			ret = r
+		} else {
+			ret.rows = append(ret.rows, r.rows...)
+		}
+	}
+	return ret, nil
+}
+
+// joinResults 用被驱动表的结果构建哈希表, 按驱动表的顺序输出JOIN结果, 然后排序并处理LIMIT
+func (p *JoinPlan) joinResults(drive, probe *joinSideResult, maxRows int) (*mysql.Result, error) {
+	probeRows := make(map[string][][]interface{})
+	for _, row := range probe.rows {
+		key, ok, err := p.getRowKey(row, probe.width, false)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			probeRows[key] = append(probeRows[key], row)
+		}
+	}
+
+	var values [][]interface{}
+	for _, row := range drive.rows {
+		key, ok, err := p.getRowKey(row, drive.width, true)
+		if err != nil {
+			return nil, err
+		}
+		var matches [][]interface{}
+		if ok {
+			matches = probeRows[key]
+		}
+		if len(matches) == 0 && p.isLeftJoin {
+			matches = [][]interface{}{nil}
+		}
+		for _, match := range matches {
+			values = append(values, p.buildJoinRow(row, drive.width, match, probe.width))
+		}
+		if len(values) > maxRows {
+			return nil, fmt.Errorf("rows of cross shard join exceed the limit %d", maxRows)
+		}
+	}
+
+	r := &mysql.Resultset{
+		Fields:     p.buildJoinFields(drive, probe),
+		FieldNames: make(map[string]int),
+		Values:     values,
+	}
+	ret := &mysql.Result{Resultset: r}
+	if err := p.sortAndLimit(ret); err != nil {
+		return nil, err
+	}
+
+	// 去掉ORDER BY补充的列
+	visible := len(r.Fields) - (len(p.fields) - p.originColumnCount)
+	r.Fields = r.Fields[:visible]
+	for i := range r.Values {
+		r.Values[i] = r.Values[i][:visible]
+	}
+	for i, f := range r.Fields {
+		r.FieldNames[string(f.Name)] = i
+	}
+	if err := GenerateSelectResultRowData(ret); err != nil {
+		return nil, fmt.Errorf("generate RowData error: %v", err)
+	}
+	return ret, nil
+}
+
+// getRowKey 返回一行中JOIN列的值组成的key, JOIN列的值为NULL时不匹配任何行
+func (p *JoinPlan) getRowKey(row []interface{}, width int, isDrive bool) (string, bool, error) {
+	var values []interface{}
+	for _, k := range p.keys {
+		index := k.probe
+		if isDrive {
+			index = k.drive
+		}
+		v := row[width+index]
+		if v == nil {
+			return "", false, nil
+		}
+		values = append(values, v)
+	}
+	key, err := generateMapKey(values)
+	return key, err == nil, err
+}
+
+func (p *JoinPlan) buildJoinRow(driveRow []interface{}, driveWidth int, probeRow []interface{}, probeWidth int) []interface{} {
+	var row []interface{}
+	for _, f := range p.fields {
+		sideRow, width := driveRow, driveWidth
+		if f.side == p.probe {
+			sideRow, width = probeRow, probeWidth
+		}
+		if f.wildcard {
+			if sideRow == nil {
+				row = append(row, make([]interface{}, width)...)
+			} else {
+				row = append(row, sideRow[:width]...)
+			}
+			continue
+		}
+		if sideRow == nil {
+			row = append(row, nil)
+		} else {
+			row = append(row, sideRow[width+f.column])
+		}
+	}
+	return row
+}
+
+func (p *JoinPlan) buildJoinFields(drive, probe *joinSideResult) []*mysql.Field {
+	var fields []*mysql.Field
+	for _, f := range p.fields {
+		r := drive
+		if f.side == p.probe {
+			r = probe
+		}
+		if f.wildcard {
+			fields = append(fields, r.fields[:r.width]...)
+			continue
+		}
+		field := r.fields[r.width+f.column]
+		if f.alias != "" {
+			copied := *field
+			copied.Name = hack.Slice(f.alias)
+			field = &copied
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// sortAndLimit ORDER BY补充的列在结果的最后
+func (p *JoinPlan) sortAndLimit(ret *mysql.Result) error {
+	if len(p.orderByDirections) != 0 {
+		first := len(ret.Fields) - len(p.orderByDirections)
+		var sortKeys []mysql.SortKey
+		for i, desc := range p.orderByDirections {
+			sortKey := mysql.SortKey{Column: first + i, Direction: mysql.SortAsc}
+			if desc {
+				sortKey.Direction = mysql.SortDesc
+			}
+			sortKeys = append(sortKeys, sortKey)
+		}
+		if err := ret.SortWithoutColumnName(sortKeys); err != nil {
+			return err
+		}
+	}
+
+	if p.count == -1 {
+		return nil
+	}
+	rowLen := int64(len(ret.Values))
+	if p.offset >= rowLen {
+		ret.Values = ret.Values[:0]
+		return nil
+	}
+	end := p.offset + p.count
+	if end > rowLen {
+		end = rowLen
+	}
+	ret.Values = ret.Values[p.offset:end]
+	return nil
+}
+
+// GetSQLs 返回驱动表和被驱动表不带下推IN条件和LIMIT的SQL, 用于EXPLAIN
+func (p *JoinPlan) GetSQLs() map[string]map[string][]string {
+	ret := make(map[string]map[string][]string)
+	for _, side := range []*joinSide{p.drive, p.probe} {
+		for slice, dbSQLs := range side.plan.GetSQLs() {
+			if _, ok := ret[slice]; !ok {
+				ret[slice] = make(map[string][]string)
+			}
+			for db, sqls := range dbSQLs {
+				ret[slice][db] = append(ret[slice][db], sqls...)
+			}
+		}
+	}
+	return ret
+}
+
+// joinColumnVisitor 收集表达式中的列名
+type joinColumnVisitor struct {
+	columns     []*ast.ColumnName
+	hasSubquery bool
+}
+
+func (v *joinColumnVisitor) Enter(n ast.Node) (ast.Node, bool) {
+	switch x := n.(type) {
+	case *ast.ColumnNameExpr:
+		v.columns = append(v.columns, x.Name)
+	case *ast.SubqueryExpr:
+		v.hasSubquery = true
+		return n, true
+	}
+	return n, false
+}
+
+func (v *joinColumnVisitor) Leave(n ast.Node) (ast.Node, bool) {
+	return n, true
+}
+
+// parseSQL 解析proxy生成的SQL, parser不是线程安全的, 每次使用新的parser
+func parseSQL(sql string) (ast.StmtNode, error) {
+	return parser.New().ParseOneStmt(sql, "", "")
+}
+
+func restoreNode(node ast.Node) (string, error) {
+	sb := &strings.Builder{}
+	ctx := format.NewRestoreCtx(util.EscapeRestoreFlags, sb)
+	if err := node.Restore(ctx); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,299 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plan
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/XiaoMi/Gaea/parser"
+	"github.com/XiaoMi/Gaea/util"
+)
+
+// joinTestExecutor 在testExecutor的基础上返回跨分片JOIN的行数上限, tables的key为子表名
+type joinTestExecutor struct {
+	testExecutor
+	maxRows int
+}
+
+func (e *joinTestExecutor) GetMaxJoinRows() int {
+	return e.maxRows
+}
+
+func TestCrossShardJoinPlan(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	tests := []SQLTestcase{
+		{
+			db:  "db_ks",
+			sql: "select t.name, o.order_id from tbl_ks t join tbl_ks_order o on t.id = o.order_id where t.id = 1",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {
+						"SELECT `t`.`name`,`t`.`id` FROM `tbl_ks_0001` AS `t` WHERE (`t`.`id`=1)",
+						"SELECT `o`.`order_id` FROM `tbl_ks_order_0000` AS `o`",
+						"SELECT `o`.`order_id` FROM `tbl_ks_order_0001` AS `o`",
+					},
+				},
+				"slice-1": {
+					"db_ks": {
+						"SELECT `o`.`order_id` FROM `tbl_ks_order_0002` AS `o`",
+						"SELECT `o`.`order_id` FROM `tbl_ks_order_0003` AS `o`",
+					},
+				},
+			},
+		},
+		{
+			db:  "db_ks",
+			sql: "select o.user_id, i.id from tbl_ks_order o join tbl_ks_order_item i on o.order_id = i.id where o.order_id = 2", // binding tables joined on non-sharding column
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {
+						"SELECT `i`.`id` FROM `tbl_ks_order_item_0000` AS `i`",
+						"SELECT `i`.`id` FROM `tbl_ks_order_item_0001` AS `i`",
+					},
+				},
+				"slice-1": {
+					"db_ks": {
+						"SELECT `o`.`user_id`,`o`.`order_id` FROM `tbl_ks_order_0002` AS `o` WHERE (`o`.`order_id`=2)",
+						"SELECT `i`.`id` FROM `tbl_ks_order_item_0002` AS `i`",
+						"SELECT `i`.`id` FROM `tbl_ks_order_item_0003` AS `i`",
+					},
+				},
+			},
+		},
+		{
+			db:  "db_ks",
+			sql: "select a.id, b.id from tbl_ks a join tbl_ks b on a.name = b.name where a.id = 1", // self join on non-sharding column
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {
+						"SELECT `a`.`id`,`a`.`name` FROM `tbl_ks_0001` AS `a` WHERE (`a`.`id`=1)",
+						"SELECT `b`.`id`,`b`.`name` FROM `tbl_ks_0000` AS `b`",
+						"SELECT `b`.`id`,`b`.`name` FROM `tbl_ks_0001` AS `b`",
+					},
+				},
+				"slice-1": {
+					"db_ks": {
+						"SELECT `b`.`id`,`b`.`name` FROM `tbl_ks_0002` AS `b`",
+						"SELECT `b`.`id`,`b`.`name` FROM `tbl_ks_0003` AS `b`",
+					},
+				},
+			},
+		},
+		{
+			db:  "db_ks",
+			sql: "explain select o.user_id from tbl_ks_order o, tbl_ks p where o.order_id = p.id and o.order_id = 2 and p.id = 2",
+			sqls: map[string]map[string][]string{
+				"slice-1": {
+					"db_ks": {
+						"SELECT `o`.`user_id`,`o`.`order_id` FROM `tbl_ks_order_0002` AS `o` WHERE (`o`.`order_id`=2)",
+						"SELECT `p`.`id` FROM `tbl_ks_0002` AS `p` WHERE (`p`.`id`=2)",
+					},
+				},
+			},
+		},
+		{
+			db:     "db_ks",
+			sql:    "select name, o.order_id from tbl_ks t join tbl_ks_order o on t.id = o.order_id", // column without table name
+			hasErr: true,
+		},
+		{
+			db:     "db_ks",
+			sql:    "select t.name, count(o.order_id) from tbl_ks t join tbl_ks_order o on t.id = o.order_id group by t.name",
+			hasErr: true,
+		},
+		{
+			db:     "db_ks",
+			sql:    "select t.name from tbl_ks t join tbl_ks_order o on t.name = o.user_id + 1",
+			hasErr: true,
+		},
+		{
+			db:     "db_ks",
+			sql:    "select t.name from tbl_ks t left join tbl_ks_order o on t.id = o.order_id where o.user_id = 1",
+			hasErr: true,
+		},
+		{
+			db:     "db_ks",
+			sql:    "select t.name from tbl_ks t join tbl_ks_order o on t.id = o.order_id where o.user_id in (select k.id from tbl_ks k where k.name = t.name)",
+			hasErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.sql, getTestFunc(ns, test))
+	}
+}
+
+func TestCrossShardJoinExecute(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	tables := map[string]*testTable{
+		"tbl_ks_order_0000": {
+			columns: []string{"order_id", "user_id"},
+			rows:    [][]interface{}{{int64(4), int64(10)}, {int64(8), int64(11)}, {int64(1), int64(10)}, {int64(5), nil}},
+		},
+		// 没有数据的子表也要返回通配符展开后的列
+		"tbl_ks_order_0001": {columns: []string{"order_id", "user_id"}},
+		"tbl_ks_order_0002": {columns: []string{"order_id", "user_id"}},
+		"tbl_ks_order_0003": {columns: []string{"order_id", "user_id"}},
+		"tbl_ks_0000": {
+			columns: []string{"id", "user_id", "amount"},
+			rows:    [][]interface{}{{int64(4), int64(10), int64(100)}},
+		},
+		"tbl_ks_0001": {
+			columns: []string{"id", "user_id", "amount"},
+			rows:    [][]interface{}{{int64(1), int64(10), int64(50)}, {int64(5), nil, int64(30)}},
+		},
+	}
+
+	tests := []struct {
+		sql      string
+		maxRows  int
+		executed []string
+		fields   []string
+		values   [][]interface{}
+		hasErr   bool
+	}{
+		{
+			// hash join, JOIN列不是分片列
+			sql: "select o.order_id, p.amount as a from tbl_ks_order o join tbl_ks p on o.user_id = p.user_id where o.order_id > 0 order by p.amount desc, o.order_id limit 1, 2",
+			executed: []string{
+				"slice-0:SELECT `o`.`order_id`,`o`.`user_id` FROM `tbl_ks_order_0000` AS `o` WHERE (`o`.`order_id`>0) LIMIT 100001",
+				"slice-0:SELECT `o`.`order_id`,`o`.`user_id` FROM `tbl_ks_order_0001` AS `o` WHERE (`o`.`order_id`>0) LIMIT 100001",
+				"slice-1:SELECT `o`.`order_id`,`o`.`user_id` FROM `tbl_ks_order_0002` AS `o` WHERE (`o`.`order_id`>0) LIMIT 100001",
+				"slice-1:SELECT `o`.`order_id`,`o`.`user_id` FROM `tbl_ks_order_0003` AS `o` WHERE (`o`.`order_id`>0) LIMIT 100001",
+				"slice-0:SELECT `p`.`amount`,`p`.`user_id` FROM `tbl_ks_0000` AS `p` LIMIT 100001",
+				"slice-0:SELECT `p`.`amount`,`p`.`user_id` FROM `tbl_ks_0001` AS `p` LIMIT 100001",
+				"slice-1:SELECT `p`.`amount`,`p`.`user_id` FROM `tbl_ks_0002` AS `p` LIMIT 100001",
+				"slice-1:SELECT `p`.`amount`,`p`.`user_id` FROM `tbl_ks_0003` AS `p` LIMIT 100001",
+			},
+			fields: []string{"order_id", "a"},
+			values: [][]interface{}{{int64(4), int64(100)}, {int64(1), int64(50)}},
+		},
+		{
+			// 嵌套循环JOIN, 驱动表JOIN列的值下推到被驱动表, 没有匹配的行补NULL
+			sql: "select o.*, p.amount from tbl_ks_order o left join tbl_ks p on o.order_id = p.id and p.amount > 40 where o.order_id > 0 order by o.order_id",
+			executed: []string{
+				"slice-0:SELECT *,`o`.`order_id` FROM `tbl_ks_order_0000` AS `o` WHERE (`o`.`order_id`>0) LIMIT 100001",
+				"slice-0:SELECT *,`o`.`order_id` FROM `tbl_ks_order_0001` AS `o` WHERE (`o`.`order_id`>0) LIMIT 100001",
+				"slice-1:SELECT *,`o`.`order_id` FROM `tbl_ks_order_0002` AS `o` WHERE (`o`.`order_id`>0) LIMIT 100001",
+				"slice-1:SELECT *,`o`.`order_id` FROM `tbl_ks_order_0003` AS `o` WHERE (`o`.`order_id`>0) LIMIT 100001",
+				"slice-0:SELECT `p`.`amount`,`p`.`id` FROM `tbl_ks_0000` AS `p` WHERE (`p`.`amount`>40) AND `p`.`id` IN (4,8) LIMIT 100001",
+				"slice-0:SELECT `p`.`amount`,`p`.`id` FROM `tbl_ks_0001` AS `p` WHERE (`p`.`amount`>40) AND `p`.`id` IN (1,5) LIMIT 100001",
+			},
+			fields: []string{"order_id", "user_id", "amount"},
+			values: [][]interface{}{
+				{int64(1), int64(10), int64(50)},
+				{int64(4), int64(10), int64(100)},
+				{int64(5), nil, nil},
+				{int64(8), int64(11), nil},
+			},
+		},
+		{
+			// 驱动表的行数超过限制时直接报错, 不再查询被驱动表
+			sql:     "select o.order_id, p.amount from tbl_ks_order o join tbl_ks p on o.user_id = p.user_id",
+			maxRows: 3,
+			executed: []string{
+				"slice-0:SELECT `o`.`order_id`,`o`.`user_id` FROM `tbl_ks_order_0000` AS `o` LIMIT 4",
+				"slice-0:SELECT `o`.`order_id`,`o`.`user_id` FROM `tbl_ks_order_0001` AS `o` LIMIT 4",
+				"slice-1:SELECT `o`.`order_id`,`o`.`user_id` FROM `tbl_ks_order_0002` AS `o` LIMIT 4",
+				"slice-1:SELECT `o`.`order_id`,`o`.`user_id` FROM `tbl_ks_order_0003` AS `o` LIMIT 4",
+			},
+			hasErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.sql, func(t *testing.T) {
+			stmt, err := parser.ParseSQL(test.sql)
+			if err != nil {
+				t.Fatalf("parse sql error: %v", err)
+			}
+			p, err := BuildPlan(stmt, ns.phyDBs, "db_ks", test.sql, ns.rt, ns.seqs)
+			if err != nil {
+				t.Fatalf("BuildPlan error: %v", err)
+			}
+			if _, ok := p.(*JoinPlan); !ok {
+				t.Fatalf("plan is not JoinPlan: %T", p)
+			}
+
+			e := &joinTestExecutor{testExecutor: testExecutor{tables: tables}, maxRows: test.maxRows}
+			r, err := p.ExecuteIn(util.NewRequestContext(), e)
+			if test.hasErr {
+				if err == nil {
+					t.Fatalf("expect error")
+				}
+				t.Logf("got expect error: %v", err)
+				if !reflect.DeepEqual(e.executed, test.executed) {
+					t.Errorf("executed sql not equal, expect: %v, actual: %v", test.executed, e.executed)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("execute error: %v", err)
+			}
+
+			if !reflect.DeepEqual(e.executed, test.executed) {
+				t.Errorf("executed sql not equal, expect: %v, actual: %v", test.executed, e.executed)
+			}
+			var fields []string
+			for _, f := range r.Fields {
+				fields = append(fields, string(f.Name))
+			}
+			if !reflect.DeepEqual(fields, test.fields) {
+				t.Errorf("fields not equal, expect: %v, actual: %v", test.fields, fields)
+			}
+			if !reflect.DeepEqual(r.Values, test.values) {
+				t.Errorf("values not equal, expect: %v, actual: %v", test.values, r.Values)
+			}
+			if len(r.RowDatas) != len(test.values) {
+				t.Errorf("row data count not equal, expect: %d, actual: %d", len(test.values), len(r.RowDatas))
+			}
+		})
+	}
+}
+
+func TestCrossShardJoinEmptyDrive(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	sql := "select t.name, o.user_id from tbl_ks t join tbl_ks_order o on t.id = o.order_id where t.id = 2"
+	stmt, _ := parser.ParseSQL(sql)
+	p, err := BuildPlan(stmt, ns.phyDBs, "db_ks", sql, ns.rt, ns.seqs)
+	if err != nil {
+		t.Fatalf("BuildPlan error: %v", err)
+	}
+	e := &joinTestExecutor{}
+	r, err := p.ExecuteIn(util.NewRequestContext(), e)
+	if err != nil {
+		t.Fatalf("execute error: %v", err)
+	}
+	if len(r.Values) != 0 || len(r.Fields) != 2 {
+		t.Errorf("result not empty, fields: %d, values: %v", len(r.Fields), r.Values)
+	}
+	last := e.executed[len(e.executed)-1]
+	if !strings.HasSuffix(last, "LIMIT 0") {
+		t.Errorf("probe sql of empty drive table not limit 0: %s", last)
+	}
+}
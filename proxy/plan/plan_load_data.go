@@ -0,0 +1,389 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plan
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/pingcap/parser/ast"
+
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/proxy/router"
+	"github.com/XiaoMi/Gaea/proxy/sequence"
+	"github.com/XiaoMi/Gaea/util"
+)
+
+// 每一轮从文件中读取的行数, 读取后按子表分批插入
+const loadDataBatchRows = 5000
+
+// LocalInfileReader 由Executor实现, 向客户端请求LOAD DATA LOCAL INFILE指定的文件.
+// 返回的ReadCloser读取客户端发送的文件内容, Close时需要读完客户端发送的全部内容.
+type LocalInfileReader interface {
+	ReadLocalInfile(filename string) (io.ReadCloser, error)
+}
+
+// LoadDataPlan 导入分片表或全局表的LOAD DATA LOCAL INFILE语句.
+// 执行时向客户端请求文件, 按FIELDS和LINES选项逐行解析, 按目标表的路由规则计算每一行所在的子表,
+// 按子表分批生成多行INSERT语句写入. 不在事务中时由proxy开启事务, 出错时回滚.
+type LoadDataPlan struct {
+	basePlan
+
+	db     string
+	sql    string
+	router *router.Router
+	seq    *sequence.SequenceManager
+}
+
+// loadData 从LOAD DATA语句中解析出的目标表, 复用INSERT ... SELECT计算路由和生成INSERT语句
+type loadData struct {
+	stmt   *ast.LoadDataStmt
+	target *insertSelect
+}
+
+// buildLoadDataPlan 检查LOAD DATA语句并构建LoadDataPlan
+func buildLoadDataPlan(stmt *ast.LoadDataStmt, db, sql string, r *router.Router, seq *sequence.SequenceManager) (*LoadDataPlan, error) {
+	if _, err := newLoadData(stmt, db, sql, r, seq); err != nil {
+		return nil, err
+	}
+	return &LoadDataPlan{
+		db:     db,
+		sql:    sql,
+		router: r,
+		seq:    seq,
+	}, nil
+}
+
+func newLoadData(stmt *ast.LoadDataStmt, db, sql string, r *router.Router, seq *sequence.SequenceManager) (*loadData, error) {
+	if !stmt.IsLocal {
+		return nil, fmt.Errorf("only LOAD DATA LOCAL INFILE is supported for sharding table")
+	}
+	for _, c := range stmt.ColumnsAndUserVars {
+		if c.UserVar != nil {
+			return nil, fmt.Errorf("LOAD DATA does not support user variables in column list")
+		}
+	}
+	if len(stmt.ColumnAssignments) != 0 {
+		return nil, fmt.Errorf("LOAD DATA does not support SET clause")
+	}
+	if stmt.FieldsInfo == nil || stmt.FieldsInfo.Terminated == "" {
+		return nil, fmt.Errorf("LOAD DATA does not support empty FIELDS TERMINATED BY")
+	}
+	if stmt.LinesInfo == nil || stmt.LinesInfo.Terminated == "" {
+		return nil, fmt.Errorf("LOAD DATA does not support empty LINES TERMINATED BY")
+	}
+
+	// LOCAL没有指定REPLACE时默认为IGNORE, 与MySQL一致
+	insert := &ast.InsertStmt{
+		IsReplace: stmt.OnDuplicate == ast.OnDuplicateKeyHandlingReplace,
+		IgnoreErr: stmt.OnDuplicate == ast.OnDuplicateKeyHandlingIgnore,
+		Table:     &ast.TableRefsClause{TableRefs: &ast.Join{Left: &ast.TableSource{Source: stmt.Table}}},
+		Columns:   stmt.Columns,
+	}
+	target, err := newInsertTarget(insert, db, sql, r, seq)
+	if err != nil {
+		return nil, err
+	}
+	return &loadData{stmt: stmt, target: target}, nil
+}
+
+// ExecuteIn implement Plan
+func (p *LoadDataPlan) ExecuteIn(reqCtx *util.RequestContext, sess Executor) (*mysql.Result, error) {
+	tx, ok := sess.(TransactionExecutor)
+	if !ok {
+		return nil, fmt.Errorf("load data need transaction")
+	}
+	infile, ok := sess.(LocalInfileReader)
+	if !ok {
+		return nil, fmt.Errorf("load data local infile is not supported by executor")
+	}
+
+	// 每次执行重新解析, 避免修改缓存的计划中的AST
+	stmt, err := parseSQL(p.sql)
+	if err != nil {
+		return nil, fmt.Errorf("parse sql in LoadDataPlan error: %v", err)
+	}
+	loadStmt, ok := stmt.(*ast.LoadDataStmt)
+	if !ok {
+		return nil, fmt.Errorf("not a load data statement: %s", p.sql)
+	}
+	l, err := newLoadData(loadStmt, p.db, p.sql, p.router, p.seq)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := infile.ReadLocalInfile(loadStmt.Path)
+	if err != nil {
+		return nil, fmt.Errorf("read local infile error: %v", err)
+	}
+	// 出错时也要读完客户端发送的文件内容, 否则连接上的包会错乱
+	defer f.Close()
+
+	r, err := executeInTransaction(tx, func() (*mysql.Result, error) {
+		return l.execute(reqCtx, sess, newLoadDataReader(f, loadStmt.FieldsInfo, loadStmt.LinesInfo))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("execute in LoadDataPlan error: %v", err)
+	}
+	if r.InsertID != 0 {
+		sess.SetLastInsertID(r.InsertID)
+	}
+	return r, nil
+}
+
+// execute 逐行读取文件, 每读取loadDataBatchRows行插入一次.
+// 字段数少于列数时缺少的列使用DEFAULT, 多于列数时忽略多余的字段, 这两种情况都计为警告.
+func (l *loadData) execute(reqCtx *util.RequestContext, sess Executor, rd *loadDataReader) (*mysql.Result, error) {
+	if err := rd.skipLines(l.stmt.IgnoreLines); err != nil {
+		return nil, fmt.Errorf("skip lines of infile error: %v", err)
+	}
+
+	columnCount := l.target.columnCount
+	ret := &mysql.Result{}
+	line := l.stmt.IgnoreLines
+	for {
+		var rows [][]ast.ExprNode
+		var values [][]interface{}
+		for len(rows) < loadDataBatchRows {
+			fields, err := rd.readRow()
+			if err == io.EOF {
+				break
+			}
+			line++
+			if err != nil {
+				return nil, fmt.Errorf("read line %d of infile error: %v", line, err)
+			}
+			if len(fields) != columnCount {
+				ret.Warnings = addWarnings(ret.Warnings, 1)
+			}
+
+			row := make([]ast.ExprNode, columnCount)
+			value := make([]interface{}, columnCount)
+			for i := range row {
+				if i >= len(fields) {
+					row[i] = &ast.DefaultExpr{}
+					continue
+				}
+				value[i] = fields[i]
+				row[i] = ast.NewValueExpr(fields[i], "", "")
+			}
+			rows = append(rows, row)
+			values = append(values, value)
+		}
+		if len(rows) == 0 {
+			return ret, nil
+		}
+
+		r, err := l.target.insertRows(reqCtx, sess, rows, values)
+		if err != nil {
+			return nil, err
+		}
+		ret.AffectedRows += r.AffectedRows
+		ret.Warnings = addWarnings(ret.Warnings, r.Warnings)
+		if ret.InsertID == 0 {
+			ret.InsertID = r.InsertID
+		}
+		if len(rows) < loadDataBatchRows {
+			return ret, nil
+		}
+	}
+}
+
+// loadDataReader 按LOAD DATA的FIELDS和LINES选项解析文件, 每次读取一行.
+// 字段的值为string, NULL值为nil. 不支持FIELDS TERMINATED BY为空的定长格式.
+type loadDataReader struct {
+	r *bufio.Reader
+
+	fieldTerminated []byte
+	enclosed        byte // 为0时字段没有包围字符
+	escaped         byte // 为0时没有转义字符
+	lineStarting    []byte
+	lineTerminated  []byte
+}
+
+func newLoadDataReader(r io.Reader, fields *ast.FieldsClause, lines *ast.LinesClause) *loadDataReader {
+	return &loadDataReader{
+		r:               bufio.NewReader(r),
+		fieldTerminated: []byte(fields.Terminated),
+		enclosed:        fields.Enclosed,
+		escaped:         fields.Escaped,
+		lineStarting:    []byte(lines.Starting),
+		lineTerminated:  []byte(lines.Terminated),
+	}
+}
+
+// skipLines 跳过IGNORE n LINES指定的行
+func (rd *loadDataReader) skipLines(n uint64) error {
+	for ; n > 0; n-- {
+		for !rd.consume(rd.lineTerminated) {
+			if _, err := rd.r.ReadByte(); err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readRow 读取一行, 没有更多的行时返回io.EOF
+func (rd *loadDataReader) readRow() ([]interface{}, error) {
+	// 跳过LINES STARTING BY之前的内容, 不包含前缀的行会被整行跳过
+	if len(rd.lineStarting) != 0 {
+		for !rd.consume(rd.lineStarting) {
+			if _, err := rd.r.ReadByte(); err != nil {
+				return nil, err
+			}
+		}
+	} else if _, err := rd.r.Peek(1); err != nil {
+		return nil, err
+	}
+
+	var row []interface{}
+	for {
+		field, endOfLine, err := rd.readField()
+		if err != nil {
+			return nil, err
+		}
+		row = append(row, field)
+		if endOfLine {
+			return row, nil
+		}
+	}
+}
+
+// readField 读取一个字段, endOfLine表示字段之后是行结束符或者文件结束
+func (rd *loadDataReader) readField() (field interface{}, endOfLine bool, err error) {
+	if rd.enclosed != 0 && rd.consume([]byte{rd.enclosed}) {
+		return rd.readEnclosedField()
+	}
+
+	var buf bytes.Buffer
+	escapedNull := false // 字段是否为转义的\N
+	for {
+		if rd.consume(rd.lineTerminated) {
+			endOfLine = true
+			break
+		}
+		if rd.consume(rd.fieldTerminated) {
+			break
+		}
+		c, err := rd.r.ReadByte()
+		if err == io.EOF {
+			endOfLine = true
+			break
+		} else if err != nil {
+			return nil, false, err
+		}
+		if escapedNull {
+			buf.WriteByte('N')
+			escapedNull = false
+		}
+		if rd.escaped != 0 && c == rd.escaped {
+			next, err := rd.readEscaped()
+			if err != nil {
+				return nil, false, err
+			}
+			if buf.Len() == 0 && next == 'N' {
+				escapedNull = true
+			} else {
+				buf.WriteByte(next)
+			}
+			continue
+		}
+		buf.WriteByte(c)
+	}
+
+	// 有包围字符时, 没有被包围的NULL也表示NULL值
+	if escapedNull || (rd.enclosed != 0 && buf.String() == "NULL") {
+		return nil, endOfLine, nil
+	}
+	return buf.String(), endOfLine, nil
+}
+
+// readEnclosedField 读取被包围的字段, 连续两个包围字符表示一个包围字符.
+// 包围字符之后不是字段结束符或行结束符时, 按普通字符处理.
+func (rd *loadDataReader) readEnclosedField() (field interface{}, endOfLine bool, err error) {
+	var buf bytes.Buffer
+	for {
+		c, err := rd.r.ReadByte()
+		if err == io.EOF {
+			return buf.String(), true, nil
+		} else if err != nil {
+			return nil, false, err
+		}
+
+		switch {
+		case rd.escaped != 0 && c == rd.escaped:
+			next, err := rd.readEscaped()
+			if err != nil {
+				return nil, false, err
+			}
+			buf.WriteByte(next)
+		case c == rd.enclosed:
+			if rd.consume([]byte{rd.enclosed}) {
+				buf.WriteByte(c)
+			} else if rd.consume(rd.fieldTerminated) {
+				return buf.String(), false, nil
+			} else if rd.consume(rd.lineTerminated) {
+				return buf.String(), true, nil
+			} else if _, err := rd.r.Peek(1); err == io.EOF {
+				return buf.String(), true, nil
+			} else {
+				buf.WriteByte(c)
+			}
+		default:
+			buf.WriteByte(c)
+		}
+	}
+}
+
+// readEscaped 读取转义字符之后的字符并转换, 文件在转义字符处结束时返回转义字符本身
+func (rd *loadDataReader) readEscaped() (byte, error) {
+	c, err := rd.r.ReadByte()
+	if err == io.EOF {
+		return rd.escaped, nil
+	} else if err != nil {
+		return 0, err
+	}
+	switch c {
+	case '0':
+		return 0, nil
+	case 'b':
+		return '\b', nil
+	case 'n':
+		return '\n', nil
+	case 'r':
+		return '\r', nil
+	case 't':
+		return '\t', nil
+	case 'Z':
+		return 0x1a, nil
+	default:
+		return c, nil
+	}
+}
+
+// consume 如果接下来的内容是s则读取并返回true, 否则不读取并返回false
+func (rd *loadDataReader) consume(s []byte) bool {
+	b, err := rd.r.Peek(len(s))
+	if err != nil || !bytes.Equal(b, s) {
+		return false
+	}
+	_, _ = rd.r.Discard(len(s))
+	return true
+}
@@ -0,0 +1,304 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plan
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pingcap/parser/ast"
+
+	"github.com/XiaoMi/Gaea/parser"
+	"github.com/XiaoMi/Gaea/util"
+)
+
+// loadDataTestExecutor 在lookupTestExecutor的基础上返回LOAD DATA LOCAL INFILE的文件内容
+type loadDataTestExecutor struct {
+	lookupTestExecutor
+	infile   string
+	filename string
+	closed   bool
+}
+
+func (e *loadDataTestExecutor) ReadLocalInfile(filename string) (io.ReadCloser, error) {
+	e.filename = filename
+	return &testInfile{Reader: strings.NewReader(e.infile), e: e}, nil
+}
+
+type testInfile struct {
+	*strings.Reader
+	e *loadDataTestExecutor
+}
+
+func (f *testInfile) Close() error {
+	f.e.closed = true
+	return nil
+}
+
+func TestLoadData(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	tests := []struct {
+		sql           string
+		infile        string
+		inTransaction bool
+		affectedRows  uint64
+		warnings      uint16
+		hasErr        bool
+		executed      []string
+	}{
+		{
+			// 默认格式, 每一行按分片列路由到不同的子表, LOCAL默认为IGNORE.
+			// 插入列中没有全局序列号列user_id, 由proxy为每一行生成
+			sql:          "load data local infile '/tmp/ks.txt' into table tbl_ks (id, code, name)",
+			infile:       "1\ta\tx\n6\t\\N\tit's\\tz\n",
+			affectedRows: 2,
+			executed: []string{
+				"BEGIN",
+				"slice-0:INSERT IGNORE INTO `tbl_ks_0001` (`id`,`code`,`name`,`user_id`) VALUES ('1','a','x',1)",
+				"slice-1:INSERT IGNORE INTO `tbl_ks_0002` (`id`,`code`,`name`,`user_id`) VALUES ('6',NULL,'it''s\tz',2)",
+				"COMMIT",
+			},
+		},
+		{
+			// CSV格式, 在客户端的事务中执行, 同一个子表的行合并为一条REPLACE
+			sql:           "load data local infile '/tmp/ks.csv' replace into table tbl_ks fields terminated by ',' optionally enclosed by '\"' lines terminated by '\\r\\n' ignore 1 lines (id, name)",
+			infile:        "id,name\r\n1,\"a,\"\"b\"\"\"\r\n5,NULL\r\n",
+			inTransaction: true,
+			affectedRows:  1,
+			executed: []string{
+				"slice-0:REPLACE INTO `tbl_ks_0001` (`id`,`name`,`user_id`) VALUES ('1','a,\"b\"',3),('5',NULL,4)",
+			},
+		},
+		{
+			// 全局表的每个子表都插入全部的行, 字段数与列数不一致时计为警告
+			sql:          "load data local infile '/tmp/global.txt' into table tbl_ks_global_one fields terminated by ',' lines starting by 'xxx' (id, name)",
+			infile:       "skipped line\nxxx1,a,extra\nabcxxx2",
+			affectedRows: 4,
+			warnings:     2,
+			executed: []string{
+				"BEGIN",
+				"slice-0:INSERT IGNORE INTO `tbl_ks_global_one` (`id`,`name`) VALUES ('1','a'),('2',DEFAULT)",
+				"slice-0:INSERT IGNORE INTO `tbl_ks_global_one` (`id`,`name`) VALUES ('1','a'),('2',DEFAULT)",
+				"slice-1:INSERT IGNORE INTO `tbl_ks_global_one` (`id`,`name`) VALUES ('1','a'),('2',DEFAULT)",
+				"slice-1:INSERT IGNORE INTO `tbl_ks_global_one` (`id`,`name`) VALUES ('1','a'),('2',DEFAULT)",
+				"COMMIT",
+			},
+		},
+		{
+			// standard规则按分库和分表两列的值路由
+			sql:          "load data local infile '/tmp/standard.txt' into table tbl_ks_standard (tenant_id, order_id, a)",
+			infile:       "1\t2\tx\n6\t3\ty\n",
+			affectedRows: 2,
+			executed: []string{
+				"BEGIN",
+				"slice-0:INSERT IGNORE INTO `tbl_ks_standard_0001` (`tenant_id`,`order_id`,`a`) VALUES ('6','3','y')",
+				"slice-1:INSERT IGNORE INTO `tbl_ks_standard_0002` (`tenant_id`,`order_id`,`a`) VALUES ('1','2','x')",
+				"COMMIT",
+			},
+		},
+		{
+			// 基因分片的基因列order_id由proxy生成, 嵌入同一行中分片列user_id的基因
+			sql:          "load data local infile '/tmp/gene.txt' into table tbl_ks_gene (user_id, a)",
+			infile:       "33\tx\n30\ty\n",
+			affectedRows: 2,
+			executed: []string{
+				"BEGIN",
+				"slice-0:INSERT IGNORE INTO `tbl_ks_gene_0001` (`user_id`,`a`,`order_id`) VALUES ('33','x',17)", // 1<<4 | 33&15
+				"slice-1:INSERT IGNORE INTO `tbl_ks_gene_0002` (`user_id`,`a`,`order_id`) VALUES ('30','y',46)", // 2<<4 | 30&15
+				"COMMIT",
+			},
+		},
+		{
+			// 没有分片列时不能生成带基因的序列号
+			sql:    "load data local infile '/tmp/gene.txt' into table tbl_ks_gene (a)",
+			infile: "x\n",
+			hasErr: true,
+			executed: []string{
+				"BEGIN",
+				"ROLLBACK",
+			},
+		},
+		{
+			// 空文件
+			sql: "load data local infile '/tmp/empty.txt' into table tbl_ks (id, name)",
+			executed: []string{
+				"BEGIN",
+				"COMMIT",
+			},
+		},
+		{
+			// 分片列的值为NULL时回滚
+			sql:    "load data local infile '/tmp/null.txt' into table tbl_ks (id, name)",
+			infile: "1\tx\n\\N\ty\n",
+			hasErr: true,
+			executed: []string{
+				"BEGIN",
+				"ROLLBACK",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.sql, func(t *testing.T) {
+			stmt, err := parser.ParseSQL(test.sql)
+			if err != nil {
+				t.Fatalf("parse sql error: %v", err)
+			}
+			p, err := BuildPlan(stmt, ns.phyDBs, "db_ks", test.sql, ns.rt, ns.seqs)
+			if err != nil {
+				t.Fatalf("build plan error: %v", err)
+			}
+			e := &loadDataTestExecutor{
+				lookupTestExecutor: lookupTestExecutor{
+					testExecutor:  testExecutor{handle: moveResults(nil, nil, nil, 0)},
+					inTransaction: test.inTransaction,
+				},
+				infile: test.infile,
+			}
+			r, err := p.ExecuteIn(util.NewRequestContext(), e)
+			if test.hasErr {
+				if err == nil {
+					t.Errorf("execute should fail")
+				}
+			} else if err != nil {
+				t.Fatalf("execute error: %v", err)
+			} else if r.AffectedRows != test.affectedRows || r.Warnings != test.warnings {
+				t.Errorf("result not equal, expect: %d rows %d warnings, actual: %d rows %d warnings",
+					test.affectedRows, test.warnings, r.AffectedRows, r.Warnings)
+			}
+			if !reflect.DeepEqual(e.executed, test.executed) {
+				t.Errorf("executed sqls not equal, expect: %v, actual: %v", test.executed, e.executed)
+			}
+			if path := stmt.(*ast.LoadDataStmt).Path; e.filename != path {
+				t.Errorf("infile name not equal, expect: %s, actual: %s", path, e.filename)
+			}
+			if !e.closed {
+				t.Errorf("infile not closed")
+			}
+			if e.inTransaction != test.inTransaction {
+				t.Errorf("transaction status not restored")
+			}
+		})
+	}
+}
+
+func TestLoadDataError(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	buildErrors := []string{
+		"load data infile '/tmp/a.txt' into table tbl_ks (id, name)",                         // 不是LOCAL
+		"load data local infile '/tmp/a.txt' into table tbl_ks",                              // 没有指定列
+		"load data local infile '/tmp/a.txt' into table tbl_ks (name)",                       // 没有分片列
+		"load data local infile '/tmp/a.txt' into table tbl_ks (id, @name)",                  // 用户变量
+		"load data local infile '/tmp/a.txt' into table tbl_ks (id) set name = 'x'",          // SET子句
+		"load data local infile '/tmp/a.txt' into table tbl_ks fields terminated by '' (id)", // 定长格式
+		"load data local infile '/tmp/a.txt' into table tbl_ks_move (user_id, name)",         // 有lookup的表, LOCAL默认为IGNORE
+		"load data local infile '/tmp/a.txt' replace into table tbl_ks_move (user_id, name)", // 有lookup的表
+	}
+	for _, sql := range buildErrors {
+		stmt, err := parser.ParseSQL(sql)
+		if err != nil {
+			t.Fatalf("parse sql error: %v", err)
+		}
+		if _, err := BuildPlan(stmt, ns.phyDBs, "db_ks", sql, ns.rt, ns.seqs); err == nil {
+			t.Errorf("build plan should fail: %s", sql)
+		}
+	}
+
+	// 不支持读取客户端文件的Executor
+	sql := "load data local infile '/tmp/a.txt' into table tbl_ks (id, name)"
+	stmt, err := parser.ParseSQL(sql)
+	if err != nil {
+		t.Fatalf("parse sql error: %v", err)
+	}
+	p, err := BuildPlan(stmt, ns.phyDBs, "db_ks", sql, ns.rt, ns.seqs)
+	if err != nil {
+		t.Fatalf("build plan error: %v", err)
+	}
+	e := &lookupTestExecutor{}
+	if _, err := p.ExecuteIn(util.NewRequestContext(), e); err == nil {
+		t.Errorf("execute without local infile reader should fail")
+	}
+	if len(e.executed) != 0 {
+		t.Errorf("nothing should be executed, actual: %v", e.executed)
+	}
+}
+
+func TestLoadDataReader(t *testing.T) {
+	tests := []struct {
+		fields *ast.FieldsClause
+		lines  *ast.LinesClause
+		infile string
+		rows   [][]interface{}
+	}{
+		{
+			// 转义字符, \N只有作为整个字段时才表示NULL
+			fields: &ast.FieldsClause{Terminated: "\t", Escaped: '\\'},
+			lines:  &ast.LinesClause{Terminated: "\n"},
+			infile: "a\\0b\\nc\t\\N\t\\Nx\t\\N\\N\t\\\t\\\\\n\n",
+			rows: [][]interface{}{
+				{"a\x00b\nc", nil, "Nx", "NN", "\t\\"},
+				{""},
+			},
+		},
+		{
+			// 包围字符中的行结束符和字段结束符, 被包围的NULL是字符串, 最后一行没有行结束符
+			fields: &ast.FieldsClause{Terminated: ",", Enclosed: '"', Escaped: '\\'},
+			lines:  &ast.LinesClause{Terminated: "\n"},
+			infile: "\"a\nb\",\"NULL\",NULL,\"x\"y\"\n\"c\\\"d\",,\"\"",
+			rows: [][]interface{}{
+				{"a\nb", "NULL", nil, "x\"y"},
+				{"c\"d", "", ""},
+			},
+		},
+		{
+			// 多字节的结束符, 没有转义字符
+			fields: &ast.FieldsClause{Terminated: "||"},
+			lines:  &ast.LinesClause{Starting: ">", Terminated: "\r\n"},
+			infile: ">a|b||\\N\r\nignored\r\n>c||d\r\n",
+			rows: [][]interface{}{
+				{"a|b", "\\N"},
+				{"c", "d"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		rd := newLoadDataReader(strings.NewReader(test.infile), test.fields, test.lines)
+		var rows [][]interface{}
+		for {
+			row, err := rd.readRow()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("read row error: %v", err)
+			}
+			rows = append(rows, row)
+		}
+		if !reflect.DeepEqual(rows, test.rows) {
+			t.Errorf("rows not equal, infile: %q, expect: %q, actual: %q", test.infile, test.rows, rows)
+		}
+	}
+}
@@ -0,0 +1,412 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plan
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/proxy/router"
+	"github.com/XiaoMi/Gaea/util"
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/format"
+	"github.com/pingcap/parser/model"
+	driver "github.com/pingcap/tidb/types/parser_driver"
+)
+
+// lookupSQL 在映射表所在的slice上执行的SQL
+type lookupSQL struct {
+	slice string
+	db    string
+	sql   string
+}
+
+// lookupRoute 通过映射表计算路由: 先查询lookup列的值对应的分片列的值, 再计算分片列的值对应的子表
+type lookupRoute struct {
+	rule router.Rule
+	sql  *lookupSQL // SELECT DISTINCT key FROM mapping_table WHERE column IN (...)
+}
+
+// lookupMaintenance 维护UPDATE和DELETE语句修改的行在映射表中的记录.
+// 执行语句之前先查询出被修改的行中分片列和lookup列的值, 删除旧的映射记录, 再插入新的映射记录.
+type lookupMaintenance struct {
+	lookups    []*router.Lookup
+	newValues  []interface{} // UPDATE语句中lookup列的新值, 与lookups一一对应, DELETE语句为nil
+	stmt       *ast.SelectStmt
+	selectSQLs map[int]string // key: table index
+}
+
+// lookupPlan 用于需要在执行时计算路由或者维护映射表的SELECT, UPDATE和DELETE语句.
+// 构建计划时为路由结果中的每个子表生成SQL, 执行时再选出实际路由到的子表的SQL.
+type lookupPlan struct {
+	rule        router.Rule
+	route       *lookupRoute // 为nil时使用构建计划时计算的路由
+	indexes     []int        // 构建计划时计算的路由
+	tableSQLs   map[int]string
+	maintenance *lookupMaintenance
+}
+
+// findLookupRoute 查找WHERE条件中AND连接的lookup列的等值条件或IN条件, 必须在handleComparisonExpr改写WHERE条件之前调用.
+// 只支持单个分片表的语句, 没有lookup条件时返回nil.
+func findLookupRoute(p *TableAliasStmtInfo, where ast.ExprNode) (*lookupRoute, error) {
+	rule, ok := getSingleShardRule(p)
+	if !ok || where == nil || len(rule.GetLookups()) == 0 {
+		return nil, nil
+	}
+
+	for _, expr := range splitAndConditions(where, nil) {
+		column, values, ok := getShardingConditionValues(expr)
+		if !ok {
+			continue
+		}
+		db, table, columnName := getColumnInfoFromColumnName(column.Name)
+		if table != "" {
+			if r, _, err := p.getSettedRuleFromTable(db, table); err != nil || r != rule {
+				continue
+			}
+		}
+		lookup, ok := rule.GetLookup(columnName)
+		if !ok {
+			continue
+		}
+		sql, err := buildLookupSelectSQL(rule, lookup, values)
+		if err != nil {
+			return nil, fmt.Errorf("build lookup sql error: %v", err)
+		}
+		return &lookupRoute{rule: rule, sql: sql}, nil
+	}
+	return nil, nil
+}
+
+// newLookupMaintenance 根据改写后的语句生成查询被修改的行的SELECT语句
+func newLookupMaintenance(rule router.Rule, lookups []*router.Lookup, newValues []interface{},
+	from *ast.TableRefsClause, where ast.ExprNode, order *ast.OrderByClause, limit *ast.Limit) *lookupMaintenance {
+	fields := []*ast.SelectField{createLookupSelectField(rule.GetShardingColumn())}
+	for _, lookup := range lookups {
+		fields = append(fields, createLookupSelectField(lookup.Column))
+	}
+	stmt := &ast.SelectStmt{
+		SelectStmtOpts: &ast.SelectStmtOpts{SQLCache: true},
+		Fields:         &ast.FieldList{Fields: fields},
+		From:           from,
+		Where:          where,
+		OrderBy:        order,
+		Limit:          limit,
+		LockTp:         ast.SelectLockForUpdate,
+	}
+	return &lookupMaintenance{
+		lookups:   lookups,
+		newValues: newValues,
+		stmt:      stmt,
+	}
+}
+
+func createLookupSelectField(column string) *ast.SelectField {
+	return &ast.SelectField{
+		Expr: &ast.ColumnNameExpr{Name: &ast.ColumnName{Name: model.NewCIStr(column)}},
+	}
+}
+
+// buildLookupPlan 在语句改写完成后调用, 不需要lookup路由也不需要维护映射表时返回nil.
+// 已经通过分片列计算出路由时, 不再查询映射表.
+func buildLookupPlan(p *TableAliasStmtInfo, stmt ast.StmtNode, route *lookupRoute, maintenance *lookupMaintenance) (*lookupPlan, error) {
+	result := p.GetRouteResult()
+	rule, ok := getSingleShardRule(p)
+	if !ok {
+		return nil, nil
+	}
+	if route != nil && (route.rule != rule || len(result.GetShardIndexes()) < len(rule.GetSubTableIndexes())) {
+		route = nil
+	}
+	if route == nil && maintenance == nil {
+		return nil, nil
+	}
+
+	tableSQLs, err := generateTableSQLs(stmt, result)
+	if err != nil {
+		return nil, err
+	}
+	if maintenance != nil {
+		if maintenance.selectSQLs, err = generateTableSQLs(maintenance.stmt, result); err != nil {
+			return nil, err
+		}
+	}
+	return &lookupPlan{
+		rule:        rule,
+		route:       route,
+		indexes:     result.GetShardIndexes(),
+		tableSQLs:   tableSQLs,
+		maintenance: maintenance,
+	}, nil
+}
+
+// getSQLs 计算路由并维护映射表, 返回需要执行的SQL
+func (l *lookupPlan) getSQLs(reqCtx *util.RequestContext, sess Executor) (map[string]map[string][]string, error) {
+	indexes, err := l.getTableIndexes(reqCtx, sess)
+	if err != nil {
+		return nil, err
+	}
+
+	if l.maintenance != nil && len(indexes) != 0 {
+		if err := l.maintenance.execute(reqCtx, sess, l.rule, getTableIndexesSQLs(l.rule, l.maintenance.selectSQLs, indexes)); err != nil {
+			return nil, fmt.Errorf("maintain lookup table error: %v", err)
+		}
+	}
+	return getTableIndexesSQLs(l.rule, l.tableSQLs, indexes), nil
+}
+
+// execute 计算路由并执行UPDATE或DELETE语句.
+// 需要维护映射表时, 映射记录和分片表的行在同一个事务中修改, 出错时回滚.
+func (l *lookupPlan) execute(reqCtx *util.RequestContext, sess Executor) (*mysql.Result, error) {
+	if l.maintenance == nil {
+		return l.executeSQLs(reqCtx, sess)
+	}
+	tx, ok := sess.(TransactionExecutor)
+	if !ok {
+		return nil, fmt.Errorf("maintain lookup table need transaction")
+	}
+	return executeInTransaction(tx, func() (*mysql.Result, error) {
+		return l.executeSQLs(reqCtx, sess)
+	})
+}
+
+func (l *lookupPlan) executeSQLs(reqCtx *util.RequestContext, sess Executor) (*mysql.Result, error) {
+	sqls, err := l.getSQLs(reqCtx, sess)
+	if err != nil {
+		return nil, err
+	}
+	if len(sqls) == 0 {
+		return nil, nil
+	}
+	rs, err := sess.ExecuteSQLs(reqCtx, sqls)
+	if err != nil {
+		return nil, err
+	}
+	return MergeExecResult(rs)
+}
+
+// getTableIndexes 计算路由, 返回需要执行的子表下标
+func (l *lookupPlan) getTableIndexes(reqCtx *util.RequestContext, sess Executor) ([]int, error) {
+	indexes := l.indexes
+	if l.route != nil {
+		found, err := l.route.findTableIndexes(reqCtx, sess)
+		if err != nil {
+			return nil, err
+		}
+		indexes = interList(indexes, found)
+		// 映射表中没有记录说明不存在对应的行, 仍然在一个子表上执行, 以得到空结果或聚合函数的结果
+		if len(indexes) == 0 && len(l.indexes) != 0 {
+			indexes = l.indexes[:1]
+		}
+	}
+	return indexes, nil
+}
+
+// findTableIndexes 查询映射表, 返回有序的子表下标
+func (r *lookupRoute) findTableIndexes(reqCtx *util.RequestContext, sess Executor) ([]int, error) {
+	rs, err := sess.ExecuteSQL(reqCtx, r.sql.slice, r.sql.db, r.sql.sql)
+	if err != nil {
+		return nil, fmt.Errorf("query lookup table error: %v", err)
+	}
+	if rs.Resultset == nil {
+		return nil, nil
+	}
+
+	indexSet := make(map[int]bool)
+	shardingColumn := r.rule.GetShardingColumn()
+	for _, row := range rs.Values {
+		if len(row) == 0 || row[0] == nil {
+			continue
+		}
+		indexes, err := r.rule.FindTableIndexes(shardingColumn, row[0])
+		if err != nil {
+			return nil, fmt.Errorf("find table index error: %v", err)
+		}
+		for _, index := range indexes {
+			indexSet[index] = true
+		}
+	}
+
+	indexes := make([]int, 0, len(indexSet))
+	for index := range indexSet {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	return indexes, nil
+}
+
+func (m *lookupMaintenance) execute(reqCtx *util.RequestContext, sess Executor, rule router.Rule, sqls map[string]map[string][]string) error {
+	rs, err := sess.ExecuteSQLs(reqCtx, sqls)
+	if err != nil {
+		return err
+	}
+
+	var lookupSQLs []*lookupSQL
+	for i, lookup := range m.lookups {
+		var oldValues []interface{}
+		var rows [][]interface{}
+		for _, r := range rs {
+			if r.Resultset == nil {
+				continue
+			}
+			for _, row := range r.Values {
+				if row[i+1] != nil {
+					oldValues = append(oldValues, row[i+1])
+				}
+				if m.newValues != nil && m.newValues[i] != nil && row[0] != nil {
+					rows = append(rows, []interface{}{m.newValues[i], row[0]})
+				}
+			}
+		}
+
+		if len(oldValues) != 0 {
+			sql, err := buildLookupDeleteSQL(rule, lookup, oldValues)
+			if err != nil {
+				return err
+			}
+			lookupSQLs = append(lookupSQLs, sql)
+		}
+		if len(rows) != 0 {
+			sql, err := buildLookupInsertSQL(rule, lookup, rows)
+			if err != nil {
+				return err
+			}
+			lookupSQLs = append(lookupSQLs, sql)
+		}
+	}
+	return executeLookupSQLs(reqCtx, sess, lookupSQLs)
+}
+
+func executeLookupSQLs(reqCtx *util.RequestContext, sess Executor, sqls []*lookupSQL) error {
+	for _, s := range sqls {
+		if _, err := sess.ExecuteSQL(reqCtx, s.slice, s.db, s.sql); err != nil {
+			return fmt.Errorf("execute lookup sql error: %v", err)
+		}
+	}
+	return nil
+}
+
+// getSingleShardRule 返回语句中唯一的分片表的路由规则
+func getSingleShardRule(p *TableAliasStmtInfo) (router.Rule, bool) {
+	if len(p.tableRules) != 1 {
+		return nil, false
+	}
+	for _, rule := range p.tableRules {
+		return rule, true
+	}
+	return nil, false
+}
+
+// generateTableSQLs 与generateShardingSQLs类似, 返回路由结果中每个子表对应的SQL
+func generateTableSQLs(stmt ast.StmtNode, result *RouteResult) (map[int]string, error) {
+	ret := make(map[int]string)
+	for result.HasNext() {
+		sb := &strings.Builder{}
+		ctx := format.NewRestoreCtx(util.EscapeRestoreFlags, sb)
+		if err := stmt.Restore(ctx); err != nil {
+			result.Reset()
+			return nil, err
+		}
+		ret[result.Next()] = sb.String()
+	}
+
+	result.Reset() // must reset the cursor for next call
+
+	return ret, nil
+}
+
+// getTableIndexesSQLs 按slice和DB组织子表的SQL, 格式与generateShardingSQLs相同
+func getTableIndexesSQLs(rule router.Rule, tableSQLs map[int]string, indexes []int) map[string]map[string][]string {
+	ret := make(map[string]map[string][]string)
+	for _, index := range indexes {
+		sql, ok := tableSQLs[index]
+		if !ok {
+			continue
+		}
+		sliceName := rule.GetSlice(rule.GetSliceIndexFromTableIndex(index))
+		dbName, _ := rule.GetDatabaseNameByTableIndex(index)
+		if _, ok := ret[sliceName]; !ok {
+			ret[sliceName] = make(map[string][]string)
+		}
+		ret[sliceName][dbName] = append(ret[sliceName][dbName], sql)
+	}
+	return ret
+}
+
+func buildLookupSelectSQL(rule router.Rule, lookup *router.Lookup, values []interface{}) (*lookupSQL, error) {
+	list, err := restoreLookupValues(values)
+	if err != nil {
+		return nil, err
+	}
+	sql := fmt.Sprintf("SELECT DISTINCT %s FROM %s WHERE %s IN (%s)",
+		quoteLookupName(rule.GetShardingColumn()), quoteLookupName(lookup.Table), quoteLookupName(lookup.Column), list)
+	return &lookupSQL{slice: lookup.Slice, db: rule.GetDB(), sql: sql}, nil
+}
+
+func buildLookupDeleteSQL(rule router.Rule, lookup *router.Lookup, values []interface{}) (*lookupSQL, error) {
+	list, err := restoreLookupValues(values)
+	if err != nil {
+		return nil, err
+	}
+	sql := fmt.Sprintf("DELETE FROM %s WHERE %s IN (%s)", quoteLookupName(lookup.Table), quoteLookupName(lookup.Column), list)
+	return &lookupSQL{slice: lookup.Slice, db: rule.GetDB(), sql: sql}, nil
+}
+
+// buildLookupInsertSQL 生成插入映射记录的SQL, rows的每一行为lookup列的值和分片列的值
+func buildLookupInsertSQL(rule router.Rule, lookup *router.Lookup, rows [][]interface{}) (*lookupSQL, error) {
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "INSERT INTO %s (%s,%s) VALUES ",
+		quoteLookupName(lookup.Table), quoteLookupName(lookup.Column), quoteLookupName(rule.GetShardingColumn()))
+	for i, row := range rows {
+		list, err := restoreLookupValues(row)
+		if err != nil {
+			return nil, err
+		}
+		if i != 0 {
+			sb.WriteString(",")
+		}
+		fmt.Fprintf(sb, "(%s)", list)
+	}
+	return &lookupSQL{slice: lookup.Slice, db: rule.GetDB(), sql: sb.String()}, nil
+}
+
+func restoreLookupValues(values []interface{}) (string, error) {
+	sb := &strings.Builder{}
+	ctx := format.NewRestoreCtx(util.EscapeRestoreFlags, sb)
+	for i, v := range values {
+		if i != 0 {
+			sb.WriteString(",")
+		}
+		if err := ast.NewValueExpr(v, "", "").Restore(ctx); err != nil {
+			return "", err
+		}
+	}
+	return sb.String(), nil
+}
+
+func quoteLookupName(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
+
+// getLookupValue 获取INSERT和UPDATE语句中lookup列的值, 只支持常量
+func getLookupValue(column string, expr ast.ExprNode) (interface{}, error) {
+	valueExpr, ok := foldSignedValueExpr(expr).(*driver.ValueExpr)
+	if !ok {
+		return nil, fmt.Errorf("value of lookup column %s must be a constant", column)
+	}
+	return util.GetValueExprResult(valueExpr)
+}
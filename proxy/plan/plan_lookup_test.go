@@ -0,0 +1,429 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plan
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/parser"
+	"github.com/XiaoMi/Gaea/util"
+)
+
+// lookupTestExecutor 在testExecutor的基础上支持事务, 记录事务操作
+type lookupTestExecutor struct {
+	testExecutor
+	inTransaction bool
+}
+
+// lookupResults 查询映射表时返回keys, 查询被修改的行时返回rows, 其他SQL返回影响1行
+func lookupResults(keys []interface{}, rows [][]interface{}) func(slice, db, sql string) (*mysql.Result, error) {
+	return func(slice, db, sql string) (*mysql.Result, error) {
+		switch {
+		case strings.HasSuffix(sql, "FOR UPDATE"):
+			return &mysql.Result{Resultset: &mysql.Resultset{Values: rows}}, nil
+		case strings.HasPrefix(sql, "SELECT DISTINCT"):
+			r := &mysql.Result{Resultset: &mysql.Resultset{}}
+			for _, key := range keys {
+				r.Values = append(r.Values, []interface{}{key})
+			}
+			return r, nil
+		default:
+			return &mysql.Result{AffectedRows: 1}, nil
+		}
+	}
+}
+
+func (e *lookupTestExecutor) IsInTransaction() bool {
+	return e.inTransaction
+}
+
+func (e *lookupTestExecutor) BeginTransaction() error {
+	e.record("BEGIN")
+	e.inTransaction = true
+	return nil
+}
+
+func (e *lookupTestExecutor) CommitTransaction() error {
+	e.record("COMMIT")
+	e.inTransaction = false
+	return nil
+}
+
+func (e *lookupTestExecutor) RollbackTransaction() error {
+	e.record("ROLLBACK")
+	e.inTransaction = false
+	return nil
+}
+
+// noTransactionExecutor 隐藏lookupTestExecutor的事务方法, 用于测试不支持事务的Executor
+type noTransactionExecutor struct {
+	Executor
+}
+
+func TestLookupSelect(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	tests := []struct {
+		sql    string
+		keys   []interface{}
+		lookup string
+		sqls   map[string]map[string][]string
+	}{
+		{
+			sql:    "select * from tbl_ks_lookup where order_no = 'a'",
+			keys:   []interface{}{int64(3)},
+			lookup: "slice-1:SELECT DISTINCT `user_id` FROM `tbl_ks_lookup_order_no` WHERE `order_no` IN ('a')",
+			sqls: map[string]map[string][]string{
+				"slice-1": {"db_ks": {"SELECT * FROM `tbl_ks_lookup_0003` WHERE `order_no`='a'"}},
+			},
+		},
+		{
+			sql:    "select name from tbl_ks_lookup as t where t.order_no in ('a', 'b') and name = 'x'",
+			keys:   []interface{}{int64(0), int64(3), int64(4)},
+			lookup: "slice-1:SELECT DISTINCT `user_id` FROM `tbl_ks_lookup_order_no` WHERE `order_no` IN ('a','b')",
+			sqls: map[string]map[string][]string{
+				"slice-0": {"db_ks": {"SELECT `name` FROM `tbl_ks_lookup_0000` AS `t` WHERE `t`.`order_no` IN ('a','b') AND `name`='x'"}},
+				"slice-1": {"db_ks": {"SELECT `name` FROM `tbl_ks_lookup_0003` AS `t` WHERE `t`.`order_no` IN ('a','b') AND `name`='x'"}},
+			},
+		},
+		{
+			// not found in the mapping table, execute in the first table
+			sql:    "select count(*) from tbl_ks_lookup where order_no = 'c'",
+			lookup: "slice-1:SELECT DISTINCT `user_id` FROM `tbl_ks_lookup_order_no` WHERE `order_no` IN ('c')",
+			sqls: map[string]map[string][]string{
+				"slice-0": {"db_ks": {"SELECT COUNT(1) FROM `tbl_ks_lookup_0000` WHERE `order_no`='c'"}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.sql, func(t *testing.T) {
+			stmt, err := parser.ParseSQL(test.sql)
+			if err != nil {
+				t.Fatalf("parse sql error: %v", err)
+			}
+			p, err := BuildPlan(stmt, ns.phyDBs, "db_ks", test.sql, ns.rt, ns.seqs)
+			if err != nil {
+				t.Fatalf("build plan error: %v", err)
+			}
+			selectPlan := p.(*SelectPlan)
+			if selectPlan.lookup == nil {
+				t.Fatalf("lookup plan not built")
+			}
+			e := &lookupTestExecutor{testExecutor: testExecutor{handle: lookupResults(test.keys, nil)}}
+			sqls, err := selectPlan.lookup.getSQLs(util.NewRequestContext(), e)
+			if err != nil {
+				t.Fatalf("get sqls error: %v", err)
+			}
+			if !reflect.DeepEqual(e.executed, []string{test.lookup}) {
+				t.Errorf("lookup sql not equal, expect: %v, actual: %v", test.lookup, e.executed)
+			}
+			if !checkSQLs(test.sqls, sqls) {
+				t.Errorf("sqls not equal, expect: %v, actual: %v", test.sqls, sqls)
+			}
+		})
+	}
+}
+
+func TestLookupRouteByShardingKey(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	tests := []SQLTestcase{
+		{
+			db:  "db_ks",
+			sql: "select * from tbl_ks_lookup where order_no = 'a' and user_id = 2",
+			sqls: map[string]map[string][]string{
+				"slice-1": {"db_ks": {"SELECT * FROM `tbl_ks_lookup_0002` WHERE `order_no`='a' AND `user_id`=2"}},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.sql, getTestFunc(ns, test))
+	}
+
+	stmt, _ := parser.ParseSQL(tests[0].sql)
+	p, err := BuildPlan(stmt, ns.phyDBs, "db_ks", tests[0].sql, ns.rt, ns.seqs)
+	if err != nil {
+		t.Fatalf("build plan error: %v", err)
+	}
+	if p.(*SelectPlan).lookup != nil {
+		t.Errorf("lookup is not needed when routed by sharding key")
+	}
+}
+
+func TestLookupModify(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	tests := []struct {
+		sql      string
+		keys     []interface{}
+		rows     [][]interface{}
+		executed []string
+	}{
+		{
+			sql: "insert into tbl_ks_lookup (user_id, order_no, name) values (1, 'a', 'x'), (5, null, 'y'), (9, 'b', 'z')",
+			executed: []string{
+				"BEGIN",
+				"slice-1:INSERT INTO `tbl_ks_lookup_order_no` (`order_no`,`user_id`) VALUES ('a',1),('b',9)",
+				"slice-0:INSERT INTO `tbl_ks_lookup_0001` (`user_id`,`order_no`,`name`) VALUES (1,'a','x'),(5,NULL,'y'),(9,'b','z')",
+				"COMMIT",
+			},
+		},
+		{
+			sql: "insert into tbl_ks_lookup set user_id = 2, order_no = 'a'",
+			executed: []string{
+				"BEGIN",
+				"slice-1:INSERT INTO `tbl_ks_lookup_order_no` (`order_no`,`user_id`) VALUES ('a',2)",
+				"slice-1:INSERT INTO `tbl_ks_lookup_0002` SET `user_id`=2,`order_no`='a'",
+				"COMMIT",
+			},
+		},
+		{
+			sql: "insert into tbl_ks_lookup (user_id, name) values (1, 'x')",
+			executed: []string{
+				"slice-0:INSERT INTO `tbl_ks_lookup_0001` (`user_id`,`name`) VALUES (1,'x')",
+			},
+		},
+		{
+			sql:  "update tbl_ks_lookup set order_no = 'b' where order_no = 'a'",
+			keys: []interface{}{int64(3)},
+			rows: [][]interface{}{{int64(3), "a"}},
+			executed: []string{
+				"BEGIN",
+				"slice-1:SELECT DISTINCT `user_id` FROM `tbl_ks_lookup_order_no` WHERE `order_no` IN ('a')",
+				"slice-1:SELECT `user_id`,`order_no` FROM `tbl_ks_lookup_0003` WHERE `order_no`='a' FOR UPDATE",
+				"slice-1:DELETE FROM `tbl_ks_lookup_order_no` WHERE `order_no` IN ('a')",
+				"slice-1:INSERT INTO `tbl_ks_lookup_order_no` (`order_no`,`user_id`) VALUES ('b',3)",
+				"slice-1:UPDATE `tbl_ks_lookup_0003` SET `order_no`='b' WHERE `order_no`='a'",
+				"COMMIT",
+			},
+		},
+		{
+			// lookup column is not updated
+			sql:  "update tbl_ks_lookup set name = 'x' where order_no = 'a'",
+			keys: []interface{}{int64(2)},
+			executed: []string{
+				"slice-1:SELECT DISTINCT `user_id` FROM `tbl_ks_lookup_order_no` WHERE `order_no` IN ('a')",
+				"slice-1:UPDATE `tbl_ks_lookup_0002` SET `name`='x' WHERE `order_no`='a'",
+			},
+		},
+		{
+			sql:  "delete from tbl_ks_lookup where user_id = 1",
+			rows: [][]interface{}{{int64(1), "a"}, {int64(1), nil}},
+			executed: []string{
+				"BEGIN",
+				"slice-0:SELECT `user_id`,`order_no` FROM `tbl_ks_lookup_0001` WHERE `user_id`=1 FOR UPDATE",
+				"slice-1:DELETE FROM `tbl_ks_lookup_order_no` WHERE `order_no` IN ('a')",
+				"slice-0:DELETE FROM `tbl_ks_lookup_0001` WHERE `user_id`=1",
+				"COMMIT",
+			},
+		},
+		{
+			// no rows to delete
+			sql:  "delete from tbl_ks_lookup where order_no = 'a'",
+			keys: []interface{}{int64(0)},
+			executed: []string{
+				"BEGIN",
+				"slice-1:SELECT DISTINCT `user_id` FROM `tbl_ks_lookup_order_no` WHERE `order_no` IN ('a')",
+				"slice-0:SELECT `user_id`,`order_no` FROM `tbl_ks_lookup_0000` WHERE `order_no`='a' FOR UPDATE",
+				"slice-0:DELETE FROM `tbl_ks_lookup_0000` WHERE `order_no`='a'",
+				"COMMIT",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.sql, func(t *testing.T) {
+			stmt, err := parser.ParseSQL(test.sql)
+			if err != nil {
+				t.Fatalf("parse sql error: %v", err)
+			}
+			p, err := BuildPlan(stmt, ns.phyDBs, "db_ks", test.sql, ns.rt, ns.seqs)
+			if err != nil {
+				t.Fatalf("build plan error: %v", err)
+			}
+			e := &lookupTestExecutor{testExecutor: testExecutor{handle: lookupResults(test.keys, test.rows)}}
+			if _, err := p.ExecuteIn(util.NewRequestContext(), e); err != nil {
+				t.Fatalf("execute error: %v", err)
+			}
+			if !reflect.DeepEqual(e.executed, test.executed) {
+				t.Errorf("executed sqls not equal, expect: %v, actual: %v", test.executed, e.executed)
+			}
+		})
+	}
+}
+
+func TestLookupModifyError(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	tests := []SQLTestcase{
+		{
+			db:     "db_ks",
+			sql:    "update tbl_ks_lookup set order_no = concat(order_no, 'x') where user_id = 1",
+			hasErr: true,
+		},
+		{
+			db:     "db_ks",
+			sql:    "insert into tbl_ks_lookup (user_id, order_no) values (1, concat('a', 'b'))",
+			hasErr: true,
+		},
+		{
+			db:     "db_ks",
+			sql:    "insert into tbl_ks_lookup (user_id, order_no) values (1, 'a') on duplicate key update order_no = 'b'",
+			hasErr: true,
+		},
+		{
+			db:     "db_ks",
+			sql:    "insert into tbl_ks_lookup (user_id, order_no) values (1, 'a') on duplicate key update name = 'b'",
+			hasErr: true, // 重复时不插入映射记录
+		},
+		{
+			db:     "db_ks",
+			sql:    "insert ignore into tbl_ks_lookup set user_id = 2, order_no = 'a'",
+			hasErr: true,
+		},
+		{
+			db:     "db_ks",
+			sql:    "replace into tbl_ks_lookup (user_id, name) values (1, 'x')",
+			hasErr: true, // 删除的旧行可能有映射记录
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.sql, getTestFunc(ns, test))
+	}
+}
+
+func TestLookupModifyRollback(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	tests := []struct {
+		sql           string
+		keys          []interface{}
+		rows          [][]interface{}
+		fail          string
+		inTransaction bool
+		executed      []string
+	}{
+		{
+			// 插入分片表失败时回滚已经插入的映射记录
+			sql:  "insert into tbl_ks_lookup (user_id, order_no) values (1, 'a')",
+			fail: "INSERT INTO `tbl_ks_lookup_0001`",
+			executed: []string{
+				"BEGIN",
+				"slice-1:INSERT INTO `tbl_ks_lookup_order_no` (`order_no`,`user_id`) VALUES ('a',1)",
+				"slice-0:INSERT INTO `tbl_ks_lookup_0001` (`user_id`,`order_no`) VALUES (1,'a')",
+				"ROLLBACK",
+			},
+		},
+		{
+			// 修改分片表失败时回滚映射记录的修改
+			sql:  "update tbl_ks_lookup set order_no = 'b' where user_id = 3",
+			rows: [][]interface{}{{int64(3), "a"}},
+			fail: "UPDATE",
+			executed: []string{
+				"BEGIN",
+				"slice-1:SELECT `user_id`,`order_no` FROM `tbl_ks_lookup_0003` WHERE `user_id`=3 FOR UPDATE",
+				"slice-1:DELETE FROM `tbl_ks_lookup_order_no` WHERE `order_no` IN ('a')",
+				"slice-1:INSERT INTO `tbl_ks_lookup_order_no` (`order_no`,`user_id`) VALUES ('b',3)",
+				"slice-1:UPDATE `tbl_ks_lookup_0003` SET `order_no`='b' WHERE `user_id`=3",
+				"ROLLBACK",
+			},
+		},
+		{
+			// 删除映射记录失败时不删除分片表的行
+			sql:  "delete from tbl_ks_lookup where user_id = 1",
+			rows: [][]interface{}{{int64(1), "a"}},
+			fail: "DELETE FROM `tbl_ks_lookup_order_no`",
+			executed: []string{
+				"BEGIN",
+				"slice-0:SELECT `user_id`,`order_no` FROM `tbl_ks_lookup_0001` WHERE `user_id`=1 FOR UPDATE",
+				"slice-1:DELETE FROM `tbl_ks_lookup_order_no` WHERE `order_no` IN ('a')",
+				"ROLLBACK",
+			},
+		},
+		{
+			// 在客户端的事务中执行, 由客户端回滚
+			sql:           "insert into tbl_ks_lookup (user_id, order_no) values (1, 'a')",
+			fail:          "INSERT INTO `tbl_ks_lookup_0001`",
+			inTransaction: true,
+			executed: []string{
+				"slice-1:INSERT INTO `tbl_ks_lookup_order_no` (`order_no`,`user_id`) VALUES ('a',1)",
+				"slice-0:INSERT INTO `tbl_ks_lookup_0001` (`user_id`,`order_no`) VALUES (1,'a')",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.sql, func(t *testing.T) {
+			stmt, err := parser.ParseSQL(test.sql)
+			if err != nil {
+				t.Fatalf("parse sql error: %v", err)
+			}
+			p, err := BuildPlan(stmt, ns.phyDBs, "db_ks", test.sql, ns.rt, ns.seqs)
+			if err != nil {
+				t.Fatalf("build plan error: %v", err)
+			}
+			e := &lookupTestExecutor{
+				testExecutor:  testExecutor{handle: lookupResults(test.keys, test.rows), fail: test.fail},
+				inTransaction: test.inTransaction,
+			}
+			if _, err := p.ExecuteIn(util.NewRequestContext(), e); err == nil {
+				t.Fatalf("execute should fail")
+			}
+			if !reflect.DeepEqual(e.executed, test.executed) {
+				t.Errorf("executed sqls not equal, expect: %v, actual: %v", test.executed, e.executed)
+			}
+			if e.inTransaction != test.inTransaction {
+				t.Errorf("transaction status not restored")
+			}
+		})
+	}
+
+	// 不支持事务的Executor不能维护映射表
+	sql := "insert into tbl_ks_lookup (user_id, order_no) values (1, 'a')"
+	stmt, err := parser.ParseSQL(sql)
+	if err != nil {
+		t.Fatalf("parse sql error: %v", err)
+	}
+	p, err := BuildPlan(stmt, ns.phyDBs, "db_ks", sql, ns.rt, ns.seqs)
+	if err != nil {
+		t.Fatalf("build plan error: %v", err)
+	}
+	e := &lookupTestExecutor{}
+	if _, err := p.ExecuteIn(util.NewRequestContext(), noTransactionExecutor{e}); err == nil {
+		t.Errorf("execute without transaction should fail")
+	}
+	if len(e.executed) != 0 {
+		t.Errorf("sqls should not be executed: %v", e.executed)
+	}
+}
@@ -0,0 +1,294 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plan
+
+import (
+	"fmt"
+
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/opcode"
+
+	"github.com/XiaoMi/Gaea/proxy/router"
+)
+
+// modifyTable 多表UPDATE, DELETE和JOIN查询中引用的表
+type modifyTable struct {
+	name    string // 有别名时为别名, 否则为表名
+	isAlias bool
+	rule    router.Rule
+}
+
+// checkMultiTableModify 检查多表UPDATE和DELETE能否在每个分片内独立执行, 需要在handleJoin之前调用.
+// 限制: 只能引用分片表和全局表, 分片表之间必须是关联表或绑定表, 并且通过分片列的等值条件连接,
+// 这样每一行只会和同一个分片中的行关联.
+func checkMultiTableModify(p *TableAliasStmtInfo, join *ast.Join, where ast.ExprNode) ([]*modifyTable, error) {
+	var tables []*modifyTable
+	var conditions []ast.ExprNode
+	if err := collectModifyTables(p, join, &tables, &conditions); err != nil {
+		return nil, err
+	}
+	if where != nil {
+		conditions = splitAndConditions(where, conditions)
+	}
+	if err := checkShardTablesJoined(tables, conditions); err != nil {
+		return nil, err
+	}
+	return tables, nil
+}
+
+// checkShardTablesJoined 检查多表语句中的分片表都在同一个路由上 (关联表或绑定表), 并且通过分片列的等值条件连接.
+// 多表UPDATE, DELETE和同一路由上的表的JOIN查询都使用这个规则判断能否在每个分片内独立执行.
+func checkShardTablesJoined(tables []*modifyTable, conditions []ast.ExprNode) error {
+	var shardTables []int
+	for i, t := range tables {
+		if t.rule.GetType() == router.GlobalTableRuleType {
+			continue
+		}
+		if len(shardTables) != 0 {
+			first := tables[shardTables[0]]
+			if first.rule.GetDB() != t.rule.GetDB() || first.rule.GetRouteTable() != t.rule.GetRouteTable() {
+				return fmt.Errorf("table %s and %s are not linked or bound", first.name, t.name)
+			}
+		}
+		shardTables = append(shardTables, i)
+	}
+	if len(shardTables) < 2 {
+		return nil
+	}
+
+	// 用并查集合并通过分片列等值条件连接的表, 两边的列在各自规则的分片列中必须位置相同.
+	// 需要所有分片列才能计算路由的规则 (complex), 每个位置的分片列都要连接; 否则连接任意一个位置即可
+	first := tables[shardTables[0]]
+	groups := 1
+	if _, ok := first.rule.GetShard().(router.ComplexShard); ok {
+		groups = len(first.rule.GetShardingColumns())
+	}
+	parents := make([][]int, groups)
+	for g := range parents {
+		parents[g] = make([]int, len(tables))
+		for i := range parents[g] {
+			parents[g][i] = i
+		}
+	}
+	var find func(g, i int) int
+	find = func(g, i int) int {
+		if parents[g][i] != i {
+			parents[g][i] = find(g, parents[g][i])
+		}
+		return parents[g][i]
+	}
+	for _, cond := range conditions {
+		e, ok := cond.(*ast.BinaryOperationExpr)
+		if !ok || e.Op != opcode.EQ {
+			continue
+		}
+		left, ok := e.L.(*ast.ColumnNameExpr)
+		if !ok {
+			continue
+		}
+		right, ok := e.R.(*ast.ColumnNameExpr)
+		if !ok {
+			continue
+		}
+		l, r := findShardingColumnTable(tables, left.Name), findShardingColumnTable(tables, right.Name)
+		if l < 0 || r < 0 {
+			continue
+		}
+		position := shardingColumnPosition(tables[l].rule, left.Name.Name.L)
+		if position != shardingColumnPosition(tables[r].rule, right.Name.Name.L) {
+			continue
+		}
+		g := 0
+		if groups > 1 {
+			if position >= groups {
+				continue
+			}
+			g = position
+		}
+		parents[g][find(g, l)] = find(g, r)
+	}
+
+	for g := range parents {
+		root := find(g, shardTables[0])
+		for _, i := range shardTables[1:] {
+			if find(g, i) != root {
+				return fmt.Errorf("table %s and %s are not joined on sharding columns", first.name, tables[i].name)
+			}
+		}
+	}
+	return nil
+}
+
+// shardingColumnPosition 返回列在规则分片列中的位置
+func shardingColumnPosition(rule router.Rule, column string) int {
+	for i, c := range rule.GetShardingColumns() {
+		if c == column {
+			return i
+		}
+	}
+	return -1
+}
+
+func collectModifyTables(p *TableAliasStmtInfo, node ast.ResultSetNode, tables *[]*modifyTable, conditions *[]ast.ExprNode) error {
+	switch n := node.(type) {
+	case *ast.Join:
+		if len(n.Using) != 0 || n.NaturalJoin {
+			return fmt.Errorf("multi-table statement does not support USING or NATURAL JOIN, use ON instead")
+		}
+		if err := collectModifyTables(p, n.Left, tables, conditions); err != nil {
+			return err
+		}
+		if n.Right != nil {
+			if err := collectModifyTables(p, n.Right, tables, conditions); err != nil {
+				return err
+			}
+		}
+		if n.On != nil {
+			*conditions = splitAndConditions(n.On.Expr, *conditions)
+		}
+		return nil
+	case *ast.TableSource:
+		tableName, ok := n.Source.(*ast.TableName)
+		if !ok {
+			return fmt.Errorf("multi-table statement does not support subquery as table")
+		}
+		db, table := getTableInfoFromTableName(tableName)
+		validDB, err := p.checkAndGetDB(db)
+		if err != nil {
+			return err
+		}
+		rule, ok := p.router.GetShardRule(validDB, table)
+		if !ok {
+			return fmt.Errorf("table %s is not a sharding table or global table", table)
+		}
+		t := &modifyTable{name: table, rule: rule}
+		if n.AsName.L != "" {
+			t.name = n.AsName.L
+			t.isAlias = true
+		}
+		*tables = append(*tables, t)
+		return nil
+	default:
+		return fmt.Errorf("invalid table type: %T", node)
+	}
+}
+
+// findShardingColumnTable 返回分片列所属的分片表下标, 不是分片列时返回-1
+// 列名不带表名时, 只有唯一一个分片表以该列作为分片列才能确定所属的表
+func findShardingColumnTable(tables []*modifyTable, column *ast.ColumnName) int {
+	ret := -1
+	for i, t := range tables {
+		if t.rule.GetType() == router.GlobalTableRuleType || !t.rule.IsShardingColumn(column.Name.L) {
+			continue
+		}
+		if column.Table.L != "" {
+			if t.name == column.Table.L {
+				return i
+			}
+			continue
+		}
+		if ret >= 0 {
+			return -1
+		}
+		ret = i
+	}
+	return ret
+}
+
+// findModifyTarget 查找UPDATE修改的列或DELETE删除的表对应的表.
+// 与分片表JOIN时, 全局表只能读取, 不能修改, 否则各个分片上的全局表数据会不一致.
+// 除mycat路由外分片表的实际表名与逻辑表名不同, 而SET列名和DELETE表名无法装饰, 因此必须通过表别名引用.
+func findModifyTarget(tables []*modifyTable, name string) (*modifyTable, error) {
+	var target *modifyTable
+	hasShardTable := false
+	for _, t := range tables {
+		if t.name == name {
+			target = t
+		}
+		if t.rule.GetType() != router.GlobalTableRuleType {
+			hasShardTable = true
+		}
+	}
+	if target == nil {
+		return nil, fmt.Errorf("unknown table %s in multi-table statement", name)
+	}
+
+	ruleType := target.rule.GetType()
+	if ruleType == router.GlobalTableRuleType {
+		if hasShardTable {
+			return nil, fmt.Errorf("cannot modify global table %s joined with sharding tables", name)
+		}
+		return target, nil
+	}
+	if !target.isAlias && !router.IsMycatShardingRule(ruleType) {
+		return nil, fmt.Errorf("table %s must be referenced by alias in multi-table statement", name)
+	}
+	return target, nil
+}
+
+// handleMultiTableAssignmentList 多表UPDATE的SET列必须带有表名或表别名, 不能修改分片列和lookup列
+func handleMultiTableAssignmentList(p *UpdatePlan) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("handleMultiTableAssignmentList panic: %v", e)
+		}
+	}()
+
+	columnNameRewriter := NewColumnNameRewriteVisitor(p.TableAliasStmtInfo)
+	for _, assignment := range p.stmt.List {
+		column := assignment.Column
+		if column.Table.L == "" {
+			return fmt.Errorf("column %s must be qualified by table in multi-table update", column.Name.O)
+		}
+		t, err := findModifyTarget(p.tables, column.Table.L)
+		if err != nil {
+			return err
+		}
+		if t.rule.GetType() != router.GlobalTableRuleType {
+			if t.rule.IsShardingColumn(column.Name.L) {
+				return fmt.Errorf("cannot update shard column value in multi-table update")
+			}
+			if _, ok := t.rule.GetLookup(column.Name.L); ok {
+				return fmt.Errorf("cannot update lookup column %s in multi-table update", column.Name.O)
+			}
+		}
+		column.Schema.O = ""
+		column.Schema.L = ""
+
+		// 这里如果出错, 只能通过panic返回err
+		expr, _ := assignment.Expr.Accept(columnNameRewriter)
+		assignment.Expr = expr.(ast.ExprNode)
+	}
+	return nil
+}
+
+// checkMultiTableDeleteTargets 检查多表DELETE删除的表, 删除的表不能带有lookup映射表
+func checkMultiTableDeleteTargets(p *DeletePlan, tables []*modifyTable) error {
+	if p.stmt.Tables == nil {
+		return nil
+	}
+	for _, n := range p.stmt.Tables.Tables {
+		t, err := findModifyTarget(tables, n.Name.L)
+		if err != nil {
+			return err
+		}
+		if len(t.rule.GetLookups()) != 0 {
+			return fmt.Errorf("cannot delete from table %s with lookups in multi-table delete", n.Name.O)
+		}
+		n.Schema.O = ""
+		n.Schema.L = ""
+	}
+	return nil
+}
@@ -0,0 +1,353 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plan
+
+import (
+	"container/heap"
+	"fmt"
+	"io"
+
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/opcode"
+	"github.com/pingcap/tidb/types"
+	driver "github.com/pingcap/tidb/types/parser_driver"
+
+	"github.com/XiaoMi/Gaea/models"
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/util"
+)
+
+// ScatterOffsetLimiter 由Executor实现, 返回跨分片分页查询的offset上限和超过上限时的处理方式.
+// 未实现或者上限不大于0时把LIMIT offset, count改写为LIMIT offset+count下推到各分片.
+// 处理方式为models中定义的ScatterOffsetPolicy, 为空时返回错误
+type ScatterOffsetLimiter interface {
+	GetMaxScatterOffset() int64
+	GetScatterOffsetPolicy() string
+}
+
+// handleDeepOffset 处理offset超过上限的跨分片分页查询, 返回实际执行的计划, 不需要处理时返回原来的计划.
+// 通过映射表计算路由的查询在执行时才能确定分片, 不做处理
+func (s *SelectPlan) handleDeepOffset(reqCtx *util.RequestContext, sess Executor) (*SelectPlan, error) {
+	l, ok := sess.(ScatterOffsetLimiter)
+	if !ok || s.paginated || !s.HasLimit() || s.lookup != nil {
+		return s, nil
+	}
+	maxOffset := l.GetMaxScatterOffset()
+	if maxOffset <= 0 || s.offset <= maxOffset || len(s.GetRouteResult().GetShardIndexes()) <= 1 {
+		return s, nil
+	}
+
+	switch l.GetScatterOffsetPolicy() {
+	case models.ScatterOffsetPolicyCap:
+		return s.capOffset(maxOffset)
+	case models.ScatterOffsetPolicyTwoPhase:
+		return s.executeTwoPhasePagination(reqCtx, sess)
+	default:
+		return nil, fmt.Errorf("offset %d of cross shard query exceeds max_scatter_offset %d", s.offset, maxOffset)
+	}
+}
+
+// capOffset 把offset减小到上限, LIMIT下推到分片时重新生成分片SQL
+func (s *SelectPlan) capOffset(maxOffset int64) (*SelectPlan, error) {
+	stmt := *s.stmt
+	if stmt.Limit != nil {
+		stmt.Limit = newShardLimit(maxOffset + s.count)
+	}
+	p, err := s.withStmt(&stmt)
+	if err != nil {
+		return nil, err
+	}
+	p.offset = maxOffset
+	return p, nil
+}
+
+// executeTwoPhasePagination 两阶段分页, 只用于可以按ORDER BY归并分片结果的查询.
+// 第一阶段各分片只返回排序列的前offset+1行, 归并后得到全局第offset行作为起始行;
+// 第二阶段在WHERE条件中加上排序列不小于起始行的条件, 各分片返回起始行之后的count行,
+// 以及前offset行中与起始行排序列相等的行, 归并后跳过这些行.
+// 起始行的排序列有NULL或者无法作为常量时, 按原来的方式执行
+func (s *SelectPlan) executeTwoPhasePagination(reqCtx *util.RequestContext, sess Executor) (*SelectPlan, error) {
+	keyFields, keyExprs, ok := s.getOrderByFields()
+	if !s.canStreamMerge() || !ok {
+		return s, nil
+	}
+	_, directions := s.GetOrderByColumnInfo()
+
+	keyStmt := *s.stmt
+	keyStmt.Fields = &ast.FieldList{Fields: keyFields}
+	keyStmt.Limit = newShardLimit(s.offset + 1)
+	keySQLs, err := generateShardingSQLs(&keyStmt, s.result, s.router)
+	if err != nil {
+		return nil, fmt.Errorf("generate pagination SQL error: %v", err)
+	}
+
+	var rs []*mysql.Result
+	if streamSess, ok := sess.(StreamExecutor); ok {
+		rs, err = streamSess.ExecuteSQLsStream(reqCtx, keySQLs)
+	} else {
+		rs, err = sess.ExecuteSQLs(reqCtx, keySQLs)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("execute pagination SQL error: %v", err)
+	}
+	cutoff, err := findPaginationCutoff(rs, directions, s.offset)
+	if closeErr := closeResultStreams(rs); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("find pagination cutoff error: %v", err)
+	}
+
+	// 各分片的行数之和不超过offset, 结果为空
+	if cutoff == nil {
+		p := *s
+		p.sqls = make(map[string]map[string][]string)
+		p.paginated = true
+		return &p, nil
+	}
+
+	values, ok, err := cutoff.valueExprs()
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		p := *s
+		p.paginated = true
+		return &p, nil
+	}
+
+	stmt := *s.stmt
+	cond := createCutoffCondition(keyExprs, directions, values)
+	if stmt.Where == nil {
+		stmt.Where = cond
+	} else {
+		stmt.Where = &ast.BinaryOperationExpr{Op: opcode.LogicAnd, L: &ast.ParenthesesExpr{Expr: stmt.Where}, R: cond}
+	}
+	stmt.Limit = newShardLimit(cutoff.ties + s.count)
+	p, err := s.withStmt(&stmt)
+	if err != nil {
+		return nil, err
+	}
+	p.offset = cutoff.ties
+	return p, nil
+}
+
+// withStmt 用改写后的语句重新生成分片SQL, 返回计划的副本, 不修改原来的计划
+func (s *SelectPlan) withStmt(stmt *ast.SelectStmt) (*SelectPlan, error) {
+	sqls, err := generateShardingSQLs(stmt, s.result, s.router)
+	if err != nil {
+		return nil, fmt.Errorf("generate select SQL error: %v", err)
+	}
+	p := *s
+	p.stmt = stmt
+	p.sqls = sqls
+	p.paginated = true
+	return &p, nil
+}
+
+// getOrderByFields 返回ORDER BY列对应的查询列和表达式
+func (s *SelectPlan) getOrderByFields() ([]*ast.SelectField, []ast.ExprNode, bool) {
+	columns, _ := s.GetOrderByColumnInfo()
+	var fields []*ast.SelectField
+	var exprs []ast.ExprNode
+	for _, column := range columns {
+		if s.stmt.Fields == nil || column >= len(s.stmt.Fields.Fields) {
+			return nil, nil, false
+		}
+		field := s.stmt.Fields.Fields[column]
+		if field.WildCard != nil || field.Expr == nil {
+			return nil, nil, false
+		}
+		fields = append(fields, field)
+		exprs = append(exprs, field.Expr)
+	}
+	return fields, exprs, len(fields) != 0
+}
+
+func newShardLimit(count int64) *ast.Limit {
+	nv := &driver.ValueExpr{}
+	nv.SetInt64(count)
+	return &ast.Limit{Count: nv}
+}
+
+// paginationCutoff 两阶段分页的起始行
+type paginationCutoff struct {
+	fields  []*mysql.Field
+	rowData mysql.RowData
+	values  []interface{}
+	ties    int64 // 前offset行中排序列与起始行相等的行数
+}
+
+// findPaginationCutoff 按ORDER BY归并各分片的排序列, 返回全局第offset行 (从0开始), 行数不够时返回nil
+func findPaginationCutoff(rs []*mysql.Result, directions []bool, offset int64) (*paginationCutoff, error) {
+	var results []*mysql.Result
+	for _, r := range rs {
+		if r != nil && r.Resultset != nil {
+			results = append(results, r)
+		}
+	}
+	if len(results) == 0 {
+		return nil, nil
+	}
+
+	fields := results[0].Fields
+	if len(fields) != len(directions) {
+		return nil, fmt.Errorf("column count %d not equal order by count %d", len(fields), len(directions))
+	}
+	h := &mergeHeap{fields: fields}
+	for i, desc := range directions {
+		k := mysql.SortKey{Column: i, Direction: mysql.SortAsc}
+		if desc {
+			k.Direction = mysql.SortDesc
+		}
+		h.sortKeys = append(h.sortKeys, k)
+	}
+	for i, r := range results {
+		if len(r.Fields) != len(fields) {
+			return nil, fmt.Errorf("column count of results not equal: %d, %d", len(r.Fields), len(fields))
+		}
+		c := &mergeCursor{index: i, result: r}
+		if err := c.next(); err == io.EOF {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		h.cursors = append(h.cursors, c)
+	}
+	heap.Init(h)
+
+	// 排好序的行中与起始行相等的行是连续的, 只需要记录最后一段相等的行数
+	var last []interface{}
+	var ties int64
+	for i := int64(0); i < offset && h.Len() != 0; i++ {
+		values := h.cursors[0].values
+		if last != nil && h.compareRows(last, values) == 0 {
+			ties++
+		} else {
+			ties = 1
+		}
+		last = values
+		if err := h.advance(); err != nil {
+			return nil, err
+		}
+	}
+	if h.Len() == 0 {
+		return nil, nil
+	}
+
+	c := h.cursors[0]
+	cutoff := &paginationCutoff{fields: fields, rowData: c.rowData, values: c.values}
+	if last != nil && h.compareRows(last, c.values) == 0 {
+		cutoff.ties = ties
+	}
+	return cutoff, nil
+}
+
+// valueExprs 把起始行的排序列转换为常量, 有NULL或者不支持的类型时返回false.
+// 浮点数比较相等不可靠, 二进制字符串无法作为文本常量, ENUM和SET按序号排序, 都不支持
+func (c *paginationCutoff) valueExprs() ([]ast.ExprNode, bool, error) {
+	var exprs []ast.ExprNode
+	for i, v := range c.values {
+		if v == nil {
+			return nil, false, nil
+		}
+		switch c.fields[i].Type {
+		case mysql.TypeTiny, mysql.TypeShort, mysql.TypeInt24, mysql.TypeLong, mysql.TypeLonglong, mysql.TypeYear:
+			switch v.(type) {
+			case int64, uint64:
+			default:
+				return nil, false, nil
+			}
+		case mysql.TypeDecimal, mysql.TypeNewDecimal:
+			text, err := formatValue(v)
+			if err != nil {
+				return nil, false, err
+			}
+			if c.rowData != nil {
+				if text, _, err = c.rowData.GetTextColumn(i); err != nil {
+					return nil, false, err
+				}
+			}
+			d := new(types.MyDecimal)
+			if err := d.FromString(text); err != nil {
+				return nil, false, fmt.Errorf("convert column %d to decimal error: %v", i, err)
+			}
+			v = d
+		case mysql.TypeVarchar, mysql.TypeVarString, mysql.TypeString,
+			mysql.TypeDate, mysql.TypeNewDate, mysql.TypeDatetime, mysql.TypeTimestamp, mysql.TypeDuration:
+			if c.fields[i].Charset == uint16(mysql.CollationIds["binary"]) && !isTimeFieldType(c.fields[i].Type) {
+				return nil, false, nil
+			}
+			switch value := v.(type) {
+			case string:
+			case []byte:
+				v = string(value)
+			default:
+				return nil, false, nil
+			}
+		default:
+			return nil, false, nil
+		}
+		exprs = append(exprs, ast.NewValueExpr(v, "", ""))
+	}
+	return exprs, true, nil
+}
+
+func isTimeFieldType(tp uint8) bool {
+	switch tp {
+	case mysql.TypeDate, mysql.TypeNewDate, mysql.TypeDatetime, mysql.TypeTimestamp, mysql.TypeDuration:
+		return true
+	}
+	return false
+}
+
+// createCutoffCondition 生成按ORDER BY排在起始行及之后的条件:
+// k1 > v1 OR (k1 = v1 AND k2 > v2) OR ... OR (k1 = v1 AND ... AND kn >= vn), DESC时使用<和<=.
+// MySQL中NULL排在最前面, DESC时NULL排在起始行之后, 需要加上IS NULL条件
+func createCutoffCondition(columns []ast.ExprNode, directions []bool, values []ast.ExprNode) ast.ExprNode {
+	var cond ast.ExprNode
+	for i := range columns {
+		op := opcode.GT
+		if i == len(columns)-1 {
+			op = opcode.GE
+		}
+		if directions[i] {
+			op = opcode.LT
+			if i == len(columns)-1 {
+				op = opcode.LE
+			}
+		}
+
+		var term ast.ExprNode = &ast.BinaryOperationExpr{Op: op, L: columns[i], R: values[i]}
+		if directions[i] {
+			term = &ast.ParenthesesExpr{Expr: &ast.BinaryOperationExpr{
+				Op: opcode.LogicOr,
+				L:  term,
+				R:  &ast.IsNullExpr{Expr: columns[i]},
+			}}
+		}
+		for j := i - 1; j >= 0; j-- {
+			eq := &ast.BinaryOperationExpr{Op: opcode.EQ, L: columns[j], R: values[j]}
+			term = &ast.BinaryOperationExpr{Op: opcode.LogicAnd, L: eq, R: term}
+		}
+
+		if cond == nil {
+			cond = term
+		} else {
+			cond = &ast.BinaryOperationExpr{Op: opcode.LogicOr, L: cond, R: term}
+		}
+	}
+	return &ast.ParenthesesExpr{Expr: cond}
+}
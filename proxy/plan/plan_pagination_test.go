@@ -0,0 +1,284 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plan
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/format"
+	"github.com/pingcap/parser/model"
+
+	"github.com/XiaoMi/Gaea/models"
+	"github.com/XiaoMi/Gaea/parser"
+	"github.com/XiaoMi/Gaea/util"
+)
+
+// paginationTestExecutor 在testExecutor的基础上返回深分页的offset上限和处理方式, tables按db.tbl_mycat保存各分片的行
+type paginationTestExecutor struct {
+	testExecutor
+	maxOffset int64
+	policy    string
+}
+
+func (e *paginationTestExecutor) GetMaxScatterOffset() int64 {
+	return e.maxOffset
+}
+
+func (e *paginationTestExecutor) GetScatterOffsetPolicy() string {
+	return e.policy
+}
+
+// newPaginationTestExecutor 按db保存tbl_mycat各分片的行, 列为id和user
+func newPaginationTestExecutor(rows map[string][][]interface{}, maxOffset int64, policy string) *paginationTestExecutor {
+	tables := make(map[string]*testTable)
+	for db, dbRows := range rows {
+		tables[db+".tbl_mycat"] = &testTable{columns: []string{"id", "user"}, rows: dbRows}
+	}
+	return &paginationTestExecutor{testExecutor: testExecutor{tables: tables, recordDB: true}, maxOffset: maxOffset, policy: policy}
+}
+
+func TestDeepOffsetPolicy(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	// tbl_mycat按id % 4分到db_mycat_0 - db_mycat_3, 每3个id的user相同, id个位为9的行user为NULL
+	rows := make(map[string][][]interface{})
+	for id := int64(0); id < 100; id++ {
+		var user interface{} = fmt.Sprintf("u%02d", id/3)
+		if id%10 == 9 {
+			user = nil
+		}
+		db := fmt.Sprintf("db_mycat_%d", id%4)
+		rows[db] = append(rows[db], []interface{}{id, user})
+	}
+
+	tests := []struct {
+		sql      string
+		policy   string
+		values   [][]interface{}
+		column   int // 只比较这一列, 排序列相等的行顺序不确定
+		err      bool
+		executed []string // 需要执行的部分SQL
+	}{
+		{
+			sql:    "select id, user from tbl_mycat order by id limit 50, 3",
+			policy: models.ScatterOffsetPolicyTwoPhase,
+			values: [][]interface{}{{int64(50), "u16"}, {int64(51), "u17"}, {int64(52), "u17"}},
+			column: -1,
+			executed: []string{
+				"db_mycat_0:SELECT `id` FROM `tbl_mycat` ORDER BY `id` LIMIT 51",
+				"db_mycat_2:SELECT `id`,`user` FROM `tbl_mycat` WHERE (`id`>=50) ORDER BY `id` LIMIT 3",
+			},
+		},
+		{
+			sql:    "select user from tbl_mycat where id > 20 order by id desc limit 30, 2",
+			policy: models.ScatterOffsetPolicyTwoPhase,
+			values: [][]interface{}{{nil}, {"u22"}},
+			column: -1,
+			executed: []string{
+				"db_mycat_1:SELECT `user`,`id` FROM `tbl_mycat` WHERE (`id`>20) AND ((`id`<=69 OR `id` IS NULL)) ORDER BY `id` DESC LIMIT 2",
+			},
+		},
+		{
+			sql:    "select user, id from tbl_mycat order by user desc, id limit 40, 6",
+			policy: models.ScatterOffsetPolicyTwoPhase,
+			column: -1,
+		},
+		{
+			sql:    "select id, user from tbl_mycat order by user limit 20, 7",
+			policy: models.ScatterOffsetPolicyTwoPhase,
+			column: 1,
+		},
+		{
+			sql:    "select id from tbl_mycat order by id limit 200, 5",
+			policy: models.ScatterOffsetPolicyTwoPhase,
+			values: [][]interface{}{},
+			column: -1,
+		},
+		{
+			// 起始行的user为NULL, 按原来的方式执行
+			sql:      "select id, user from tbl_mycat order by user desc limit 95, 2",
+			policy:   models.ScatterOffsetPolicyTwoPhase,
+			column:   1,
+			executed: []string{"db_mycat_0:SELECT `id`,`user` FROM `tbl_mycat` ORDER BY `user` DESC LIMIT 97"},
+		},
+		{
+			sql:      "select id from tbl_mycat order by id limit 90, 2",
+			policy:   models.ScatterOffsetPolicyCap,
+			values:   [][]interface{}{{int64(10)}, {int64(11)}},
+			column:   -1,
+			executed: []string{"db_mycat_3:SELECT `id` FROM `tbl_mycat` ORDER BY `id` LIMIT 12"},
+		},
+		{
+			sql:    "select id from tbl_mycat order by id limit 90, 2",
+			policy: models.ScatterOffsetPolicyReject,
+			err:    true,
+		},
+		{
+			sql:    "select id from tbl_mycat order by id limit 90, 2",
+			policy: "",
+			err:    true,
+		},
+		{
+			// 只路由到一个分片
+			sql:    "select id from tbl_mycat where id = 3 limit 90, 2",
+			policy: models.ScatterOffsetPolicyReject,
+			values: [][]interface{}{},
+			column: -1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.sql+" "+test.policy, func(t *testing.T) {
+			build := func() *SelectPlan {
+				stmt, err := parser.ParseSQL(test.sql)
+				if err != nil {
+					t.Fatalf("parse sql error: %v", err)
+				}
+				p, err := BuildPlan(stmt, nil, "db_mycat", test.sql, ns.rt, ns.seqs)
+				if err != nil {
+					t.Fatalf("BuildPlan error: %v", err)
+				}
+				return p.(*SelectPlan)
+			}
+
+			e := newPaginationTestExecutor(rows, 10, test.policy)
+			ret, err := build().ExecuteIn(util.NewRequestContext(), e)
+			if test.err {
+				if err == nil {
+					t.Fatalf("ExecuteIn should fail")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ExecuteIn error: %v", err)
+			}
+
+			expect := test.values
+			if expect == nil {
+				// 与不限制offset时的结果比较
+				r, err := build().ExecuteIn(util.NewRequestContext(), newPaginationTestExecutor(rows, 0, ""))
+				if err != nil {
+					t.Fatalf("ExecuteIn without limit error: %v", err)
+				}
+				expect = r.Values
+			}
+			values := ret.Values
+			if test.column >= 0 {
+				values, expect = getColumnValues(values, test.column), getColumnValues(expect, test.column)
+			}
+			if len(values) != 0 || len(expect) != 0 {
+				if !reflect.DeepEqual(values, expect) {
+					t.Errorf("values not equal, expect: %v, actual: %v", expect, values)
+				}
+			}
+
+			for _, sql := range test.executed {
+				found := false
+				for _, executed := range e.executed {
+					found = found || executed == sql
+				}
+				if !found {
+					t.Errorf("sql not executed: %s, executed: %v", sql, e.executed)
+				}
+			}
+		})
+	}
+}
+
+// 排序列有重复值时, 逐页读取的结果也不重复不遗漏
+func TestTwoPhasePaginationTies(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	// 每3个id的user相同, 分布在不同的分片, 同一分片中也有user相同的行
+	rows := make(map[string][][]interface{})
+	for id := int64(0); id < 100; id++ {
+		db := fmt.Sprintf("db_mycat_%d", id%4)
+		rows[db] = append(rows[db], []interface{}{id, fmt.Sprintf("u%02d", id%33/3)})
+	}
+
+	for _, desc := range []string{"", " desc"} {
+		ids := make(map[int64]int)
+		for offset := 0; offset < 100; offset += 7 {
+			sql := fmt.Sprintf("select id, user from tbl_mycat order by user%s limit %d, 7", desc, offset)
+			stmt, err := parser.ParseSQL(sql)
+			if err != nil {
+				t.Fatalf("parse sql error: %v", err)
+			}
+			p, err := BuildPlan(stmt, nil, "db_mycat", sql, ns.rt, ns.seqs)
+			if err != nil {
+				t.Fatalf("BuildPlan error: %v", err)
+			}
+			e := newPaginationTestExecutor(rows, 10, models.ScatterOffsetPolicyTwoPhase)
+			ret, err := p.(*SelectPlan).ExecuteIn(util.NewRequestContext(), e)
+			if err != nil {
+				t.Fatalf("ExecuteIn error: %v", err)
+			}
+			for _, v := range ret.Values {
+				ids[v[0].(int64)]++
+			}
+		}
+		for id := int64(0); id < 100; id++ {
+			if ids[id] != 1 {
+				t.Errorf("order by user%s, id %d returned %d times", desc, id, ids[id])
+			}
+		}
+	}
+}
+
+func getColumnValues(rows [][]interface{}, column int) [][]interface{} {
+	var ret [][]interface{}
+	for _, row := range rows {
+		ret = append(ret, []interface{}{row[column]})
+	}
+	return ret
+}
+
+func TestCreateCutoffCondition(t *testing.T) {
+	column := func(name string) ast.ExprNode {
+		return &ast.ColumnNameExpr{Name: &ast.ColumnName{Name: model.NewCIStr(name)}}
+	}
+	tests := []struct {
+		directions []bool
+		expect     string
+	}{
+		{[]bool{false}, "(`a`>=1)"},
+		{[]bool{true}, "((`a`<=1 OR `a` IS NULL))"},
+		{[]bool{false, true}, "(`a`>1 OR `a`=1 AND (`b`<='x' OR `b` IS NULL))"},
+		{[]bool{true, false}, "((`a`<1 OR `a` IS NULL) OR `a`=1 AND `b`>='x')"},
+	}
+	for _, test := range tests {
+		columns := []ast.ExprNode{column("a"), column("b")}[:len(test.directions)]
+		values := []ast.ExprNode{ast.NewValueExpr(int64(1), "", ""), ast.NewValueExpr("x", "", "")}[:len(test.directions)]
+		cond := createCutoffCondition(columns, test.directions, values)
+
+		sb := &strings.Builder{}
+		if err := cond.Restore(format.NewRestoreCtx(util.EscapeRestoreFlags, sb)); err != nil {
+			t.Fatalf("restore error: %v", err)
+		}
+		if sb.String() != test.expect {
+			t.Errorf("condition not equal, expect: %s, actual: %s", test.expect, sb.String())
+		}
+	}
+}
@@ -18,8 +18,11 @@ import (
 	"fmt"
 	"github.com/pingcap/parser/ast"
 	"github.com/pingcap/parser/opcode"
+	"io"
+	"strings"
 
 	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/provider"
 	"github.com/XiaoMi/Gaea/proxy/router"
 	"github.com/XiaoMi/Gaea/util"
 	driver "github.com/pingcap/tidb/types/parser_driver"
@@ -39,12 +42,18 @@ type SelectPlan struct {
 	originColumnCount int    // 补列前的列长度
 	columnCount       int    // 补列后的列长度
 
-	aggregateFuncs map[int]AggregateFuncMerger // key = column index
+	aggregateFuncs   map[int]AggregateFuncMerger // key = column index
+	aggregateGroupBy bool                        // 聚合函数的参数是否作为GROUP BY列下推
+	selectExprs      map[int]evalExpr            // 包含聚合函数的查询列表达式, 合并结果后计算, key = column index
+	having           evalExpr                    // HAVING条件, 合并结果后计算
 
 	offset int64 // LIMIT offset
 	count  int64 // LIMIT count, 未设置则为-1
 
-	sqls map[string]map[string][]string
+	sqls   map[string]map[string][]string
+	lookup *lookupPlan // 通过映射表计算路由时不为nil
+
+	paginated bool // 已经按offset上限的处理方式改写了分片SQL
 }
 
 // NewSelectPlan constructor of SelectPlan
@@ -53,6 +62,7 @@ func NewSelectPlan(db string, sql string, r *router.Router) *SelectPlan {
 	return &SelectPlan{
 		TableAliasStmtInfo: NewTableAliasStmtInfo(db, sql, r),
 		aggregateFuncs:     make(map[int]AggregateFuncMerger),
+		selectExprs:        make(map[int]evalExpr),
 		offset:             -1,
 		count:              -1,
 	}
@@ -60,9 +70,24 @@ func NewSelectPlan(db string, sql string, r *router.Router) *SelectPlan {
 
 // ExecuteIn implement Plan
 func (s *SelectPlan) ExecuteIn(reqCtx *util.RequestContext, sess Executor) (*mysql.Result, error) {
-	sqls := s.GetSQLs()
-	if sqls == nil {
-		return nil, fmt.Errorf("SQL has not generated")
+	// 设置了合并结果的内存上限时逐行读取分片结果, 只有返回的结果全部读取到内存中
+	if _, ok := sess.(StreamExecutor); ok && getMaxMergeMemory(sess) > 0 && (s.canStreamMerge() || s.canSpillMerge()) {
+		r, err := s.ExecuteStreamIn(reqCtx, sess)
+		if err != nil {
+			return nil, err
+		}
+		return fetchStreamResult(r)
+	}
+
+	if p, err := s.handleDeepOffset(reqCtx, sess); err != nil {
+		return nil, err
+	} else if p != s {
+		return p.ExecuteIn(reqCtx, sess)
+	}
+
+	sqls, err := s.getExecuteSQLs(reqCtx, sess)
+	if err != nil {
+		return nil, err
 	}
 
 	if len(sqls) == 0 {
@@ -78,6 +103,25 @@ func (s *SelectPlan) ExecuteIn(reqCtx *util.RequestContext, sess Executor) (*mys
 		return nil, fmt.Errorf("execute in SelectPlan error: %v", err)
 	}
 
+	// 只有ORDER BY的查询与ExecuteStreamIn相同按ORDER BY归并, 排序列相等时按分片结果的顺序返回,
+	// 两阶段分页第二阶段跳过的行与第一阶段的顺序一致
+	if s.canStreamMerge() {
+		r, err := MergeSelectResultStream(s, rs)
+		if err != nil {
+			return nil, fmt.Errorf("merge select result error: %v", err)
+		}
+		return fetchStreamResult(r)
+	}
+
+	// 不支持逐行读取分片结果时, GROUP BY结果超过内存上限后同样写入临时文件合并, 释放已读取的分片结果
+	if spiller, ok := sess.(MergeSpiller); ok && s.canSpillMerge() && spiller.GetMaxMergeMemory() > 0 {
+		r, err := MergeSelectResultSpill(s, s.stmt, rs, spiller)
+		if err != nil {
+			return nil, fmt.Errorf("merge select result error: %v", err)
+		}
+		return fetchStreamResult(r)
+	}
+
 	r, err := MergeSelectResult(s, s.stmt, rs)
 	if err != nil {
 		return nil, fmt.Errorf("merge select result error: %v", err)
@@ -86,6 +130,100 @@ func (s *SelectPlan) ExecuteIn(reqCtx *util.RequestContext, sess Executor) (*mys
 	return r, nil
 }
 
+// ExecuteStreamIn implement StreamPlan
+// 只有ORDER BY, 没有聚合和去重的跨分片查询按ORDER BY归并各分片的结果, 逐行返回;
+// 设置了合并结果的内存上限时, GROUP BY查询超过上限后写入临时文件合并, 逐行返回. 其他查询与ExecuteIn相同
+func (s *SelectPlan) ExecuteStreamIn(reqCtx *util.RequestContext, sess Executor) (*mysql.Result, error) {
+	streamSess, ok := sess.(StreamExecutor)
+	spill := !s.canStreamMerge() && s.canSpillMerge() && getMaxMergeMemory(sess) > 0
+	if !ok || !(s.canStreamMerge() || spill) {
+		return s.ExecuteIn(reqCtx, sess)
+	}
+
+	if p, err := s.handleDeepOffset(reqCtx, sess); err != nil {
+		return nil, err
+	} else if p != s {
+		return p.ExecuteStreamIn(reqCtx, sess)
+	}
+
+	sqls, err := s.getExecuteSQLs(reqCtx, sess)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sqls) == 0 {
+		r := newEmptyResultset(s, s.GetStmt())
+		ret := &mysql.Result{
+			Resultset: r,
+		}
+		return ret, nil
+	}
+
+	rs, err := streamSess.ExecuteSQLsStream(reqCtx, sqls)
+	if err != nil {
+		return nil, fmt.Errorf("execute in SelectPlan error: %v", err)
+	}
+
+	var r *mysql.Result
+	if spill {
+		r, err = MergeSelectResultSpill(s, s.stmt, rs, sess.(MergeSpiller))
+	} else {
+		r, err = MergeSelectResultStream(s, rs)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("merge select result error: %v", err)
+	}
+
+	return r, nil
+}
+
+// fetchStreamResult 读取结果集Stream中的全部行, 然后关闭Stream
+func fetchStreamResult(r *mysql.Result) (*mysql.Result, error) {
+	if r.Resultset == nil || r.Stream == nil {
+		return r, nil
+	}
+
+	stream := r.Stream
+	r.Stream = nil
+	for {
+		rowData, values, err := stream.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			stream.Close()
+			return nil, fmt.Errorf("merge select result error: %v", err)
+		}
+		r.RowDatas = append(r.RowDatas, rowData)
+		r.Values = append(r.Values, values)
+	}
+	if err := stream.Close(); err != nil {
+		return nil, fmt.Errorf("merge select result error: %v", err)
+	}
+	return r, nil
+}
+
+// getExecuteSQLs 获取需要执行的分片SQL, 有lookup时先查询映射表
+func (s *SelectPlan) getExecuteSQLs(reqCtx *util.RequestContext, sess Executor) (map[string]map[string][]string, error) {
+	sqls := s.GetSQLs()
+	if sqls == nil {
+		return nil, fmt.Errorf("SQL has not generated")
+	}
+
+	if s.lookup != nil {
+		var err error
+		if sqls, err = s.lookup.getSQLs(reqCtx, sess); err != nil {
+			return nil, fmt.Errorf("execute lookup in SelectPlan error: %v", err)
+		}
+	}
+	return sqls, nil
+}
+
+// canStreamMerge 是否可以按ORDER BY归并各分片的结果, 需要合并或者去重的查询需要读取全部结果
+func (s *SelectPlan) canStreamMerge() bool {
+	return s.HasOrderBy() && !s.HasGroupBy() && len(s.aggregateFuncs) == 0 &&
+		!s.distinct && s.having == nil && len(s.selectExprs) == 0
+}
+
 // GetStmt SelectStmt
 func (s *SelectPlan) GetStmt() *ast.SelectStmt {
 	return s.stmt
@@ -180,21 +318,19 @@ func HandleSelectStmt(p *SelectPlan, stmt *ast.SelectStmt) error {
 
 	handleExtraFieldList(p, stmt)
 
-	// 记录补列后的Fields长度, 后面的handler不会补列了
-	if stmt.Fields != nil {
-		p.columnCount = len(stmt.Fields.Fields)
+	// 聚合函数的补列放在GROUP BY和ORDER BY补列之后
+	if err := handleAggregateFuncExtraFields(p, stmt); err != nil {
+		return fmt.Errorf("handle aggregate function error: %v", err)
 	}
 
-	if err := handleWhere(p, stmt); err != nil {
-		return fmt.Errorf("handle Where error: %v", err)
-	}
-
-	if err := handleHaving(p, stmt); err != nil {
-		return fmt.Errorf("handle Having error: %v", err)
+	// lookup条件需要在改写WHERE条件之前查找
+	route, err := findLookupRoute(p.TableAliasStmtInfo, stmt.Where)
+	if err != nil {
+		return fmt.Errorf("handle lookup error: %v", err)
 	}
 
-	if err := handleLimit(p, stmt); err != nil {
-		return fmt.Errorf("handle Limit error: %v", err)
+	if err := handleWhere(p, stmt); err != nil {
+		return fmt.Errorf("handle Where error: %v", err)
 	}
 
 	if err := postHandleGlobalTableRouteResultInQuery(p.StmtInfo); err != nil {
@@ -205,6 +341,24 @@ func HandleSelectStmt(p *SelectPlan, stmt *ast.SelectStmt) error {
 		return fmt.Errorf("handle Hint error: %v", err)
 	}
 
+	// 包含聚合函数的查询列表达式和HAVING需要根据路由判断是否在proxy中计算, 放在路由计算之后, 计算时会补列
+	if err := handleAggregateExprFields(p, stmt); err != nil {
+		return fmt.Errorf("handle aggregate expression error: %v", err)
+	}
+
+	if err := handleHaving(p, stmt); err != nil {
+		return fmt.Errorf("handle Having error: %v", err)
+	}
+
+	// 记录补列后的Fields长度, 后面的handler不会补列了
+	if stmt.Fields != nil {
+		p.columnCount = len(stmt.Fields.Fields)
+	}
+
+	if err := handleLimit(p, stmt); err != nil {
+		return fmt.Errorf("handle Limit error: %v", err)
+	}
+
 	sqls, err := generateShardingSQLs(p.stmt, p.result, p.router)
 	if err != nil {
 		return fmt.Errorf("generate select SQL error: %v", err)
@@ -212,6 +366,10 @@ func HandleSelectStmt(p *SelectPlan, stmt *ast.SelectStmt) error {
 
 	p.sqls = sqls
 
+	if p.lookup, err = buildLookupPlan(p.TableAliasStmtInfo, p.stmt, route, nil); err != nil {
+		return fmt.Errorf("build lookup plan error: %v", err)
+	}
+
 	return nil
 }
 
@@ -362,6 +520,11 @@ func handleTableRefs(p *SelectPlan, stmt *ast.SelectStmt) error {
 		return nil
 	}
 
+	// 改写表名之前检查JOIN的表能否在各分片内独立执行
+	if err := checkColocatedJoin(join, stmt.Where, p.db, p.router); err != nil {
+		return fmt.Errorf("check join error: %v", err)
+	}
+
 	return handleJoin(p.TableAliasStmtInfo, join)
 }
 
@@ -413,6 +576,12 @@ func handleWhere(p *SelectPlan, stmt *ast.SelectStmt) (err error) {
 		return nil
 	}
 
+	// 多列分片的路由需要在改写WHERE条件之前计算
+	complexHas, complexResult, err := handleComplexShardingCondition(p.TableAliasStmtInfo, stmt.Where)
+	if err != nil {
+		return fmt.Errorf("handle complex sharding condition error: %v", err)
+	}
+
 	has, result, decorator, err := handleComparisonExpr(p.TableAliasStmtInfo, stmt.Where)
 	if err != nil {
 		return fmt.Errorf("rewrite Where error: %v", err)
@@ -420,6 +589,9 @@ func handleWhere(p *SelectPlan, stmt *ast.SelectStmt) (err error) {
 	if has {
 		p.GetRouteResult().Inter(result)
 	}
+	if complexHas {
+		p.GetRouteResult().Inter(complexResult)
+	}
 	stmt.Where = decorator
 	return nil
 }
@@ -584,6 +756,10 @@ func handleFieldList(p *SelectPlan, stmt *ast.SelectStmt) (err error) {
 	for i, f := range fields.Fields {
 		switch field := f.Expr.(type) {
 		case *ast.AggregateFuncExpr:
+			// 需要补列的聚合函数在GROUP BY和ORDER BY补列之后处理
+			if needAggregateFuncExtraFields(field) {
+				continue
+			}
 			merger, err := CreateAggregateFunctionMerger(field.F, i)
 			if err != nil {
 				return fmt.Errorf("create aggregate function merger error, column index: %d, err: %v", i, err)
@@ -610,13 +786,200 @@ func handleHaving(p *SelectPlan, stmt *ast.SelectStmt) (err error) {
 		return nil
 	}
 
+	// 不需要合并聚合结果, 或者HAVING不依赖聚合结果时, HAVING可以下推
+	pushDown := !p.needMergeAggregate() ||
+		(!p.aggregateGroupBy && !hasAggregateFunc(having.Expr) && !referAggregateField(p, stmt, having.Expr))
+
 	// 先用一个Visitor生成一个替换表名的装饰器
 	// 这里如果出错, 只能通过panic返回err
 	columnNameRewriter := NewColumnNameRewriteVisitor(p.TableAliasStmtInfo)
 	having.Accept(columnNameRewriter)
+	if pushDown {
+		return nil
+	}
+
+	// 各分片的分组只是完整分组的一部分, HAVING不能下推, 合并结果后在proxy中计算
+	b := newEvalExprBuilder(p, stmt)
+	if p.having, err = b.build(having.Expr); err != nil {
+		return fmt.Errorf("HAVING is not supported in cross shard query: %v", err)
+	}
+	stmt.Having = nil
 	return nil
 }
 
+// needMergeAggregate 是否需要在proxy中合并各分片的聚合结果.
+// 路由到多个分片, 或者聚合函数下推了GROUP BY时, 后端返回的分组都只是完整分组的一部分
+func (s *SelectPlan) needMergeAggregate() bool {
+	return len(s.result.indexes) > 1 || s.aggregateGroupBy
+}
+
+// referAggregateField 表达式中是否引用了包含聚合函数的查询列的别名
+func referAggregateField(p *SelectPlan, stmt *ast.SelectStmt, expr ast.ExprNode) bool {
+	v := &joinColumnVisitor{}
+	expr.Accept(v)
+	for _, c := range v.columns {
+		if c.Table.L != "" {
+			continue
+		}
+		for i := 0; i < p.originColumnCount; i++ {
+			field := stmt.Fields.Fields[i]
+			if field.AsName.L == c.Name.L && hasAggregateFunc(field.Expr) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// needAggregateFuncExtraFields 聚合函数是否需要补列
+// AVG()补充SUM()和COUNT()列, COUNT(DISTINCT), SUM(DISTINCT)和GROUP_CONCAT()补充参数列
+func needAggregateFuncExtraFields(f *ast.AggregateFuncExpr) bool {
+	switch strings.ToLower(f.F) {
+	case ast.AggFuncAvg, ast.AggFuncGroupConcat:
+		return true
+	case ast.AggFuncCount, ast.AggFuncSum:
+		return f.Distinct
+	default:
+		return false
+	}
+}
+
+// handleAggregateFuncExtraFields 为需要补列的聚合函数补列, 并生成聚合函数装饰器
+// 补充的列与GROUP BY, ORDER BY补充的列一样, 在返回结果时去掉.
+func handleAggregateFuncExtraFields(p *SelectPlan, stmt *ast.SelectStmt) error {
+	b := newEvalExprBuilder(p, stmt)
+	for i := 0; i < p.originColumnCount; i++ {
+		field, ok := stmt.Fields.Fields[i].Expr.(*ast.AggregateFuncExpr)
+		if !ok || !needAggregateFuncExtraFields(field) {
+			continue
+		}
+		if err := b.setAggregateFuncMerger(field, i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleAggregateExprFields 需要合并聚合结果时, 把包含聚合函数的查询列表达式中的聚合函数补列, 合并结果后在proxy中计算表达式的值.
+// 不需要合并时表达式直接下推
+func handleAggregateExprFields(p *SelectPlan, stmt *ast.SelectStmt) error {
+	if !p.needMergeAggregate() {
+		return nil
+	}
+	b := newEvalExprBuilder(p, stmt)
+	for i := 0; i < p.originColumnCount; i++ {
+		field := stmt.Fields.Fields[i].Expr
+		if _, ok := field.(*ast.AggregateFuncExpr); ok || !hasAggregateFunc(field) {
+			continue
+		}
+		expr, err := b.build(field)
+		if err != nil {
+			return fmt.Errorf("select expression with aggregate function is not supported in cross shard query, column index: %d, err: %v", i, err)
+		}
+		p.selectExprs[i] = expr
+	}
+	return nil
+}
+
+// createAggregateFuncMerger 生成聚合函数装饰器, 需要补列的聚合函数同时补列
+func createAggregateFuncMerger(p *SelectPlan, stmt *ast.SelectStmt, field *ast.AggregateFuncExpr, fieldIndex int, countIndex *int) (AggregateFuncMerger, error) {
+	switch {
+	case !needAggregateFuncExtraFields(field):
+		return CreateAggregateFunctionMerger(field.F, fieldIndex)
+	case strings.ToLower(field.F) == ast.AggFuncAvg && !field.Distinct:
+		// AVG(x)补充SUM(x)和COUNT(x)
+		sumIndex := appendExtraField(stmt, &ast.AggregateFuncExpr{F: ast.AggFuncSum, Args: field.Args})
+		avgCountIndex := appendExtraField(stmt, &ast.AggregateFuncExpr{F: ast.AggFuncCount, Args: field.Args})
+		return NewAggregateFuncAvgMerger(fieldIndex, sumIndex, avgCountIndex), nil
+	case strings.ToLower(field.F) == ast.AggFuncGroupConcat && !field.Distinct && field.Order == nil:
+		separator, err := getGroupConcatSeparator(field)
+		if err != nil {
+			return nil, err
+		}
+		return NewAggregateFuncGroupConcatMerger(fieldIndex, separator), nil
+	default:
+		return createAggregateFuncValuesMerger(p, stmt, field, fieldIndex, countIndex)
+	}
+}
+
+// createAggregateFuncValuesMerger 把聚合函数的参数和ORDER BY列补到FieldList中, 并加到GROUP BY中
+// 不去重的GROUP_CONCAT()还需要补充COUNT(1)列, 记录每个分组的行数
+func createAggregateFuncValuesMerger(p *SelectPlan, stmt *ast.SelectStmt, field *ast.AggregateFuncExpr, fieldIndex int, countIndex *int) (*AggregateFuncValuesMerger, error) {
+	args := field.Args
+	isGroupConcat := strings.ToLower(field.F) == ast.AggFuncGroupConcat
+	if isGroupConcat {
+		args = args[:len(args)-1] // 最后一个参数是分隔符
+	}
+
+	var argIndexes []int
+	for _, arg := range args {
+		index, err := appendAggregateGroupByField(p, stmt, arg)
+		if err != nil {
+			return nil, err
+		}
+		argIndexes = append(argIndexes, index)
+	}
+
+	merger, err := NewAggregateFuncValuesMerger(field.F, fieldIndex, field.Distinct, argIndexes)
+	if err != nil {
+		return nil, err
+	}
+	if !isGroupConcat {
+		return merger, nil
+	}
+
+	if merger.separator, err = getGroupConcatSeparator(field); err != nil {
+		return nil, err
+	}
+	if field.Order != nil {
+		for _, item := range field.Order.Items {
+			index, err := appendAggregateGroupByField(p, stmt, item.Expr)
+			if err != nil {
+				return nil, err
+			}
+			merger.orderByIndexes = append(merger.orderByIndexes, index)
+			merger.orderByDescs = append(merger.orderByDescs, item.Desc)
+		}
+	}
+	if !field.Distinct {
+		if *countIndex < 0 {
+			*countIndex = appendExtraField(stmt, &ast.AggregateFuncExpr{F: ast.AggFuncCount, Args: []ast.ExprNode{ast.NewValueExpr(1, "", "")}})
+		}
+		merger.countIndex = *countIndex
+	}
+	return merger, nil
+}
+
+func getGroupConcatSeparator(field *ast.AggregateFuncExpr) (string, error) {
+	separator, ok := field.Args[len(field.Args)-1].(*driver.ValueExpr)
+	if !ok {
+		return "", fmt.Errorf("GROUP_CONCAT separator is not a value")
+	}
+	return separator.GetString(), nil
+}
+
+// appendExtraField 把补充的列加到FieldList最后, 返回列位置
+func appendExtraField(stmt *ast.SelectStmt, expr ast.ExprNode) int {
+	stmt.Fields.Fields = append(stmt.Fields.Fields, &ast.SelectField{Expr: expr})
+	return len(stmt.Fields.Fields) - 1
+}
+
+// appendAggregateGroupByField 补充聚合函数的参数列, 并加到GROUP BY中下推, 返回列位置
+func appendAggregateGroupByField(p *SelectPlan, stmt *ast.SelectStmt, expr ast.ExprNode) (int, error) {
+	// GROUP BY常量会被当作列位置处理
+	switch expr.(type) {
+	case ast.ValueExpr, *ast.PositionExpr:
+		return 0, fmt.Errorf("constant argument is not supported in aggregate function rewritten to GROUP BY")
+	}
+
+	if stmt.GroupBy == nil {
+		stmt.GroupBy = &ast.GroupByClause{}
+	}
+	stmt.GroupBy.Items = append(stmt.GroupBy.Items, &ast.ByItem{Expr: expr})
+	p.aggregateGroupBy = true
+	return appendExtraField(stmt, expr), nil
+}
+
 func handleComparisonExpr(p *TableAliasStmtInfo, comp ast.ExprNode) (bool, []int, ast.ExprNode, error) {
 	switch expr := comp.(type) {
 	case *ast.BinaryOperationExpr:
@@ -638,6 +1001,9 @@ func handleComparisonExpr(p *TableAliasStmtInfo, comp ast.ExprNode) (bool, []int
 }
 
 func handlePatternInExpr(p *TableAliasStmtInfo, expr *ast.PatternInExpr) (bool, []int, ast.ExprNode, error) {
+	for i := range expr.List {
+		expr.List[i] = foldSignedValueExpr(expr.List[i])
+	}
 	rule, need, isAlias, err := NeedCreatePatternInExprDecorator(p, expr)
 	if err != nil {
 		return false, nil, nil, fmt.Errorf("check PatternInExpr error: %v", err)
@@ -653,6 +1019,8 @@ func handlePatternInExpr(p *TableAliasStmtInfo, expr *ast.PatternInExpr) (bool,
 }
 
 func handleBetweenExpr(p *TableAliasStmtInfo, expr *ast.BetweenExpr) (bool, []int, ast.ExprNode, error) {
+	expr.Left = foldSignedValueExpr(expr.Left)
+	expr.Right = foldSignedValueExpr(expr.Right)
 	rule, need, isAlias, err := NeedCreateBetweenExprDecorator(p, expr)
 	if err != nil {
 		return false, nil, nil, fmt.Errorf("check BetweenExpr error: %v", err)
@@ -713,6 +1081,8 @@ func handleBinaryOperationExprLogic(p *TableAliasStmtInfo, expr *ast.BinaryOpera
 // 如果出现列名, 则必须为列名与列名比较, 列名与值比较, 否则会报错 (比如 id + 2 = 3 就会报错, 因为 id + 2 处理不了)
 // 如果是其他情况, 则直接返回 (如 1 = 1 这种)
 func handleBinaryOperationExprMathCompare(p *TableAliasStmtInfo, expr *ast.BinaryOperationExpr) (bool, []int, ast.ExprNode, error) {
+	expr.L = foldSignedValueExpr(expr.L)
+	expr.R = foldSignedValueExpr(expr.R)
 	lType := getExprNodeTypeInBinaryOperation(expr.L)
 	rType := getExprNodeTypeInBinaryOperation(expr.R)
 
@@ -829,24 +1199,25 @@ func getDatabaseFuncHint(f *ast.FuncCallExpr, v ast.ExprNode) (string, error) {
 func getFindTableIndexesFunc(op opcode.Op) func(rule router.Rule, columnName string, v interface{}) ([]int, error) {
 	findTableIndexesFunc := func(rule router.Rule, columnName string, v interface{}) ([]int, error) {
 		// 如果不是分表列, 则需要返回所有分片
-		if rule.GetShardingColumn() != columnName {
+		if !rule.IsShardingColumn(columnName) {
 			return rule.GetSubTableIndexes(), nil
 		}
 
 		// 如果是分表列, 还需要根据运算符判断
 		switch op {
 		case opcode.EQ:
-			index, err := rule.FindTableIndex(v)
-			if err != nil {
-				return nil, err
-			}
-			return []int{index}, nil
+			return rule.FindTableIndexes(columnName, v)
 		case opcode.NE:
 			return rule.GetSubTableIndexes(), nil
 		case opcode.GT, opcode.GE, opcode.LT, opcode.LE:
+			// 如果分片算法可以计算范围条件的路由, 直接使用其结果
+			if s, ok := rule.GetShard().(router.RangeFinderShard); ok {
+				return s.FindForRange(getKeyRange(op, v))
+			}
+
 			// 如果是range路由, 需要做一些特殊处理
 			if rangeShard, ok := rule.GetShard().(router.RangeShard); ok {
-				index, err := rule.FindTableIndex(v)
+				index, err := findRangeTableIndex(rule, v)
 				if err != nil {
 					return nil, err
 				}
@@ -870,6 +1241,28 @@ func getFindTableIndexesFunc(op opcode.Op) func(rule router.Rule, columnName str
 	return findTableIndexesFunc
 }
 
+// 将列名与值的比较转换为值的范围, 如 id > 10 转换为 (10, +∞)
+func getKeyRange(op opcode.Op, v interface{}) provider.KeyRange {
+	switch op {
+	case opcode.GT:
+		return provider.KeyRange{Lower: v}
+	case opcode.GE:
+		return provider.KeyRange{Lower: v, LowerInclusive: true}
+	case opcode.LT:
+		return provider.KeyRange{Upper: v}
+	default:
+		return provider.KeyRange{Upper: v, UpperInclusive: true}
+	}
+}
+
+// 计算范围条件边界值的路由, 如果分片支持, 超出范围的值会被调整为第一个或最后一个子表
+func findRangeTableIndex(rule router.Rule, v interface{}) (int, error) {
+	if s, ok := rule.GetShard().(router.ClampedRangeShard); ok {
+		return s.FindForRangeKey(v)
+	}
+	return rule.FindTableIndex(v)
+}
+
 // copy from PlanBuilder.adjustShardIndex()
 func adjustShardIndex(s router.RangeShard, value interface{}, index int) int {
 	if s.EqualStart(value, index) {
@@ -1006,6 +1399,11 @@ func handleLimit(p *SelectPlan, stmt *ast.SelectStmt) error {
 	need, originOffset, originCount, newLimit := NeedRewriteLimitOrCreateRewrite(stmt)
 	p.offset = originOffset
 	p.count = originCount
+	// 聚合函数下推了GROUP BY时, 后端返回的是更细的分组, 在proxy中计算HAVING时, 后端返回的分组会被过滤, LIMIT都不能下推
+	if p.aggregateGroupBy || p.having != nil {
+		stmt.Limit = nil
+		return nil
+	}
 	if need {
 		stmt.Limit = newLimit
 	}
@@ -1752,6 +1752,107 @@ func TestMycatSelectAggregationFunctionSum(t *testing.T) {
 	}
 }
 
+func TestMycatSelectAggregationFunctionExtraFields(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	allSQLs := func(sql string) map[string]map[string][]string {
+		return map[string]map[string][]string{
+			"slice-0": {
+				"db_mycat_0": {sql},
+				"db_mycat_1": {sql},
+			},
+			"slice-1": {
+				"db_mycat_2": {sql},
+				"db_mycat_3": {sql},
+			},
+		}
+	}
+
+	tests := []SQLTestcase{
+		{
+			db:   "db_mycat",
+			sql:  "select avg(id) from tbl_mycat",
+			sqls: allSQLs("SELECT AVG(`id`),SUM(`id`),COUNT(`id`) FROM `tbl_mycat`"),
+		},
+		{
+			db:   "db_mycat",
+			sql:  "select user, avg(id) from tbl_mycat group by user order by user",
+			sqls: allSQLs("SELECT `user`,AVG(`id`),SUM(`id`),COUNT(`id`) FROM `tbl_mycat` GROUP BY `user` ORDER BY `user`"),
+		},
+		{
+			db:   "db_mycat",
+			sql:  "select count(distinct user), sum(distinct id) from tbl_mycat limit 1, 1",
+			sqls: allSQLs("SELECT COUNT(DISTINCT `user`),SUM(DISTINCT `id`),`user`,`id` FROM `tbl_mycat` GROUP BY `user`,`id`"),
+		},
+		{
+			db:   "db_mycat",
+			sql:  "select id, count(distinct user) from tbl_mycat group by id",
+			sqls: allSQLs("SELECT `id`,COUNT(DISTINCT `user`),`user` FROM `tbl_mycat` GROUP BY `id`,`user`"),
+		},
+		{
+			db:   "db_mycat",
+			sql:  "select group_concat(user separator ';') from tbl_mycat",
+			sqls: allSQLs("SELECT GROUP_CONCAT(`user` SEPARATOR ';') FROM `tbl_mycat`"),
+		},
+		{
+			db:   "db_mycat",
+			sql:  "select group_concat(user order by id desc) from tbl_mycat",
+			sqls: allSQLs("SELECT GROUP_CONCAT(`user` ORDER BY `id` DESC SEPARATOR ','),`user`,`id`,COUNT(1) FROM `tbl_mycat` GROUP BY `user`,`id`"),
+		},
+		{
+			db:   "db_mycat",
+			sql:  "select group_concat(distinct user) from tbl_mycat",
+			sqls: allSQLs("SELECT GROUP_CONCAT(DISTINCT `user` SEPARATOR ','),`user` FROM `tbl_mycat` GROUP BY `user`"),
+		},
+		{
+			db:   "db_mycat",
+			sql:  "select id, count(distinct user) from tbl_mycat group by id having count(distinct user) > 1 and avg(user) < 5 limit 10",
+			sqls: allSQLs("SELECT `id`,COUNT(DISTINCT `user`),`user`,AVG(`user`),SUM(`user`),COUNT(`user`) FROM `tbl_mycat` GROUP BY `id`,`user`"),
+		},
+		{
+			db:   "db_mycat",
+			sql:  "select id, sum(user) / count(user) as a, max(user) - min(user) from tbl_mycat group by id having a > 1",
+			sqls: allSQLs("SELECT `id`,SUM(`user`)/COUNT(`user`) AS `a`,MAX(`user`)-MIN(`user`),SUM(`user`),COUNT(`user`),MAX(`user`),MIN(`user`) FROM `tbl_mycat` GROUP BY `id`"),
+		},
+		{
+			db:     "db_mycat",
+			sql:    "select id, concat(max(user), 'a') from tbl_mycat group by id",
+			hasErr: true, // function is not supported in evaluator
+		},
+		{
+			db:     "db_mycat",
+			sql:    "select id, count(user) from tbl_mycat group by id having concat(count(user), 'a') = '1a'",
+			hasErr: true, // function is not supported in evaluator
+		},
+		{
+			db:  "db_mycat",
+			sql: "select id, concat(max(user), 'a') from tbl_mycat where id = 1 group by id having sum(user) / count(user) > 1",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_mycat_1": {"SELECT `id`,CONCAT(MAX(`user`), 'a') FROM `tbl_mycat` WHERE `id`=1 GROUP BY `id` HAVING SUM(`user`)/COUNT(`user`)>1"},
+				},
+			}, // single shard, expressions are pushed down
+		},
+		{
+			db:   "db_mycat",
+			sql:  "select id, count(user) from tbl_mycat group by id having id > 1",
+			sqls: allSQLs("SELECT `id`,COUNT(`user`) FROM `tbl_mycat` GROUP BY `id` HAVING `id`>1"), // having without aggregate is pushed down
+		},
+		{
+			db:     "db_mycat",
+			sql:    "select count(distinct 1) from tbl_mycat",
+			hasErr: true, // constant in GROUP BY is a column position
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.sql, getTestFunc(ns, test))
+	}
+}
+
 func TestMycatSelectGroupBy(t *testing.T) {
 	ns, err := preparePlanInfo()
 	if err != nil {
@@ -2126,6 +2227,98 @@ func TestSelectMultiTablesKingshard(t *testing.T) {
 	}
 }
 
+func TestSelectKingshardBindingTables(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	tests := []SQLTestcase{
+		{
+			db:  "db_ks",
+			sql: "select * from tbl_ks_order o join tbl_ks_order_item i on o.order_id = i.order_id where o.order_id = 5",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_order_0001` AS `o` JOIN `tbl_ks_order_item_0001` AS `i` ON `o`.`order_id`=`i`.`order_id` WHERE `o`.`order_id`=5",
+					},
+				},
+			},
+		},
+		{
+			db:  "db_ks",
+			sql: "select * from tbl_ks_order o join tbl_ks_order_item i on o.order_id = i.order_id join tbl_ks_order_payment p on o.order_id = p.pay_order_id where p.pay_order_id in (2, 7)",
+			sqls: map[string]map[string][]string{
+				"slice-1": {
+					"db_ks": {
+						"SELECT * FROM (`tbl_ks_order_0002` AS `o` JOIN `tbl_ks_order_item_0002` AS `i` ON `o`.`order_id`=`i`.`order_id`) JOIN `tbl_ks_order_payment_0002` AS `p` ON `o`.`order_id`=`p`.`pay_order_id` WHERE `p`.`pay_order_id` IN (2)",
+						"SELECT * FROM (`tbl_ks_order_0003` AS `o` JOIN `tbl_ks_order_item_0003` AS `i` ON `o`.`order_id`=`i`.`order_id`) JOIN `tbl_ks_order_payment_0003` AS `p` ON `o`.`order_id`=`p`.`pay_order_id` WHERE `p`.`pay_order_id` IN (7)",
+					},
+				},
+			},
+		},
+		{
+			db:  "db_ks",
+			sql: "select * from tbl_ks_order_item i join tbl_ks_order_payment p on i.order_id = p.pay_order_id",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_order_item_0000` AS `i` JOIN `tbl_ks_order_payment_0000` AS `p` ON `i`.`order_id`=`p`.`pay_order_id`",
+						"SELECT * FROM `tbl_ks_order_item_0001` AS `i` JOIN `tbl_ks_order_payment_0001` AS `p` ON `i`.`order_id`=`p`.`pay_order_id`",
+					},
+				},
+				"slice-1": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_order_item_0002` AS `i` JOIN `tbl_ks_order_payment_0002` AS `p` ON `i`.`order_id`=`p`.`pay_order_id`",
+						"SELECT * FROM `tbl_ks_order_item_0003` AS `i` JOIN `tbl_ks_order_payment_0003` AS `p` ON `i`.`order_id`=`p`.`pay_order_id`",
+					},
+				},
+			},
+		},
+		{
+			db:   "db_ks",
+			sql:  "select * from tbl_ks_order o join tbl_ks_order_item i on o.order_id = i.order_id where o.order_id = 5 and i.order_id = 6",
+			sqls: map[string]map[string][]string{},
+		},
+		{
+			db:  "db_ks",
+			sql: "select * from tbl_ks_order o join tbl_ks_order_item i using (order_id) where o.order_id = 5",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_order_0001` AS `o` JOIN `tbl_ks_order_item_0001` AS `i` USING (`order_id`) WHERE `o`.`order_id`=5",
+					},
+				},
+			},
+		},
+		{
+			db:  "db_ks",
+			sql: "select * from tbl_ks a join tbl_ks b on a.id = b.id where a.id = 5",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_0001` AS `a` JOIN `tbl_ks_0001` AS `b` ON `a`.`id`=`b`.`id` WHERE `a`.`id`=5",
+					},
+				},
+			},
+		},
+		{
+			db:     "db_ks",
+			sql:    "select * from tbl_ks_order o join tbl_ks t on o.order_id > t.id", // tables have different route and no equal join condition
+			hasErr: true,
+		},
+		{
+			db:     "db_ks",
+			sql:    "select * from tbl_ks_order o join tbl_ks_order_item i on o.order_id = i.order_id join tbl_ks_order_payment p on o.order_id = p.id", // not joined on sharding column
+			hasErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.sql, getTestFunc(ns, test))
+	}
+}
+
 func TestSelectKingshardNumRange(t *testing.T) {
 	ns, err := preparePlanInfo()
 	if err != nil {
@@ -3559,6 +3752,680 @@ func TestSelectGroupByAliasColumn(t *testing.T) {
 	}
 }
 
+func TestSelectKingshardInline(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	tests := []SQLTestcase{
+		{
+			db:  "db_ks",
+			sql: "select * from tbl_ks_inline where user_id = 5",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_inline_0001` WHERE `user_id`=5",
+					},
+				},
+			},
+		},
+		{
+			db:  "db_ks",
+			sql: "select * from tbl_ks_inline where user_id in (2, 7)",
+			sqls: map[string]map[string][]string{
+				"slice-1": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_inline_0002` WHERE `user_id` IN (2)",
+						"SELECT * FROM `tbl_ks_inline_0003` WHERE `user_id` IN (7)",
+					},
+				},
+			},
+		},
+		{
+			db:  "db_ks",
+			sql: "select * from tbl_ks_inline where user_id > 5",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_inline_0000` WHERE `user_id`>5",
+						"SELECT * FROM `tbl_ks_inline_0001` WHERE `user_id`>5",
+					},
+				},
+				"slice-1": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_inline_0002` WHERE `user_id`>5",
+						"SELECT * FROM `tbl_ks_inline_0003` WHERE `user_id`>5",
+					},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.sql, getTestFunc(ns, test))
+	}
+}
+
+func TestSelectKingshardStandard(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	tests := []SQLTestcase{
+		{
+			db:  "db_ks",
+			sql: "select * from tbl_ks_standard where tenant_id = 3 and order_id = 4",
+			sqls: map[string]map[string][]string{
+				"slice-1": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_standard_0002` WHERE `tenant_id`=3 AND `order_id`=4",
+					},
+				},
+			},
+		},
+		{
+			db:  "db_ks",
+			sql: "select * from tbl_ks_standard where tenant_id = 2",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_standard_0000` WHERE `tenant_id`=2",
+						"SELECT * FROM `tbl_ks_standard_0001` WHERE `tenant_id`=2",
+					},
+				},
+			},
+		},
+		{
+			db:  "db_ks",
+			sql: "select * from tbl_ks_standard where order_id = 5",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_standard_0001` WHERE `order_id`=5",
+					},
+				},
+				"slice-1": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_standard_0003` WHERE `order_id`=5",
+					},
+				},
+			},
+		},
+		{
+			db:  "db_ks",
+			sql: "select * from tbl_ks_standard where tenant_id in (1, 2) and order_id in (3, 5)",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_standard_0001` WHERE `tenant_id` IN (2) AND `order_id` IN (3,5)",
+					},
+				},
+				"slice-1": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_standard_0003` WHERE `tenant_id` IN (1) AND `order_id` IN (3,5)",
+					},
+				},
+			},
+		},
+		{
+			db:  "db_ks",
+			sql: "select * from tbl_ks_standard where tenant_id = 1 or order_id = 2",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_standard_0000` WHERE `tenant_id`=1 OR `order_id`=2",
+					},
+				},
+				"slice-1": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_standard_0002` WHERE `tenant_id`=1 OR `order_id`=2",
+						"SELECT * FROM `tbl_ks_standard_0003` WHERE `tenant_id`=1 OR `order_id`=2",
+					},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.sql, getTestFunc(ns, test))
+	}
+}
+
+func TestSelectKingshardComplex(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	tests := []SQLTestcase{
+		{
+			db:  "db_ks",
+			sql: "select * from tbl_ks_complex where region_id = 1 and user_id = 3",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_complex_0001` WHERE `region_id`=1 AND `user_id`=3",
+					},
+				},
+			},
+		},
+		{
+			db:  "db_ks",
+			sql: "select * from tbl_ks_complex where 3 = user_id and (a = 'hi' and region_id = 1)",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_complex_0001` WHERE 3=`user_id` AND (`a`='hi' AND `region_id`=1)",
+					},
+				},
+			},
+		},
+		{
+			db:  "db_ks",
+			sql: "select * from tbl_ks_complex where region_id in (1, 2) and user_id = 1",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_complex_0001` WHERE `region_id` IN (1,2) AND `user_id`=1",
+					},
+				},
+				"slice-1": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_complex_0003` WHERE `region_id` IN (1,2) AND `user_id`=1",
+					},
+				},
+			},
+		},
+		{
+			db:  "db_ks",
+			sql: "select * from tbl_ks_complex where region_id = 1",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_complex_0000` WHERE `region_id`=1",
+						"SELECT * FROM `tbl_ks_complex_0001` WHERE `region_id`=1",
+					},
+				},
+				"slice-1": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_complex_0002` WHERE `region_id`=1",
+						"SELECT * FROM `tbl_ks_complex_0003` WHERE `region_id`=1",
+					},
+				},
+			},
+		},
+		{
+			db:  "db_ks",
+			sql: "select * from tbl_ks_complex where region_id = 1 or user_id = 3",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_complex_0000` WHERE `region_id`=1 OR `user_id`=3",
+						"SELECT * FROM `tbl_ks_complex_0001` WHERE `region_id`=1 OR `user_id`=3",
+					},
+				},
+				"slice-1": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_complex_0002` WHERE `region_id`=1 OR `user_id`=3",
+						"SELECT * FROM `tbl_ks_complex_0003` WHERE `region_id`=1 OR `user_id`=3",
+					},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.sql, getTestFunc(ns, test))
+	}
+}
+
+func TestSelectKingshardActualDataNodes(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	tests := []SQLTestcase{
+		{
+			db:  "db_ks",
+			sql: "select * from tbl_ks_nodes where id = 4",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_nodes_b` WHERE `id`=4",
+					},
+				},
+			},
+		},
+		{
+			db:  "db_ks",
+			sql: "select tbl_ks_nodes.name from tbl_ks_nodes where tbl_ks_nodes.id in (2, 3)",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {
+						"SELECT `tbl_ks_nodes_a`.`name` FROM `tbl_ks_nodes_a` WHERE `tbl_ks_nodes_a`.`id` IN (3)",
+					},
+				},
+				"slice-1": {
+					"db_ks": {
+						"SELECT `tbl_ks_nodes_c`.`name` FROM `tbl_ks_nodes_c` WHERE `tbl_ks_nodes_c`.`id` IN (2)",
+					},
+				},
+			},
+		},
+		{
+			db:  "db_ks",
+			sql: "select * from tbl_ks_nodes",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_nodes_a`",
+						"SELECT * FROM `tbl_ks_nodes_b`",
+					},
+				},
+				"slice-1": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_nodes_c`",
+					},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.sql, getTestFunc(ns, test))
+	}
+}
+
+func TestSelectKingshardInterval(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	tests := []SQLTestcase{
+		{
+			db:  "db_ks",
+			sql: "select * from tbl_ks_interval where create_time = '2019-03-15 10:00:00'",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_interval_201903` WHERE `create_time`='2019-03-15 10:00:00'",
+					},
+				},
+			},
+		},
+		{
+			db:  "db_ks",
+			sql: "select * from tbl_ks_interval where create_time between '2019-02-01' and '2019-03-31'",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_interval_201903` WHERE `create_time` BETWEEN '2019-02-01' AND '2019-03-31'",
+					},
+				},
+				"slice-1": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_interval_201902` WHERE `create_time` BETWEEN '2019-02-01' AND '2019-03-31'",
+					},
+				},
+			},
+		},
+		{
+			db:  "db_ks",
+			sql: "select * from tbl_ks_interval where create_time >= '2019-05-01' and create_time < '2020-01-01'",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_interval_201905` WHERE `create_time`>='2019-05-01' AND `create_time`<'2020-01-01'",
+					},
+				},
+				"slice-1": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_interval_201906` WHERE `create_time`>='2019-05-01' AND `create_time`<'2020-01-01'",
+					},
+				},
+			},
+		},
+		{
+			db:  "db_ks",
+			sql: "select * from tbl_ks_interval where create_time < '2019-02-01'",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_interval_201901` WHERE `create_time`<'2019-02-01'",
+					},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.sql, getTestFunc(ns, test))
+	}
+}
+
+func TestSelectKingshardBoundaryRange(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	tests := []SQLTestcase{
+		{
+			db:  "db_ks",
+			sql: "select * from tbl_ks_volume where id = -10",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_volume_0000` WHERE `id`=-10",
+					},
+				},
+			},
+		},
+		{
+			db:  "db_ks",
+			sql: "select * from tbl_ks_volume where id in (-10, 150)",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_volume_0000` WHERE `id` IN (-10)",
+						"SELECT * FROM `tbl_ks_volume_0002` WHERE `id` IN (150)",
+					},
+				},
+			},
+		},
+		{
+			db:  "db_ks",
+			sql: "select * from tbl_ks_volume where id between -50 and 50",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_volume_0000` WHERE `id` BETWEEN -50 AND 50",
+						"SELECT * FROM `tbl_ks_volume_0001` WHERE `id` BETWEEN -50 AND 50",
+					},
+				},
+			},
+		},
+		{
+			db:  "db_ks",
+			sql: "select * from tbl_ks_volume where id = 100000",
+			sqls: map[string]map[string][]string{
+				"slice-1": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_volume_0004` WHERE `id`=100000",
+					},
+				},
+			},
+		},
+		{
+			db:  "db_ks",
+			sql: "select * from tbl_ks_volume where id between 50 and 150",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_volume_0001` WHERE `id` BETWEEN 50 AND 150",
+						"SELECT * FROM `tbl_ks_volume_0002` WHERE `id` BETWEEN 50 AND 150",
+					},
+				},
+			},
+		},
+		{
+			db:  "db_ks",
+			sql: "select * from tbl_ks_volume where id < 100",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_volume_0000` WHERE `id`<100",
+						"SELECT * FROM `tbl_ks_volume_0001` WHERE `id`<100",
+					},
+				},
+			},
+		},
+		{
+			db:  "db_ks",
+			sql: "select * from tbl_ks_volume where id >= 250",
+			sqls: map[string]map[string][]string{
+				"slice-1": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_volume_0003` WHERE `id`>=250",
+						"SELECT * FROM `tbl_ks_volume_0004` WHERE `id`>=250",
+					},
+				},
+			},
+		},
+		{
+			db:  "db_ks",
+			sql: "select * from tbl_ks_boundary where name = 'zoo'",
+			sqls: map[string]map[string][]string{
+				"slice-1": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_boundary_0002` WHERE `name`='zoo'",
+					},
+				},
+			},
+		},
+		{
+			db:  "db_ks",
+			sql: "select * from tbl_ks_boundary where name < 'h'",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_boundary_0000` WHERE `name`<'h'",
+					},
+				},
+			},
+		},
+		{
+			db:  "db_ks",
+			sql: "select * from tbl_ks_boundary where name between 'abc' and 'jack'",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_boundary_0000` WHERE `name` BETWEEN 'abc' AND 'jack'",
+						"SELECT * FROM `tbl_ks_boundary_0001` WHERE `name` BETWEEN 'abc' AND 'jack'",
+					},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.sql, getTestFunc(ns, test))
+	}
+}
+
+func TestSelectKingshardHashMod(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	tests := []SQLTestcase{
+		{
+			db:  "db_ks",
+			sql: "select * from tbl_ks_hash_mod where name = 'hello'",
+			sqls: map[string]map[string][]string{
+				"slice-1": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_hash_mod_0002` WHERE `name`='hello'",
+					},
+				},
+			},
+		},
+		{
+			db:  "db_ks",
+			sql: "select * from tbl_ks_hash_mod where name in ('hello', 'polygenelubricants', '12345')",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_hash_mod_0000` WHERE `name` IN ('polygenelubricants')",
+					},
+				},
+				"slice-1": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_hash_mod_0002` WHERE `name` IN ('hello')",
+						"SELECT * FROM `tbl_ks_hash_mod_0003` WHERE `name` IN ('12345')",
+					},
+				},
+			},
+		},
+		{
+			db:  "db_ks",
+			sql: "select * from tbl_ks_hash_mod where name = 12345",
+			sqls: map[string]map[string][]string{
+				"slice-1": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_hash_mod_0003` WHERE `name`=12345",
+					},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.sql, getTestFunc(ns, test))
+	}
+}
+
+func TestSelectKingshardGene(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	tests := []SQLTestcase{
+		{
+			db:  "db_ks",
+			sql: "select * from tbl_ks_gene where user_id = 33",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_gene_0001` WHERE `user_id`=33",
+					},
+				},
+			},
+		},
+		{
+			db:  "db_ks",
+			sql: "select * from tbl_ks_gene where order_id = 17",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_gene_0001` WHERE `order_id`=17",
+					},
+				},
+			},
+		},
+		{
+			db:   "db_ks",
+			sql:  "select * from tbl_ks_gene where user_id = 33 and order_id = 22",
+			sqls: map[string]map[string][]string{},
+		},
+		{
+			db:  "db_ks",
+			sql: "select * from tbl_ks_gene where order_id in (1, 18, 30)",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_gene_0001` WHERE `order_id` IN (1)",
+					},
+				},
+				"slice-1": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_gene_0002` WHERE `order_id` IN (18,30)",
+					},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.sql, getTestFunc(ns, test))
+	}
+}
+
+func TestSelectKingshardCustom(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	tests := []SQLTestcase{
+		{
+			db:  "db_ks",
+			sql: "select * from tbl_ks_custom where id = 150",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_custom_0001` WHERE `id`=150",
+					},
+				},
+			},
+		},
+		{
+			db:  "db_ks",
+			sql: "select * from tbl_ks_custom where id between 120 and 250",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_custom_0001` WHERE `id` BETWEEN 120 AND 250",
+					},
+				},
+				"slice-1": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_custom_0002` WHERE `id` BETWEEN 120 AND 250",
+					},
+				},
+			},
+		},
+		{
+			db:  "db_ks",
+			sql: "select * from tbl_ks_custom where id < 100",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_custom_0000` WHERE `id`<100",
+					},
+				},
+			},
+		},
+		{
+			db:  "db_ks",
+			sql: "select * from tbl_ks_custom where id > 199 and id <= 300",
+			sqls: map[string]map[string][]string{
+				"slice-1": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_custom_0002` WHERE `id`>199 AND `id`<=300",
+						"SELECT * FROM `tbl_ks_custom_0003` WHERE `id`>199 AND `id`<=300",
+					},
+				},
+			},
+		},
+		{
+			db:  "db_ks",
+			sql: "select * from tbl_ks_custom where id not between 100 and 399",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_custom_0000` WHERE `id` NOT BETWEEN 100 AND 399",
+					},
+				},
+				"slice-1": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_custom_0003` WHERE `id` NOT BETWEEN 100 AND 399",
+					},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.sql, getTestFunc(ns, test))
+	}
+}
 func prepareShardKingshardRouter() (*router.Router, error) {
 	nsStr := `
 {
@@ -0,0 +1,440 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plan
+
+import (
+	"fmt"
+
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/proxy/router"
+	"github.com/XiaoMi/Gaea/proxy/sequence"
+	"github.com/XiaoMi/Gaea/util"
+	"github.com/pingcap/parser/ast"
+)
+
+// DefaultMaxSubqueryRows 子查询结果的默认行数上限
+const DefaultMaxSubqueryRows = 10000
+
+// SubqueryRowsLimiter 由Executor实现, 返回子查询结果的行数上限, 未实现或者返回值不大于0时使用DefaultMaxSubqueryRows
+type SubqueryRowsLimiter interface {
+	GetMaxSubqueryRows() int
+}
+
+// SubqueryPlan WHERE中包含子查询的分片语句, 只支持非关联子查询.
+// 执行时先执行子查询 (子查询可以是跨分片的), 把IN子查询替换为常量列表, EXISTS子查询替换为0或1,
+// 标量子查询替换为常量, 然后按替换后的语句重新构建计划并执行, 外层语句按子查询的结果路由.
+type SubqueryPlan struct {
+	basePlan
+
+	db     string
+	sql    string
+	phyDBs map[string]string
+	router *router.Router
+	seq    *sequence.SequenceManager
+}
+
+// subqueryResult 子查询的结果
+type subqueryResult struct {
+	rows [][]interface{}
+}
+
+// whereSubqueryVisitor 收集WHERE中最外层的子查询, 不进入子查询内部
+type whereSubqueryVisitor struct {
+	subqueries []ast.ExprNode // SubqueryExpr or ExistsSubqueryExpr
+}
+
+// Enter implement ast.Visitor
+func (v *whereSubqueryVisitor) Enter(n ast.Node) (ast.Node, bool) {
+	switch x := n.(type) {
+	case *ast.SubqueryExpr:
+		v.subqueries = append(v.subqueries, x)
+		return n, true
+	case *ast.ExistsSubqueryExpr:
+		v.subqueries = append(v.subqueries, x)
+		return n, true
+	}
+	return n, false
+}
+
+// Leave implement ast.Visitor
+func (v *whereSubqueryVisitor) Leave(n ast.Node) (ast.Node, bool) {
+	return n, true
+}
+
+// getWhereSubqueries 返回SELECT, UPDATE, DELETE语句WHERE中的子查询
+func getWhereSubqueries(stmt ast.StmtNode) []ast.ExprNode {
+	var where ast.ExprNode
+	switch s := stmt.(type) {
+	case *ast.SelectStmt:
+		where = s.Where
+	case *ast.UpdateStmt:
+		where = s.Where
+	case *ast.DeleteStmt:
+		where = s.Where
+	}
+	if where == nil {
+		return nil
+	}
+	v := &whereSubqueryVisitor{}
+	where.Accept(v)
+	return v.subqueries
+}
+
+// buildSubqueryPlan 检查子查询是否为非关联子查询, 并构建SubqueryPlan
+func buildSubqueryPlan(stmt ast.StmtNode, subqueries []ast.ExprNode, phyDBs map[string]string, db, sql string, r *router.Router, seq *sequence.SequenceManager) (*SubqueryPlan, error) {
+	outer := &outerColumnVisitor{columns: make(map[string]bool)}
+	stmt.Accept(outer)
+	outerColumns := outer.getColumns(db, r)
+
+	for _, s := range subqueries {
+		query := getSubqueryQuery(s)
+		if err := checkUncorrelatedSubquery(query, outerColumns, db, r); err != nil {
+			return nil, err
+		}
+		if _, err := restoreNode(query); err != nil {
+			return nil, fmt.Errorf("restore subquery error: %v", err)
+		}
+	}
+
+	return &SubqueryPlan{
+		db:     db,
+		sql:    sql,
+		phyDBs: phyDBs,
+		router: r,
+		seq:    seq,
+	}, nil
+}
+
+func getSubqueryQuery(s ast.ExprNode) ast.ResultSetNode {
+	switch x := s.(type) {
+	case *ast.SubqueryExpr:
+		return x.Query
+	case *ast.ExistsSubqueryExpr:
+		return x.Sel.(*ast.SubqueryExpr).Query
+	}
+	return nil
+}
+
+// subqueryTableVisitor 收集子查询中的表名和别名, 带有表名的列, 不带表名的列和字段别名
+type subqueryTableVisitor struct {
+	tables      map[string]bool
+	tableNames  []*ast.TableName
+	columns     []*ast.ColumnName
+	unqualified []*ast.ColumnName
+	aliases     map[string]bool
+}
+
+// Enter implement ast.Visitor
+func (v *subqueryTableVisitor) Enter(n ast.Node) (ast.Node, bool) {
+	switch x := n.(type) {
+	case *ast.TableSource:
+		if x.AsName.L != "" {
+			v.tables[x.AsName.L] = true
+		}
+		if t, ok := x.Source.(*ast.TableName); ok && x.AsName.L == "" {
+			v.tables[t.Name.L] = true
+		}
+	case *ast.TableName:
+		v.tableNames = append(v.tableNames, x)
+	case *ast.SelectField:
+		if x.AsName.L != "" {
+			v.aliases[x.AsName.L] = true
+		}
+	case *ast.ColumnNameExpr:
+		if x.Name.Table.L != "" {
+			v.columns = append(v.columns, x.Name)
+		} else {
+			v.unqualified = append(v.unqualified, x.Name)
+		}
+	}
+	return n, false
+}
+
+// Leave implement ast.Visitor
+func (v *subqueryTableVisitor) Leave(n ast.Node) (ast.Node, bool) {
+	return n, true
+}
+
+// outerColumnVisitor 收集外层语句中的表和列名, 不进入子查询内部
+type outerColumnVisitor struct {
+	tableNames []*ast.TableName
+	columns    map[string]bool
+}
+
+// Enter implement ast.Visitor
+func (v *outerColumnVisitor) Enter(n ast.Node) (ast.Node, bool) {
+	switch x := n.(type) {
+	case *ast.SubqueryExpr, *ast.ExistsSubqueryExpr:
+		return n, true
+	case *ast.TableName:
+		v.tableNames = append(v.tableNames, x)
+	case *ast.ColumnName:
+		v.columns[x.Name.L] = true
+	}
+	return n, false
+}
+
+// Leave implement ast.Visitor
+func (v *outerColumnVisitor) Leave(n ast.Node) (ast.Node, bool) {
+	return n, true
+}
+
+// getColumns 返回已知属于外层表的列: 外层语句中出现的列, 以及外层分片表的分片列和lookup列
+func (v *outerColumnVisitor) getColumns(db string, r *router.Router) map[string]bool {
+	columns := make(map[string]bool, len(v.columns))
+	for c := range v.columns {
+		columns[c] = true
+	}
+	for c := range getRuleColumns(v.tableNames, db, r) {
+		columns[c] = true
+	}
+	return columns
+}
+
+// getRuleColumns 返回分片表的分片列和lookup列
+func getRuleColumns(tables []*ast.TableName, db string, r *router.Router) map[string]bool {
+	columns := make(map[string]bool)
+	for _, t := range tables {
+		tableDB := db
+		if t.Schema.L != "" {
+			tableDB = t.Schema.L
+		}
+		rule, ok := r.GetShardRule(tableDB, t.Name.L)
+		if !ok {
+			continue
+		}
+		for _, c := range rule.GetShardingColumns() {
+			columns[c] = true
+		}
+		for _, l := range rule.GetLookups() {
+			columns[l.Column] = true
+		}
+	}
+	return columns
+}
+
+// checkUncorrelatedSubquery 子查询中的列如果带有表名, 表名必须是子查询中的表, 否则是关联子查询.
+// proxy没有表结构, 不带表名的列只有已知属于子查询中的表时 (子查询中带有表名的列, 子查询中分片表的分片列和lookup列,
+// 或者字段别名) 才按子查询中的列处理, 已知属于外层表时可能是关联列, 返回错误, 都不确定时按子查询中的列处理.
+func checkUncorrelatedSubquery(query ast.ResultSetNode, outerColumns map[string]bool, db string, r *router.Router) error {
+	v := &subqueryTableVisitor{tables: make(map[string]bool), aliases: make(map[string]bool)}
+	query.Accept(v)
+	for _, c := range v.columns {
+		if !v.tables[c.Table.L] {
+			return fmt.Errorf("correlated subquery is not supported in sharding, column: %s.%s", c.Table.O, c.Name.O)
+		}
+	}
+
+	innerColumns := getRuleColumns(v.tableNames, db, r)
+	for _, c := range v.columns {
+		innerColumns[c.Name.L] = true
+	}
+	for a := range v.aliases {
+		innerColumns[a] = true
+	}
+	for _, c := range v.unqualified {
+		if !innerColumns[c.Name.L] && outerColumns[c.Name.L] {
+			return fmt.Errorf("column %s in subquery may be a correlated column of the outer table, qualify it with the table name of the subquery", c.Name.O)
+		}
+	}
+	return nil
+}
+
+// ExecuteIn implement Plan
+func (p *SubqueryPlan) ExecuteIn(reqCtx *util.RequestContext, sess Executor) (*mysql.Result, error) {
+	// 每次执行重新解析, 避免修改缓存的计划中的AST
+	stmt, err := parseSQL(p.sql)
+	if err != nil {
+		return nil, fmt.Errorf("parse sql in SubqueryPlan error: %v", err)
+	}
+
+	maxRows := DefaultMaxSubqueryRows
+	if l, ok := sess.(SubqueryRowsLimiter); ok && l.GetMaxSubqueryRows() > 0 {
+		maxRows = l.GetMaxSubqueryRows()
+	}
+
+	results := make(map[ast.ExprNode]*subqueryResult)
+	for _, s := range getWhereSubqueries(stmt) {
+		if results[s], err = p.executeSubquery(reqCtx, sess, s, maxRows); err != nil {
+			return nil, err
+		}
+	}
+
+	rewriter := &subqueryRewriteVisitor{results: results}
+	switch s := stmt.(type) {
+	case *ast.SelectStmt:
+		s.Where = rewriter.rewrite(s.Where)
+	case *ast.UpdateStmt:
+		s.Where = rewriter.rewrite(s.Where)
+	case *ast.DeleteStmt:
+		s.Where = rewriter.rewrite(s.Where)
+	}
+	if rewriter.err != nil {
+		return nil, rewriter.err
+	}
+
+	sql, err := restoreNode(stmt)
+	if err != nil {
+		return nil, fmt.Errorf("restore sql in SubqueryPlan error: %v", err)
+	}
+	plan, err := BuildPlan(stmt, p.phyDBs, p.db, sql, p.router, p.seq)
+	if err != nil {
+		return nil, fmt.Errorf("build plan in SubqueryPlan error: %v", err)
+	}
+	return plan.ExecuteIn(reqCtx, sess)
+}
+
+// executeSubquery 执行子查询, EXISTS子查询只需要查询一行, 其他子查询最多查询上限加一行, 超过上限时返回错误
+func (p *SubqueryPlan) executeSubquery(reqCtx *util.RequestContext, sess Executor, s ast.ExprNode, maxRows int) (*subqueryResult, error) {
+	query := getSubqueryQuery(s)
+	if _, ok := s.(*ast.ExistsSubqueryExpr); ok {
+		if sel, ok := query.(*ast.SelectStmt); ok && sel.Limit == nil {
+			sel.Limit = &ast.Limit{Count: ast.NewValueExpr(1, "", "")}
+		}
+	} else {
+		limitQueryRows(query, maxRows+1)
+	}
+
+	sql, err := restoreNode(query)
+	if err != nil {
+		return nil, fmt.Errorf("restore subquery error: %v", err)
+	}
+	stmt, err := parseSQL(sql)
+	if err != nil {
+		return nil, fmt.Errorf("parse subquery error: %v", err)
+	}
+	plan, err := BuildPlan(stmt, p.phyDBs, p.db, sql, p.router, p.seq)
+	if err != nil {
+		return nil, fmt.Errorf("build plan of subquery error: %v", err)
+	}
+	r, err := plan.ExecuteIn(reqCtx, sess)
+	if err != nil {
+		return nil, fmt.Errorf("execute subquery error: %v", err)
+	}
+	if r == nil || r.Resultset == nil {
+		return nil, fmt.Errorf("subquery has no result set: %s", sql)
+	}
+	if len(r.Values) > maxRows {
+		return nil, fmt.Errorf("rows of subquery exceed the limit %d", maxRows)
+	}
+	return &subqueryResult{rows: r.Values}, nil
+}
+
+// limitQueryRows 把查询的LIMIT行数限制为不超过count, 没有LIMIT时加上LIMIT count
+func limitQueryRows(query ast.ResultSetNode, count int) {
+	var limit **ast.Limit
+	switch q := query.(type) {
+	case *ast.SelectStmt:
+		limit = &q.Limit
+	case *ast.UnionStmt:
+		limit = &q.Limit
+	default:
+		return
+	}
+	if *limit == nil {
+		*limit = &ast.Limit{Count: ast.NewValueExpr(count, "", "")}
+		return
+	}
+	if v, ok := (*limit).Count.(ast.ValueExpr); ok {
+		if n, ok := v.GetValue().(uint64); ok && n > uint64(count) {
+			(*limit).Count = ast.NewValueExpr(count, "", "")
+		}
+	}
+}
+
+// subqueryRewriteVisitor 把子查询替换为子查询的结果
+type subqueryRewriteVisitor struct {
+	results map[ast.ExprNode]*subqueryResult
+	err     error
+}
+
+func (v *subqueryRewriteVisitor) rewrite(where ast.ExprNode) ast.ExprNode {
+	n, _ := where.Accept(v)
+	return n.(ast.ExprNode)
+}
+
+// Enter implement ast.Visitor
+func (v *subqueryRewriteVisitor) Enter(n ast.Node) (ast.Node, bool) {
+	switch x := n.(type) {
+	case *ast.PatternInExpr:
+		if x.Sel != nil {
+			return n, true
+		}
+	case *ast.SubqueryExpr, *ast.ExistsSubqueryExpr:
+		return n, true
+	}
+	return n, false
+}
+
+// Leave implement ast.Visitor
+func (v *subqueryRewriteVisitor) Leave(n ast.Node) (ast.Node, bool) {
+	if v.err != nil {
+		return n, false
+	}
+
+	switch x := n.(type) {
+	case *ast.PatternInExpr:
+		if x.Sel == nil {
+			return n, true
+		}
+		r := v.results[x.Sel]
+		// IN空列表: IN为false, NOT IN为true
+		if len(r.rows) == 0 {
+			return ast.NewValueExpr(boolToInt(x.Not), "", ""), true
+		}
+		list := make([]ast.ExprNode, 0, len(r.rows))
+		for _, row := range r.rows {
+			list = append(list, newSubqueryRowExpr(row))
+		}
+		return &ast.PatternInExpr{Expr: x.Expr, List: list, Not: x.Not}, true
+	case *ast.ExistsSubqueryExpr:
+		exists := len(v.results[x].rows) != 0
+		if x.Not {
+			exists = !exists
+		}
+		return ast.NewValueExpr(boolToInt(exists), "", ""), true
+	case *ast.SubqueryExpr:
+		r := v.results[x]
+		if len(r.rows) > 1 {
+			v.err = fmt.Errorf("subquery returns more than 1 row")
+			return n, false
+		}
+		// 标量子查询没有结果时为NULL
+		if len(r.rows) == 0 {
+			return ast.NewValueExpr(nil, "", ""), true
+		}
+		return newSubqueryRowExpr(r.rows[0]), true
+	}
+	return n, true
+}
+
+// newSubqueryRowExpr 一列时返回常量, 多列时返回行表达式
+func newSubqueryRowExpr(row []interface{}) ast.ExprNode {
+	if len(row) == 1 {
+		return ast.NewValueExpr(row[0], "", "")
+	}
+	values := make([]ast.ExprNode, 0, len(row))
+	for _, v := range row {
+		values = append(values, ast.NewValueExpr(v, "", ""))
+	}
+	return &ast.RowExpr{Values: values}
+}
+
+func boolToInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,186 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plan
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/XiaoMi/Gaea/parser"
+	"github.com/XiaoMi/Gaea/util"
+)
+
+// subqueryTestExecutor 在joinTestExecutor的基础上返回子查询结果的行数上限
+type subqueryTestExecutor struct {
+	joinTestExecutor
+	maxSubqueryRows int
+}
+
+func (e *subqueryTestExecutor) GetMaxSubqueryRows() int {
+	return e.maxSubqueryRows
+}
+
+func TestSubqueryPlanBuildError(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	tests := []SQLTestcase{
+		{
+			db:     "db_ks",
+			sql:    "select * from tbl_ks_order o where exists (select id from tbl_ks t where t.id = o.order_id)",
+			hasErr: true,
+		},
+		{
+			db:     "db_ks",
+			sql:    "delete from tbl_ks_order where user_id in (select t.id from tbl_ks t where t.name = tbl_ks_order.name)",
+			hasErr: true,
+		},
+		{
+			// 不带表名的列是外层表的分片列, 可能是关联列
+			db:     "db_ks",
+			sql:    "select * from tbl_ks_order where user_id = 1 and exists (select id from tbl_ks where name = order_id)",
+			hasErr: true,
+		},
+		{
+			// 不带表名的列出现在外层语句中, 可能是关联列
+			db:     "db_ks",
+			sql:    "update tbl_ks_order set remark = 'a' where order_id in (select id from tbl_ks where name = remark)",
+			hasErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.sql, getTestFunc(ns, test))
+	}
+}
+
+func TestSubqueryPlanExecute(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	tables := map[string]*testTable{
+		"tbl_ks_0001": {
+			columns: []string{"id", "name"},
+			rows:    [][]interface{}{{int64(1), "a"}, {int64(6), "a"}},
+		},
+		"tbl_ks_order_0002": {
+			columns: []string{"order_id", "user_id"},
+			rows:    [][]interface{}{{int64(6), int64(10)}},
+		},
+	}
+	subquerySQLs := func(field, suffix string) []string {
+		return []string{
+			"slice-0:SELECT " + field + " FROM `tbl_ks_0000` WHERE `name`='a'" + suffix,
+			"slice-0:SELECT " + field + " FROM `tbl_ks_0001` WHERE `name`='a'" + suffix,
+			"slice-1:SELECT " + field + " FROM `tbl_ks_0002` WHERE `name`='a'" + suffix,
+			"slice-1:SELECT " + field + " FROM `tbl_ks_0003` WHERE `name`='a'" + suffix,
+		}
+	}
+
+	tests := []struct {
+		sql      string
+		executed []string
+		maxRows  int
+		values   [][]interface{}
+		hasErr   bool
+	}{
+		{
+			sql: "select order_id, user_id from tbl_ks_order where order_id in (select id from tbl_ks where name = 'a')",
+			executed: append(subquerySQLs("`id`", " LIMIT 10001"),
+				"slice-0:SELECT `order_id`,`user_id` FROM `tbl_ks_order_0001` WHERE `order_id` IN (1)",
+				"slice-1:SELECT `order_id`,`user_id` FROM `tbl_ks_order_0002` WHERE `order_id` IN (6)",
+			),
+			values: [][]interface{}{{int64(6), int64(10)}},
+		},
+		{
+			// IN空列表
+			sql: "select order_id from tbl_ks_order where user_id = 10 and order_id not in (select id from tbl_ks where id = 2)",
+			executed: []string{
+				"slice-1:SELECT `id` FROM `tbl_ks_0002` WHERE `id`=2 LIMIT 10001",
+				"slice-0:SELECT `order_id` FROM `tbl_ks_order_0000` WHERE `user_id`=10 AND 1",
+				"slice-0:SELECT `order_id` FROM `tbl_ks_order_0001` WHERE `user_id`=10 AND 1",
+				"slice-1:SELECT `order_id` FROM `tbl_ks_order_0002` WHERE `user_id`=10 AND 1",
+				"slice-1:SELECT `order_id` FROM `tbl_ks_order_0003` WHERE `user_id`=10 AND 1",
+			},
+			values: [][]interface{}{{int64(6)}},
+		},
+		{
+			sql: "select order_id from tbl_ks_order where order_id = 6 and exists (select name from tbl_ks where name = 'a')",
+			executed: append(subquerySQLs("`name`", " LIMIT 1"),
+				"slice-1:SELECT `order_id` FROM `tbl_ks_order_0002` WHERE `order_id`=6 AND 1",
+			),
+			values: [][]interface{}{{int64(6)}},
+		},
+		{
+			sql:    "select order_id from tbl_ks_order where order_id = (select id from tbl_ks where name = 'a')", // more than 1 row
+			hasErr: true,
+		},
+		{
+			sql: "update tbl_ks_order set user_id = 1 where order_id in (select id from tbl_ks where name = 'a')",
+			executed: append(subquerySQLs("`id`", " LIMIT 10001"),
+				"slice-0:UPDATE `tbl_ks_order_0001` SET `user_id`=1 WHERE `order_id` IN (1)",
+				"slice-1:UPDATE `tbl_ks_order_0002` SET `user_id`=1 WHERE `order_id` IN (6)",
+			),
+		},
+		{
+			// 子查询的LIMIT不超过上限加一行, 结果超过上限时报错
+			sql:      "select order_id from tbl_ks_order where order_id in (select id from tbl_ks where name = 'a' limit 100)",
+			maxRows:  1,
+			executed: subquerySQLs("`id`", " LIMIT 2"),
+			hasErr:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.sql, func(t *testing.T) {
+			stmt, err := parser.ParseSQL(test.sql)
+			if err != nil {
+				t.Fatalf("parse sql error: %v", err)
+			}
+			p, err := BuildPlan(stmt, ns.phyDBs, "db_ks", test.sql, ns.rt, ns.seqs)
+			if err != nil {
+				t.Fatalf("BuildPlan error: %v", err)
+			}
+			if _, ok := p.(*SubqueryPlan); !ok {
+				t.Fatalf("plan is not SubqueryPlan: %T", p)
+			}
+
+			e := &subqueryTestExecutor{joinTestExecutor: joinTestExecutor{testExecutor: testExecutor{tables: tables}}, maxSubqueryRows: test.maxRows}
+			r, err := p.ExecuteIn(util.NewRequestContext(), e)
+			if test.hasErr {
+				if err == nil {
+					t.Fatalf("expect error")
+				}
+				t.Logf("got expect error: %v", err)
+				if test.executed != nil && !reflect.DeepEqual(e.executed, test.executed) {
+					t.Errorf("executed sql not equal, expect: %v, actual: %v", test.executed, e.executed)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("execute error: %v", err)
+			}
+			if !reflect.DeepEqual(e.executed, test.executed) {
+				t.Errorf("executed sql not equal, expect: %v, actual: %v", test.executed, e.executed)
+			}
+			if test.values != nil && !reflect.DeepEqual(r.Values, test.values) {
+				t.Errorf("values not equal, expect: %v, actual: %v", test.values, r.Values)
+			}
+		})
+	}
+}
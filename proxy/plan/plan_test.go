@@ -16,12 +16,15 @@ package plan
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/XiaoMi/Gaea/parser"
+	"strconv"
 	"sync/atomic"
 	"testing"
 
 	"github.com/XiaoMi/Gaea/backend"
 	"github.com/XiaoMi/Gaea/models"
+	"github.com/XiaoMi/Gaea/provider"
 	"github.com/XiaoMi/Gaea/proxy/router"
 	"github.com/XiaoMi/Gaea/proxy/sequence"
 )
@@ -63,6 +66,71 @@ func (s *OrderSequence) NextSeq() (int64, error) {
 	return newInt, nil
 }
 
+// testDivAlgorithm 自定义分片算法, 子表下标为 id / size, 超出范围的值分别路由到第一个和最后一个子表
+type testDivAlgorithm struct{}
+
+type testDivSharding struct {
+	size       int64
+	tableCount int
+}
+
+func init() {
+	if err := provider.RegisterShardingAlgorithm(&testDivAlgorithm{}); err != nil {
+		panic(err)
+	}
+}
+
+func (a *testDivAlgorithm) GetName() string {
+	return "test_div"
+}
+
+func (a *testDivAlgorithm) Verify(props map[string]string, tableCount int) error {
+	_, err := a.Create(props, tableCount)
+	return err
+}
+
+func (a *testDivAlgorithm) Create(props map[string]string, tableCount int) (provider.Sharding, error) {
+	size, err := strconv.ParseInt(props["size"], 10, 64)
+	if err != nil || size <= 0 {
+		return nil, fmt.Errorf("invalid size %s", props["size"])
+	}
+	return &testDivSharding{size: size, tableCount: tableCount}, nil
+}
+
+func (s *testDivSharding) FindForKey(key interface{}) (int, error) {
+	return s.findIndex(router.NumValue(key)), nil
+}
+
+func (s *testDivSharding) FindForRange(r provider.KeyRange) ([]int, error) {
+	start, end := 0, s.tableCount-1
+	if r.Lower != nil {
+		v := router.NumValue(r.Lower)
+		if !r.LowerInclusive {
+			v++
+		}
+		start = s.findIndex(v)
+	}
+	if r.Upper != nil {
+		v := router.NumValue(r.Upper)
+		if !r.UpperInclusive {
+			v--
+		}
+		end = s.findIndex(v)
+	}
+	return makeList(start, end+1), nil
+}
+
+func (s *testDivSharding) findIndex(v int64) int {
+	index := v / s.size
+	if index < 0 {
+		return 0
+	}
+	if index >= int64(s.tableCount) {
+		return s.tableCount - 1
+	}
+	return int(index)
+}
+
 // 获取使用TiDB parser测试SQL改写结果的测试函数
 func getTestFunc(info *PlanInfo, test SQLTestcase) func(t *testing.T) {
 	return func(t *testing.T) {
@@ -88,6 +156,8 @@ func getTestFunc(info *PlanInfo, test SQLTestcase) func(t *testing.T) {
 		switch plan := p.(type) {
 		case *SelectPlan:
 			actualSQLs = plan.GetSQLs()
+		case *JoinPlan:
+			actualSQLs = plan.GetSQLs()
 		case *InsertPlan:
 			actualSQLs = plan.sqls
 		case *UpdatePlan:
@@ -310,6 +380,197 @@ func preparePlanInfo() (*PlanInfo, error) {
 				"20140907-20140908"
 			]
         },
+        {
+            "db": "db_ks",
+            "table": "tbl_ks_inline",
+            "type": "inline",
+            "key": "user_id",
+            "algorithm_expression": "tbl_ks_inline_${user_id % 4}",
+            "locations": [
+                2,
+                2
+            ],
+            "slices": [
+                "slice-0",
+                "slice-1"
+            ]
+        },
+        {
+            "db": "db_ks",
+            "table": "tbl_ks_standard",
+            "type": "standard",
+            "database_strategy": {
+                "type": "mod",
+                "key": "tenant_id"
+            },
+            "table_strategy": {
+                "type": "inline",
+                "key": "order_id",
+                "algorithm_expression": "tbl_ks_standard_${order_id % 2}"
+            },
+            "locations": [
+                2,
+                2
+            ],
+            "slices": [
+                "slice-0",
+                "slice-1"
+            ]
+        },
+        {
+            "db": "db_ks",
+            "table": "tbl_ks_complex",
+            "type": "complex",
+            "keys": ["region_id", "user_id"],
+            "algorithm_expression": "tbl_ks_complex_${(region_id * 2 + user_id) % 4}",
+            "locations": [
+                2,
+                2
+            ],
+            "slices": [
+                "slice-0",
+                "slice-1"
+            ]
+        },
+        {
+            "db": "db_ks",
+            "table": "tbl_ks_complex_item",
+            "type": "complex",
+            "keys": ["area_id", "uid"],
+            "algorithm_expression": "tbl_ks_complex_item_${(area_id * 2 + uid) % 4}",
+            "locations": [
+                2,
+                2
+            ],
+            "slices": [
+                "slice-0",
+                "slice-1"
+            ]
+        },
+        {
+            "db": "db_ks",
+            "table": "tbl_ks_nodes",
+            "type": "mod",
+            "key": "id",
+            "actual_data_nodes": "slice-0.tbl_ks_nodes_${['a', 'b']}, slice-1.tbl_ks_nodes_c"
+        },
+        {
+            "db": "db_ks",
+            "table": "tbl_ks_interval",
+            "type": "interval",
+            "key": "create_time",
+            "slices": ["slice-0", "slice-1"],
+            "datetime_lower": "2019-01-01 00:00:00",
+            "datetime_upper": "2019-06-30 23:59:59",
+            "datetime_interval": "month",
+            "suffix_pattern": "yyyyMM"
+        },
+        {
+            "db": "db_ks",
+            "table": "tbl_ks_volume",
+            "type": "volume_range",
+            "key": "id",
+            "locations": [3, 2],
+            "slices": ["slice-0", "slice-1"],
+            "range_lower": "0",
+            "range_upper": "300",
+            "sharding_volume": "100"
+        },
+        {
+            "db": "db_ks",
+            "table": "tbl_ks_boundary",
+            "type": "boundary_range",
+            "key": "name",
+            "locations": [2, 1],
+            "slices": ["slice-0", "slice-1"],
+            "range_type": "string",
+            "sharding_ranges": ["h", "p"]
+        },
+        {
+            "db": "db_ks",
+            "table": "tbl_ks_hash_mod",
+            "type": "hash_mod",
+            "key": "name",
+            "locations": [2, 2],
+            "slices": ["slice-0", "slice-1"],
+            "hash_function": "java_hash_code"
+        },
+        {
+            "db": "db_ks",
+            "table": "tbl_ks_custom",
+            "type": "custom:test_div",
+            "key": "id",
+            "locations": [2, 2],
+            "slices": ["slice-0", "slice-1"],
+            "properties": {
+                "size": "100"
+            }
+        },
+        {
+            "db": "db_ks",
+            "table": "tbl_ks_lookup",
+            "type": "mod",
+            "key": "user_id",
+            "locations": [2, 2],
+            "slices": ["slice-0", "slice-1"],
+            "lookups": [
+                {
+                    "column": "order_no",
+                    "table": "tbl_ks_lookup_order_no",
+                    "slice": "slice-1"
+                }
+            ]
+        },
+        {
+            "db": "db_ks",
+            "table": "tbl_ks_move",
+            "type": "mod",
+            "key": "user_id",
+            "locations": [2, 2],
+            "slices": ["slice-0", "slice-1"],
+            "lookups": [
+                {
+                    "column": "order_no",
+                    "table": "tbl_ks_move_order_no",
+                    "slice": "slice-1"
+                }
+            ],
+            "allow_shard_column_update": true
+        },
+        {
+            "db": "db_ks",
+            "table": "tbl_ks_gene",
+            "type": "gene",
+            "key": "user_id",
+            "gene_columns": ["order_id"],
+            "gene_bits": 4,
+            "locations": [2, 2],
+            "slices": ["slice-0", "slice-1"]
+        },
+        {
+            "db": "db_ks",
+            "table": "tbl_ks_order",
+            "type": "mod",
+            "key": "order_id",
+            "locations": [2, 2],
+            "slices": ["slice-0", "slice-1"]
+        },
+        {
+            "db": "db_ks",
+            "table": "tbl_ks_order_item",
+            "type": "mod",
+            "key": "order_id",
+            "locations": [2, 2],
+            "slices": ["slice-0", "slice-1"]
+        },
+        {
+            "db": "db_ks",
+            "table": "tbl_ks_order_payment",
+            "type": "mod",
+            "key": "pay_order_id",
+            "locations": [2, 2],
+            "slices": ["slice-0", "slice-1"]
+        },
         {
             "db": "db_ks",
             "table": "TBL_KS_UPPERCASE",
@@ -465,6 +726,22 @@ func preparePlanInfo() (*PlanInfo, error) {
 			"table": "tbl_ks",
 			"type": "test",
 			"pk_name": "user_id"
+		},
+		{
+			"db": "db_ks",
+			"table": "tbl_ks_gene",
+			"type": "test",
+			"pk_name": "order_id"
+		}
+	],
+	"binding_tables": [
+		{
+			"db": "db_ks",
+			"tables": ["tbl_ks_order", "tbl_ks_order_item", "tbl_ks_order_payment"]
+		},
+		{
+			"db": "db_ks",
+			"tables": ["tbl_ks_complex", "tbl_ks_complex_item"]
 		}
 	],
     "users": [
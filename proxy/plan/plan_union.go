@@ -0,0 +1,228 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plan
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/proxy/router"
+	"github.com/XiaoMi/Gaea/proxy/sequence"
+	"github.com/XiaoMi/Gaea/util"
+	"github.com/pingcap/parser/ast"
+	driver "github.com/pingcap/tidb/types/parser_driver"
+)
+
+// TransactionChecker 由Executor实现, 事务中每个slice只有一个后端连接, 不能并发使用, 此时UNION的各分支串行执行
+type TransactionChecker interface {
+	IsInTransaction() bool
+}
+
+// UnionPlan 包含分片表的UNION语句.
+// 每个分支单独构建计划 (分片表的分支为SelectPlan, 使用MergeSelectResult合并各分片的结果), 并发执行,
+// 然后在proxy中合并各分支的结果: UNION ALL直接拼接, UNION去重, 最后处理外层的ORDER BY和LIMIT.
+type UnionPlan struct {
+	basePlan
+
+	branches []Plan
+	distinct []bool // 与branches一一对应, 分支前是否为UNION DISTINCT, 第一个分支为false
+
+	orderByItems []*ast.ByItem
+	offset       int64
+	count        int64 // 未设置LIMIT则为-1
+}
+
+func buildUnionPlan(stmt *ast.UnionStmt, phyDBs map[string]string, db string, r *router.Router, seq *sequence.SequenceManager) (*UnionPlan, error) {
+	p := &UnionPlan{
+		offset: -1,
+		count:  -1,
+	}
+	for i, sel := range stmt.SelectList.Selects {
+		sql, err := restoreNode(sel)
+		if err != nil {
+			return nil, fmt.Errorf("restore union branch error: %v", err)
+		}
+		branch, err := BuildPlan(sel, phyDBs, db, sql, r, seq)
+		if err != nil {
+			return nil, fmt.Errorf("build plan of union branch %d error: %v", i, err)
+		}
+		p.branches = append(p.branches, branch)
+		p.distinct = append(p.distinct, i != 0 && sel.IsAfterUnionDistinct)
+	}
+
+	if stmt.OrderBy != nil {
+		for _, item := range stmt.OrderBy.Items {
+			switch item.Expr.(type) {
+			case *ast.ColumnNameExpr, *ast.PositionExpr:
+			default:
+				return nil, fmt.Errorf("only column name or position is supported in ORDER BY of UNION")
+			}
+		}
+		p.orderByItems = stmt.OrderBy.Items
+	}
+
+	if stmt.Limit != nil {
+		p.count = stmt.Limit.Count.(*driver.ValueExpr).GetInt64()
+		p.offset = 0
+		if stmt.Limit.Offset != nil {
+			p.offset = stmt.Limit.Offset.(*driver.ValueExpr).GetInt64()
+		}
+	}
+	return p, nil
+}
+
+// ExecuteIn implement Plan
+func (p *UnionPlan) ExecuteIn(reqCtx *util.RequestContext, sess Executor) (*mysql.Result, error) {
+	rs, err := p.executeBranches(reqCtx, sess)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &mysql.Result{
+		Resultset: &mysql.Resultset{
+			Fields:     rs[0].Fields,
+			FieldNames: make(map[string]int),
+		},
+	}
+	for i, f := range ret.Fields {
+		ret.FieldNames[string(f.Name)] = i
+	}
+
+	for i, r := range rs {
+		if len(r.Fields) != len(ret.Fields) {
+			return nil, fmt.Errorf("the used SELECT statements have a different number of columns")
+		}
+		ret.Values = append(ret.Values, r.Values...)
+		// UNION DISTINCT去掉前面所有分支的重复行, 包括前面用UNION ALL连接的分支
+		if p.distinct[i] {
+			if err := removeUnionDuplicateRows(ret); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := p.sortAndLimit(ret); err != nil {
+		return nil, err
+	}
+	if err := GenerateSelectResultRowData(ret); err != nil {
+		return nil, fmt.Errorf("generate RowData error: %v", err)
+	}
+	return ret, nil
+}
+
+// executeBranches 执行各分支, 不在事务中时并发执行
+func (p *UnionPlan) executeBranches(reqCtx *util.RequestContext, sess Executor) ([]*mysql.Result, error) {
+	rs := make([]*mysql.Result, len(p.branches))
+	errs := make([]error, len(p.branches))
+
+	if c, ok := sess.(TransactionChecker); ok && c.IsInTransaction() {
+		for i, branch := range p.branches {
+			rs[i], errs[i] = branch.ExecuteIn(reqCtx, sess)
+		}
+	} else {
+		var wg sync.WaitGroup
+		for i, branch := range p.branches {
+			wg.Add(1)
+			go func(i int, branch Plan) {
+				defer wg.Done()
+				rs[i], errs[i] = branch.ExecuteIn(reqCtx, sess)
+			}(i, branch)
+		}
+		wg.Wait()
+	}
+
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("execute union branch %d error: %v", i, err)
+		}
+		if rs[i] == nil || rs[i].Resultset == nil {
+			return nil, fmt.Errorf("union branch %d has no result set", i)
+		}
+	}
+	return rs, nil
+}
+
+func removeUnionDuplicateRows(ret *mysql.Result) error {
+	seen := make(map[string]bool, len(ret.Values))
+	values := ret.Values[:0]
+	for _, row := range ret.Values {
+		key, err := generateMapKey(row)
+		if err != nil {
+			return err
+		}
+		if !seen[key] {
+			seen[key] = true
+			values = append(values, row)
+		}
+	}
+	ret.Values = values
+	return nil
+}
+
+// sortAndLimit 处理外层的ORDER BY和LIMIT, ORDER BY的列名按第一个分支的列名匹配
+func (p *UnionPlan) sortAndLimit(ret *mysql.Result) error {
+	if len(p.orderByItems) != 0 {
+		var sortKeys []mysql.SortKey
+		for _, item := range p.orderByItems {
+			column, err := getUnionOrderByColumn(ret.Fields, item)
+			if err != nil {
+				return err
+			}
+			sortKey := mysql.SortKey{Column: column, Direction: mysql.SortAsc}
+			if item.Desc {
+				sortKey.Direction = mysql.SortDesc
+			}
+			sortKeys = append(sortKeys, sortKey)
+		}
+		if err := ret.SortWithoutColumnName(sortKeys); err != nil {
+			return err
+		}
+	}
+
+	if p.count == -1 {
+		return nil
+	}
+	rowLen := int64(len(ret.Values))
+	if p.offset >= rowLen {
+		ret.Values = ret.Values[:0]
+		return nil
+	}
+	end := p.offset + p.count
+	if end > rowLen {
+		end = rowLen
+	}
+	ret.Values = ret.Values[p.offset:end]
+	return nil
+}
+
+func getUnionOrderByColumn(fields []*mysql.Field, item *ast.ByItem) (int, error) {
+	switch x := item.Expr.(type) {
+	case *ast.PositionExpr:
+		if x.N < 1 || x.N > len(fields) {
+			return 0, fmt.Errorf("unknown column %d in ORDER BY of UNION", x.N)
+		}
+		return x.N - 1, nil
+	case *ast.ColumnNameExpr:
+		for i, f := range fields {
+			if strings.EqualFold(string(f.Name), x.Name.Name.O) {
+				return i, nil
+			}
+		}
+		return 0, fmt.Errorf("unknown column %s in ORDER BY of UNION", x.Name.Name.O)
+	}
+	return 0, fmt.Errorf("only column name or position is supported in ORDER BY of UNION")
+}
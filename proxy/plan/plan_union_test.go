@@ -0,0 +1,143 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plan
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/XiaoMi/Gaea/parser"
+	"github.com/XiaoMi/Gaea/util"
+)
+
+func TestUnionPlan(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	tables := map[string]*testTable{
+		"tbl_ks_0001": {
+			columns: []string{"id", "name"},
+			rows:    [][]interface{}{{int64(1), "a"}, {int64(5), "b"}},
+		},
+		"tbl_ks_order_0001": {
+			columns: []string{"order_id", "user_id"},
+			rows:    [][]interface{}{{int64(1), int64(10)}, {int64(9), int64(10)}},
+		},
+	}
+
+	tests := []struct {
+		sql      string
+		executed []string
+		fields   []string
+		values   [][]interface{}
+		hasErr   bool
+	}{
+		{
+			sql: "select id from tbl_ks where id in (1, 5) union all select order_id from tbl_ks_order where order_id in (1, 9) order by id desc limit 2",
+			executed: []string{
+				"slice-0:SELECT `id` FROM `tbl_ks_0001` WHERE `id` IN (1,5)",
+				"slice-0:SELECT `order_id` FROM `tbl_ks_order_0001` WHERE `order_id` IN (1,9)",
+			},
+			fields: []string{"id"},
+			values: [][]interface{}{{int64(9)}, {int64(5)}},
+		},
+		{
+			sql:    "select id from tbl_ks where id in (1, 5) union select order_id from tbl_ks_order where order_id in (1, 9)",
+			fields: []string{"id"},
+			values: [][]interface{}{{int64(1)}, {int64(5)}, {int64(9)}},
+		},
+		{
+			// UNION DISTINCT去掉前面用UNION ALL连接的分支中的重复行, 后面的UNION ALL保留重复行
+			sql:    "select id from tbl_ks where id in (1, 5) union all select id from tbl_ks where id in (1, 5) union select order_id from tbl_ks_order where order_id in (1, 9) union all select order_id from tbl_ks_order where order_id in (1, 9) order by 1",
+			fields: []string{"id"},
+			values: [][]interface{}{{int64(1)}, {int64(1)}, {int64(5)}, {int64(9)}, {int64(9)}},
+		},
+		{
+			// 外层ORDER BY按第一个分支的列名匹配
+			sql:    "select id from tbl_ks where id in (1, 5) union select user_id as uid from tbl_ks_order where order_id in (1, 9) order by id desc limit 1, 2",
+			fields: []string{"id"},
+			values: [][]interface{}{{int64(5)}, {int64(1)}},
+		},
+		{
+			sql:    "select id, name from tbl_ks where id in (1, 5) union select order_id from tbl_ks_order where order_id in (1, 9)",
+			hasErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.sql, func(t *testing.T) {
+			stmt, err := parser.ParseSQL(test.sql)
+			if err != nil {
+				t.Fatalf("parse sql error: %v", err)
+			}
+			p, err := BuildPlan(stmt, ns.phyDBs, "db_ks", test.sql, ns.rt, ns.seqs)
+			if err != nil {
+				t.Fatalf("BuildPlan error: %v", err)
+			}
+			if _, ok := p.(*UnionPlan); !ok {
+				t.Fatalf("plan is not UnionPlan: %T", p)
+			}
+
+			e := &joinTestExecutor{testExecutor: testExecutor{tables: tables}}
+			r, err := p.ExecuteIn(util.NewRequestContext(), e)
+			if test.hasErr {
+				if err == nil {
+					t.Fatalf("expect error")
+				}
+				t.Logf("got expect error: %v", err)
+				return
+			}
+			if err != nil {
+				t.Fatalf("execute error: %v", err)
+			}
+
+			sort.Strings(e.executed)
+			if test.executed != nil && !reflect.DeepEqual(e.executed, test.executed) {
+				t.Errorf("executed sql not equal, expect: %v, actual: %v", test.executed, e.executed)
+			}
+			var fields []string
+			for _, f := range r.Fields {
+				fields = append(fields, string(f.Name))
+			}
+			if !reflect.DeepEqual(fields, test.fields) {
+				t.Errorf("fields not equal, expect: %v, actual: %v", test.fields, fields)
+			}
+			if !reflect.DeepEqual(r.Values, test.values) {
+				t.Errorf("values not equal, expect: %v, actual: %v", test.values, r.Values)
+			}
+		})
+	}
+}
+
+func TestUnionPlanBuildError(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	tests := []SQLTestcase{
+		{
+			db:     "db_ks",
+			sql:    "select id from tbl_ks union select order_id from tbl_ks_order order by id + 1",
+			hasErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.sql, getTestFunc(ns, test))
+	}
+}
@@ -28,8 +28,11 @@ type UpdatePlan struct {
 	basePlan
 	*TableAliasStmtInfo
 
-	stmt *ast.UpdateStmt
-	sqls map[string]map[string][]string
+	stmt   *ast.UpdateStmt
+	sqls   map[string]map[string][]string
+	lookup *lookupPlan    // 通过映射表计算路由或者需要维护映射表时不为nil
+	move   *shardMove     // 修改分片列时不为nil
+	tables []*modifyTable // 多表UPDATE引用的表, 单表时为nil
 }
 
 // NewUpdatePlan constructor of UpdatePlan
@@ -47,6 +50,18 @@ func (s *UpdatePlan) ExecuteIn(reqCtx *util.RequestContext, sess Executor) (*mys
 		return nil, fmt.Errorf("SQL has not generated")
 	}
 
+	if s.move != nil {
+		return s.executeMove(reqCtx, sess)
+	}
+
+	if s.lookup != nil {
+		r, err := s.lookup.execute(reqCtx, sess)
+		if err != nil {
+			return nil, fmt.Errorf("execute lookup in UpdatePlan error: %v", err)
+		}
+		return r, nil
+	}
+
 	if len(sqls) == 0 {
 		return nil, nil
 	}
@@ -65,6 +80,26 @@ func (s *UpdatePlan) ExecuteIn(reqCtx *util.RequestContext, sess Executor) (*mys
 	return r, nil
 }
 
+// executeMove 修改分片列时把被修改的行移动到新的分片
+func (s *UpdatePlan) executeMove(reqCtx *util.RequestContext, sess Executor) (*mysql.Result, error) {
+	indexes := s.move.indexes
+	if s.lookup != nil {
+		var err error
+		if indexes, err = s.lookup.getTableIndexes(reqCtx, sess); err != nil {
+			return nil, fmt.Errorf("execute lookup in UpdatePlan error: %v", err)
+		}
+	}
+	if len(indexes) == 0 {
+		return nil, nil
+	}
+
+	r, err := s.move.execute(reqCtx, sess, indexes)
+	if err != nil {
+		return nil, fmt.Errorf("move rows in UpdatePlan error: %v", err)
+	}
+	return r, nil
+}
+
 // HandleUpdatePlan build a UpdatePlan
 func HandleUpdatePlan(p *UpdatePlan) error {
 	if err := handleUpdateTableRefs(p); err != nil {
@@ -75,6 +110,22 @@ func HandleUpdatePlan(p *UpdatePlan) error {
 		return fmt.Errorf("handle assignment list error: %v", err)
 	}
 
+	// 移动行时映射表按移动的行维护
+	var lookups []*router.Lookup
+	var lookupValues []interface{}
+	var err error
+	if p.move == nil {
+		if lookups, lookupValues, err = getUpdateLookupValues(p); err != nil {
+			return fmt.Errorf("handle assignment list error: %v", err)
+		}
+	}
+
+	// lookup条件需要在改写WHERE条件之前查找
+	route, err := findLookupRoute(p.TableAliasStmtInfo, p.stmt.Where)
+	if err != nil {
+		return fmt.Errorf("handle lookup error: %v", err)
+	}
+
 	if err := handleUpdateWhere(p); err != nil {
 		return fmt.Errorf("handle Where error: %v", err)
 	}
@@ -96,6 +147,20 @@ func HandleUpdatePlan(p *UpdatePlan) error {
 	}
 
 	p.sqls = sqls
+
+	var maintenance *lookupMaintenance
+	if len(lookups) != 0 {
+		rule, _ := getSingleShardRule(p.TableAliasStmtInfo)
+		maintenance = newLookupMaintenance(rule, lookups, lookupValues, p.stmt.TableRefs, p.stmt.Where, p.stmt.Order, p.stmt.Limit)
+	}
+	if p.lookup, err = buildLookupPlan(p.TableAliasStmtInfo, p.stmt, route, maintenance); err != nil {
+		return fmt.Errorf("build lookup plan error: %v", err)
+	}
+	if p.move != nil {
+		if err := p.move.build(p); err != nil {
+			return fmt.Errorf("build shard column update error: %v", err)
+		}
+	}
 	return nil
 }
 
@@ -111,7 +176,11 @@ func handleUpdateTableRefs(p *UpdatePlan) error {
 	}
 
 	if join.Right != nil {
-		return fmt.Errorf("does not support update multiple tables in sharding")
+		tables, err := checkMultiTableModify(p.TableAliasStmtInfo, join, p.stmt.Where)
+		if err != nil {
+			return fmt.Errorf("check multi-table update error: %v", err)
+		}
+		p.tables = tables
 	}
 
 	return handleJoin(p.TableAliasStmtInfo, join)
@@ -123,6 +192,12 @@ func handleUpdateWhere(p *UpdatePlan) error {
 		return nil
 	}
 
+	// 多列分片的路由需要在改写WHERE条件之前计算
+	complexHas, complexResult, err := handleComplexShardingCondition(p.TableAliasStmtInfo, stmt.Where)
+	if err != nil {
+		return fmt.Errorf("handle complex sharding condition error: %v", err)
+	}
+
 	has, result, decorator, err := handleComparisonExpr(p.TableAliasStmtInfo, stmt.Where)
 	if err != nil {
 		return fmt.Errorf("rewrite Where error: %v", err)
@@ -130,6 +205,9 @@ func handleUpdateWhere(p *UpdatePlan) error {
 	if has {
 		p.GetRouteResult().Inter(result)
 	}
+	if complexHas {
+		p.GetRouteResult().Inter(complexResult)
+	}
 	stmt.Where = decorator
 	return nil
 }
@@ -160,8 +238,12 @@ func handleUpdateOrderBy(p *UpdatePlan) error {
 	return nil
 }
 
-// TODO: Assignment直接引用ColumnName, 不能做表名的装饰器. 采用的解决办法是UPDATE只支持一个表, 然后把DB名和表名去掉.
+// TODO: Assignment直接引用ColumnName, 不能做表名的装饰器. 单表UPDATE把DB名和表名去掉, 多表UPDATE要求通过表别名引用分片表.
 func handleUpdateAssignmentList(p *UpdatePlan) error {
+	if p.tables != nil {
+		return handleMultiTableAssignmentList(p)
+	}
+
 	l := p.stmt.List
 	for _, assignment := range l {
 		r, need, _, err := needCreateColumnNameDecorator(p.TableAliasStmtInfo, assignment.Column)
@@ -169,10 +251,36 @@ func handleUpdateAssignmentList(p *UpdatePlan) error {
 			return err
 		}
 
-		if need && r.GetShardingColumn() == assignment.Column.Name.L {
-			return fmt.Errorf("cannot update shard column value")
+		if need && r.IsShardingColumn(assignment.Column.Name.L) {
+			if p.move, err = newShardMove(r, assignment.Column); err != nil {
+				return err
+			}
 		}
 		removeSchemaAndTableInfoInColumnName(assignment.Column)
 	}
 	return nil
 }
+
+// getUpdateLookupValues 获取SET中lookup列的新值, 修改了lookup列的行需要维护映射表
+func getUpdateLookupValues(p *UpdatePlan) ([]*router.Lookup, []interface{}, error) {
+	rule, ok := getSingleShardRule(p.TableAliasStmtInfo)
+	if !ok {
+		return nil, nil, nil
+	}
+
+	var lookups []*router.Lookup
+	var values []interface{}
+	for _, assignment := range p.stmt.List {
+		lookup, ok := rule.GetLookup(assignment.Column.Name.L)
+		if !ok {
+			continue
+		}
+		v, err := getLookupValue(lookup.Column, assignment.Expr)
+		if err != nil {
+			return nil, nil, err
+		}
+		lookups = append(lookups, lookup)
+		values = append(values, v)
+	}
+	return lookups, values, nil
+}
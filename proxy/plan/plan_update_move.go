@@ -0,0 +1,414 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plan
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/format"
+
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/proxy/router"
+	"github.com/XiaoMi/Gaea/util"
+)
+
+// TransactionExecutor 由Executor实现, 需要在多个分片上原子执行的语句不在事务中时, 由proxy开启和提交事务
+type TransactionExecutor interface {
+	TransactionChecker
+	BeginTransaction() error
+	CommitTransaction() error
+	RollbackTransaction() error
+}
+
+// shardMove 修改分片列的UPDATE语句, 在事务中查询出被修改的完整的行, 从原来的分片删除, 再按分片列的新值插入新的分片.
+// SET中的表达式在查询时由MySQL按修改前的值计算, 映射表中的记录同时更新
+type shardMove struct {
+	rule       router.Rule
+	table      *ast.TableName // 插入新的行的表, 没有改写表名
+	columns    []string       // SET中的列, 与查询结果的最后几列一一对应
+	indexes    []int          // 构建计划时计算的路由
+	selectSQLs map[int]string // key: table index, SELECT *, SET中的表达式 ... FOR UPDATE
+	deleteSQLs map[int]string // key: table index
+}
+
+// movedRow 修改后的行, values和texts分别为解析后的值和文本协议中的原始值
+type movedRow struct {
+	tableIndex int
+	values     []interface{}
+	texts      [][]byte
+}
+
+// build 在改写完UPDATE语句后生成查询和删除被修改的行的SQL
+func (m *shardMove) build(p *UpdatePlan) error {
+	source, ok := p.stmt.TableRefs.TableRefs.Left.(*ast.TableSource)
+	if !ok {
+		return fmt.Errorf("invalid table source type: %T", p.stmt.TableRefs.TableRefs.Left)
+	}
+	decorator, ok := source.Source.(*TableNameDecorator)
+	if !ok {
+		return fmt.Errorf("invalid table name type: %T", source.Source)
+	}
+	m.table = decorator.origin
+
+	// 每个SET表达式都按修改前的值计算, 不支持引用前面已经赋值的列
+	fields := []*ast.SelectField{{WildCard: &ast.WildCardField{}}}
+	for _, assignment := range p.stmt.List {
+		v := &joinColumnVisitor{}
+		assignment.Expr.Accept(v)
+		if v.hasSubquery {
+			return fmt.Errorf("subquery in assignment of shard column update is not supported")
+		}
+		for _, column := range v.columns {
+			if includeString(m.columns, column.Name.L) {
+				return fmt.Errorf("assignment of column %s references the assigned column %s", assignment.Column.Name.O, column.Name.O)
+			}
+		}
+		m.columns = append(m.columns, assignment.Column.Name.L)
+
+		expr := assignment.Expr
+		if _, ok := expr.(*ast.DefaultExpr); ok {
+			expr = &ast.DefaultExpr{Name: &ast.ColumnName{Name: assignment.Column.Name}}
+		}
+		fields = append(fields, &ast.SelectField{Expr: expr})
+	}
+
+	selectStmt := &ast.SelectStmt{
+		SelectStmtOpts: &ast.SelectStmtOpts{SQLCache: true},
+		Fields:         &ast.FieldList{Fields: fields},
+		From:           p.stmt.TableRefs,
+		Where:          p.stmt.Where,
+		OrderBy:        p.stmt.Order,
+		Limit:          p.stmt.Limit,
+		LockTp:         ast.SelectLockForUpdate,
+	}
+	deleteStmt := &ast.DeleteStmt{
+		TableRefs: p.stmt.TableRefs,
+		Where:     p.stmt.Where,
+		Order:     p.stmt.Order,
+		Limit:     p.stmt.Limit,
+	}
+	// 单表DELETE不支持表别名, 使用多表DELETE的语法, 此时不支持ORDER BY和LIMIT
+	if source.AsName.L != "" {
+		if p.stmt.Order != nil || p.stmt.Limit != nil {
+			return fmt.Errorf("ORDER BY and LIMIT with table alias is not supported in shard column update")
+		}
+		deleteStmt.IsMultiTable = true
+		deleteStmt.BeforeFrom = true
+		deleteStmt.Tables = &ast.DeleteTableList{Tables: []*ast.TableName{{Name: source.AsName}}}
+	}
+
+	var err error
+	result := p.GetRouteResult()
+	m.indexes = result.GetShardIndexes()
+	if m.selectSQLs, err = generateTableSQLs(selectStmt, result); err != nil {
+		return fmt.Errorf("generate select sqls error: %v", err)
+	}
+	if m.deleteSQLs, err = generateTableSQLs(deleteStmt, result); err != nil {
+		return fmt.Errorf("generate delete sqls error: %v", err)
+	}
+	return nil
+}
+
+// execute 不在事务中时开启事务, 移动行出错时回滚
+func (m *shardMove) execute(reqCtx *util.RequestContext, sess Executor, indexes []int) (*mysql.Result, error) {
+	tx, ok := sess.(TransactionExecutor)
+	if !ok {
+		return nil, fmt.Errorf("shard column update need transaction")
+	}
+	return executeInTransaction(tx, func() (*mysql.Result, error) {
+		return m.move(reqCtx, sess, indexes)
+	})
+}
+
+// executeInTransaction 已经在事务中时直接执行, 否则开启事务执行, 出错时回滚
+func executeInTransaction(tx TransactionExecutor, f func() (*mysql.Result, error)) (*mysql.Result, error) {
+	if tx.IsInTransaction() {
+		return f()
+	}
+
+	if err := tx.BeginTransaction(); err != nil {
+		return nil, fmt.Errorf("begin transaction error: %v", err)
+	}
+	r, err := f()
+	if err != nil {
+		if rollbackErr := tx.RollbackTransaction(); rollbackErr != nil {
+			return nil, fmt.Errorf("%v, rollback error: %v", err, rollbackErr)
+		}
+		return nil, err
+	}
+	if err := tx.CommitTransaction(); err != nil {
+		return nil, fmt.Errorf("commit transaction error: %v", err)
+	}
+	return r, nil
+}
+
+// move 查询并锁定被修改的行, 删除后插入新的分片, 返回值被修改的行数
+func (m *shardMove) move(reqCtx *util.RequestContext, sess Executor, indexes []int) (*mysql.Result, error) {
+	rs, err := sess.ExecuteSQLs(reqCtx, getTableIndexesSQLs(m.rule, m.selectSQLs, indexes))
+	if err != nil {
+		return nil, fmt.Errorf("select updated rows error: %v", err)
+	}
+
+	var fields []*mysql.Field
+	var oldRows, newRows []*movedRow
+	var changed uint64
+	for _, r := range rs {
+		if r.Resultset == nil || len(r.Values) == 0 {
+			continue
+		}
+		if fields == nil {
+			fields = r.Fields
+		}
+		for i, values := range r.Values {
+			oldRow, newRow, err := m.newRow(fields, values, r.RowDatas[i])
+			if err != nil {
+				return nil, err
+			}
+			if !oldRow.equal(newRow) {
+				changed++
+			}
+			oldRows = append(oldRows, oldRow)
+			newRows = append(newRows, newRow)
+		}
+	}
+	if len(newRows) == 0 {
+		return &mysql.Result{}, nil
+	}
+
+	rs, err = sess.ExecuteSQLs(reqCtx, getTableIndexesSQLs(m.rule, m.deleteSQLs, indexes))
+	if err != nil {
+		return nil, fmt.Errorf("delete updated rows error: %v", err)
+	}
+	r, _ := MergeExecResult(rs)
+	if r.AffectedRows != uint64(len(oldRows)) {
+		return nil, fmt.Errorf("deleted %d rows but selected %d rows", r.AffectedRows, len(oldRows))
+	}
+
+	columns := fields[:len(fields)-len(m.columns)]
+	insertSQLs, insertIndexes, err := m.buildInsertSQLs(columns, newRows)
+	if err != nil {
+		return nil, fmt.Errorf("build insert sql error: %v", err)
+	}
+	if _, err := sess.ExecuteSQLs(reqCtx, getTableIndexesSQLs(m.rule, insertSQLs, insertIndexes)); err != nil {
+		return nil, fmt.Errorf("insert updated rows error: %v", err)
+	}
+
+	lookupSQLs, err := m.buildLookupSQLs(columns, oldRows, newRows)
+	if err != nil {
+		return nil, fmt.Errorf("build lookup sql error: %v", err)
+	}
+	if err := executeLookupSQLs(reqCtx, sess, lookupSQLs); err != nil {
+		return nil, err
+	}
+	return &mysql.Result{AffectedRows: changed}, nil
+}
+
+// newRow 用SET表达式的值替换查询结果中对应的列, 计算新的行所在的子表
+func (m *shardMove) newRow(fields []*mysql.Field, values []interface{}, rowData mysql.RowData) (*movedRow, *movedRow, error) {
+	count := len(fields) - len(m.columns)
+	if count <= 0 || len(values) != len(fields) {
+		return nil, nil, fmt.Errorf("invalid column count of updated rows: %d", len(fields))
+	}
+	texts := make([][]byte, len(fields))
+	for i := range fields {
+		text, _, err := rowData.GetTextColumn(i)
+		if err != nil {
+			return nil, nil, err
+		}
+		texts[i] = text
+	}
+
+	oldRow := &movedRow{values: values[:count], texts: texts[:count]}
+	newRow := &movedRow{
+		values: append([]interface{}{}, oldRow.values...),
+		texts:  append([][]byte{}, oldRow.texts...),
+	}
+	for i, column := range m.columns {
+		index := getFieldIndex(fields[:count], column)
+		if index < 0 {
+			return nil, nil, fmt.Errorf("column %s not found in updated rows", column)
+		}
+		newRow.values[index] = values[count+i]
+		newRow.texts[index] = texts[count+i]
+	}
+
+	shardingColumn := m.rule.GetShardingColumn()
+	index := getFieldIndex(fields[:count], shardingColumn)
+	if index < 0 {
+		return nil, nil, fmt.Errorf("sharding column %s not found in updated rows", shardingColumn)
+	}
+	if newRow.values[index] == nil {
+		return nil, nil, fmt.Errorf("sharding column %s can not be NULL", shardingColumn)
+	}
+	tableIndexes, err := m.rule.FindTableIndexes(shardingColumn, newRow.values[index])
+	if err != nil {
+		return nil, nil, fmt.Errorf("find table index of %v error: %v", newRow.values[index], err)
+	}
+	if len(tableIndexes) != 1 {
+		return nil, nil, fmt.Errorf("value %v of sharding column %s is not routed to one table", newRow.values[index], shardingColumn)
+	}
+	newRow.tableIndex = tableIndexes[0]
+	return oldRow, newRow, nil
+}
+
+func (r *movedRow) equal(o *movedRow) bool {
+	for i := range r.values {
+		if (r.values[i] == nil) != (o.values[i] == nil) || !bytes.Equal(r.texts[i], o.texts[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// buildInsertSQLs 按新的子表生成批量插入的SQL
+func (m *shardMove) buildInsertSQLs(columns []*mysql.Field, rows []*movedRow) (map[int]string, []int, error) {
+	names := make([]string, 0, len(columns))
+	for _, f := range columns {
+		names = append(names, quoteLookupName(string(f.Name)))
+	}
+
+	builders := make(map[int]*strings.Builder)
+	var indexes []int
+	for _, row := range rows {
+		sb, ok := builders[row.tableIndex]
+		if !ok {
+			sb = &strings.Builder{}
+			ctx := format.NewRestoreCtx(util.EscapeRestoreFlags, sb)
+			sb.WriteString("INSERT INTO ")
+			table, err := CreateTableNameDecorator(m.table, m.rule, NewRouteResult(m.rule.GetDB(), m.rule.GetTable(), []int{row.tableIndex}))
+			if err != nil {
+				return nil, nil, err
+			}
+			if err := table.Restore(ctx); err != nil {
+				return nil, nil, err
+			}
+			fmt.Fprintf(sb, " (%s) VALUES ", strings.Join(names, ","))
+			builders[row.tableIndex] = sb
+			indexes = append(indexes, row.tableIndex)
+		} else {
+			sb.WriteString(",")
+		}
+
+		sb.WriteString("(")
+		for i, f := range columns {
+			if i != 0 {
+				sb.WriteString(",")
+			}
+			if err := restoreMovedValue(sb, f, row.values[i], row.texts[i]); err != nil {
+				return nil, nil, err
+			}
+		}
+		sb.WriteString(")")
+	}
+
+	ret := make(map[int]string, len(builders))
+	for index, sb := range builders {
+		ret[index] = sb.String()
+	}
+	return ret, indexes, nil
+}
+
+// restoreMovedValue 把文本协议中的值转换为常量: 数值直接使用, 二进制字符串使用十六进制, 其他类型使用字符串
+func restoreMovedValue(sb *strings.Builder, f *mysql.Field, value interface{}, text []byte) error {
+	if value == nil {
+		sb.WriteString("NULL")
+		return nil
+	}
+	switch f.Type {
+	case mysql.TypeTiny, mysql.TypeShort, mysql.TypeInt24, mysql.TypeLong, mysql.TypeLonglong, mysql.TypeYear,
+		mysql.TypeFloat, mysql.TypeDouble, mysql.TypeDecimal, mysql.TypeNewDecimal:
+		sb.Write(text)
+		return nil
+	case mysql.TypeJSON:
+	default:
+		if f.Charset == uint16(mysql.CollationIds["binary"]) && !isTimeFieldType(f.Type) {
+			sb.WriteString("X'")
+			sb.WriteString(hex.EncodeToString(text))
+			sb.WriteString("'")
+			return nil
+		}
+	}
+	return ast.NewValueExpr(string(text), "", "").Restore(format.NewRestoreCtx(util.EscapeRestoreFlags, sb))
+}
+
+// buildLookupSQLs 删除被移动的行在映射表中的记录, 再插入lookup列和分片列的新值
+func (m *shardMove) buildLookupSQLs(columns []*mysql.Field, oldRows, newRows []*movedRow) ([]*lookupSQL, error) {
+	shardingIndex := getFieldIndex(columns, m.rule.GetShardingColumn())
+
+	var sqls []*lookupSQL
+	for _, lookup := range m.rule.GetLookups() {
+		index := getFieldIndex(columns, lookup.Column)
+		if index < 0 {
+			return nil, fmt.Errorf("lookup column %s not found in updated rows", lookup.Column)
+		}
+
+		var oldValues []interface{}
+		var rows [][]interface{}
+		for i := range oldRows {
+			if v := oldRows[i].values[index]; v != nil {
+				oldValues = append(oldValues, v)
+			}
+			if v := newRows[i].values[index]; v != nil {
+				rows = append(rows, []interface{}{v, newRows[i].values[shardingIndex]})
+			}
+		}
+
+		if len(oldValues) != 0 {
+			sql, err := buildLookupDeleteSQL(m.rule, lookup, oldValues)
+			if err != nil {
+				return nil, err
+			}
+			sqls = append(sqls, sql)
+		}
+		if len(rows) != 0 {
+			sql, err := buildLookupInsertSQL(m.rule, lookup, rows)
+			if err != nil {
+				return nil, err
+			}
+			sqls = append(sqls, sql)
+		}
+	}
+	return sqls, nil
+}
+
+func getFieldIndex(fields []*mysql.Field, column string) int {
+	for i, f := range fields {
+		if strings.EqualFold(string(f.Name), column) {
+			return i
+		}
+	}
+	return -1
+}
+
+func includeString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// newShardMove 返回修改分片列的UPDATE语句的shardMove, 只有分片规则允许时才能修改分片列
+func newShardMove(rule router.Rule, column *ast.ColumnName) (*shardMove, error) {
+	if !rule.IsShardColumnUpdateAllowed() || column.Name.L != rule.GetShardingColumn() {
+		return nil, fmt.Errorf("cannot update shard column value")
+	}
+	return &shardMove{rule: rule}, nil
+}
@@ -0,0 +1,266 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plan
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/parser"
+	"github.com/XiaoMi/Gaea/util"
+)
+
+// moveResults 在lookupResults的基础上, 查询被修改的行时返回tables中对应子表的行,
+// 删除时返回的影响行数为子表的行数加上deleteDelta
+func moveResults(keys []interface{}, fields []*mysql.Field, tables map[string][][]interface{}, deleteDelta uint64) func(slice, db, sql string) (*mysql.Result, error) {
+	lookup := lookupResults(keys, nil)
+	return func(slice, db, sql string) (*mysql.Result, error) {
+		var rows [][]interface{}
+		found := false
+		for table, tableRows := range tables {
+			if strings.Contains(sql, "`"+table+"`") {
+				rows, found = tableRows, true
+			}
+		}
+		switch {
+		case strings.HasPrefix(sql, "SELECT DISTINCT") && !found: // 映射表
+			return lookup(slice, db, sql)
+		case strings.HasPrefix(sql, "SELECT"):
+			r := &mysql.Resultset{Fields: fields, Values: rows}
+			for _, row := range rows {
+				rowData, err := generateRowData(row)
+				if err != nil {
+					return nil, err
+				}
+				r.RowDatas = append(r.RowDatas, rowData)
+			}
+			return &mysql.Result{Resultset: r}, nil
+		case strings.HasPrefix(sql, "DELETE"):
+			return &mysql.Result{AffectedRows: uint64(len(rows)) + deleteDelta}, nil
+		default:
+			return &mysql.Result{AffectedRows: 1}, nil
+		}
+	}
+}
+
+func TestShardColumnUpdate(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	fields := []*mysql.Field{
+		{Name: []byte("user_id"), Type: mysql.TypeLonglong},
+		{Name: []byte("order_no"), Type: mysql.TypeVarString, Charset: 33},
+		{Name: []byte("name"), Type: mysql.TypeVarString, Charset: 33},
+		{Name: []byte("data"), Type: mysql.TypeBlob, Charset: 63},
+	}
+	tests := []struct {
+		sql           string
+		exprFields    []*mysql.Field // SET表达式的列
+		keys          []interface{}
+		tables        map[string][][]interface{}
+		deleteDelta   uint64
+		inTransaction bool
+		affectedRows  uint64
+		hasErr        bool
+		executed      []string
+	}{
+		{
+			sql:        "update tbl_ks_move set user_id = 6 where user_id = 1",
+			exprFields: []*mysql.Field{{Name: []byte("6"), Type: mysql.TypeLonglong}},
+			tables: map[string][][]interface{}{
+				"tbl_ks_move_0001": {
+					{int64(1), "a", "x", []byte{0, 1}, int64(6)},
+					{int64(1), nil, "it's", nil, int64(6)},
+				},
+			},
+			affectedRows: 2,
+			executed: []string{
+				"BEGIN",
+				"slice-0:SELECT *,6 FROM `tbl_ks_move_0001` WHERE `user_id`=1 FOR UPDATE",
+				"slice-0:DELETE FROM `tbl_ks_move_0001` WHERE `user_id`=1",
+				"slice-1:INSERT INTO `tbl_ks_move_0002` (`user_id`,`order_no`,`name`,`data`) VALUES (6,'a','x',X'0001'),(6,NULL,'it''s',NULL)",
+				"slice-1:DELETE FROM `tbl_ks_move_order_no` WHERE `order_no` IN ('a')",
+				"slice-1:INSERT INTO `tbl_ks_move_order_no` (`order_no`,`user_id`) VALUES ('a',6)",
+				"COMMIT",
+			},
+		},
+		{
+			// 通过映射表计算路由, 修改后的行分布在不同的子表中
+			sql: "update tbl_ks_move set user_id = user_id + 1, order_no = 'c' where order_no in ('a', 'b')",
+			exprFields: []*mysql.Field{
+				{Name: []byte("`user_id`+1"), Type: mysql.TypeLonglong},
+				{Name: []byte("'c'"), Type: mysql.TypeVarString, Charset: 33},
+			},
+			keys: []interface{}{int64(4), int64(5)},
+			tables: map[string][][]interface{}{
+				"tbl_ks_move_0000": {{int64(4), "a", "x", nil, int64(5), "c"}},
+				"tbl_ks_move_0001": {{int64(5), "b", "y", nil, int64(6), "c"}},
+			},
+			affectedRows: 2,
+			executed: []string{
+				"slice-1:SELECT DISTINCT `user_id` FROM `tbl_ks_move_order_no` WHERE `order_no` IN ('a','b')",
+				"BEGIN",
+				"slice-0:SELECT *,`user_id`+1,'c' FROM `tbl_ks_move_0000` WHERE `order_no` IN ('a','b') FOR UPDATE",
+				"slice-0:SELECT *,`user_id`+1,'c' FROM `tbl_ks_move_0001` WHERE `order_no` IN ('a','b') FOR UPDATE",
+				"slice-0:DELETE FROM `tbl_ks_move_0000` WHERE `order_no` IN ('a','b')",
+				"slice-0:DELETE FROM `tbl_ks_move_0001` WHERE `order_no` IN ('a','b')",
+				"slice-0:INSERT INTO `tbl_ks_move_0001` (`user_id`,`order_no`,`name`,`data`) VALUES (5,'c','x',NULL)",
+				"slice-1:INSERT INTO `tbl_ks_move_0002` (`user_id`,`order_no`,`name`,`data`) VALUES (6,'c','y',NULL)",
+				"slice-1:DELETE FROM `tbl_ks_move_order_no` WHERE `order_no` IN ('a','b')",
+				"slice-1:INSERT INTO `tbl_ks_move_order_no` (`order_no`,`user_id`) VALUES ('c',5),('c',6)",
+				"COMMIT",
+			},
+		},
+		{
+			// 在客户端的事务中执行, 值没有变化的行不计入影响行数
+			sql:        "update tbl_ks_move t set t.user_id = 2 where t.name = 'x'",
+			exprFields: []*mysql.Field{{Name: []byte("2"), Type: mysql.TypeLonglong}},
+			tables: map[string][][]interface{}{
+				"tbl_ks_move_0002": {{int64(2), nil, "x", nil, int64(2)}},
+			},
+			inTransaction: true,
+			affectedRows:  0,
+			executed: []string{
+				"slice-0:SELECT *,2 FROM `tbl_ks_move_0000` AS `t` WHERE `t`.`name`='x' FOR UPDATE",
+				"slice-0:SELECT *,2 FROM `tbl_ks_move_0001` AS `t` WHERE `t`.`name`='x' FOR UPDATE",
+				"slice-1:SELECT *,2 FROM `tbl_ks_move_0002` AS `t` WHERE `t`.`name`='x' FOR UPDATE",
+				"slice-1:SELECT *,2 FROM `tbl_ks_move_0003` AS `t` WHERE `t`.`name`='x' FOR UPDATE",
+				"slice-0:DELETE `t` FROM `tbl_ks_move_0000` AS `t` WHERE `t`.`name`='x'",
+				"slice-0:DELETE `t` FROM `tbl_ks_move_0001` AS `t` WHERE `t`.`name`='x'",
+				"slice-1:DELETE `t` FROM `tbl_ks_move_0002` AS `t` WHERE `t`.`name`='x'",
+				"slice-1:DELETE `t` FROM `tbl_ks_move_0003` AS `t` WHERE `t`.`name`='x'",
+				"slice-1:INSERT INTO `tbl_ks_move_0002` (`user_id`,`order_no`,`name`,`data`) VALUES (2,NULL,'x',NULL)",
+			},
+		},
+		{
+			// 没有被修改的行
+			sql:        "update tbl_ks_move set user_id = 2 where user_id = 1 order by name limit 1",
+			exprFields: []*mysql.Field{{Name: []byte("2"), Type: mysql.TypeLonglong}},
+			executed: []string{
+				"BEGIN",
+				"slice-0:SELECT *,2 FROM `tbl_ks_move_0001` WHERE `user_id`=1 ORDER BY `name` LIMIT 1 FOR UPDATE",
+				"COMMIT",
+			},
+		},
+		{
+			// 删除的行数与查询的行数不一致时回滚
+			sql:        "update tbl_ks_move set user_id = 6 where user_id = 1",
+			exprFields: []*mysql.Field{{Name: []byte("6"), Type: mysql.TypeLonglong}},
+			tables: map[string][][]interface{}{
+				"tbl_ks_move_0001": {{int64(1), "a", "x", nil, int64(6)}},
+			},
+			deleteDelta: 1,
+			hasErr:      true,
+			executed: []string{
+				"BEGIN",
+				"slice-0:SELECT *,6 FROM `tbl_ks_move_0001` WHERE `user_id`=1 FOR UPDATE",
+				"slice-0:DELETE FROM `tbl_ks_move_0001` WHERE `user_id`=1",
+				"ROLLBACK",
+			},
+		},
+		{
+			sql:        "update tbl_ks_move set user_id = null where user_id = 1",
+			exprFields: []*mysql.Field{{Name: []byte("NULL"), Type: mysql.TypeNull}},
+			tables: map[string][][]interface{}{
+				"tbl_ks_move_0001": {{int64(1), "a", "x", nil, nil}},
+			},
+			hasErr: true,
+			executed: []string{
+				"BEGIN",
+				"slice-0:SELECT *,NULL FROM `tbl_ks_move_0001` WHERE `user_id`=1 FOR UPDATE",
+				"ROLLBACK",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.sql, func(t *testing.T) {
+			stmt, err := parser.ParseSQL(test.sql)
+			if err != nil {
+				t.Fatalf("parse sql error: %v", err)
+			}
+			p, err := BuildPlan(stmt, ns.phyDBs, "db_ks", test.sql, ns.rt, ns.seqs)
+			if err != nil {
+				t.Fatalf("build plan error: %v", err)
+			}
+			moveFields := append(append([]*mysql.Field{}, fields...), test.exprFields...)
+			e := &lookupTestExecutor{
+				testExecutor:  testExecutor{handle: moveResults(test.keys, moveFields, test.tables, test.deleteDelta)},
+				inTransaction: test.inTransaction,
+			}
+			r, err := p.ExecuteIn(util.NewRequestContext(), e)
+			if test.hasErr {
+				if err == nil {
+					t.Errorf("execute should fail")
+				}
+			} else if err != nil {
+				t.Fatalf("execute error: %v", err)
+			} else if r.AffectedRows != test.affectedRows {
+				t.Errorf("affected rows not equal, expect: %d, actual: %d", test.affectedRows, r.AffectedRows)
+			}
+			if !reflect.DeepEqual(e.executed, test.executed) {
+				t.Errorf("executed sqls not equal, expect: %v, actual: %v", test.executed, e.executed)
+			}
+			if e.inTransaction != test.inTransaction {
+				t.Errorf("transaction status not restored")
+			}
+		})
+	}
+}
+
+func TestShardColumnUpdateError(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	buildErrors := []string{
+		"update tbl_ks_lookup set user_id = 2 where user_id = 1",                      // 没有开启allow_shard_column_update
+		"update tbl_ks_move set name = 'x', user_id = length(name) where user_id = 1", // 引用了前面赋值的列
+		"update tbl_ks_move set user_id = (select 1) where user_id = 1",
+		"update tbl_ks_move t set user_id = 2 where user_id = 1 limit 1",
+	}
+	for _, sql := range buildErrors {
+		stmt, err := parser.ParseSQL(sql)
+		if err != nil {
+			t.Fatalf("parse sql error: %v", err)
+		}
+		if _, err := BuildPlan(stmt, ns.phyDBs, "db_ks", sql, ns.rt, ns.seqs); err == nil {
+			t.Errorf("build plan should fail: %s", sql)
+		}
+	}
+
+	// 不支持事务的Executor
+	sql := "update tbl_ks_move set user_id = 2 where user_id = 1"
+	stmt, err := parser.ParseSQL(sql)
+	if err != nil {
+		t.Fatalf("parse sql error: %v", err)
+	}
+	p, err := BuildPlan(stmt, ns.phyDBs, "db_ks", sql, ns.rt, ns.seqs)
+	if err != nil {
+		t.Fatalf("build plan error: %v", err)
+	}
+	e := &lookupTestExecutor{}
+	if _, err := p.ExecuteIn(util.NewRequestContext(), noTransactionExecutor{e}); err == nil {
+		t.Errorf("execute without transaction should fail")
+	}
+	if len(e.executed) != 0 {
+		t.Errorf("sqls should not be executed: %v", e.executed)
+	}
+}
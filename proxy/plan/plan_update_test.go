@@ -59,7 +59,7 @@ func TestMycatShardSimpleUpdate(t *testing.T) {
 		{
 			db:     "db_mycat",
 			sql:    "update tbl_mycat, tbl_mycat_child set id = 5",
-			hasErr: true, // does not support update multiple tables in sharding
+			hasErr: true, // not joined on sharding columns
 		},
 	}
 	for _, test := range tests {
@@ -346,3 +346,170 @@ func TestGlobalTableUpdate(t *testing.T) {
 		t.Run(test.sql, getTestFunc(ns, test))
 	}
 }
+
+func TestKingshardStandardUpdate(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	tests := []SQLTestcase{
+		{
+			db:  "db_ks",
+			sql: "update tbl_ks_standard set a = 'hi' where tenant_id = 2 and order_id = 3",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {"UPDATE `tbl_ks_standard_0001` SET `a`='hi' WHERE `tenant_id`=2 AND `order_id`=3"},
+				},
+			},
+		},
+		{
+			db:     "db_ks",
+			sql:    "update tbl_ks_standard set tenant_id = 1 where order_id = 3",
+			hasErr: true, // cannot update shard column value
+		},
+		{
+			db:     "db_ks",
+			sql:    "update tbl_ks_standard set order_id = 1 where tenant_id = 3",
+			hasErr: true, // cannot update shard column value
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.sql, getTestFunc(ns, test))
+	}
+}
+
+func TestKingshardMultiTableUpdate(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	tests := []SQLTestcase{
+		{
+			db:  "db_ks",
+			sql: "update tbl_ks a join tbl_ks_child b on a.id = b.id set b.name = a.name where a.id = 5",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {"UPDATE `tbl_ks_0001` AS `a` JOIN `tbl_ks_child_0001` AS `b` ON `a`.`id`=`b`.`id` SET `b`.`name`=`a`.`name` WHERE `a`.`id`=5"},
+				},
+			},
+		},
+		{
+			db:  "db_ks",
+			sql: "update db_ks.tbl_ks a, tbl_ks_user_child b set db_ks.b.name = 'hi', a.name = b.name where a.id = b.user_id and b.user_id = 2",
+			sqls: map[string]map[string][]string{
+				"slice-1": {
+					"db_ks": {"UPDATE (`db_ks`.`tbl_ks_0002` AS `a`) JOIN `tbl_ks_user_child_0002` AS `b` SET `b`.`name`='hi', `a`.`name`=`b`.`name` WHERE `a`.`id`=`b`.`user_id` AND `b`.`user_id`=2"},
+				},
+			},
+		},
+		{
+			db:  "db_ks",
+			sql: "update tbl_ks_order o join tbl_ks_order_item i on o.order_id = i.order_id set i.price = 1 where o.order_id = 6",
+			sqls: map[string]map[string][]string{
+				"slice-1": {
+					"db_ks": {"UPDATE `tbl_ks_order_0002` AS `o` JOIN `tbl_ks_order_item_0002` AS `i` ON `o`.`order_id`=`i`.`order_id` SET `i`.`price`=1 WHERE `o`.`order_id`=6"},
+				},
+			},
+		},
+		{
+			db:  "db_ks",
+			sql: "update tbl_ks a join tbl_ks_global_one g on a.name = g.name set a.age = g.age where a.id = 1",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {"UPDATE `tbl_ks_0001` AS `a` JOIN `tbl_ks_global_one` AS `g` ON `a`.`name`=`g`.`name` SET `a`.`age`=`g`.`age` WHERE `a`.`id`=1"},
+				},
+			},
+		},
+		{
+			db:  "db_ks",
+			sql: "update tbl_ks_complex a join tbl_ks_complex_item b on a.region_id = b.area_id and a.user_id = b.uid set b.name = a.name where a.region_id = 0 and a.user_id = 2",
+			sqls: map[string]map[string][]string{
+				"slice-1": {
+					"db_ks": {"UPDATE `tbl_ks_complex_0002` AS `a` JOIN `tbl_ks_complex_item_0002` AS `b` ON `a`.`region_id`=`b`.`area_id` AND `a`.`user_id`=`b`.`uid` SET `b`.`name`=`a`.`name` WHERE `a`.`region_id`=0 AND `a`.`user_id`=2"},
+				},
+			},
+		},
+		{
+			db:     "db_ks",
+			sql:    "update tbl_ks a join tbl_ks_child b on a.name = b.name set b.name = 'hi'",
+			hasErr: true, // not joined on sharding columns
+		},
+		{
+			db:     "db_ks",
+			sql:    "update tbl_ks_complex a join tbl_ks_complex_item b on a.region_id = b.uid and a.user_id = b.area_id set b.name = 'hi'",
+			hasErr: true, // sharding columns at different positions are not co-located
+		},
+		{
+			db:     "db_ks",
+			sql:    "update tbl_ks_complex a join tbl_ks_complex_item b on a.region_id = b.area_id set b.name = 'hi'",
+			hasErr: true, // not joined on all sharding columns of complex rule
+		},
+		{
+			db:     "db_ks",
+			sql:    "update tbl_ks_gene a join tbl_ks_gene b on a.order_id = b.user_id set b.name = 'hi'",
+			hasErr: true, // sharding key and gene column are not co-located
+		},
+		{
+			db:     "db_ks",
+			sql:    "update tbl_ks a join tbl_ks_order o on a.id = o.order_id set a.name = 'hi'",
+			hasErr: true, // not linked or bound
+		},
+		{
+			db:     "db_ks",
+			sql:    "update tbl_ks a join tbl_ks_global_one g on a.name = g.name set g.age = 1",
+			hasErr: true, // cannot modify global table joined with sharding tables
+		},
+		{
+			db:     "db_ks",
+			sql:    "update tbl_ks join tbl_ks_child on tbl_ks.id = tbl_ks_child.id set tbl_ks_child.name = 'hi'",
+			hasErr: true, // table must be referenced by alias
+		},
+		{
+			db:     "db_ks",
+			sql:    "update tbl_ks a join tbl_ks_child b on a.id = b.id set name = 'hi'",
+			hasErr: true, // column must be qualified by table
+		},
+		{
+			db:     "db_ks",
+			sql:    "update tbl_ks a join tbl_ks_child b on a.id = b.id set b.id = 3",
+			hasErr: true, // cannot update shard column value
+		},
+		{
+			db:     "db_ks",
+			sql:    "update tbl_ks a join tbl_ks_child b using (id) set b.name = 'hi'",
+			hasErr: true, // does not support USING
+		},
+		{
+			db:     "db_ks",
+			sql:    "update tbl_ks_lookup a join tbl_ks_global_one g on a.name = g.name set a.order_no = 'x'",
+			hasErr: true, // cannot update lookup column
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.sql, getTestFunc(ns, test))
+	}
+}
+
+func TestMycatMultiTableUpdate(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	tests := []SQLTestcase{
+		{
+			db:  "db_mycat",
+			sql: "update tbl_mycat join tbl_mycat_child on tbl_mycat.id = tbl_mycat_child.id set tbl_mycat_child.a = tbl_mycat.a where tbl_mycat.id = 5",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_mycat_1": {"UPDATE `tbl_mycat` JOIN `tbl_mycat_child` ON `tbl_mycat`.`id`=`tbl_mycat_child`.`id` SET `tbl_mycat_child`.`a`=`tbl_mycat`.`a` WHERE `tbl_mycat`.`id`=5"},
+				},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.sql, getTestFunc(ns, test))
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package router
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/XiaoMi/Gaea/models"
+)
+
+// parseBindingTables bind the rules of each binding group to the first table of the group,
+// the tables in the same group share the route result, so they are joined on the same table index.
+func (r *Router) parseBindingTables(groups []*models.BindingTableGroup) error {
+	for _, g := range groups {
+		if len(g.Tables) == 0 {
+			continue
+		}
+		first, err := r.getBindingRule(g.DB, g.Tables[0])
+		if err != nil {
+			return err
+		}
+		for _, table := range g.Tables {
+			rule, err := r.getBindingRule(g.DB, table)
+			if err != nil {
+				return err
+			}
+			if rule.bindingTable != "" {
+				return fmt.Errorf("binding table %s.%s duplicate", g.DB, table)
+			}
+			if !isSameTopology(first, rule) {
+				return fmt.Errorf("binding table %s.%s has different sharding algorithm or topology with %s.%s", g.DB, table, g.DB, first.table)
+			}
+			rule.bindingTable = first.table
+		}
+	}
+	return nil
+}
+
+func (r *Router) getBindingRule(db, table string) (*BaseRule, error) {
+	rule, ok := r.rules[db][strings.ToLower(table)]
+	if !ok {
+		return nil, fmt.Errorf("binding table %s.%s is not found in shard rules", db, table)
+	}
+	baseRule, ok := rule.(*BaseRule)
+	if !ok || baseRule.ruleType == GlobalTableRuleType {
+		return nil, fmt.Errorf("binding table %s.%s must be a sharding table", db, table)
+	}
+	return baseRule, nil
+}
+
+// isSameTopology check the two rules have the same type and sharding algorithm config, such as table_row_limit
+// of range rule or the expression of inline rule, and each table index is in the same slice and DB
+func isSameTopology(a, b *BaseRule) bool {
+	if a.ruleType != b.ruleType || !reflect.DeepEqual(a.subTableIndexes, b.subTableIndexes) {
+		return false
+	}
+	if a.signature != b.signature {
+		return false
+	}
+	if !reflect.DeepEqual(a.mycatDatabases, b.mycatDatabases) {
+		return false
+	}
+	for _, index := range a.subTableIndexes {
+		sa, sb := a.GetSliceIndexFromTableIndex(index), b.GetSliceIndexFromTableIndex(index)
+		if sa < 0 || sb < 0 {
+			if sa != sb {
+				return false
+			}
+			continue
+		}
+		if a.GetSlice(sa) != b.GetSlice(sb) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,41 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package router
+
+import (
+	"strings"
+
+	"github.com/XiaoMi/Gaea/models"
+)
+
+// Lookup maps the values of a secondary unique column to the values of the sharding column by a mapping table,
+// so the statements filtered only by the column can be routed to the tables holding the rows.
+type Lookup struct {
+	Column string // secondary unique column of the sharding table, lower case
+	Table  string // mapping table with two columns named after Column and the sharding column
+	Slice  string // slice of the mapping table, the mapping table is in the same DB as the sharding table
+}
+
+func parseLookups(cfgs []*models.ShardLookup) []*Lookup {
+	var lookups []*Lookup
+	for _, cfg := range cfgs {
+		lookups = append(lookups, &Lookup{
+			Column: strings.ToLower(cfg.Column),
+			Table:  cfg.Table,
+			Slice:  cfg.Slice,
+		})
+	}
+	return lookups
+}
@@ -97,6 +97,10 @@ func NewRouter(namespace *models.Namespace) (*Router, error) {
 		rt.rules[rule.db][rule.table] = rule
 	}
 
+	if err := rt.parseBindingTables(namespace.BindingTables); err != nil {
+		return nil, fmt.Errorf("create binding tables error: %v", err)
+	}
+
 	return rt, nil
 }
 
@@ -53,6 +53,15 @@ const (
 	MycatStringRuleType     = models.ShardMycatString
 	MycatMurmurRuleType     = models.ShardMycatMURMUR
 	MycatPaddingModRuleType = models.ShardMycatPaddingMod
+	InlineRuleType          = models.ShardInline
+	StandardRuleType        = models.ShardStandard
+	ComplexRuleType         = models.ShardComplex
+	IntervalRuleType        = models.ShardInterval
+	AutoIntervalRuleType    = models.ShardAutoInterval
+	VolumeRangeRuleType     = models.ShardVolumeRange
+	BoundaryRangeRuleType   = models.ShardBoundaryRange
+	HashModRuleType         = models.ShardHashMod
+	GeneRuleType            = models.ShardGene
 
 	MinMonthDaysCount = 28
 	MaxMonthDaysCount = 31
@@ -63,9 +72,12 @@ type Rule interface {
 	GetDB() string
 	GetTable() string
 	GetShardingColumn() string
+	GetShardingColumns() []string
+	IsShardingColumn(column string) bool
 	IsLinkedRule() bool
 	GetShard() Shard
 	FindTableIndex(key interface{}) (int, error)
+	FindTableIndexes(column string, key interface{}) ([]int, error)
 	GetSlice(i int) string // i is slice index
 	GetSliceIndexFromTableIndex(i int) int
 	GetSlices() []string
@@ -74,6 +86,11 @@ type Rule interface {
 	GetLastTableIndex() int
 	GetType() string
 	GetDatabaseNameByTableIndex(index int) (string, error)
+	GetActualTableName(index int) (string, bool)
+	GetLookups() []*Lookup
+	GetLookup(column string) (*Lookup, bool)
+	GetRouteTable() string
+	IsShardColumnUpdateAllowed() bool
 }
 
 type MycatRule interface {
@@ -92,6 +109,11 @@ type BaseRule struct {
 	subTableIndexes []int       //subTableIndexes store all the index of sharding sub-table
 	tableToSlice    map[int]int //key is table index, and value is slice index
 	shard           Shard
+	actualTables    []string // physical table name of each table index, only set by actual_data_nodes
+	lookups         []*Lookup
+	bindingTable    string // the first table of the binding group, empty if the table is not bound
+	keyUpdatable    bool   // UPDATE can assign the sharding column, see allow_shard_column_update
+	signature       string // sharding algorithm config without table and sharding columns, see models.BindingShardSignature
 
 	// TODO: 目前全局表也借用这两个field存放默认分片的物理DB名
 	mycatDatabases               []string
@@ -124,10 +146,36 @@ func (r *BaseRule) GetTable() string {
 	return r.table
 }
 
+// IsShardColumnUpdateAllowed return true if UPDATE can assign the sharding column by moving rows between shards
+func (r *BaseRule) IsShardColumnUpdateAllowed() bool {
+	return r.keyUpdatable
+}
+
 func (r *BaseRule) GetShardingColumn() string {
 	return r.shardingColumn
 }
 
+// GetShardingColumns return all the sharding columns of the rule
+func (r *BaseRule) GetShardingColumns() []string {
+	if s, ok := r.shard.(MultiColumnShard); ok {
+		return s.GetShardingColumns()
+	}
+	if r.shardingColumn == "" {
+		return nil
+	}
+	return []string{r.shardingColumn}
+}
+
+// IsShardingColumn check if the column is one of the sharding columns
+func (r *BaseRule) IsShardingColumn(column string) bool {
+	for _, c := range r.GetShardingColumns() {
+		if c == column {
+			return true
+		}
+	}
+	return false
+}
+
 func (r *BaseRule) IsLinkedRule() bool {
 	return false
 }
@@ -140,12 +188,31 @@ func (r *BaseRule) FindTableIndex(key interface{}) (int, error) {
 	return r.shard.FindForKey(key)
 }
 
+// FindTableIndexes return the table indexes by the value of a column.
+// If the column is not a sharding column, all the table indexes are returned.
+func (r *BaseRule) FindTableIndexes(column string, key interface{}) ([]int, error) {
+	if s, ok := r.shard.(MultiColumnShard); ok {
+		return s.FindForColumnKey(column, key)
+	}
+	if column != r.shardingColumn {
+		return r.GetSubTableIndexes(), nil
+	}
+	index, err := r.shard.FindForKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return []int{index}, nil
+}
+
 // The confs should be verified before use to avoid panic.
 func (r *BaseRule) GetSlice(i int) string {
 	return r.slices[i]
 }
 
 func (r *BaseRule) GetSliceIndexFromTableIndex(i int) int {
+	if s, ok := r.shard.(ComputedTableShard); ok {
+		return s.GetSliceIndex(i)
+	}
 	sliceIndex, ok := r.tableToSlice[i]
 	if !ok {
 		return -1
@@ -160,15 +227,19 @@ func (r *BaseRule) GetSlices() []string {
 }
 
 func (r *BaseRule) GetSubTableIndexes() []int {
+	if s, ok := r.shard.(ComputedTableShard); ok {
+		return s.GetTableIndexes()
+	}
 	return r.subTableIndexes
 }
 
 func (r *BaseRule) GetFirstTableIndex() int {
-	return r.subTableIndexes[0]
+	return r.GetSubTableIndexes()[0]
 }
 
 func (r *BaseRule) GetLastTableIndex() int {
-	return r.subTableIndexes[len(r.subTableIndexes)-1]
+	subTableIndexes := r.GetSubTableIndexes()
+	return subTableIndexes[len(subTableIndexes)-1]
 }
 
 func (r *BaseRule) GetType() string {
@@ -185,6 +256,42 @@ func (r *BaseRule) GetDatabaseNameByTableIndex(index int) (string, error) {
 	return r.db, nil
 }
 
+// GetActualTableName return the physical table name set by actual_data_nodes or computed by the shard.
+// If it is not set, the physical table name is table_%04d, and false is returned.
+func (r *BaseRule) GetActualTableName(index int) (string, bool) {
+	if s, ok := r.shard.(ComputedTableShard); ok {
+		return s.GetActualTableName(index)
+	}
+	if index < 0 || index >= len(r.actualTables) {
+		return "", false
+	}
+	return r.actualTables[index], true
+}
+
+// GetLookups return the lookups of the rule
+func (r *BaseRule) GetLookups() []*Lookup {
+	return r.lookups
+}
+
+// GetLookup return the lookup of the column
+func (r *BaseRule) GetLookup(column string) (*Lookup, bool) {
+	for _, l := range r.lookups {
+		if l.Column == column {
+			return l, true
+		}
+	}
+	return nil, false
+}
+
+// GetRouteTable return the table whose route result is shared by the rule,
+// it is the first table of the binding group if the table is bound, or the table itself
+func (r *BaseRule) GetRouteTable() string {
+	if r.bindingTable != "" {
+		return r.bindingTable
+	}
+	return r.table
+}
+
 func (r *BaseRule) GetTableIndexByDatabaseName(phyDB string) (int, bool) {
 	idx, ok := r.mycatDatabaseToTableIndexMap[phyDB]
 	return idx, ok
@@ -214,6 +321,14 @@ func (l *LinkedRule) GetShardingColumn() string {
 	return l.shardingColumn
 }
 
+func (l *LinkedRule) GetShardingColumns() []string {
+	return []string{l.shardingColumn}
+}
+
+func (l *LinkedRule) IsShardingColumn(column string) bool {
+	return l.shardingColumn == column
+}
+
 func (l *LinkedRule) IsLinkedRule() bool {
 	return true
 }
@@ -226,6 +341,17 @@ func (l *LinkedRule) FindTableIndex(key interface{}) (int, error) {
 	return l.linkToRule.FindTableIndex(key)
 }
 
+func (l *LinkedRule) FindTableIndexes(column string, key interface{}) ([]int, error) {
+	if column != l.shardingColumn {
+		return l.linkToRule.GetSubTableIndexes(), nil
+	}
+	index, err := l.linkToRule.FindTableIndex(key)
+	if err != nil {
+		return nil, err
+	}
+	return []int{index}, nil
+}
+
 func (l *LinkedRule) GetFirstTableIndex() int {
 	return l.linkToRule.GetFirstTableIndex()
 }
@@ -258,6 +384,30 @@ func (l *LinkedRule) GetDatabaseNameByTableIndex(index int) (string, error) {
 	return l.linkToRule.GetDatabaseNameByTableIndex(index)
 }
 
+// GetActualTableName of linked table is always table_%04d, since actual_data_nodes belongs to the parent table
+func (l *LinkedRule) GetActualTableName(index int) (string, bool) {
+	return "", false
+}
+
+// GetLookups of linked table is always empty
+func (l *LinkedRule) GetLookups() []*Lookup {
+	return nil
+}
+
+func (l *LinkedRule) GetLookup(column string) (*Lookup, bool) {
+	return nil, false
+}
+
+// GetRouteTable of linked table is the route table of parent table
+func (l *LinkedRule) GetRouteTable() string {
+	return l.linkToRule.GetRouteTable()
+}
+
+// IsShardColumnUpdateAllowed of linked table is always false, the sharding column is the link to parent table
+func (l *LinkedRule) IsShardColumnUpdateAllowed() bool {
+	return false
+}
+
 func (l *LinkedRule) GetDatabases() []string {
 	return l.linkToRule.GetDatabases()
 }
@@ -286,6 +436,10 @@ func createLinkedRule(rules map[string]map[string]Rule, shard *models.Shard) (*L
 	if !ok {
 		return nil, fmt.Errorf("LinkedRule must link to a BaseRule")
 	}
+	// the gene columns of gene shard are routed in the same way as the sharding key, so the linked table can use any of them
+	if _, ok := linkToRule.GetShard().(MultiColumnShard); ok && linkToRule.GetType() != GeneRuleType {
+		return nil, fmt.Errorf("LinkedRule cannot link to a rule with multiple sharding columns")
+	}
 
 	linkedRule := &LinkedRule{
 		db:             shard.DB,
@@ -305,6 +459,16 @@ func parseRule(cfg *models.Shard) (*BaseRule, error) {
 	r.ruleType = cfg.Type
 	r.slices = cfg.Slices //将rule model中的slices赋值给rule
 	r.mycatDatabaseToTableIndexMap = make(map[string]int)
+	r.lookups = parseLookups(cfg.Lookups)
+	r.keyUpdatable = cfg.AllowShardColumnUpdate
+	r.signature = models.BindingShardSignature(cfg)
+
+	if cfg.ActualDataNodes != "" {
+		if err := r.parseActualDataNodes(cfg); err != nil {
+			return nil, err
+		}
+		return r, nil
+	}
 
 	subTableIndexs, tableToSlice, shard, err := parseRuleSliceInfos(cfg)
 	if err != nil {
@@ -346,6 +510,18 @@ func parseRule(cfg *models.Shard) (*BaseRule, error) {
 }
 
 func parseRuleSliceInfos(cfg *models.Shard) ([]int, map[int]int, Shard, error) {
+	if _, ok := models.GetCustomShardName(cfg.Type); ok {
+		subTableIndexs, tableToSlice, err := parseHashRuleSliceInfos(cfg.Locations, cfg.Slices)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		shard, err := NewCustomShard(cfg, len(tableToSlice))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return subTableIndexs, tableToSlice, shard, nil
+	}
+
 	switch cfg.Type {
 	case HashRuleType:
 		subTableIndexs, tableToSlice, err := parseHashRuleSliceInfos(cfg.Locations, cfg.Slices)
@@ -361,6 +537,73 @@ func parseRuleSliceInfos(cfg *models.Shard) ([]int, map[int]int, Shard, error) {
 		}
 		shard := &ModShard{ShardNum: len(tableToSlice)}
 		return subTableIndexs, tableToSlice, shard, nil
+	case HashModRuleType:
+		subTableIndexs, tableToSlice, err := parseHashRuleSliceInfos(cfg.Locations, cfg.Slices)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		shard, err := NewHashModShard(cfg.HashFunction, len(tableToSlice))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return subTableIndexs, tableToSlice, shard, nil
+	case GeneRuleType:
+		subTableIndexs, tableToSlice, err := parseHashRuleSliceInfos(cfg.Locations, cfg.Slices)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		shard := NewGeneShard(cfg.Key, cfg.GeneColumns, cfg.GeneBits, len(tableToSlice))
+		return subTableIndexs, tableToSlice, shard, nil
+	case InlineRuleType:
+		subTableIndexs, tableToSlice, err := parseHashRuleSliceInfos(cfg.Locations, cfg.Slices)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		shard, err := NewInlineShard(cfg.AlgorithmExpression, cfg.Key, len(tableToSlice))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return subTableIndexs, tableToSlice, shard, nil
+	case StandardRuleType:
+		subTableIndexs, tableToSlice, err := parseHashRuleSliceInfos(cfg.Locations, cfg.Slices)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if len(tableToSlice) == 0 || len(tableToSlice)%len(cfg.Slices) != 0 {
+			return nil, nil, nil, errors.ErrLocationsCount
+		}
+		shard, err := NewStandardShard(cfg.DatabaseStrategy, cfg.TableStrategy, len(cfg.Slices), len(tableToSlice)/len(cfg.Slices))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return subTableIndexs, tableToSlice, shard, nil
+	case ComplexRuleType:
+		subTableIndexs, tableToSlice, err := parseHashRuleSliceInfos(cfg.Locations, cfg.Slices)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		shard, err := NewComplexInlineShard(cfg.AlgorithmExpression, cfg.Keys, len(tableToSlice))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return subTableIndexs, tableToSlice, shard, nil
+	case IntervalRuleType, AutoIntervalRuleType:
+		// the tables of interval shard are computed by the shard, see ComputedTableShard
+		shard, err := NewIntervalShard(cfg)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return nil, nil, shard, nil
+	case VolumeRangeRuleType, BoundaryRangeRuleType:
+		subTableIndexs, tableToSlice, err := parseHashRuleSliceInfos(cfg.Locations, cfg.Slices)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		shard, err := newBoundaryRangeShardWithTables(cfg, len(tableToSlice))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return subTableIndexs, tableToSlice, shard, nil
 	case RangeRuleType:
 		subTableIndexs, tableToSlice, err := parseHashRuleSliceInfos(cfg.Locations, cfg.Slices)
 		if err != nil {
@@ -463,6 +706,80 @@ func parseRuleSliceInfos(cfg *models.Shard) ([]int, map[int]int, Shard, error) {
 	}
 }
 
+// parseActualDataNodes set the slices, tables and shard of the rule by actual_data_nodes.
+// The table index is the position of the data node, and the slices are in the order of first appearance.
+// For standard rule, the table index is sliceIndex * tablesPerSlice + index of table in slice.
+func (r *BaseRule) parseActualDataNodes(cfg *models.Shard) error {
+	var nodes []models.DataNode
+	var err error
+	switch cfg.Type {
+	case HashRuleType, ModRuleType, HashModRuleType, GeneRuleType, VolumeRangeRuleType, BoundaryRangeRuleType:
+		nodes, err = models.ParseActualDataNodes(cfg.ActualDataNodes)
+	case InlineRuleType, ComplexRuleType:
+		nodes, err = models.ParseUniqueTableDataNodes(cfg.ActualDataNodes)
+	case StandardRuleType:
+		slices, tables, err := models.ParseStandardDataNodes(cfg.ActualDataNodes)
+		if err != nil {
+			return err
+		}
+		for _, slice := range slices {
+			for _, table := range tables {
+				nodes = append(nodes, models.DataNode{Slice: slice, Table: table})
+			}
+		}
+		if r.shard, err = NewStandardShardWithNames(cfg.DatabaseStrategy, cfg.TableStrategy, slices, tables); err != nil {
+			return err
+		}
+	default:
+		if _, ok := models.GetCustomShardName(cfg.Type); !ok {
+			return fmt.Errorf("actual_data_nodes is not supported by %s rule", cfg.Type)
+		}
+		nodes, err = models.ParseActualDataNodes(cfg.ActualDataNodes)
+	}
+	if err != nil {
+		return err
+	}
+
+	r.slices = nil
+	r.subTableIndexes = nil
+	r.tableToSlice = make(map[int]int, len(nodes))
+	r.actualTables = nil
+	sliceIndexes := make(map[string]int)
+	for i, node := range nodes {
+		sliceIndex, ok := sliceIndexes[node.Slice]
+		if !ok {
+			sliceIndex = len(r.slices)
+			sliceIndexes[node.Slice] = sliceIndex
+			r.slices = append(r.slices, node.Slice)
+		}
+		r.subTableIndexes = append(r.subTableIndexes, i)
+		r.tableToSlice[i] = sliceIndex
+		r.actualTables = append(r.actualTables, node.Table)
+	}
+
+	switch cfg.Type {
+	case HashRuleType:
+		r.shard = &HashShard{ShardNum: len(nodes)}
+	case ModRuleType:
+		r.shard = &ModShard{ShardNum: len(nodes)}
+	case HashModRuleType:
+		r.shard, err = NewHashModShard(cfg.HashFunction, len(nodes))
+	case GeneRuleType:
+		r.shard = NewGeneShard(cfg.Key, cfg.GeneColumns, cfg.GeneBits, len(nodes))
+	case InlineRuleType:
+		r.shard, err = NewInlineShardWithNames(cfg.AlgorithmExpression, cfg.Key, r.actualTables)
+	case ComplexRuleType:
+		r.shard, err = NewComplexInlineShardWithNames(cfg.AlgorithmExpression, cfg.Keys, r.actualTables)
+	case VolumeRangeRuleType, BoundaryRangeRuleType:
+		r.shard, err = newBoundaryRangeShardWithTables(cfg, len(nodes))
+	case StandardRuleType:
+		// created with the slices and tables above
+	default:
+		r.shard, err = NewCustomShard(cfg, len(nodes))
+	}
+	return err
+}
+
 func parseHashRuleSliceInfos(locations []int, slices []string) ([]int, map[int]int, error) {
 	var sumTables int
 	var subTableIndexs []int
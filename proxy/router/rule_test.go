@@ -458,3 +458,508 @@ func TestParseRule(t *testing.T) {
 		t.Fatal("nil error")
 	}
 }
+
+func TestParseInlineRule(t *testing.T) {
+	var s = `
+	{
+		"name": "gaea_namespace_1",
+		"online": true,
+		"read_only": true,
+		"allowed_dbs": {
+			"gaea": true
+		},
+		"slices": [
+			{
+				"name": "slice-0",
+				"user_name": "root",
+				"password": "root",
+				"master": "127.0.0.1:3306"
+			},
+			{
+				"name": "slice-1",
+				"user_name": "root",
+				"password": "root",
+				"master": "127.0.0.1:3307"
+			}
+		],
+		"shard_rules": [
+			{
+				"db": "gaea",
+				"table": "t_order",
+				"type": "inline",
+				"key": "user_id",
+				"algorithm_expression": "t_order_${user_id % 4}",
+				"locations": [
+					2,
+					2
+				],
+				"slices": [
+					"slice-0",
+					"slice-1"
+				]
+			},
+			{
+				"db": "gaea",
+				"table": "t_user",
+				"type": "inline",
+				"key": "name",
+				"algorithm_expression": "t_user_$->{Math.abs(name.hashCode()) % 4}",
+				"locations": [
+					2,
+					2
+				],
+				"slices": [
+					"slice-0",
+					"slice-1"
+				]
+			}
+		],
+		"default_slice": "slice-0"
+	}
+`
+	var namespace = new(models.Namespace)
+	if err := models.JSONDecode(namespace, []byte(s)); err != nil {
+		t.Fatal(err)
+	}
+
+	rt, err := NewRouter(namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		table      string
+		key        interface{}
+		tableIndex int
+		slice      string
+	}{
+		{"t_order", int64(5), 1, "slice-0"},
+		{"t_order", uint64(7), 3, "slice-1"},
+		{"t_order", "10", 2, "slice-1"},
+		{"t_user", "abc", 2, "slice-1"},
+		{"t_user", "hello", 2, "slice-1"},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%s_%v", test.table, test.key), func(t *testing.T) {
+			rule := rt.GetRule("gaea", test.table)
+			if rule.GetType() != InlineRuleType {
+				t.Fatalf("rule type not equal, expect: %s, actual: %s", InlineRuleType, rule.GetType())
+			}
+			tableIndex, err := rule.FindTableIndex(test.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tableIndex != test.tableIndex {
+				t.Errorf("table index not equal, expect: %d, actual: %d", test.tableIndex, tableIndex)
+			}
+			if slice := rule.GetSlice(rule.GetSliceIndexFromTableIndex(tableIndex)); slice != test.slice {
+				t.Errorf("slice not equal, expect: %s, actual: %s", test.slice, slice)
+			}
+		})
+	}
+
+	if _, err := rt.GetRule("gaea", "t_order").FindTableIndex(int64(-1)); err == nil {
+		t.Errorf("expect key out of range error")
+	}
+	if _, err := NewInlineShard("t_order_${id % 4}", "user_id", 4); err == nil {
+		t.Errorf("expect error for expression not referencing shard key")
+	}
+	if _, err := NewInlineShard("t_order_${user_id % 4}_${user_id % 2}", "user_id", 4); err == nil {
+		t.Errorf("expect error for multiple placeholders")
+	}
+}
+
+func TestParseStandardRule(t *testing.T) {
+	var s = `
+	{
+		"name": "gaea_namespace_1",
+		"online": true,
+		"read_only": true,
+		"allowed_dbs": {
+			"gaea": true
+		},
+		"slices": [
+			{
+				"name": "slice-0",
+				"user_name": "root",
+				"password": "root",
+				"master": "127.0.0.1:3306"
+			},
+			{
+				"name": "slice-1",
+				"user_name": "root",
+				"password": "root",
+				"master": "127.0.0.1:3307"
+			}
+		],
+		"shard_rules": [
+			{
+				"db": "gaea",
+				"table": "t_order",
+				"type": "standard",
+				"database_strategy": {
+					"type": "mod",
+					"key": "tenant_id"
+				},
+				"table_strategy": {
+					"type": "inline",
+					"key": "ORDER_ID",
+					"algorithm_expression": "t_order_${order_id % 3}"
+				},
+				"locations": [
+					3,
+					3
+				],
+				"slices": [
+					"slice-0",
+					"slice-1"
+				]
+			},
+			{
+				"db": "gaea",
+				"table": "t_user",
+				"type": "standard",
+				"database_strategy": {
+					"type": "mod",
+					"key": "user_id"
+				},
+				"table_strategy": {
+					"type": "inline",
+					"key": "user_id",
+					"algorithm_expression": "t_user_${user_id.intdiv(2) % 2}"
+				},
+				"locations": [
+					2,
+					2
+				],
+				"slices": [
+					"slice-0",
+					"slice-1"
+				]
+			}
+		],
+		"default_slice": "slice-0"
+	}
+`
+	var namespace = new(models.Namespace)
+	if err := models.JSONDecode(namespace, []byte(s)); err != nil {
+		t.Fatal(err)
+	}
+
+	rt, err := NewRouter(namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orderRule := rt.GetRule("gaea", "t_order")
+	if orderRule.GetType() != StandardRuleType {
+		t.Fatalf("rule type not equal, expect: %s, actual: %s", StandardRuleType, orderRule.GetType())
+	}
+	if columns := orderRule.GetShardingColumns(); len(columns) != 2 || columns[0] != "tenant_id" || columns[1] != "order_id" {
+		t.Errorf("sharding columns not equal, actual: %v", columns)
+	}
+	if !orderRule.IsShardingColumn("order_id") || orderRule.IsShardingColumn("id") {
+		t.Errorf("check sharding column error")
+	}
+	if _, err := orderRule.FindTableIndex(1); err == nil {
+		t.Errorf("expect error when find table index without column")
+	}
+	orderShard := orderRule.GetShard().(ComplexShard)
+	if index, err := orderShard.FindForKeys(map[string]interface{}{"tenant_id": int64(3), "order_id": int64(4)}); err != nil || index != 4 {
+		t.Errorf("find table index by keys error, index: %d, err: %v", index, err)
+	}
+	if _, err := orderShard.FindForKeys(map[string]interface{}{"tenant_id": int64(3)}); err == nil {
+		t.Errorf("expect error when find table index without table strategy column")
+	}
+	// database strategy and table strategy use the same column
+	if index, err := rt.GetRule("gaea", "t_user").FindTableIndex(int64(3)); err != nil || index != 3 {
+		t.Errorf("find table index of single column error, index: %d, err: %v", index, err)
+	}
+
+	tests := []struct {
+		table   string
+		column  string
+		key     interface{}
+		indexes []int
+	}{
+		{"t_order", "tenant_id", int64(3), []int{3, 4, 5}},
+		{"t_order", "order_id", int64(4), []int{1, 4}},
+		{"t_order", "id", int64(4), []int{0, 1, 2, 3, 4, 5}},
+		{"t_user", "user_id", int64(0), []int{0}},
+		{"t_user", "user_id", int64(3), []int{3}},
+		{"t_user", "user_id", int64(6), []int{1}},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%s_%s_%v", test.table, test.column, test.key), func(t *testing.T) {
+			indexes, err := rt.GetRule("gaea", test.table).FindTableIndexes(test.column, test.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(indexes) != fmt.Sprint(test.indexes) {
+				t.Errorf("table indexes not equal, expect: %v, actual: %v", test.indexes, indexes)
+			}
+		})
+	}
+}
+
+func TestParseActualDataNodesRule(t *testing.T) {
+	var s = `
+	{
+		"name": "gaea_namespace_1",
+		"online": true,
+		"read_only": true,
+		"allowed_dbs": {
+			"gaea": true
+		},
+		"slices": [
+			{
+				"name": "slice-0",
+				"user_name": "root",
+				"password": "root",
+				"master": "127.0.0.1:3306"
+			},
+			{
+				"name": "slice-1",
+				"user_name": "root",
+				"password": "root",
+				"master": "127.0.0.1:3307"
+			}
+		],
+		"shard_rules": [
+			{
+				"db": "gaea",
+				"table": "t_order",
+				"type": "mod",
+				"key": "id",
+				"actual_data_nodes": "slice-1.t_order_${0..1}, slice-0.t_order_x"
+			},
+			{
+				"db": "gaea",
+				"table": "t_log",
+				"type": "inline",
+				"key": "log_date",
+				"algorithm_expression": "t_log_${log_date.substring(0, 6)}",
+				"actual_data_nodes": "slice-0.t_log_${201901..201906}, slice-1.t_log_${201907..201912}"
+			},
+			{
+				"db": "gaea",
+				"table": "t_item",
+				"type": "standard",
+				"database_strategy": {
+					"type": "inline",
+					"key": "tenant_id",
+					"algorithm_expression": "slice-${tenant_id % 2}"
+				},
+				"table_strategy": {
+					"type": "inline",
+					"key": "item_id",
+					"algorithm_expression": "t_item_${['a', 'b', 'c'][item_id % 3]}"
+				},
+				"actual_data_nodes": "slice-${[1, 0]}.t_item_${['a', 'b', 'c']}"
+			}
+		],
+		"default_slice": "slice-0"
+	}
+`
+	var namespace = new(models.Namespace)
+	if err := models.JSONDecode(namespace, []byte(s)); err != nil {
+		t.Fatal(err)
+	}
+
+	rt, err := NewRouter(namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkSlices := func(table string, slices []string) {
+		if actual := rt.GetRule("gaea", table).GetSlices(); fmt.Sprint(actual) != fmt.Sprint(slices) {
+			t.Errorf("slices of %s not equal, expect: %v, actual: %v", table, slices, actual)
+		}
+	}
+	checkSlices("t_order", []string{"slice-1", "slice-0"})
+	checkSlices("t_log", []string{"slice-0", "slice-1"})
+	checkSlices("t_item", []string{"slice-1", "slice-0"})
+
+	tests := []struct {
+		table       string
+		column      string
+		key         interface{}
+		tableIndex  int
+		slice       string
+		actualTable string
+	}{
+		{"t_order", "id", int64(3), 0, "slice-1", "t_order_0"},
+		{"t_order", "id", int64(5), 2, "slice-0", "t_order_x"},
+		{"t_log", "log_date", "20190312", 2, "slice-0", "t_log_201903"},
+		{"t_log", "log_date", "2019-12-01", -1, "", ""},
+		{"t_log", "log_date", "20191201", 11, "slice-1", "t_log_201912"},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%s_%v", test.table, test.key), func(t *testing.T) {
+			rule := rt.GetRule("gaea", test.table)
+			indexes, err := rule.FindTableIndexes(test.column, test.key)
+			if test.tableIndex == -1 {
+				if err == nil {
+					t.Errorf("expect error for key %v", test.key)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(indexes) != 1 || indexes[0] != test.tableIndex {
+				t.Fatalf("table index not equal, expect: %d, actual: %v", test.tableIndex, indexes)
+			}
+			if slice := rule.GetSlice(rule.GetSliceIndexFromTableIndex(test.tableIndex)); slice != test.slice {
+				t.Errorf("slice not equal, expect: %s, actual: %s", test.slice, slice)
+			}
+			if actualTable, ok := rule.GetActualTableName(test.tableIndex); !ok || actualTable != test.actualTable {
+				t.Errorf("actual table not equal, expect: %s, actual: %s", test.actualTable, actualTable)
+			}
+		})
+	}
+
+	// standard: slice-1 is the first slice, and the tables in each slice are t_item_a, t_item_b, t_item_c
+	itemRule := rt.GetRule("gaea", "t_item")
+	indexes, err := itemRule.FindTableIndexes("tenant_id", int64(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(indexes) != fmt.Sprint([]int{0, 1, 2}) {
+		t.Errorf("table indexes not equal, actual: %v", indexes)
+	}
+	indexes, err = itemRule.FindTableIndexes("item_id", int64(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(indexes) != fmt.Sprint([]int{1, 4}) {
+		t.Errorf("table indexes not equal, actual: %v", indexes)
+	}
+	if actualTable, _ := itemRule.GetActualTableName(4); actualTable != "t_item_b" {
+		t.Errorf("actual table not equal, expect: t_item_b, actual: %s", actualTable)
+	}
+	if slice := itemRule.GetSlice(itemRule.GetSliceIndexFromTableIndex(4)); slice != "slice-0" {
+		t.Errorf("slice not equal, expect: slice-0, actual: %s", slice)
+	}
+	if _, ok := rt.GetRule("gaea", "t_not_exists").GetActualTableName(0); ok {
+		t.Errorf("expect no actual table of default rule")
+	}
+}
+
+func TestParseBindingTables(t *testing.T) {
+	var s = `
+	{
+		"name": "gaea_namespace_1",
+		"online": true,
+		"read_only": true,
+		"allowed_dbs": {
+			"gaea": true
+		},
+		"slices": [
+			{
+				"name": "slice-0",
+				"user_name": "root",
+				"password": "root",
+				"master": "127.0.0.1:3306"
+			},
+			{
+				"name": "slice-1",
+				"user_name": "root",
+				"password": "root",
+				"master": "127.0.0.1:3307"
+			}
+		],
+		"shard_rules": [
+			{
+				"db": "gaea",
+				"table": "t_order",
+				"type": "mod",
+				"key": "order_id",
+				"locations": [2, 2],
+				"slices": ["slice-0", "slice-1"]
+			},
+			{
+				"db": "gaea",
+				"table": "T_Order_Item",
+				"type": "mod",
+				"key": "order_id",
+				"locations": [2, 2],
+				"slices": ["slice-0", "slice-1"]
+			},
+			{
+				"db": "gaea",
+				"table": "t_order_child",
+				"type": "linked",
+				"key": "order_id",
+				"parent_table": "t_order"
+			},
+			{
+				"db": "gaea",
+				"table": "t_user",
+				"type": "mod",
+				"key": "user_id",
+				"locations": [2, 2],
+				"slices": ["slice-1", "slice-0"]
+			},
+			{
+				"db": "gaea",
+				"table": "t_log",
+				"type": "range",
+				"key": "id",
+				"locations": [2, 2],
+				"slices": ["slice-0", "slice-1"],
+				"table_row_limit": 100
+			},
+			{
+				"db": "gaea",
+				"table": "t_log_item",
+				"type": "range",
+				"key": "log_id",
+				"locations": [2, 2],
+				"slices": ["slice-0", "slice-1"],
+				"table_row_limit": 1000
+			}
+		],
+		"binding_tables": [
+			{
+				"db": "gaea",
+				"tables": ["t_order", "t_order_item"]
+			}
+		],
+		"default_slice": "slice-0"
+	}
+`
+	var namespace = new(models.Namespace)
+	if err := models.JSONDecode(namespace, []byte(s)); err != nil {
+		t.Fatal(err)
+	}
+
+	rt, err := NewRouter(namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for table, routeTable := range map[string]string{"t_order": "t_order", "t_order_item": "t_order", "t_order_child": "t_order", "t_user": "t_user"} {
+		if actual := rt.GetRule("gaea", table).GetRouteTable(); actual != routeTable {
+			t.Errorf("route table of %s not equal, expect: %s, actual: %s", table, routeTable, actual)
+		}
+	}
+
+	// t_user has different topology with t_order
+	namespace.BindingTables = append(namespace.BindingTables, &models.BindingTableGroup{DB: "gaea", Tables: []string{"t_user", "t_order"}})
+	if _, err := NewRouter(namespace); err == nil {
+		t.Errorf("expect duplicate binding table error")
+	}
+	namespace.BindingTables = []*models.BindingTableGroup{{DB: "gaea", Tables: []string{"t_order", "t_user"}}}
+	if _, err := NewRouter(namespace); err == nil {
+		t.Errorf("expect different topology error")
+	}
+	namespace.BindingTables = []*models.BindingTableGroup{{DB: "gaea", Tables: []string{"t_order", "t_order_child"}}}
+	if _, err := NewRouter(namespace); err == nil {
+		t.Errorf("expect linked table error")
+	}
+	// t_log and t_log_item have the same topology, but different table_row_limit
+	namespace.BindingTables = []*models.BindingTableGroup{{DB: "gaea", Tables: []string{"t_log", "t_log_item"}}}
+	if _, err := NewRouter(namespace); err == nil {
+		t.Errorf("expect different sharding algorithm error")
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package router
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/XiaoMi/Gaea/models"
+	"github.com/XiaoMi/Gaea/provider"
+)
+
+// RangeFinderShard is a shard which finds the table indexes of range condition by itself
+type RangeFinderShard interface {
+	Shard
+	FindForRange(r provider.KeyRange) ([]int, error)
+}
+
+// CustomShard is the shard of custom:<name> rule, the table index is computed by the registered sharding algorithm
+type CustomShard struct {
+	name       string
+	sharding   provider.Sharding
+	tableCount int
+}
+
+// NewCustomShard constructor of CustomShard
+func NewCustomShard(cfg *models.Shard, tableCount int) (*CustomShard, error) {
+	name, ok := models.GetCustomShardName(cfg.Type)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a custom shard type", cfg.Type)
+	}
+	alg, err := provider.LoadShardingAlgorithm(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := alg.Verify(cfg.Properties, tableCount); err != nil {
+		return nil, err
+	}
+	sharding, err := alg.Create(cfg.Properties, tableCount)
+	if err != nil {
+		return nil, err
+	}
+	return &CustomShard{name: name, sharding: sharding, tableCount: tableCount}, nil
+}
+
+// FindForKey return the table index computed by sharding algorithm
+func (s *CustomShard) FindForKey(key interface{}) (int, error) {
+	index, err := s.sharding.FindForKey(key)
+	if err != nil {
+		return -1, err
+	}
+	if err := s.checkIndex(index); err != nil {
+		return -1, err
+	}
+	return index, nil
+}
+
+// FindForRange return the sorted table indexes computed by sharding algorithm
+func (s *CustomShard) FindForRange(r provider.KeyRange) ([]int, error) {
+	indexes, err := s.sharding.FindForRange(r)
+	if err != nil {
+		return nil, err
+	}
+	exists := make(map[int]bool, len(indexes))
+	ret := make([]int, 0, len(indexes))
+	for _, index := range indexes {
+		if err := s.checkIndex(index); err != nil {
+			return nil, err
+		}
+		if !exists[index] {
+			exists[index] = true
+			ret = append(ret, index)
+		}
+	}
+	sort.Ints(ret)
+	return ret, nil
+}
+
+func (s *CustomShard) checkIndex(index int) error {
+	if index < 0 || index >= s.tableCount {
+		return fmt.Errorf("sharding algorithm %s return invalid table index %d, table count: %d", s.name, index, s.tableCount)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package router
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/XiaoMi/Gaea/util/hack"
+)
+
+// GeneShard compute the table index by the gene, which is the low bits of the sharding key, mod the count of tables.
+// The ids of gene columns generated by the global sequence carry the gene of the sharding key of the same row,
+// so the rows can be routed by the sharding key or any of the gene columns, and all of them get the same table.
+type GeneShard struct {
+	ShardNum int
+	bits     uint
+	columns  []string // the sharding key and the gene columns
+}
+
+// NewGeneShard constructor of GeneShard
+func NewGeneShard(key string, geneColumns []string, bits int, shardNum int) *GeneShard {
+	columns := []string{strings.ToLower(key)}
+	for _, c := range geneColumns {
+		columns = append(columns, strings.ToLower(c))
+	}
+	return &GeneShard{
+		ShardNum: shardNum,
+		bits:     uint(bits),
+		columns:  columns,
+	}
+}
+
+// FindForKey return the table index of the gene of key
+func (s *GeneShard) FindForKey(key interface{}) (int, error) {
+	gene, err := s.GetGene(key)
+	if err != nil {
+		return -1, err
+	}
+	return int(gene % uint64(s.ShardNum)), nil
+}
+
+// GetShardingColumns return the sharding key and the gene columns
+func (s *GeneShard) GetShardingColumns() []string {
+	return s.columns
+}
+
+// FindForColumnKey return the table index of the gene, all the tables are returned if the column is not a sharding column
+func (s *GeneShard) FindForColumnKey(column string, key interface{}) ([]int, error) {
+	if !s.IsGeneColumn(column) && column != s.columns[0] {
+		return makeIndexes(s.ShardNum), nil
+	}
+	index, err := s.FindForKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return []int{index}, nil
+}
+
+// IsGeneColumn check if the column is a gene column, the sharding key is not a gene column
+func (s *GeneShard) IsGeneColumn(column string) bool {
+	for _, c := range s.columns[1:] {
+		if c == column {
+			return true
+		}
+	}
+	return false
+}
+
+// GetGeneBits return the count of gene bits
+func (s *GeneShard) GetGeneBits() int {
+	return int(s.bits)
+}
+
+// GetGene return the low bits of the integer key
+func (s *GeneShard) GetGene(key interface{}) (uint64, error) {
+	var v uint64
+	switch val := key.(type) {
+	case int:
+		v = uint64(val)
+	case int64:
+		v = uint64(val)
+	case uint64:
+		v = val
+	case string:
+		n, err := parseGeneKey(val)
+		if err != nil {
+			return 0, err
+		}
+		v = n
+	case []byte:
+		n, err := parseGeneKey(hack.String(val))
+		if err != nil {
+			return 0, err
+		}
+		v = n
+	default:
+		return 0, NewKeyError("Unexpected key variable type %T", key)
+	}
+	return v & (1<<s.bits - 1), nil
+}
+
+func parseGeneKey(s string) (uint64, error) {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return uint64(n), nil
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, NewKeyError("invalid num format %s", s)
+	}
+	return n, nil
+}
@@ -0,0 +1,92 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package router
+
+import (
+	"hash/crc32"
+	"strconv"
+
+	"github.com/XiaoMi/Gaea/models"
+	"github.com/XiaoMi/Gaea/util"
+	"github.com/XiaoMi/Gaea/util/hack"
+)
+
+// HashModShard compute the table index by the hash of the string of key mod the count of tables.
+// The key is always hashed as string, a number key is converted to its decimal string,
+// so the client can compute the same table index from the raw value.
+type HashModShard struct {
+	ShardNum int
+	hash     func(b []byte) uint64
+}
+
+// NewHashModShard constructor of HashModShard
+func NewHashModShard(hashFunction string, shardNum int) (*HashModShard, error) {
+	if err := models.VerifyHashFunction(hashFunction); err != nil {
+		return nil, err
+	}
+	s := &HashModShard{ShardNum: shardNum}
+	switch hashFunction {
+	case models.HashFunctionCRC32:
+		s.hash = func(b []byte) uint64 {
+			return uint64(crc32.ChecksumIEEE(b))
+		}
+	case models.HashFunctionMurmur3:
+		murmur := util.NewMurmurHash(0)
+		s.hash = func(b []byte) uint64 {
+			// unsigned, the same as Integer.toUnsignedLong(hash) in java
+			return uint64(uint32(murmur.HashBytes(b)))
+		}
+	case models.HashFunctionXXHash64:
+		s.hash = func(b []byte) uint64 {
+			return util.XXHash64(b, 0)
+		}
+	case models.HashFunctionJavaHashCode:
+		s.hash = func(b []byte) uint64 {
+			// the same as Math.abs((long) hashCode) in HASH_MOD algorithm of ShardingSphere
+			h := int64(util.JavaStringHashCode(hack.String(b)))
+			if h < 0 {
+				h = -h
+			}
+			return uint64(h)
+		}
+	}
+	return s, nil
+}
+
+// FindForKey return the table index of key
+func (s *HashModShard) FindForKey(key interface{}) (int, error) {
+	b, err := getHashModKeyBytes(key)
+	if err != nil {
+		return -1, err
+	}
+	return int(s.hash(b) % uint64(s.ShardNum)), nil
+}
+
+// getHashModKeyBytes return the UTF-8 bytes of the string of key
+func getHashModKeyBytes(key interface{}) ([]byte, error) {
+	switch val := key.(type) {
+	case int:
+		return strconv.AppendInt(nil, int64(val), 10), nil
+	case int64:
+		return strconv.AppendInt(nil, val, 10), nil
+	case uint64:
+		return strconv.AppendUint(nil, val, 10), nil
+	case string:
+		return hack.Slice(val), nil
+	case []byte:
+		return val, nil
+	}
+	return nil, NewKeyError("Unexpected key variable type %T", key)
+}
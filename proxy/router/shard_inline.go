@@ -0,0 +1,148 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package router
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/XiaoMi/Gaea/core/errors"
+	"github.com/XiaoMi/Gaea/models"
+	"github.com/XiaoMi/Gaea/util/inline"
+)
+
+// InlineShard evaluate a groovy-like expression such as t_order_${user_id % 16} to get the table index
+type InlineShard struct {
+	key      string
+	expr     *inline.Expression
+	indexes  map[string]int // name to index, nil means the placeholder is evaluated to the index
+	ShardNum int
+}
+
+// NewInlineShard constructor of InlineShard
+func NewInlineShard(expr string, key string, shardNum int) (*InlineShard, error) {
+	e, err := models.ParseInlineExpression(expr, key)
+	if err != nil {
+		return nil, err
+	}
+	return &InlineShard{key: key, expr: e, ShardNum: shardNum}, nil
+}
+
+// NewInlineShardWithNames create InlineShard whose expression is evaluated to a name, such as the table name of data nodes,
+// the index of the name in names is the table index.
+func NewInlineShardWithNames(expr string, key string, names []string) (*InlineShard, error) {
+	s, err := NewInlineShard(expr, key, len(names))
+	if err != nil {
+		return nil, err
+	}
+	if s.indexes, err = makeNameIndexes(names); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// FindForKey return the table index evaluated by the expression
+func (s *InlineShard) FindForKey(key interface{}) (int, error) {
+	return evaluateInlineIndex(s.expr, map[string]interface{}{s.key: key}, s.indexes, s.ShardNum)
+}
+
+func makeNameIndexes(names []string) (map[string]int, error) {
+	indexes := make(map[string]int, len(names))
+	for i, name := range names {
+		if _, ok := indexes[name]; ok {
+			return nil, fmt.Errorf("duplicate name %s", name)
+		}
+		indexes[name] = i
+	}
+	return indexes, nil
+}
+
+// evaluateInlineIndex evaluate the expression to the index in [0, shardNum).
+// If indexes is not nil, the expression is evaluated to a name and the index of the name is returned.
+func evaluateInlineIndex(expr *inline.Expression, vars map[string]interface{}, indexes map[string]int, shardNum int) (int, error) {
+	if indexes != nil {
+		name, err := expr.Evaluate(vars)
+		if err != nil {
+			return -1, NewKeyError("%v", err)
+		}
+		index, ok := indexes[name]
+		if !ok {
+			return -1, NewKeyError("%s evaluated by %s is not found", name, expr)
+		}
+		return index, nil
+	}
+
+	index, err := expr.EvaluateInt(vars)
+	if err != nil {
+		return -1, NewKeyError("%v", err)
+	}
+	if index < 0 || index >= int64(shardNum) {
+		return -1, errors.ErrKeyOutOfRange
+	}
+	return int(index), nil
+}
+
+// ComplexInlineShard evaluate a groovy-like expression over several columns, such as t_order_${(region_id * 16 + user_id) % 4}
+type ComplexInlineShard struct {
+	keys     []string
+	expr     *inline.Expression
+	indexes  map[string]int // name to index, nil means the placeholder is evaluated to the index
+	ShardNum int
+}
+
+// NewComplexInlineShard constructor of ComplexInlineShard
+func NewComplexInlineShard(expr string, keys []string, shardNum int) (*ComplexInlineShard, error) {
+	e, err := models.ParseInlineExpression(expr, keys...)
+	if err != nil {
+		return nil, err
+	}
+	s := &ComplexInlineShard{expr: e, ShardNum: shardNum}
+	for _, key := range keys {
+		s.keys = append(s.keys, strings.ToLower(key))
+	}
+	return s, nil
+}
+
+// NewComplexInlineShardWithNames create ComplexInlineShard whose expression is evaluated to a name, such as the table name of data nodes
+func NewComplexInlineShardWithNames(expr string, keys []string, names []string) (*ComplexInlineShard, error) {
+	s, err := NewComplexInlineShard(expr, keys, len(names))
+	if err != nil {
+		return nil, err
+	}
+	if s.indexes, err = makeNameIndexes(names); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// FindForKey is not supported, since the values of all sharding columns are needed
+func (s *ComplexInlineShard) FindForKey(key interface{}) (int, error) {
+	return -1, NewKeyError("complex shard must find table index with all sharding columns")
+}
+
+// GetShardingColumns return all the sharding columns
+func (s *ComplexInlineShard) GetShardingColumns() []string {
+	return s.keys
+}
+
+// FindForColumnKey return all the table indexes, since the value of one column cannot decide the table
+func (s *ComplexInlineShard) FindForColumnKey(column string, key interface{}) ([]int, error) {
+	return makeIndexes(s.ShardNum), nil
+}
+
+// FindForKeys return the table index evaluated by the expression, keys must contain all the sharding columns
+func (s *ComplexInlineShard) FindForKeys(keys map[string]interface{}) (int, error) {
+	return evaluateInlineIndex(s.expr, keys, s.indexes, s.ShardNum)
+}
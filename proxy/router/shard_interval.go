@@ -0,0 +1,221 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package router
+
+import (
+	"time"
+
+	"github.com/XiaoMi/Gaea/core/errors"
+	"github.com/XiaoMi/Gaea/models"
+	"github.com/XiaoMi/Gaea/util/hack"
+)
+
+// ComputedTableShard is a shard whose tables are computed by itself instead of the locations in config,
+// the tables may grow over time.
+type ComputedTableShard interface {
+	Shard
+	GetTableIndexes() []int
+	GetSliceIndex(tableIndex int) int
+	GetActualTableName(tableIndex int) (string, bool)
+}
+
+// ClampedRangeShard is a RangeShard whose key out of range is clamped to the first or the last table in range conditions
+type ClampedRangeShard interface {
+	RangeShard
+	FindForRangeKey(key interface{}) (int, error)
+}
+
+// IntervalShard compute the table index by the count of datetime intervals from the lower bound.
+// The tables are distributed to slices in turn, and the table of interval shard is named by the suffix pattern,
+// while the table of auto_interval shard is named by table index.
+// If there is no upper bound, the last table is the one of current time.
+type IntervalShard struct {
+	interval    *models.DatetimeInterval
+	tablePrefix string // empty for auto_interval shard
+	upperIndex  int    // -1 means no upper bound
+	sliceCount  int
+
+	now func() time.Time
+}
+
+// NewIntervalShard constructor of IntervalShard
+func NewIntervalShard(cfg *models.Shard) (*IntervalShard, error) {
+	interval, err := models.ParseDatetimeInterval(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.Slices) == 0 {
+		return nil, errors.ErrLocationsCount
+	}
+	s := &IntervalShard{
+		interval:   interval,
+		upperIndex: -1,
+		sliceCount: len(cfg.Slices),
+		now:        time.Now,
+	}
+	if cfg.Type == IntervalRuleType {
+		s.tablePrefix = cfg.Table + "_"
+	}
+	if !interval.Upper.IsZero() {
+		s.upperIndex = s.getIndex(interval.Upper)
+	}
+	return s, nil
+}
+
+// FindForKey return the table index of the datetime, the key can be datetime string or unix timestamp
+func (s *IntervalShard) FindForKey(key interface{}) (int, error) {
+	t, err := parseDatetimeKey(key)
+	if err != nil {
+		return -1, err
+	}
+	if t.Before(s.interval.Lower) || (s.upperIndex != -1 && t.After(s.interval.Upper)) {
+		return -1, errors.ErrKeyOutOfRange
+	}
+	return s.getIndex(t), nil
+}
+
+// FindForRangeKey return the table index of the datetime, clamped to the first or the last table
+func (s *IntervalShard) FindForRangeKey(key interface{}) (int, error) {
+	t, err := parseDatetimeKey(key)
+	if err != nil {
+		return -1, err
+	}
+	if t.Before(s.interval.Lower) {
+		return 0, nil
+	}
+	index := s.getIndex(t)
+	if last := s.getLastIndex(); index > last {
+		return last, nil
+	}
+	return index, nil
+}
+
+// EqualStart check if the datetime is the start of the interval of table index
+func (s *IntervalShard) EqualStart(key interface{}, index int) bool {
+	t, err := parseDatetimeKey(key)
+	if err != nil {
+		return false
+	}
+	return t.Equal(s.getStart(index))
+}
+
+// GetTableIndexes return the indexes from the lower bound to the upper bound, or to current time if there is no upper bound
+func (s *IntervalShard) GetTableIndexes() []int {
+	return makeIndexes(s.getLastIndex() + 1)
+}
+
+// GetSliceIndex return the slice index of table, the tables are distributed to slices in turn
+func (s *IntervalShard) GetSliceIndex(tableIndex int) int {
+	if tableIndex < 0 || (s.upperIndex != -1 && tableIndex > s.upperIndex) {
+		return -1
+	}
+	return tableIndex % s.sliceCount
+}
+
+// GetActualTableName return the table name with the suffix of interval start, such as t_order_201901
+func (s *IntervalShard) GetActualTableName(tableIndex int) (string, bool) {
+	if s.tablePrefix == "" || tableIndex < 0 {
+		return "", false
+	}
+	return s.tablePrefix + models.FormatSuffix(s.interval.SuffixPattern, s.getStart(tableIndex)), true
+}
+
+func (s *IntervalShard) getLastIndex() int {
+	if s.upperIndex != -1 {
+		return s.upperIndex
+	}
+	now := s.now()
+	if now.Before(s.interval.Lower) {
+		return 0
+	}
+	return s.getIndex(now)
+}
+
+// getIndex return the table index of the datetime which is not before the lower bound
+func (s *IntervalShard) getIndex(t time.Time) int {
+	lower := s.interval.Lower
+	var n int
+	switch s.interval.Unit {
+	case models.IntervalHour:
+		n = int(t.Sub(lower) / time.Hour)
+	case models.IntervalDay:
+		n = daysBetween(lower, t)
+	case models.IntervalWeek:
+		n = daysBetween(lower, t) / 7
+	case models.IntervalMonth:
+		n = monthsBetween(lower, t)
+	case models.IntervalQuarter:
+		n = monthsBetween(lower, t) / 3
+	case models.IntervalYear:
+		n = t.Year() - lower.Year()
+	}
+	return n / s.interval.Amount
+}
+
+// getStart return the start datetime of the interval of table index
+func (s *IntervalShard) getStart(index int) time.Time {
+	lower := s.interval.Lower
+	n := index * s.interval.Amount
+	switch s.interval.Unit {
+	case models.IntervalHour:
+		return lower.Add(time.Duration(n) * time.Hour)
+	case models.IntervalDay:
+		return lower.AddDate(0, 0, n)
+	case models.IntervalWeek:
+		return lower.AddDate(0, 0, 7*n)
+	case models.IntervalMonth:
+		return lower.AddDate(0, n, 0)
+	case models.IntervalQuarter:
+		return lower.AddDate(0, 3*n, 0)
+	default:
+		return lower.AddDate(n, 0, 0)
+	}
+}
+
+// daysBetween return the count of calendar days from the date of start to the date of end
+func daysBetween(start, end time.Time) int {
+	s := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+	e := time.Date(end.Year(), end.Month(), end.Day(), 0, 0, 0, 0, time.UTC)
+	return int(e.Sub(s).Hours() / 24)
+}
+
+func monthsBetween(start, end time.Time) int {
+	return (end.Year()-start.Year())*12 + int(end.Month()) - int(start.Month())
+}
+
+// parseDatetimeKey parse the datetime string or unix timestamp
+func parseDatetimeKey(key interface{}) (time.Time, error) {
+	switch val := key.(type) {
+	case int:
+		return time.Unix(int64(val), 0), nil
+	case int64:
+		return time.Unix(val, 0), nil
+	case uint64:
+		return time.Unix(int64(val), 0), nil
+	case string:
+		t, err := models.ParseDatetime(val)
+		if err != nil {
+			return time.Time{}, NewInvalidDateFormatKeyError(key)
+		}
+		return t, nil
+	case []byte:
+		t, err := models.ParseDatetime(hack.String(val))
+		if err != nil {
+			return time.Time{}, NewInvalidDateFormatKeyError(key)
+		}
+		return t, nil
+	}
+	return time.Time{}, NewKeyError("Unexpected key variable type %T", key)
+}
@@ -0,0 +1,127 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package router
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/XiaoMi/Gaea/models"
+	"github.com/XiaoMi/Gaea/util/hack"
+)
+
+// BoundaryRangeShard is the shard of volume_range and boundary_range rule.
+// Table 0 holds the keys less than the first boundary, table i holds the keys in [boundary[i-1], boundary[i]),
+// and the last table holds the keys not less than the last boundary, so every key can be routed.
+type BoundaryRangeShard struct {
+	boundaries *models.RangeBoundaries
+}
+
+// NewBoundaryRangeShard constructor of BoundaryRangeShard
+func NewBoundaryRangeShard(cfg *models.Shard) (*BoundaryRangeShard, error) {
+	boundaries, err := models.ParseRangeBoundaries(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &BoundaryRangeShard{boundaries: boundaries}, nil
+}
+
+// newBoundaryRangeShardWithTables create the shard and check the count of ranges is equal to the count of tables
+func newBoundaryRangeShardWithTables(cfg *models.Shard, tableCount int) (*BoundaryRangeShard, error) {
+	shard, err := NewBoundaryRangeShard(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if shard.PartitionCount() != tableCount {
+		return nil, fmt.Errorf("range space %d not equal tables %d", shard.PartitionCount(), tableCount)
+	}
+	return shard, nil
+}
+
+// FindForKey return the index of the range containing the key
+func (s *BoundaryRangeShard) FindForKey(key interface{}) (int, error) {
+	v, err := parseRangeKey(s.boundaries.Type, key)
+	if err != nil {
+		return -1, err
+	}
+	values := s.boundaries.Values
+	return sort.Search(len(values), func(i int) bool {
+		return values[i].Compare(v) > 0
+	}), nil
+}
+
+// EqualStart check if the key is the start of the range of index, the underflow range has no start
+func (s *BoundaryRangeShard) EqualStart(key interface{}, index int) bool {
+	if index <= 0 || index > len(s.boundaries.Values) {
+		return false
+	}
+	v, err := parseRangeKey(s.boundaries.Type, key)
+	if err != nil {
+		return false
+	}
+	return s.boundaries.Values[index-1].Compare(v) == 0
+}
+
+// PartitionCount return the count of ranges, including the underflow and the overflow range
+func (s *BoundaryRangeShard) PartitionCount() int {
+	return s.boundaries.PartitionCount()
+}
+
+// parseRangeKey convert the key to the range value of the key type
+func parseRangeKey(typ string, key interface{}) (models.RangeValue, error) {
+	switch typ {
+	case models.RangeTypeInt:
+		switch val := key.(type) {
+		case int:
+			return models.RangeValue{Num: int64(val)}, nil
+		case int64:
+			return models.RangeValue{Num: val}, nil
+		case uint64:
+			// greater than any boundary
+			if val > math.MaxInt64 {
+				return models.RangeValue{Num: math.MaxInt64}, nil
+			}
+			return models.RangeValue{Num: int64(val)}, nil
+		case string:
+			return parseRangeIntKey(val)
+		case []byte:
+			return parseRangeIntKey(hack.String(val))
+		}
+	case models.RangeTypeString:
+		switch val := key.(type) {
+		case int, int64, uint64, string:
+			return models.RangeValue{Str: GetString(val)}, nil
+		case []byte:
+			return models.RangeValue{Str: string(val)}, nil
+		}
+	case models.RangeTypeDatetime:
+		t, err := parseDatetimeKey(key)
+		if err != nil {
+			return models.RangeValue{}, err
+		}
+		return models.RangeValue{Num: t.UnixNano()}, nil
+	}
+	return models.RangeValue{}, NewKeyError("Unexpected key variable type %T", key)
+}
+
+func parseRangeIntKey(s string) (models.RangeValue, error) {
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return models.RangeValue{}, NewKeyError("invalid num format %s", s)
+	}
+	return models.RangeValue{Num: v}, nil
+}
@@ -0,0 +1,188 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package router
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/XiaoMi/Gaea/core/errors"
+	"github.com/XiaoMi/Gaea/models"
+)
+
+// MultiColumnShard is a shard routed by more than one column.
+// The value of one column may only narrow the route to several tables.
+type MultiColumnShard interface {
+	Shard
+	GetShardingColumns() []string
+	FindForColumnKey(column string, key interface{}) ([]int, error)
+}
+
+// ComplexShard is a shard routed by the values of all its sharding columns
+type ComplexShard interface {
+	MultiColumnShard
+	FindForKeys(keys map[string]interface{}) (int, error)
+}
+
+// StandardShard compute the slice by database strategy and the table in slice by table strategy.
+// Each slice has the same count of tables, the table index is sliceIndex * tablesPerSlice + index of table in slice.
+type StandardShard struct {
+	databaseColumn string
+	databaseShard  Shard // nil means there is only one slice
+	tableColumn    string
+	tableShard     Shard // nil means there is only one table in each slice
+
+	sliceCount     int
+	tablesPerSlice int
+}
+
+// NewStandardShard constructor of StandardShard
+func NewStandardShard(databaseStrategy, tableStrategy *models.ShardStrategy, sliceCount, tablesPerSlice int) (*StandardShard, error) {
+	return newStandardShard(databaseStrategy, tableStrategy, sliceCount, tablesPerSlice, nil, nil)
+}
+
+// NewStandardShardWithNames create StandardShard of actual data nodes, the inline expression of database strategy
+// is evaluated to the slice name, and the inline expression of table strategy is evaluated to the table name.
+func NewStandardShardWithNames(databaseStrategy, tableStrategy *models.ShardStrategy, sliceNames, tableNames []string) (*StandardShard, error) {
+	return newStandardShard(databaseStrategy, tableStrategy, len(sliceNames), len(tableNames), sliceNames, tableNames)
+}
+
+func newStandardShard(databaseStrategy, tableStrategy *models.ShardStrategy, sliceCount, tablesPerSlice int, sliceNames, tableNames []string) (*StandardShard, error) {
+	s := &StandardShard{
+		sliceCount:     sliceCount,
+		tablesPerSlice: tablesPerSlice,
+	}
+	if databaseStrategy != nil {
+		shard, err := newStrategyShard(databaseStrategy, sliceCount, sliceNames)
+		if err != nil {
+			return nil, fmt.Errorf("create database strategy error: %v", err)
+		}
+		s.databaseColumn = strings.ToLower(databaseStrategy.Key)
+		s.databaseShard = shard
+	}
+	if tableStrategy != nil {
+		shard, err := newStrategyShard(tableStrategy, tablesPerSlice, tableNames)
+		if err != nil {
+			return nil, fmt.Errorf("create table strategy error: %v", err)
+		}
+		s.tableColumn = strings.ToLower(tableStrategy.Key)
+		s.tableShard = shard
+	}
+	return s, nil
+}
+
+// newStrategyShard create the shard of strategy, if names is not nil, inline expression is evaluated to one of the names
+func newStrategyShard(strategy *models.ShardStrategy, shardNum int, names []string) (Shard, error) {
+	switch strategy.Type {
+	case HashRuleType:
+		return &HashShard{ShardNum: shardNum}, nil
+	case ModRuleType:
+		return &ModShard{ShardNum: shardNum}, nil
+	case HashModRuleType:
+		return NewHashModShard(strategy.HashFunction, shardNum)
+	case InlineRuleType:
+		if names != nil {
+			return NewInlineShardWithNames(strategy.AlgorithmExpression, strategy.Key, names)
+		}
+		return NewInlineShard(strategy.AlgorithmExpression, strategy.Key, shardNum)
+	default:
+		return nil, errors.ErrUnknownRuleType
+	}
+}
+
+// FindForKey find the table index when the shard has only one sharding column,
+// otherwise the key of which column is unknown, and FindForKeys must be used.
+func (s *StandardShard) FindForKey(key interface{}) (int, error) {
+	columns := s.GetShardingColumns()
+	if len(columns) != 1 {
+		return -1, NewKeyError("standard shard must find table index with sharding column")
+	}
+	return s.FindForKeys(map[string]interface{}{columns[0]: key})
+}
+
+// FindForKeys find the table index by the values of database strategy column and table strategy column
+func (s *StandardShard) FindForKeys(keys map[string]interface{}) (int, error) {
+	sliceIndex, err := findStrategyIndex(s.databaseShard, s.databaseColumn, keys)
+	if err != nil {
+		return -1, err
+	}
+	tableIndex, err := findStrategyIndex(s.tableShard, s.tableColumn, keys)
+	if err != nil {
+		return -1, err
+	}
+	return sliceIndex*s.tablesPerSlice + tableIndex, nil
+}
+
+func findStrategyIndex(shard Shard, column string, keys map[string]interface{}) (int, error) {
+	if shard == nil {
+		return 0, nil
+	}
+	key, ok := keys[column]
+	if !ok {
+		return -1, NewKeyError("value of sharding column %s not found", column)
+	}
+	return shard.FindForKey(key)
+}
+
+// GetShardingColumns return the columns of database strategy and table strategy
+func (s *StandardShard) GetShardingColumns() []string {
+	var columns []string
+	if s.databaseShard != nil {
+		columns = append(columns, s.databaseColumn)
+	}
+	if s.tableShard != nil && s.tableColumn != s.databaseColumn {
+		columns = append(columns, s.tableColumn)
+	}
+	return columns
+}
+
+// FindForColumnKey return the sorted table indexes by the value of a column.
+// If the column is used by database strategy, only the tables in the slice are returned,
+// if it is used by table strategy, only the table in each slice is returned.
+func (s *StandardShard) FindForColumnKey(column string, key interface{}) ([]int, error) {
+	sliceIndexes := makeIndexes(s.sliceCount)
+	if s.databaseShard != nil && column == s.databaseColumn {
+		index, err := s.databaseShard.FindForKey(key)
+		if err != nil {
+			return nil, err
+		}
+		sliceIndexes = []int{index}
+	}
+
+	tableIndexes := makeIndexes(s.tablesPerSlice)
+	if s.tableShard != nil && column == s.tableColumn {
+		index, err := s.tableShard.FindForKey(key)
+		if err != nil {
+			return nil, err
+		}
+		tableIndexes = []int{index}
+	}
+
+	ret := make([]int, 0, len(sliceIndexes)*len(tableIndexes))
+	for _, sliceIndex := range sliceIndexes {
+		for _, tableIndex := range tableIndexes {
+			ret = append(ret, sliceIndex*s.tablesPerSlice+tableIndex)
+		}
+	}
+	return ret, nil
+}
+
+func makeIndexes(count int) []int {
+	ret := make([]int, count)
+	for i := 0; i < count; i++ {
+		ret[i] = i
+	}
+	return ret
+}
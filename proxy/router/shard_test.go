@@ -14,7 +14,17 @@
 
 package router
 
-import "testing"
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/XiaoMi/Gaea/core/errors"
+	"github.com/XiaoMi/Gaea/models"
+	"github.com/XiaoMi/Gaea/provider"
+)
 
 func TestGetString(t *testing.T) {
 	tests := []struct {
@@ -47,3 +57,367 @@ func TestGetString(t *testing.T) {
 		})
 	}
 }
+
+func TestComplexInlineShard(t *testing.T) {
+	shard, err := NewComplexInlineShard("t_${(region_id * 2 + USER_ID) % 4}", []string{"REGION_ID", "user_id"}, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if columns := shard.GetShardingColumns(); len(columns) != 2 || columns[0] != "region_id" || columns[1] != "user_id" {
+		t.Errorf("sharding columns not equal, actual: %v", columns)
+	}
+	if indexes, _ := shard.FindForColumnKey("region_id", 1); len(indexes) != 4 {
+		t.Errorf("expect all table indexes, actual: %v", indexes)
+	}
+	index, err := shard.FindForKeys(map[string]interface{}{"region_id": int64(1), "user_id": "3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index != 1 {
+		t.Errorf("table index not equal, expect: 1, actual: %d", index)
+	}
+	if _, err := shard.FindForKeys(map[string]interface{}{"region_id": int64(1)}); err == nil {
+		t.Errorf("expect error when sharding column is missing")
+	}
+	if _, err := NewComplexInlineShard("t_${(region_id + id) % 4}", []string{"region_id", "user_id"}, 4); err == nil {
+		t.Errorf("expect error for expression referencing non sharding column")
+	}
+}
+
+func TestIntervalShard(t *testing.T) {
+	tests := []struct {
+		unit        string
+		amount      int
+		lower       string
+		pattern     string
+		key         interface{}
+		index       int
+		actualTable string
+	}{
+		{models.IntervalHour, 1, "2019-01-01 10:00:00", "yyyyMMddHH", "2019-01-02 09:59:59", 23, "t_2019010209"},
+		{models.IntervalDay, 1, "2019-01-30", "yyyyMMdd", "2019-03-01 00:00:00", 30, "t_20190301"},
+		{models.IntervalDay, 10, "2019-01-01", "yyyy_MM_dd", "2019-01-21", 2, "t_2019_01_21"},
+		{models.IntervalWeek, 1, "2018-12-31", "yyyyMMdd", "2019-01-13 23:59:59", 1, "t_20190107"},
+		{models.IntervalMonth, 1, "2018-11-01", "yyyyMM", "2019-02-28", 3, "t_201902"},
+		{models.IntervalQuarter, 1, "2019-01-01", "yyyyQ", "2019-08-08", 2, "t_20193"},
+		{models.IntervalYear, 2, "2016-01-01", "yyyy", "2019-12-31 23:00:00", 1, "t_2018"},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%s_%v", test.unit, test.key), func(t *testing.T) {
+			shard, err := NewIntervalShard(&models.Shard{
+				Table:                  "t",
+				Type:                   IntervalRuleType,
+				Slices:                 []string{"slice-0", "slice-1"},
+				DatetimeLower:          test.lower,
+				DatetimeUpper:          "2030-01-01",
+				DatetimeInterval:       test.unit,
+				DatetimeIntervalAmount: test.amount,
+				SuffixPattern:          test.pattern,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			index, err := shard.FindForKey(test.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if index != test.index {
+				t.Errorf("table index not equal, expect: %d, actual: %d", test.index, index)
+			}
+			if actualTable, _ := shard.GetActualTableName(index); actualTable != test.actualTable {
+				t.Errorf("actual table not equal, expect: %s, actual: %s", test.actualTable, actualTable)
+			}
+			if shard.GetSliceIndex(index) != index%2 {
+				t.Errorf("slice index not equal, expect: %d, actual: %d", index%2, shard.GetSliceIndex(index))
+			}
+			if _, err := shard.FindForKey("2000-01-01"); err != errors.ErrKeyOutOfRange {
+				t.Errorf("expect key out of range error, actual: %v", err)
+			}
+			if _, err := shard.FindForKey("2030-12-31"); err != errors.ErrKeyOutOfRange {
+				t.Errorf("expect key out of range error, actual: %v", err)
+			}
+		})
+	}
+}
+
+func TestAutoIntervalShard(t *testing.T) {
+	shard, err := NewIntervalShard(&models.Shard{
+		Table:            "t",
+		Type:             AutoIntervalRuleType,
+		Slices:           []string{"slice-0"},
+		DatetimeLower:    "2019-01-01",
+		DatetimeInterval: models.IntervalMonth,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	shard.now = func() time.Time {
+		return time.Date(2019, 4, 15, 0, 0, 0, 0, time.Local)
+	}
+	if indexes := shard.GetTableIndexes(); fmt.Sprint(indexes) != "[0 1 2 3]" {
+		t.Errorf("table indexes not equal, actual: %v", indexes)
+	}
+	if _, ok := shard.GetActualTableName(1); ok {
+		t.Errorf("expect no actual table name of auto_interval shard")
+	}
+	// no upper bound, the table of future is allowed
+	if index, err := shard.FindForKey(time.Date(2020, 1, 1, 0, 0, 0, 0, time.Local).Unix()); err != nil || index != 12 {
+		t.Errorf("table index not equal, expect: 12, actual: %d, err: %v", index, err)
+	}
+
+	rangeTests := []struct {
+		key   interface{}
+		index int
+	}{
+		{"2018-01-01", 0},
+		{"2019-02-02 10:00:00", 1},
+		{"2020-01-01", 3},
+	}
+	for _, test := range rangeTests {
+		index, err := shard.FindForRangeKey(test.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if index != test.index {
+			t.Errorf("range table index of %v not equal, expect: %d, actual: %d", test.key, test.index, index)
+		}
+	}
+	if !shard.EqualStart("2019-03-01 00:00:00", 2) || shard.EqualStart("2019-03-01 00:00:01", 2) {
+		t.Errorf("check equal start error")
+	}
+	if _, err := shard.FindForKey("2019/01/01"); err == nil {
+		t.Errorf("expect invalid date format error")
+	}
+}
+
+func TestBoundaryRangeShard(t *testing.T) {
+	tests := []struct {
+		cfg     *models.Shard
+		keys    []interface{}
+		indexes []int
+	}{
+		{
+			cfg:     &models.Shard{Type: VolumeRangeRuleType, RangeLower: "10", RangeUpper: "45", ShardingVolume: "10"},
+			keys:    []interface{}{int64(-5), int64(9), int64(10), "19", []byte("20"), int64(44), int64(45), uint64(1 << 63)},
+			indexes: []int{0, 0, 1, 1, 2, 4, 5, 5},
+		},
+		{
+			cfg: &models.Shard{Type: VolumeRangeRuleType, RangeType: models.RangeTypeDatetime,
+				RangeLower: "2019-01-01", RangeUpper: "2019-01-03", ShardingVolume: "24h"},
+			keys:    []interface{}{"2018-12-31 23:59:59", "2019-01-01", "2019-01-02 12:00:00", "2019-01-03", "2020-01-01"},
+			indexes: []int{0, 1, 2, 3, 3},
+		},
+		{
+			cfg:     &models.Shard{Type: BoundaryRangeRuleType, ShardingRanges: []string{"-1", "100", "1000"}},
+			keys:    []interface{}{int64(-100), int64(-1), int64(99), uint64(100), int64(1000), int64(100000)},
+			indexes: []int{0, 1, 1, 2, 3, 3},
+		},
+		{
+			cfg:     &models.Shard{Type: BoundaryRangeRuleType, RangeType: models.RangeTypeString, ShardingRanges: []string{"g", "n", "t"}},
+			keys:    []interface{}{"a", "g", "hello", []byte("n"), "zoo", int64(1)},
+			indexes: []int{0, 1, 1, 2, 3, 0},
+		},
+		{
+			cfg: &models.Shard{Type: BoundaryRangeRuleType, RangeType: models.RangeTypeDatetime,
+				ShardingRanges: []string{"2019-01-01", "2020-01-01 00:00:00"}},
+			keys:    []interface{}{"2018-06-01", "2019-01-01 00:00:00", "20191231", "2020-01-01"},
+			indexes: []int{0, 1, 1, 2},
+		},
+	}
+	for _, test := range tests {
+		shard, err := NewBoundaryRangeShard(test.cfg)
+		if err != nil {
+			t.Fatalf("create shard error: %v", err)
+		}
+		for i, key := range test.keys {
+			index, err := shard.FindForKey(key)
+			if err != nil {
+				t.Fatalf("find key %v error: %v", key, err)
+			}
+			if index != test.indexes[i] {
+				t.Errorf("table index of %v not equal, expect: %d, actual: %d", key, test.indexes[i], index)
+			}
+		}
+	}
+
+	shard, err := NewBoundaryRangeShard(&models.Shard{Type: BoundaryRangeRuleType, ShardingRanges: []string{"100", "1000"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shard.EqualStart(int64(0), 0) || !shard.EqualStart(int64(100), 1) || shard.EqualStart(int64(101), 1) || !shard.EqualStart("1000", 2) {
+		t.Errorf("EqualStart not correct")
+	}
+	if _, err := shard.FindForKey("abc"); err == nil {
+		t.Errorf("expect invalid key error")
+	}
+}
+
+func TestHashModShard(t *testing.T) {
+	tests := []struct {
+		hashFunction string
+		key          interface{}
+		index        int
+	}{
+		{models.HashFunctionCRC32, "hello", 2},                     // 0x3610a686
+		{models.HashFunctionMurmur3, []byte("hello"), 3},           // 0x248bfa47
+		{models.HashFunctionXXHash64, "abc", 1},                    // 0x44bc2cf5ad770999
+		{models.HashFunctionJavaHashCode, "hello", 2},              // 99162322
+		{models.HashFunctionJavaHashCode, "polygenelubricants", 0}, // Integer.MIN_VALUE
+		{models.HashFunctionJavaHashCode, int64(12345), 3},         // "12345".hashCode() = 46792755
+		{models.HashFunctionJavaHashCode, uint64(12345), 3},
+	}
+	for _, test := range tests {
+		shard, err := NewHashModShard(test.hashFunction, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		index, err := shard.FindForKey(test.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if index != test.index {
+			t.Errorf("table index of %s(%v) not equal, expect: %d, actual: %d", test.hashFunction, test.key, test.index, index)
+		}
+	}
+
+	if _, err := NewHashModShard("md5", 4); err == nil {
+		t.Errorf("expect invalid hash function error")
+	}
+	shard, _ := NewHashModShard(models.HashFunctionCRC32, 4)
+	if _, err := shard.FindForKey(1.5); err == nil {
+		t.Errorf("expect unexpected key type error")
+	}
+}
+
+func TestGeneShard(t *testing.T) {
+	shard := NewGeneShard("user_id", []string{"ORDER_ID"}, 4, 4)
+	tests := []struct {
+		key   interface{}
+		index int
+	}{
+		{int64(33), 1},    // gene 1
+		{uint64(17), 1},   // 1<<4 | 1
+		{"46", 2},         // 2<<4 | 14
+		{[]byte("30"), 2}, // gene 14
+		{int64(-1), 3},    // gene 15
+		{"18446744073709551615", 3},
+	}
+	for _, test := range tests {
+		index, err := shard.FindForKey(test.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if index != test.index {
+			t.Errorf("table index of %v not equal, expect: %d, actual: %d", test.key, test.index, index)
+		}
+	}
+
+	if !reflect.DeepEqual(shard.GetShardingColumns(), []string{"user_id", "order_id"}) {
+		t.Errorf("sharding columns not equal, actual: %v", shard.GetShardingColumns())
+	}
+	if !shard.IsGeneColumn("order_id") || shard.IsGeneColumn("user_id") {
+		t.Errorf("check gene column failed")
+	}
+	indexes, err := shard.FindForColumnKey("order_id", int64(33))
+	if err != nil || !reflect.DeepEqual(indexes, []int{1}) {
+		t.Errorf("find for gene column failed, indexes: %v, err: %v", indexes, err)
+	}
+	indexes, err = shard.FindForColumnKey("name", "hello")
+	if err != nil || !reflect.DeepEqual(indexes, []int{0, 1, 2, 3}) {
+		t.Errorf("find for unsharding column failed, indexes: %v, err: %v", indexes, err)
+	}
+	if _, err := shard.FindForKey("abc"); err == nil {
+		t.Errorf("expect invalid num format error")
+	}
+}
+
+// testCellAlgorithm route the customer to the cell (table) configured in properties
+type testCellAlgorithm struct{}
+
+type testCellSharding struct {
+	cells       map[string]int
+	defaultCell int
+	tableCount  int
+}
+
+func (a *testCellAlgorithm) GetName() string {
+	return "test_cell"
+}
+
+func (a *testCellAlgorithm) Verify(props map[string]string, tableCount int) error {
+	_, err := a.Create(props, tableCount)
+	return err
+}
+
+func (a *testCellAlgorithm) Create(props map[string]string, tableCount int) (provider.Sharding, error) {
+	s := &testCellSharding{cells: make(map[string]int), defaultCell: -1, tableCount: tableCount}
+	for customer, cell := range props {
+		index, err := strconv.Atoi(cell)
+		if err != nil || index < 0 || index >= tableCount {
+			return nil, fmt.Errorf("invalid cell %s", cell)
+		}
+		if customer == "default" {
+			s.defaultCell = index
+		} else {
+			s.cells[customer] = index
+		}
+	}
+	if s.defaultCell == -1 {
+		return nil, fmt.Errorf("default cell is not set")
+	}
+	return s, nil
+}
+
+func (s *testCellSharding) FindForKey(key interface{}) (int, error) {
+	if index, ok := s.cells[GetString(key)]; ok {
+		return index, nil
+	}
+	return s.defaultCell, nil
+}
+
+func (s *testCellSharding) FindForRange(r provider.KeyRange) ([]int, error) {
+	return []int{3, 1, 2, 0, 1}, nil
+}
+
+func TestCustomShard(t *testing.T) {
+	if err := provider.RegisterShardingAlgorithm(&testCellAlgorithm{}); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &models.Shard{Type: "custom:test_cell", Properties: map[string]string{"1001": "2", "abc": "3", "default": "0"}}
+	shard, err := NewCustomShard(cfg, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		key   interface{}
+		index int
+	}{
+		{int64(1001), 2},
+		{"abc", 3},
+		{uint64(1002), 0},
+	}
+	for _, test := range tests {
+		index, err := shard.FindForKey(test.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if index != test.index {
+			t.Errorf("table index of %v not equal, expect: %d, actual: %d", test.key, test.index, index)
+		}
+	}
+	indexes, err := shard.FindForRange(provider.KeyRange{Lower: int64(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(indexes) != "[0 1 2 3]" {
+		t.Errorf("range indexes not sorted and distinct: %v", indexes)
+	}
+
+	// table index out of range
+	if _, err := NewCustomShard(cfg, 3); err == nil {
+		t.Errorf("expect verify error")
+	}
+	if _, err := NewCustomShard(&models.Shard{Type: "custom:not_exists"}, 4); err == nil {
+		t.Errorf("expect not registered error")
+	}
+}
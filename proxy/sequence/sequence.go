@@ -52,3 +52,16 @@ func (s *SequenceManager) GetSequence(db, table string) (Sequence, bool) {
 	seq, ok := dbSeq[table]
 	return seq, ok
 }
+
+// NextGeneSeq return the next id carrying the gene, the id is (seq << bits) | gene,
+// so the id has the same low bits as the sharding key of the row, which is used in gene shard.
+func NextGeneSeq(seq Sequence, gene uint64, bits int) (int64, error) {
+	v, err := seq.NextSeq()
+	if err != nil {
+		return 0, err
+	}
+	if v < 0 || v >= 1<<uint(63-bits) {
+		return 0, fmt.Errorf("sequence value %d of %s overflows with %d gene bits", v, seq.GetPKName(), bits)
+	}
+	return v<<uint(bits) | int64(gene&(1<<uint(bits)-1)), nil
+}
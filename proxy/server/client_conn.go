@@ -18,6 +18,7 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/XiaoMi/Gaea/logging"
+	"io"
 
 	"github.com/XiaoMi/Gaea/mysql"
 )
@@ -33,6 +34,8 @@ type ClientConn struct {
 	manager *Manager
 
 	namespace string // TODO: remove it when refactor is done
+
+	clientLocalFiles bool // 客户端是否支持LOAD DATA LOCAL INFILE
 }
 
 // HandshakeResponseInfo handshake response information
@@ -44,6 +47,7 @@ type HandshakeResponseInfo struct {
 	Database         string
 	AuthPlugin       string
 	ClientPluginAuth bool
+	ClientLocalFiles bool
 }
 
 // NewClientConn constructor of ClientConn
@@ -276,6 +280,7 @@ func (cc *ClientConn) readHandshakeResponse() (HandshakeResponseInfo, error) {
 	}
 	info.User = user
 	info.ClientPluginAuth = capability&mysql.ClientPluginAuth > 0
+	info.ClientLocalFiles = capability&mysql.ClientLocalFiles > 0
 	info.AuthResponse, pos, ok = readAuthData(data, pos, capability)
 
 	// check if with database
@@ -328,9 +333,68 @@ func (cc *ClientConn) WriteAuthSwitchRequest(authMethod string) error {
 	return cc.WriteEphemeralPacket()
 }
 
+// requestLocalInfile 向客户端请求LOAD DATA LOCAL INFILE的文件, 返回的Reader逐个读取客户端发送的文件内容包
+// https://dev.mysql.com/doc/internals/en/com-query-response.html#packet-Protocol::LOCAL_INFILE_Request
+func (cc *ClientConn) requestLocalInfile(filename string) (io.ReadCloser, error) {
+	if !cc.clientLocalFiles {
+		return nil, mysql.NewDefaultError(mysql.ErrNotAllowedCommand)
+	}
+
+	// 执行命令时命令包还没有回收, 不能使用EphemeralPacket
+	data := make([]byte, 1+len(filename))
+	pos := mysql.WriteByte(data, 0, mysql.LocalInFileHeader)
+	copy(data[pos:], filename)
+	if err := cc.WritePacket(data); err != nil {
+		return nil, err
+	}
+	return &localInfileReader{cc: cc}, nil
+}
+
+// localInfileReader 读取客户端发送的文件内容, 客户端发送空包表示文件结束
+type localInfileReader struct {
+	cc  *ClientConn
+	buf []byte
+	eof bool
+}
+
+// Read implement io.Reader
+func (r *localInfileReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.eof {
+			return 0, io.EOF
+		}
+		data, err := r.cc.ReadPacket()
+		if err != nil {
+			return 0, err
+		}
+		r.cc.manager.GetStatisticManager().AddReadFlowCount(r.cc.namespace, len(data))
+		if len(data) == 0 {
+			r.eof = true
+		}
+		r.buf = data
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// Close 读完客户端发送的剩余内容
+func (r *localInfileReader) Close() error {
+	r.buf = nil
+	for !r.eof {
+		data, err := r.cc.ReadPacket()
+		if err != nil {
+			return err
+		}
+		r.cc.manager.GetStatisticManager().AddReadFlowCount(r.cc.namespace, len(data))
+		r.eof = len(data) == 0
+	}
+	return nil
+}
+
 func (cc *ClientConn) writeOKResult(status uint16, r *mysql.Result) error {
 	if r.Resultset == nil {
-		return cc.WriteOKPacket(r.AffectedRows, r.InsertID, status, 0)
+		return cc.WriteOKPacket(r.AffectedRows, r.InsertID, status, r.Warnings)
 	}
 	return cc.writeResultset(status, r.Resultset)
 }
@@ -372,6 +436,10 @@ func (cc *ClientConn) writeRow(row []byte) error {
 
 // https://dev.mysql.com/doc/internals/en/com-query-response.html#packet-ProtocolText::Resultset
 func (cc *ClientConn) writeResultset(status uint16, r *mysql.Resultset) error {
+	if r.Stream != nil {
+		defer r.Stream.Close()
+	}
+
 	var err error
 	cc.StartWriterBuffering()
 
@@ -397,6 +465,26 @@ func (cc *ClientConn) writeResultset(status uint16, r *mysql.Resultset) error {
 		}
 	}
 
+	// 流式读取的结果逐行发送, 写缓冲区满时就会发送给客户端, 不需要等待读取全部结果
+	if r.Stream != nil {
+		for {
+			row, _, readErr := r.Stream.Next()
+			if readErr == io.EOF {
+				break
+			}
+			if readErr != nil {
+				// 列信息已经发送, 读取出错时用错误包代替EOF包结束结果集
+				if err = cc.writeErrorPacket(readErr); err != nil {
+					return err
+				}
+				return cc.Flush()
+			}
+			if err = cc.writeRow(row); err != nil {
+				return err
+			}
+		}
+	}
+
 	err = cc.writeEOFPacket(status)
 	if err != nil {
 		return err
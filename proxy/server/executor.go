@@ -22,6 +22,8 @@ import (
 	"github.com/pingcap/parser/ast"
 	"github.com/pingcap/parser/format"
 	_ "github.com/pingcap/tidb/types/parser_driver"
+	"io"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -68,6 +70,8 @@ type SessionExecutor struct {
 	stmts  map[uint32]*Stmt //prepare相关,client端到proxy的stmt
 
 	parser *parser.Parser
+
+	clientConn *ClientConn // LOAD DATA LOCAL INFILE时向客户端请求文件
 }
 
 // Response response info
@@ -219,6 +223,54 @@ func (se *SessionExecutor) SetLastInsertID(id uint64) {
 	se.lastInsertID = id
 }
 
+// GetMaxJoinRows return the limit of rows in cross shard join, implement plan.JoinRowsLimiter
+func (se *SessionExecutor) GetMaxJoinRows() int {
+	return se.GetNamespace().GetMaxJoinRows()
+}
+
+// GetMaxMergeMemory return the memory limit of merging cross shard results, implement plan.MergeSpiller
+func (se *SessionExecutor) GetMaxMergeMemory() int64 {
+	return se.GetNamespace().GetMaxMergeMemory()
+}
+
+// GetMergeSpillDir return the directory of temp files, implement plan.MergeSpiller
+func (se *SessionExecutor) GetMergeSpillDir() string {
+	return se.GetNamespace().GetMergeSpillDir()
+}
+
+// RecordMergeSpill record the bytes written to temp files, implement plan.MergeSpiller
+func (se *SessionExecutor) RecordMergeSpill(byteCount int64) {
+	se.manager.GetStatisticManager().RecordMergeSpill(se.namespace, byteCount)
+}
+
+// GetMaxScatterOffset return the limit of offset in cross shard query, implement plan.ScatterOffsetLimiter
+func (se *SessionExecutor) GetMaxScatterOffset() int64 {
+	return se.GetNamespace().GetMaxScatterOffset()
+}
+
+// GetScatterOffsetPolicy return how to handle deep offset, implement plan.ScatterOffsetLimiter
+func (se *SessionExecutor) GetScatterOffsetPolicy() string {
+	return se.GetNamespace().GetScatterOffsetPolicy()
+}
+
+// GetMaxInsertSelectRows return the limit of rows selected by INSERT ... SELECT, implement plan.InsertSelectRowsLimiter
+func (se *SessionExecutor) GetMaxInsertSelectRows() int {
+	return se.GetNamespace().GetMaxInsertSelectRows()
+}
+
+// GetMaxSubqueryRows return the limit of rows returned by subquery in WHERE, implement plan.SubqueryRowsLimiter
+func (se *SessionExecutor) GetMaxSubqueryRows() int {
+	return se.GetNamespace().GetMaxSubqueryRows()
+}
+
+// ReadLocalInfile implement plan.LocalInfileReader
+func (se *SessionExecutor) ReadLocalInfile(filename string) (io.ReadCloser, error) {
+	if se.clientConn == nil {
+		return nil, fmt.Errorf("no client connection to read local infile")
+	}
+	return se.clientConn.requestLocalInfile(filename)
+}
+
 // GetStatus return session status
 func (se *SessionExecutor) GetStatus() uint16 {
 	return se.status
@@ -280,7 +332,7 @@ func (se *SessionExecutor) ExecuteCommand(cmd byte, data []byte) Response {
 	case mysql.ComQuery: // data type: string[EOF]
 		sql := string(data)
 		// handle phase
-		r, err := se.handleQuery(sql)
+		r, err := se.handleQuery(sql, true)
 		if err != nil {
 			return CreateErrorResponse(se.status, err)
 		}
@@ -492,7 +544,8 @@ func (se *SessionExecutor) executeInMultiSlices(reqCtx *util.RequestContext, pcs
 	rs := make([]interface{}, resultCount)
 
 	f := func(reqCtx *util.RequestContext, rs []interface{}, i int, execSqls map[string][]string, pc backend.PooledConnect) {
-		for db, sqls := range execSqls {
+		for _, db := range sortedDBNames(execSqls) {
+			sqls := execSqls[db]
 			err := initBackendConn(pc, db, se.GetCharset(), se.GetCollationID(), se.GetVariables())
 			if err != nil {
 				rs[i] = err
@@ -513,8 +566,10 @@ func (se *SessionExecutor) executeInMultiSlices(reqCtx *util.RequestContext, pcs
 		wg.Done()
 	}
 
+	// 结果按slice名和db名排序, 跨分片合并时排序列相等的行顺序固定
 	offset := 0
-	for sliceName, pc := range pcs {
+	for _, sliceName := range sortedSliceNames(pcs) {
+		pc := pcs[sliceName]
 		s := sqls[sliceName] //map[string][]string
 		go f(reqCtx, rs, offset, s, pc)
 		for _, sqlDB := range sqls[sliceName] {
@@ -539,6 +594,145 @@ func (se *SessionExecutor) executeInMultiSlices(reqCtx *util.RequestContext, pcs
 	return r, err
 }
 
+// ExecuteSQLsStream execute sqls in multi slices, implement plan.StreamExecutor
+// 每个slice使用一个后端连接, slice上的最后一条SQL流式读取结果, 之前的SQL读取全部结果.
+// 返回结果中的Stream关闭时回收后端连接, 出错时关闭所有已经返回的Stream
+func (se *SessionExecutor) ExecuteSQLsStream(reqCtx *util.RequestContext, sqls map[string]map[string][]string) ([]*mysql.Result, error) {
+	if len(sqls) == 0 {
+		return nil, fmt.Errorf("no parser to execute")
+	}
+
+	pcs, err := se.getBackendConns(sqls, getFromSlave(reqCtx))
+	if err != nil {
+		se.recycleBackendConns(pcs, false)
+		exeLogger.Warnf("getShardConns failed: %v", err)
+		return nil, err
+	}
+
+	type sliceResult struct {
+		rs  []*mysql.Result
+		err error
+	}
+	sliceResults := make([]sliceResult, len(pcs))
+
+	f := func(ret *sliceResult, execSqls map[string][]string, pc backend.PooledConnect) {
+		sqlCount := 0
+		for _, sqls := range execSqls {
+			sqlCount += len(sqls)
+		}
+
+		streaming := false
+		defer func() {
+			if !streaming {
+				se.recycleBackendConn(pc, false)
+			}
+		}()
+
+		for _, db := range sortedDBNames(execSqls) {
+			sqls := execSqls[db]
+			if err := initBackendConn(pc, db, se.GetCharset(), se.GetCollationID(), se.GetVariables()); err != nil {
+				ret.err = err
+				return
+			}
+			for _, v := range sqls {
+				sqlCount--
+				startTime := time.Now()
+				var r *mysql.Result
+				var err error
+				if sqlCount == 0 {
+					r, err = pc.ExecuteStream(v)
+				} else {
+					r, err = pc.Execute(v)
+				}
+				se.manager.RecordBackendSQLMetrics(reqCtx, se.namespace, v, pc.GetAddr(), startTime, err)
+				if err != nil {
+					ret.err = err
+					return
+				}
+				if r.Resultset != nil && r.Stream != nil {
+					streaming = true
+					r.Stream = &backendRowStream{RowStream: r.Stream, se: se, pc: pc}
+				}
+				ret.rs = append(ret.rs, r)
+			}
+		}
+	}
+
+	// 结果按slice名和db名排序, 与ExecuteSQLs相同
+	var wg sync.WaitGroup
+	i := 0
+	for _, sliceName := range sortedSliceNames(pcs) {
+		pc := pcs[sliceName]
+		wg.Add(1)
+		go func(ret *sliceResult, execSqls map[string][]string, pc backend.PooledConnect) {
+			defer wg.Done()
+			f(ret, execSqls, pc)
+		}(&sliceResults[i], sqls[sliceName], pc)
+		i++
+	}
+	wg.Wait()
+
+	var rs []*mysql.Result
+	for _, ret := range sliceResults {
+		rs = append(rs, ret.rs...)
+		if ret.err != nil && err == nil {
+			err = ret.err
+		}
+	}
+	if err != nil {
+		exeLogger.Warnf("execute sqls by stream error: %v", err)
+		for _, r := range rs {
+			if r.Resultset != nil && r.Stream != nil {
+				r.Stream.Close()
+			}
+		}
+		return nil, err
+	}
+	return rs, nil
+}
+
+func sortedSliceNames(pcs map[string]backend.PooledConnect) []string {
+	names := make([]string, 0, len(pcs))
+	for name := range pcs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func sortedDBNames(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// backendRowStream 流式读取后端连接上的结果, 关闭时回收后端连接
+type backendRowStream struct {
+	mysql.RowStream
+	se     *SessionExecutor
+	pc     backend.PooledConnect
+	closed bool
+}
+
+// Close implement mysql.RowStream
+func (s *backendRowStream) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	// 没有读取完的结果无法丢弃时连接不能复用
+	err := s.RowStream.Close()
+	if err != nil {
+		s.pc.Close()
+	}
+	s.se.recycleBackendConn(s.pc, false)
+	return err
+}
+
 const variableRestoreFlag = format.RestoreKeyWordLowercase | format.RestoreNameLowercase
 
 // 获取SET语句中变量的字符串值, 去掉各种引号并转换为小写
@@ -581,7 +775,7 @@ func isSQLNotAllowedByUser(c *SessionExecutor, stmtType parser2.StatementType) b
 		return false
 	}
 
-	return stmtType == parser2.StmtDelete || stmtType == parser2.StmtInsert || stmtType == parser2.StmtUpdate
+	return stmtType == parser2.StmtDelete || stmtType == parser2.StmtInsert || stmtType == parser2.StmtUpdate || stmtType == parser2.StmtLoad
 }
 
 func modifyResultStatus(r *mysql.Result, cc *SessionExecutor) {
@@ -642,6 +836,35 @@ func getFromSlave(reqCtx *util.RequestContext) bool {
 	return false
 }
 
+func isStreamResult(reqCtx *util.RequestContext) bool {
+	streamFlag := reqCtx.Get(util.StreamResult)
+	if streamFlag != nil && streamFlag.(int) == 1 {
+		return true
+	}
+
+	return false
+}
+
+// IsInTransaction implement plan.TransactionChecker
+func (se *SessionExecutor) IsInTransaction() bool {
+	return se.isInTransaction()
+}
+
+// BeginTransaction implement plan.TransactionExecutor
+func (se *SessionExecutor) BeginTransaction() error {
+	return se.handleBegin()
+}
+
+// CommitTransaction implement plan.TransactionExecutor
+func (se *SessionExecutor) CommitTransaction() error {
+	return se.commit()
+}
+
+// RollbackTransaction implement plan.TransactionExecutor
+func (se *SessionExecutor) RollbackTransaction() error {
+	return se.rollback()
+}
+
 func (se *SessionExecutor) isInTransaction() bool {
 	return se.status&mysql.ServerStatusInTrans > 0 ||
 		!se.isAutoCommit()
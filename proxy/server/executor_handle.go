@@ -38,8 +38,8 @@ func (se *SessionExecutor) Parse(sql string) (ast.StmtNode, error) {
 	return se.parser.ParseOneStmt(sql, "", "")
 }
 
-// 处理query语句
-func (se *SessionExecutor) handleQuery(sql string) (r *mysql.Result, err error) {
+// 处理query语句, stream为true时结果集可能通过Resultset.Stream逐行返回
+func (se *SessionExecutor) handleQuery(sql string, stream bool) (r *mysql.Result, err error) {
 	defer func() {
 		if e := recover(); e != nil {
 			exeLogger.Warnf("handle query command failed, error: %v, parser: %s", e, sql)
@@ -74,6 +74,9 @@ func (se *SessionExecutor) handleQuery(sql string) (r *mysql.Result, err error)
 	startTime := time.Now()
 	stmtType := parser.PreviewSql(sql)
 	reqCtx.Set(util.StmtType, stmtType)
+	if stream {
+		reqCtx.Set(util.StreamResult, 1)
+	}
 
 	r, err = se.doQuery(reqCtx, sql)
 	se.manager.RecordSessionSQLMetrics(reqCtx, se, sql, startTime, err)
@@ -102,7 +105,12 @@ func (se *SessionExecutor) doQuery(reqCtx *util.RequestContext, sql string) (*my
 		reqCtx.Set(util.FromSlave, 1)
 	}
 
-	r, err := p.ExecuteIn(reqCtx, se)
+	var r *mysql.Result
+	if sp, ok := p.(plan.StreamPlan); ok && isStreamResult(reqCtx) {
+		r, err = sp.ExecuteStreamIn(reqCtx, se)
+	} else {
+		r, err = p.ExecuteIn(reqCtx, se)
+	}
 	if err != nil {
 		exeLogger.Warnf("execute select: %s", err.Error())
 		return nil, err
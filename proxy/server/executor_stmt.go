@@ -202,7 +202,7 @@ func (se *SessionExecutor) handleStmtExecute(data []byte) (*mysql.Result, error)
 	defer s.ResetParams()
 
 	// execute parser using ComQuery
-	r, err := se.handleQuery(executeSQL)
+	r, err := se.handleQuery(executeSQL, false)
 	if err != nil {
 		return nil, err
 	}
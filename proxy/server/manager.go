@@ -636,6 +636,8 @@ type StatisticManager struct {
 	sqlForbidenCounts         *stats.CountersWithMultiLabels // SQL黑名单请求统计
 	flowCounts                *stats.CountersWithMultiLabels // 业务流量统计
 	sessionCounts             *stats.GaugesWithMultiLabels   // 前端会话数统计
+	mergeSpillCounts          *stats.CountersWithMultiLabels // 合并结果写入临时文件的查询数统计
+	mergeSpillBytes           *stats.CountersWithMultiLabels // 合并结果写入临时文件的字节数统计
 
 	backendSQLTimings                *stats.MultiTimings            // 后端SQL耗时统计
 	backendSQLFingerprintSlowCounts  *stats.CountersWithMultiLabels // 后端慢SQL指纹数量统计
@@ -714,6 +716,10 @@ func (s *StatisticManager) Init(cfg *models.Proxy) error {
 		"gaea proxy flow counts", []string{statsLabelCluster, statsLabelNamespace, statsLabelFlowDirection})
 	s.sessionCounts = stats.NewGaugesWithMultiLabels("SessionCounts",
 		"gaea proxy session counts", []string{statsLabelCluster, statsLabelNamespace})
+	s.mergeSpillCounts = stats.NewCountersWithMultiLabels("MergeSpillCounts",
+		"gaea proxy merge result spill counts", []string{statsLabelCluster, statsLabelNamespace})
+	s.mergeSpillBytes = stats.NewCountersWithMultiLabels("MergeSpillBytes",
+		"gaea proxy merge result spill bytes", []string{statsLabelCluster, statsLabelNamespace})
 
 	s.backendSQLTimings = stats.NewMultiTimings("BackendSqlTimings",
 		"gaea proxy backend parser sqlTimings", []string{statsLabelCluster, statsLabelNamespace, statsLabelOperation})
@@ -845,6 +851,13 @@ func (s *StatisticManager) AddWriteFlowCount(namespace string, byteCount int) {
 	s.flowCounts.Add(statsKey, int64(byteCount))
 }
 
+// RecordMergeSpill record the bytes written to temp files when merging results
+func (s *StatisticManager) RecordMergeSpill(namespace string, byteCount int64) {
+	statsKey := []string{s.clusterName, namespace}
+	s.mergeSpillCounts.Add(statsKey, 1)
+	s.mergeSpillBytes.Add(statsKey, byteCount)
+}
+
 //record idle connect count
 func (s *StatisticManager) recordConnectPoolIdleCount(namespace string, slice string, addr string, count int64) {
 	statsKey := []string{s.clusterName, namespace, slice, addr}
@@ -52,19 +52,26 @@ type UserProperty struct {
 
 // Namespace is struct driected used by server
 type Namespace struct {
-	name               string
-	allowedDBs         map[string]bool
-	defaultPhyDBs      map[string]string // logicDBName-phyDBName
-	sqls               map[string]string //key: parser fingerprint
-	slowSQLTime        int64             // session slow parser time, millisecond, default 1000
-	allowips           []util.IPInfo
-	router             *router.Router
-	sequences          *sequence.SequenceManager
-	slices             map[string]*backend.Slice // key: slice name
-	userProperties     map[string]*UserProperty  // key: user name ,value: user's properties
-	defaultCharset     string
-	defaultCollationID mysql.CollationID
-	openGeneralLog     bool
+	name                string
+	allowedDBs          map[string]bool
+	defaultPhyDBs       map[string]string // logicDBName-phyDBName
+	sqls                map[string]string //key: parser fingerprint
+	slowSQLTime         int64             // session slow parser time, millisecond, default 1000
+	allowips            []util.IPInfo
+	router              *router.Router
+	sequences           *sequence.SequenceManager
+	slices              map[string]*backend.Slice // key: slice name
+	userProperties      map[string]*UserProperty  // key: user name ,value: user's properties
+	defaultCharset      string
+	defaultCollationID  mysql.CollationID
+	openGeneralLog      bool
+	maxJoinRows         int
+	maxMergeMemory      int64
+	mergeSpillDir       string
+	maxScatterOffset    int64
+	deepOffsetPolicy    string
+	maxInsertSelectRows int
+	maxSubqueryRows     int
 
 	slowSQLCache         *cache.LRUCache
 	errorSQLCache        *cache.LRUCache
@@ -114,6 +121,23 @@ func NewNamespace(namespaceConfig *models.Namespace) (*Namespace, error) {
 	}
 	namespace.allowedDBs = allowDBs
 
+	namespace.maxJoinRows = namespaceConfig.MaxJoinRows
+	if namespace.maxJoinRows == 0 {
+		namespace.maxJoinRows = plan.DefaultMaxJoinRows
+	}
+	namespace.maxMergeMemory = namespaceConfig.MaxMergeMemory
+	namespace.mergeSpillDir = strings.TrimSpace(namespaceConfig.MergeSpillDir)
+	namespace.maxScatterOffset = namespaceConfig.MaxScatterOffset
+	namespace.deepOffsetPolicy = namespaceConfig.ScatterOffsetPolicy
+	namespace.maxInsertSelectRows = namespaceConfig.MaxInsertSelectRows
+	if namespace.maxInsertSelectRows == 0 {
+		namespace.maxInsertSelectRows = plan.DefaultMaxInsertSelectRows
+	}
+	namespace.maxSubqueryRows = namespaceConfig.MaxSubqueryRows
+	if namespace.maxSubqueryRows == 0 {
+		namespace.maxSubqueryRows = plan.DefaultMaxSubqueryRows
+	}
+
 	defaultPhyDBs := make(map[string]string, len(namespaceConfig.DefaultPhyDBS))
 	for db, phyDB := range namespaceConfig.DefaultPhyDBS {
 		defaultPhyDBs[strings.TrimSpace(db)] = strings.TrimSpace(phyDB)
@@ -284,6 +308,41 @@ func (n *Namespace) GetDefaultCollationID() mysql.CollationID {
 	return n.defaultCollationID
 }
 
+// GetMaxJoinRows return the limit of rows in cross shard join
+func (n *Namespace) GetMaxJoinRows() int {
+	return n.maxJoinRows
+}
+
+// GetMaxMergeMemory return the memory limit of merging cross shard results in one query, 0 means no limit
+func (n *Namespace) GetMaxMergeMemory() int64 {
+	return n.maxMergeMemory
+}
+
+// GetMergeSpillDir return the directory of temp files when merging results exceeds memory limit
+func (n *Namespace) GetMergeSpillDir() string {
+	return n.mergeSpillDir
+}
+
+// GetMaxScatterOffset return the limit of offset in cross shard query, 0 means no limit
+func (n *Namespace) GetMaxScatterOffset() int64 {
+	return n.maxScatterOffset
+}
+
+// GetScatterOffsetPolicy return how to handle cross shard query whose offset exceeds the limit
+func (n *Namespace) GetScatterOffsetPolicy() string {
+	return n.deepOffsetPolicy
+}
+
+// GetMaxInsertSelectRows return the limit of rows selected by INSERT ... SELECT
+func (n *Namespace) GetMaxInsertSelectRows() int {
+	return n.maxInsertSelectRows
+}
+
+// GetMaxSubqueryRows return the limit of rows returned by subquery in WHERE
+func (n *Namespace) GetMaxSubqueryRows() int {
+	return n.maxSubqueryRows
+}
+
 // GetCachedPlan get plan in cache
 func (n *Namespace) GetCachedPlan(db, sql string) (plan.Plan, bool) {
 	v, ok := n.planCache.Get(db + "|" + sql)
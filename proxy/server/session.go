@@ -29,13 +29,15 @@ import (
 
 /*
 CLIENT_LONG_PASSWORD | CLIENT_LONG_FLAG | CLIENT_CONNECT_WITH_DB | CLIENT_PROTOCOL_41 |
-			CLIENT_TRANSACTIONS | CLIENT_SECURE_CONNECTION | CLIENT_PLUGIN_AUTH | CLIENT_SSL | CLIENT_PLUGIN_AUTH_LENENC_CLIENT_DATA,
+			CLIENT_TRANSACTIONS | CLIENT_SECURE_CONNECTION | CLIENT_PLUGIN_AUTH | CLIENT_SSL | CLIENT_PLUGIN_AUTH_LENENC_CLIENT_DATA |
+			CLIENT_LOCAL_FILES,
 */
 
 // DefaultCapability means default capability
 var DefaultCapability = mysql.ClientLongPassword | mysql.ClientLongFlag |
 	mysql.ClientConnectWithDB | mysql.ClientProtocol41 |
-	mysql.ClientTransactions | mysql.ClientSecureConnection | mysql.ClientPluginAuth | mysql.ClientPluginAuthLenencClientData
+	mysql.ClientTransactions | mysql.ClientSecureConnection | mysql.ClientPluginAuth | mysql.ClientPluginAuthLenencClientData |
+	mysql.ClientLocalFiles
 
 var baseConnID uint32 = 10000
 
@@ -78,6 +80,7 @@ func newSession(s *Server, co net.Conn) *Session {
 
 	cc.executor = newSessionExecutor(s.manager)
 	cc.executor.clientAddr = co.RemoteAddr().String()
+	cc.executor.clientConn = cc.c
 	cc.closed.Store(false)
 	return cc
 }
@@ -204,6 +207,8 @@ func (cc *Session) handleHandshakeResponse(info HandshakeResponseInfo) error {
 	// set database
 	cc.executor.SetDatabase(info.Database)
 
+	cc.c.clientLocalFiles = info.ClientLocalFiles
+
 	// set namespace
 	namespace := cc.manager.GetNamespaceByUser(user, password)
 	cc.namespace = namespace
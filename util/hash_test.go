@@ -0,0 +1,70 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import "testing"
+
+func TestXXHash64(t *testing.T) {
+	tests := []struct {
+		input  string
+		seed   uint64
+		expect uint64
+	}{
+		{"", 0, 0xef46db3751d8e999},
+		{"a", 0, 0xd24ec4f1a98c6e5b},
+		{"abc", 0, 0x44bc2cf5ad770999},
+		{"The quick brown fox jumps over the lazy dog", 0, 0x0b242d361fda71bc},
+	}
+	for _, test := range tests {
+		if actual := XXHash64([]byte(test.input), test.seed); actual != test.expect {
+			t.Errorf("XXHash64(%q) not equal, expect: %x, actual: %x", test.input, test.expect, actual)
+		}
+	}
+}
+
+func TestMurmurHashBytes(t *testing.T) {
+	tests := []struct {
+		input  string
+		seed   int
+		expect uint32
+	}{
+		{"", 0, 0},
+		{"hello", 0, 0x248bfa47},
+		{"hello, world", 0, 0x149bbb7f},
+		{"The quick brown fox jumps over the lazy dog", 0, 0x2e4ff723},
+	}
+	for _, test := range tests {
+		if actual := uint32(NewMurmurHash(test.seed).HashBytes([]byte(test.input))); actual != test.expect {
+			t.Errorf("HashBytes(%q) not equal, expect: %x, actual: %x", test.input, test.expect, actual)
+		}
+	}
+}
+
+func TestJavaStringHashCode(t *testing.T) {
+	tests := []struct {
+		input  string
+		expect int32
+	}{
+		{"", 0},
+		{"hello", 99162322},
+		{"polygenelubricants", -2147483648},
+		{"中文", 646394},
+	}
+	for _, test := range tests {
+		if actual := JavaStringHashCode(test.input); actual != test.expect {
+			t.Errorf("JavaStringHashCode(%q) not equal, expect: %d, actual: %d", test.input, test.expect, actual)
+		}
+	}
+}
@@ -0,0 +1,412 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inline
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// values inside an expression are either int64 or string
+func normalizeValue(v interface{}) (interface{}, error) {
+	switch x := v.(type) {
+	case int64:
+		return x, nil
+	case string:
+		return x, nil
+	case []byte:
+		return string(x), nil
+	case int:
+		return int64(x), nil
+	case int8:
+		return int64(x), nil
+	case int16:
+		return int64(x), nil
+	case int32:
+		return int64(x), nil
+	case uint:
+		return uint64ToInt(uint64(x))
+	case uint8:
+		return int64(x), nil
+	case uint16:
+		return int64(x), nil
+	case uint32:
+		return int64(x), nil
+	case uint64:
+		return uint64ToInt(x)
+	case float32:
+		return floatToInt(float64(x))
+	case float64:
+		return floatToInt(x)
+	case nil:
+		return nil, fmt.Errorf("null value is not supported")
+	default:
+		return nil, fmt.Errorf("unsupported value type %T", v)
+	}
+}
+
+func uint64ToInt(v uint64) (interface{}, error) {
+	if v > math.MaxInt64 {
+		return nil, fmt.Errorf("value %d overflows int64", v)
+	}
+	return int64(v), nil
+}
+
+func floatToInt(v float64) (interface{}, error) {
+	if v != math.Trunc(v) || v > math.MaxInt64 || v < math.MinInt64 {
+		return nil, fmt.Errorf("float value %v is not supported", v)
+	}
+	return int64(v), nil
+}
+
+func toInt(v interface{}) (int64, error) {
+	switch x := v.(type) {
+	case int64:
+		return x, nil
+	case string:
+		i, err := strconv.ParseInt(strings.TrimSpace(x), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("'%s' is not an integer", x)
+		}
+		return i, nil
+	default:
+		return 0, fmt.Errorf("unsupported value type %T", v)
+	}
+}
+
+func isNonNumericString(v interface{}) bool {
+	if _, ok := v.(string); !ok {
+		return false
+	}
+	_, err := toInt(v)
+	return err != nil
+}
+
+func toString(v interface{}) string {
+	switch x := v.(type) {
+	case int64:
+		return strconv.FormatInt(x, 10)
+	case string:
+		return x
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// javaStringHashCode is the same as java.lang.String.hashCode()
+func javaStringHashCode(s string) int64 {
+	var h int32
+	for _, c := range utf16.Encode([]rune(s)) {
+		h = 31*h + int32(c)
+	}
+	return int64(h)
+}
+
+// javaLongHashCode is the same as java.lang.Long.hashCode()
+func javaLongHashCode(v int64) int64 {
+	return int64(int32(v ^ int64(uint64(v)>>32)))
+}
+
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func (n *literalNode) eval(vars map[string]interface{}) (interface{}, error) {
+	return n.value, nil
+}
+
+func (n *variableNode) eval(vars map[string]interface{}) (interface{}, error) {
+	v, ok := vars[strings.ToLower(n.name)]
+	if !ok {
+		return nil, fmt.Errorf("variable %s is not defined", n.name)
+	}
+	ret, err := normalizeValue(v)
+	if err != nil {
+		return nil, fmt.Errorf("variable %s: %v", n.name, err)
+	}
+	return ret, nil
+}
+
+func (n *unaryNode) eval(vars map[string]interface{}) (interface{}, error) {
+	v, err := n.operand.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	i, err := toInt(v)
+	if err != nil {
+		return nil, err
+	}
+	return -i, nil
+}
+
+func (n *binaryNode) eval(vars map[string]interface{}) (interface{}, error) {
+	l, err := n.left.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	r, err := n.right.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	// like groovy, plus with a string operand is string concatenation,
+	// but numeric strings are added as integers, since sharding values from sql may be quoted
+	if n.op == "+" && (isNonNumericString(l) || isNonNumericString(r)) {
+		return toString(l) + toString(r), nil
+	}
+
+	li, err := toInt(l)
+	if err != nil {
+		return nil, err
+	}
+	ri, err := toInt(r)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "+":
+		return li + ri, nil
+	case "-":
+		return li - ri, nil
+	case "*":
+		return li * ri, nil
+	case "/":
+		if ri == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return li / ri, nil
+	case "%":
+		if ri == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return li % ri, nil
+	default:
+		return nil, fmt.Errorf("unknown operator %s", n.op)
+	}
+}
+
+// maxRangeSize limit the count of values of a range, to avoid exhausting memory by a mistake such as 0..2000000000
+const maxRangeSize = 100000
+
+func (n *rangeNode) eval(vars map[string]interface{}) (interface{}, error) {
+	from, err := evalInt(n.from, vars)
+	if err != nil {
+		return nil, err
+	}
+	to, err := evalInt(n.to, vars)
+	if err != nil {
+		return nil, err
+	}
+	// like groovy, the range is reversed if from is greater than to
+	step := int64(1)
+	if from > to {
+		step = -1
+	}
+	if abs(to-from) >= maxRangeSize {
+		return nil, fmt.Errorf("range %d..%d is too large", from, to)
+	}
+	var ret []interface{}
+	for i := from; ; i += step {
+		ret = append(ret, i)
+		if i == to {
+			return ret, nil
+		}
+	}
+}
+
+func evalInt(n node, vars map[string]interface{}) (int64, error) {
+	v, err := n.eval(vars)
+	if err != nil {
+		return 0, err
+	}
+	return toInt(v)
+}
+
+func (n *listNode) eval(vars map[string]interface{}) (interface{}, error) {
+	ret := make([]interface{}, 0, len(n.elements))
+	for _, e := range n.elements {
+		v, err := e.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, v)
+	}
+	return ret, nil
+}
+
+func (n *indexNode) eval(vars map[string]interface{}) (interface{}, error) {
+	v, err := n.target.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unsupported index of type %T", v)
+	}
+	i, err := evalInt(n.index, vars)
+	if err != nil {
+		return nil, err
+	}
+	if i < 0 || i >= int64(len(list)) {
+		return nil, fmt.Errorf("index %d out of range, size: %d", i, len(list))
+	}
+	return list[i], nil
+}
+
+func (n *callNode) eval(vars map[string]interface{}) (interface{}, error) {
+	var args []interface{}
+	if n.receiver != nil {
+		v, err := n.receiver.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+	}
+	for _, arg := range n.args {
+		v, err := arg.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+	}
+
+	if n.receiver != nil {
+		return callMethod(n.name, args[0], args[1:])
+	}
+	return callFunction(n.name, args)
+}
+
+func checkArgCount(name string, args []interface{}, count int) error {
+	if len(args) != count {
+		return fmt.Errorf("%s expects %d arguments but got %d", name, count, len(args))
+	}
+	return nil
+}
+
+func intArgs(name string, args []interface{}, count int) ([]int64, error) {
+	if err := checkArgCount(name, args, count); err != nil {
+		return nil, err
+	}
+	ret := make([]int64, 0, len(args))
+	for _, arg := range args {
+		i, err := toInt(arg)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		ret = append(ret, i)
+	}
+	return ret, nil
+}
+
+func callFunction(name string, args []interface{}) (interface{}, error) {
+	switch name {
+	case "abs", "Math.abs":
+		i, err := intArgs(name, args, 1)
+		if err != nil {
+			return nil, err
+		}
+		return abs(i[0]), nil
+	case "Math.floorMod":
+		i, err := intArgs(name, args, 2)
+		if err != nil {
+			return nil, err
+		}
+		if i[1] == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		m := i[0] % i[1]
+		if m != 0 && (m < 0) != (i[1] < 0) {
+			m += i[1]
+		}
+		return m, nil
+	default:
+		return nil, fmt.Errorf("unknown function %s", name)
+	}
+}
+
+func callMethod(name string, receiver interface{}, args []interface{}) (interface{}, error) {
+	switch name {
+	case "hashCode":
+		if err := checkArgCount(name, args, 0); err != nil {
+			return nil, err
+		}
+		if s, ok := receiver.(string); ok {
+			return javaStringHashCode(s), nil
+		}
+		i, err := toInt(receiver)
+		if err != nil {
+			return nil, err
+		}
+		return javaLongHashCode(i), nil
+	case "abs":
+		if err := checkArgCount(name, args, 0); err != nil {
+			return nil, err
+		}
+		i, err := toInt(receiver)
+		if err != nil {
+			return nil, err
+		}
+		return abs(i), nil
+	case "intdiv":
+		i, err := intArgs(name, append([]interface{}{receiver}, args...), 2)
+		if err != nil {
+			return nil, err
+		}
+		if i[1] == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return i[0] / i[1], nil
+	case "toString":
+		if err := checkArgCount(name, args, 0); err != nil {
+			return nil, err
+		}
+		return toString(receiver), nil
+	case "toLong", "toInteger":
+		if err := checkArgCount(name, args, 0); err != nil {
+			return nil, err
+		}
+		return toInt(receiver)
+	case "length":
+		if err := checkArgCount(name, args, 0); err != nil {
+			return nil, err
+		}
+		return int64(len(toString(receiver))), nil
+	case "substring":
+		s := toString(receiver)
+		if len(args) != 1 && len(args) != 2 {
+			return nil, fmt.Errorf("substring expects 1 or 2 arguments but got %d", len(args))
+		}
+		i, err := intArgs(name, args, len(args))
+		if err != nil {
+			return nil, err
+		}
+		begin, end := i[0], int64(len(s))
+		if len(i) == 2 {
+			end = i[1]
+		}
+		if begin < 0 || end > int64(len(s)) || begin > end {
+			return nil, fmt.Errorf("substring(%d, %d) out of range, length: %d", begin, end, len(s))
+		}
+		return s[begin:end], nil
+	default:
+		return nil, fmt.Errorf("unknown method %s", name)
+	}
+}
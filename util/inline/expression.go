@@ -0,0 +1,258 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package inline implements the groovy-like inline expression used by ShardingSphere,
+// such as t_order_${user_id % 16}. Both ${...} and $->{...} placeholders are supported.
+// Ranges and lists such as slice-${0..3}.t_order_${['a', 'b']} can be expanded to all the values.
+package inline
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// segment is literal text or a placeholder of an expression
+type segment struct {
+	text string
+	code node // nil for literal text
+}
+
+// Expression is a parsed inline expression
+type Expression struct {
+	source   string
+	segments []segment
+}
+
+// Parse parse an inline expression
+func Parse(s string) (*Expression, error) {
+	e := &Expression{source: s}
+	literal := &strings.Builder{}
+	i := 0
+	for i < len(s) {
+		var open string
+		if strings.HasPrefix(s[i:], "${") {
+			open = "${"
+		} else if strings.HasPrefix(s[i:], "$->{") {
+			open = "$->{"
+		} else {
+			literal.WriteByte(s[i])
+			i++
+			continue
+		}
+
+		start := i + len(open)
+		end, err := findPlaceholderEnd(s, start)
+		if err != nil {
+			return nil, fmt.Errorf("parse inline expression %s error: %v", s, err)
+		}
+		code, err := parseCode(s[start:end])
+		if err != nil {
+			return nil, fmt.Errorf("parse inline expression %s error: %v", s, err)
+		}
+		if literal.Len() != 0 {
+			e.segments = append(e.segments, segment{text: literal.String()})
+			literal.Reset()
+		}
+		e.segments = append(e.segments, segment{code: code})
+		i = end + 1
+	}
+	if literal.Len() != 0 {
+		e.segments = append(e.segments, segment{text: literal.String()})
+	}
+	return e, nil
+}
+
+// findPlaceholderEnd return the position of the '}' closing the placeholder, skipping quoted strings
+func findPlaceholderEnd(s string, start int) (int, error) {
+	var quote byte
+	for i := start; i < len(s); i++ {
+		c := s[i]
+		if quote != 0 {
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch c {
+		case '\'', '"':
+			quote = c
+		case '}':
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("placeholder at position %d is not closed", start)
+}
+
+// String return the source of the expression
+func (e *Expression) String() string {
+	return e.source
+}
+
+// Placeholders return the count of placeholders
+func (e *Expression) Placeholders() int {
+	count := 0
+	for _, seg := range e.segments {
+		if seg.code != nil {
+			count++
+		}
+	}
+	return count
+}
+
+// Variables return the sorted lower case variable names referenced by the expression
+func (e *Expression) Variables() []string {
+	names := make(map[string]bool)
+	for _, seg := range e.segments {
+		if seg.code != nil {
+			seg.code.collectVariables(names)
+		}
+	}
+	ret := make([]string, 0, len(names))
+	for name := range names {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Evaluate evaluate the expression to a string, variable names are case insensitive
+func (e *Expression) Evaluate(vars map[string]interface{}) (string, error) {
+	vars = lowerKeys(vars)
+	sb := &strings.Builder{}
+	for _, seg := range e.segments {
+		if seg.code == nil {
+			sb.WriteString(seg.text)
+			continue
+		}
+		v, err := seg.code.eval(vars)
+		if err != nil {
+			return "", fmt.Errorf("evaluate inline expression %s error: %v", e.source, err)
+		}
+		if _, ok := v.([]interface{}); ok {
+			return "", fmt.Errorf("evaluate inline expression %s error: placeholder has several values", e.source)
+		}
+		sb.WriteString(toString(v))
+	}
+	return sb.String(), nil
+}
+
+// EvaluateInt evaluate the only placeholder of the expression to an integer,
+// the literal text around the placeholder is ignored, e.g. t_order_${user_id % 16} is evaluated to user_id % 16
+func (e *Expression) EvaluateInt(vars map[string]interface{}) (int64, error) {
+	if e.Placeholders() != 1 {
+		return 0, fmt.Errorf("inline expression %s must have exactly one placeholder", e.source)
+	}
+	vars = lowerKeys(vars)
+	for _, seg := range e.segments {
+		if seg.code == nil {
+			continue
+		}
+		v, err := seg.code.eval(vars)
+		if err != nil {
+			return 0, fmt.Errorf("evaluate inline expression %s error: %v", e.source, err)
+		}
+		i, err := toInt(v)
+		if err != nil {
+			return 0, fmt.Errorf("evaluate inline expression %s error: %v", e.source, err)
+		}
+		return i, nil
+	}
+	return 0, nil
+}
+
+// maxExpandSize limit the count of strings expanded from an expression
+const maxExpandSize = 100000
+
+// Expand expand the ranges and lists in the placeholders to the cartesian product of their values,
+// e.g. ds_${0..1}.t_${['a', 'b']} is expanded to [ds_0.t_a ds_0.t_b ds_1.t_a ds_1.t_b].
+// The expression must not reference any variable.
+func (e *Expression) Expand() ([]string, error) {
+	ret := []string{""}
+	for _, seg := range e.segments {
+		values := []string{seg.text}
+		if seg.code != nil {
+			v, err := seg.code.eval(nil)
+			if err != nil {
+				return nil, fmt.Errorf("expand inline expression %s error: %v", e.source, err)
+			}
+			values = values[:0]
+			if list, ok := v.([]interface{}); ok {
+				for _, item := range list {
+					values = append(values, toString(item))
+				}
+			} else {
+				values = append(values, toString(v))
+			}
+		}
+		if len(ret)*len(values) > maxExpandSize {
+			return nil, fmt.Errorf("expand inline expression %s error: too many values", e.source)
+		}
+		next := make([]string, 0, len(ret)*len(values))
+		for _, prefix := range ret {
+			for _, v := range values {
+				next = append(next, prefix+v)
+			}
+		}
+		ret = next
+	}
+	return ret, nil
+}
+
+// SplitAndExpand split the comma separated expressions and expand each of them,
+// the commas inside placeholders are not separators.
+func SplitAndExpand(s string) ([]string, error) {
+	var ret []string
+	start := 0
+	for i := 0; i <= len(s); i++ {
+		if i < len(s) && s[i] != ',' {
+			if strings.HasPrefix(s[i:], "${") || strings.HasPrefix(s[i:], "$->{") {
+				end, err := findPlaceholderEnd(s, strings.IndexByte(s[i:], '{')+i+1)
+				if err != nil {
+					return nil, fmt.Errorf("parse inline expression %s error: %v", s, err)
+				}
+				i = end
+			}
+			continue
+		}
+		item := strings.TrimSpace(s[start:i])
+		start = i + 1
+		if item == "" {
+			continue
+		}
+		e, err := Parse(item)
+		if err != nil {
+			return nil, err
+		}
+		values, err := e.Expand()
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, values...)
+		if len(ret) > maxExpandSize {
+			return nil, fmt.Errorf("expand inline expression %s error: too many values", s)
+		}
+	}
+	return ret, nil
+}
+
+func lowerKeys(vars map[string]interface{}) map[string]interface{} {
+	ret := make(map[string]interface{}, len(vars))
+	for k, v := range vars {
+		ret[strings.ToLower(k)] = v
+	}
+	return ret
+}
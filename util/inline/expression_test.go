@@ -0,0 +1,173 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inline
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEvaluate(t *testing.T) {
+	tests := []struct {
+		expr   string
+		vars   map[string]interface{}
+		expect string
+	}{
+		{"t_order_${user_id % 16}", map[string]interface{}{"user_id": int64(37)}, "t_order_5"},
+		{"ds_$->{user_id % 4}", map[string]interface{}{"USER_ID": uint64(6)}, "ds_2"},
+		{"t_order", nil, "t_order"},
+		{"t_${a + b * 2}_${(a + b) * 2}", map[string]interface{}{"a": 1, "b": 2}, "t_5_6"},
+		{"t_${a / 2 - -1}", map[string]interface{}{"a": "7"}, "t_4"},
+		{"t_${'x' + a + 1}", map[string]interface{}{"a": 1}, "t_x11"},
+		{"t_${a + b}", map[string]interface{}{"a": "1", "b": 2}, "t_3"},
+		{"t_${Math.abs(id.hashCode()) % 4}", map[string]interface{}{"id": "abc"}, "t_2"},
+		{"t_${Math.floorMod(id, 4)}", map[string]interface{}{"id": -5}, "t_3"},
+		{"t_${id.intdiv(100)}", map[string]interface{}{"id": 1234}, "t_12"},
+		{"t_${id.substring(0, 6)}", map[string]interface{}{"id": "201912-01"}, "t_201912"},
+		{"t_${'}' + id.toString().length()}", map[string]interface{}{"id": 1234}, "t_}4"},
+		{"t_${['a', 'b', 'c'][id % 3]}", map[string]interface{}{"id": 7}, "t_b"},
+	}
+	for _, test := range tests {
+		e, err := Parse(test.expr)
+		if err != nil {
+			t.Fatalf("parse %s error: %v", test.expr, err)
+		}
+		actual, err := e.Evaluate(test.vars)
+		if err != nil {
+			t.Fatalf("evaluate %s error: %v", test.expr, err)
+		}
+		if actual != test.expect {
+			t.Errorf("evaluate %s, expect: %s, actual: %s", test.expr, test.expect, actual)
+		}
+	}
+}
+
+func TestEvaluateInt(t *testing.T) {
+	e, err := Parse("t_order_${user_id % 16}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Placeholders() != 1 {
+		t.Errorf("expect 1 placeholder, actual: %d", e.Placeholders())
+	}
+	if !reflect.DeepEqual(e.Variables(), []string{"user_id"}) {
+		t.Errorf("unexpected variables: %v", e.Variables())
+	}
+	v, err := e.EvaluateInt(map[string]interface{}{"user_id": "35"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != 3 {
+		t.Errorf("expect 3, actual: %d", v)
+	}
+	if _, err := e.EvaluateInt(map[string]interface{}{"user_id": "abc"}); err == nil {
+		t.Errorf("expect error for non integer key")
+	}
+	if _, err := e.EvaluateInt(map[string]interface{}{"id": 1}); err == nil {
+		t.Errorf("expect error for undefined variable")
+	}
+}
+
+func TestJavaHashCode(t *testing.T) {
+	if h := javaStringHashCode("hello"); h != 99162322 {
+		t.Errorf("expect 99162322, actual: %d", h)
+	}
+	if h := javaStringHashCode("order_10086"); h != 1910228094 {
+		t.Errorf("expect 1910228094, actual: %d", h)
+	}
+	if h := javaLongHashCode(-1); h != 0 {
+		t.Errorf("expect 0, actual: %d", h)
+	}
+	if h := javaLongHashCode(1 << 32); h != 1 {
+		t.Errorf("expect 1, actual: %d", h)
+	}
+}
+
+func TestParseError(t *testing.T) {
+	tests := []string{
+		"t_${user_id % }",
+		"t_${user_id",
+		"t_${1.5}",
+		"t_${'abc}",
+		"t_${user_id # 2}",
+		"t_${(user_id % 2}",
+		"t_${user_id.}",
+		"t_${[1, 2}",
+		"t_${0..}",
+	}
+	for _, test := range tests {
+		if _, err := Parse(test); err == nil {
+			t.Errorf("expect error for %s", test)
+		}
+	}
+}
+
+func TestExpand(t *testing.T) {
+	tests := []struct {
+		expr   string
+		expect []string
+	}{
+		{"slice-${0..1}.t_order_${0..2}", []string{"slice-0.t_order_0", "slice-0.t_order_1", "slice-0.t_order_2", "slice-1.t_order_0", "slice-1.t_order_1", "slice-1.t_order_2"}},
+		{"t_${['a', 'b']}_$->{2..1}", []string{"t_a_2", "t_a_1", "t_b_2", "t_b_1"}},
+		{"t_${1 + 1..3}", []string{"t_2", "t_3"}},
+		{"t_order", []string{"t_order"}},
+		{"t_${[]}", []string{}},
+	}
+	for _, test := range tests {
+		e, err := Parse(test.expr)
+		if err != nil {
+			t.Fatalf("parse %s error: %v", test.expr, err)
+		}
+		actual, err := e.Expand()
+		if err != nil {
+			t.Fatalf("expand %s error: %v", test.expr, err)
+		}
+		if !reflect.DeepEqual(actual, test.expect) {
+			t.Errorf("expand %s, expect: %v, actual: %v", test.expr, test.expect, actual)
+		}
+	}
+
+	e, err := Parse("t_${user_id..3}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Expand(); err == nil {
+		t.Errorf("expect error for variable in range")
+	}
+	e, err = Parse("t_${0..3}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Evaluate(nil); err == nil {
+		t.Errorf("expect error for evaluating range")
+	}
+}
+
+func TestSplitAndExpand(t *testing.T) {
+	actual, err := SplitAndExpand("slice-0.t_order_${[0, 1]}, slice-1.t_order_${2..3},,slice-1.t_order_x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []string{"slice-0.t_order_0", "slice-0.t_order_1", "slice-1.t_order_2", "slice-1.t_order_3", "slice-1.t_order_x"}
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf("expect: %v, actual: %v", expect, actual)
+	}
+	if _, err := SplitAndExpand("slice-0.t_${0..1"); err == nil {
+		t.Errorf("expect error for unclosed placeholder")
+	}
+	if _, err := SplitAndExpand("t_${0..200000}"); err == nil {
+		t.Errorf("expect error for too large range")
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inline
+
+import (
+	"fmt"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenInt
+	tokenString
+	tokenIdent
+	tokenOperator
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	value int64
+	pos   int
+}
+
+func (t token) is(kind tokenKind, text string) bool {
+	return t.kind == kind && t.text == text
+}
+
+func (t token) String() string {
+	if t.kind == tokenEOF {
+		return "end of expression"
+	}
+	return fmt.Sprintf("'%s'", t.text)
+}
+
+// ".." must be matched before "."
+var operators = []string{"+", "-", "*", "/", "%", "(", ")", "[", "]", ",", "..", "."}
+
+// tokenize split the code inside ${...} into tokens
+func tokenize(code string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(code) {
+		c := code[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case isDigit(c):
+			start := i
+			var v int64
+			for i < len(code) && isDigit(code[i]) {
+				v = v*10 + int64(code[i]-'0')
+				if v < 0 {
+					return nil, fmt.Errorf("integer overflow at position %d", start)
+				}
+				i++
+			}
+			if i+1 < len(code) && code[i] == '.' && isDigit(code[i+1]) {
+				return nil, fmt.Errorf("float literal is not supported at position %d", start)
+			}
+			tokens = append(tokens, token{kind: tokenInt, text: code[start:i], value: v, pos: start})
+		case isIdentStart(c):
+			start := i
+			for i < len(code) && isIdentPart(code[i]) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: code[start:i], pos: start})
+		case c == '\'' || c == '"':
+			start := i
+			quote := c
+			sb := &strings.Builder{}
+			i++
+			closed := false
+			for i < len(code) {
+				if code[i] == '\\' && i+1 < len(code) {
+					sb.WriteByte(code[i+1])
+					i += 2
+					continue
+				}
+				if code[i] == quote {
+					closed = true
+					i++
+					break
+				}
+				sb.WriteByte(code[i])
+				i++
+			}
+			if !closed {
+				return nil, fmt.Errorf("unterminated string literal at position %d", start)
+			}
+			tokens = append(tokens, token{kind: tokenString, text: sb.String(), pos: start})
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(code[i:], op) {
+					tokens = append(tokens, token{kind: tokenOperator, text: op, pos: i})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character '%c' at position %d", c, i)
+			}
+		}
+	}
+	tokens = append(tokens, token{kind: tokenEOF, pos: len(code)})
+	return tokens, nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || isDigit(c)
+}
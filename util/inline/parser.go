@@ -0,0 +1,328 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inline
+
+import (
+	"fmt"
+	"strings"
+)
+
+// node is a node of the syntax tree inside ${...}
+type node interface {
+	eval(vars map[string]interface{}) (interface{}, error)
+	collectVariables(names map[string]bool)
+}
+
+type literalNode struct {
+	value interface{} // int64 or string
+}
+
+type variableNode struct {
+	name string
+}
+
+type unaryNode struct {
+	op      string
+	operand node
+}
+
+type binaryNode struct {
+	op          string
+	left, right node
+}
+
+// rangeNode is an integer range such as 0..15, both bounds are included
+type rangeNode struct {
+	from, to node
+}
+
+// listNode is a list such as ['a', 'b']
+type listNode struct {
+	elements []node
+}
+
+// indexNode is an element of list such as ['a', 'b'][x]
+type indexNode struct {
+	target, index node
+}
+
+// callNode is a function call such as Math.abs(x), or a method call such as x.hashCode()
+type callNode struct {
+	name     string
+	receiver node // nil for function call
+	args     []node
+}
+
+// grammar:
+//
+//	code    := expr ('..' expr)?
+//	expr    := term (('+' | '-') term)*
+//	term    := unary (('*' | '/' | '%') unary)*
+//	unary   := '-' unary | postfix
+//	postfix := primary ('.' ident '(' args ')' | '[' expr ']')*
+//	primary := int | string | ident | class ('.' ident)* '(' args ')' | '(' expr ')' | '[' args ']'
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func parseCode(code string) (node, error) {
+	tokens, err := tokenize(code)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	n, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().is(tokenOperator, "..") {
+		p.next()
+		to, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		n = &rangeNode{from: n, to: to}
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %s at position %d", t, t.pos)
+	}
+	return n, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) expect(text string) error {
+	t := p.next()
+	if !t.is(tokenOperator, text) {
+		return fmt.Errorf("expect '%s' but got %s at position %d", text, t, t.pos)
+	}
+	return nil
+}
+
+func (p *parser) parseExpr() (node, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if !t.is(tokenOperator, "+") && !t.is(tokenOperator, "-") {
+			return left, nil
+		}
+		p.next()
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: t.text, left: left, right: right}
+	}
+}
+
+func (p *parser) parseTerm() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if !t.is(tokenOperator, "*") && !t.is(tokenOperator, "/") && !t.is(tokenOperator, "%") {
+			return left, nil
+		}
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: t.text, left: left, right: right}
+	}
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.peek().is(tokenOperator, "-") {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{op: "-", operand: operand}, nil
+	}
+	return p.parsePostfix()
+}
+
+func (p *parser) parsePostfix() (node, error) {
+	n, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if p.peek().is(tokenOperator, "[") {
+			p.next()
+			index, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			n = &indexNode{target: n, index: index}
+			continue
+		}
+		if !p.peek().is(tokenOperator, ".") {
+			return n, nil
+		}
+		p.next()
+		t := p.next()
+		if t.kind != tokenIdent {
+			return nil, fmt.Errorf("expect method name but got %s at position %d", t, t.pos)
+		}
+		args, err := p.parseArgs()
+		if err != nil {
+			return nil, err
+		}
+		n = &callNode{name: t.text, receiver: n, args: args}
+	}
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenInt:
+		return &literalNode{value: t.value}, nil
+	case tokenString:
+		return &literalNode{value: t.text}, nil
+	case tokenIdent:
+		// qualified function name of a class, such as Math.abs(...)
+		name := t.text
+		save := p.pos
+		for isClassName(t.text) && p.peek().is(tokenOperator, ".") && p.tokens[p.pos+1].kind == tokenIdent {
+			p.pos += 2
+			name += "." + p.tokens[p.pos-1].text
+		}
+		if p.peek().is(tokenOperator, "(") {
+			args, err := p.parseArgs()
+			if err != nil {
+				return nil, err
+			}
+			return &callNode{name: name, args: args}, nil
+		}
+		// not a function call, x.hashCode() is handled as postfix
+		p.pos = save
+		return &variableNode{name: t.text}, nil
+	case tokenOperator:
+		if t.text == "(" {
+			n, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return n, nil
+		}
+		if t.text == "[" {
+			elements, err := p.parseList("]")
+			if err != nil {
+				return nil, err
+			}
+			return &listNode{elements: elements}, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected %s at position %d", t, t.pos)
+}
+
+func isClassName(name string) bool {
+	return name[0] >= 'A' && name[0] <= 'Z'
+}
+
+func (p *parser) parseArgs() ([]node, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	return p.parseList(")")
+}
+
+// parseList parse the comma separated expressions until the end token, the open token is consumed by caller
+func (p *parser) parseList(end string) ([]node, error) {
+	var args []node
+	if p.peek().is(tokenOperator, end) {
+		p.next()
+		return args, nil
+	}
+	for {
+		arg, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		t := p.next()
+		if t.is(tokenOperator, end) {
+			return args, nil
+		}
+		if !t.is(tokenOperator, ",") {
+			return nil, fmt.Errorf("expect ',' or '%s' but got %s at position %d", end, t, t.pos)
+		}
+	}
+}
+
+func (n *literalNode) collectVariables(names map[string]bool) {}
+
+func (n *variableNode) collectVariables(names map[string]bool) {
+	names[strings.ToLower(n.name)] = true
+}
+
+func (n *unaryNode) collectVariables(names map[string]bool) {
+	n.operand.collectVariables(names)
+}
+
+func (n *binaryNode) collectVariables(names map[string]bool) {
+	n.left.collectVariables(names)
+	n.right.collectVariables(names)
+}
+
+func (n *rangeNode) collectVariables(names map[string]bool) {
+	n.from.collectVariables(names)
+	n.to.collectVariables(names)
+}
+
+func (n *listNode) collectVariables(names map[string]bool) {
+	for _, e := range n.elements {
+		e.collectVariables(names)
+	}
+}
+
+func (n *indexNode) collectVariables(names map[string]bool) {
+	n.target.collectVariables(names)
+	n.index.collectVariables(names)
+}
+
+func (n *callNode) collectVariables(names map[string]bool) {
+	if n.receiver != nil {
+		n.receiver.collectVariables(names)
+	}
+	for _, arg := range n.args {
+		arg.collectVariables(names)
+	}
+}
@@ -88,3 +88,23 @@ func rotateLeft(i, distance int32) int32 {
 	b1 := ui >> (32 - udistance)
 	return int32(a1 | b1)
 }
+
+// HashBytes is the same as guava Hashing.murmur3_32(seed).hashBytes(input).asInt()
+func (m *MurmurHash) HashBytes(input []byte) int {
+	h1 := int32(m.seed)
+
+	i := 0
+	for ; i+4 <= len(input); i += 4 {
+		k1 := int32(uint32(input[i]) | uint32(input[i+1])<<8 | uint32(input[i+2])<<16 | uint32(input[i+3])<<24)
+		k1 = mixK1(k1)
+		h1 = mixH1(h1, k1)
+	}
+
+	var k1 int32
+	for shift := uint(0); i < len(input); i, shift = i+1, shift+8 {
+		k1 ^= int32(uint32(input[i]) << shift)
+	}
+	h1 ^= mixK1(k1)
+
+	return int(fmix(h1, int32(len(input))))
+}
@@ -23,6 +23,8 @@ const (
 	StmtType = "stmtType" // SQL类型, 值类型为int (对应parser.Preview()得到的值)
 	// FromSlave if read from slave
 	FromSlave = "fromSlave" // 读写分离标识, 值类型为int, false = 0, true = 1
+	// StreamResult if result can be returned by stream
+	StreamResult = "streamResult" // 结果是否可以逐行返回给客户端, 值类型为int, false = 0, true = 1
 )
 
 // RequestContext means request scope context with values
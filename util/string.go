@@ -14,7 +14,10 @@
 
 package util
 
-import "bytes"
+import (
+	"bytes"
+	"unicode/utf16"
+)
 
 func Concat(strings ...string) string {
 	var buffer bytes.Buffer
@@ -23,3 +26,12 @@ func Concat(strings ...string) string {
 	}
 	return buffer.String()
 }
+
+// JavaStringHashCode is the same as java.lang.String.hashCode()
+func JavaStringHashCode(s string) int32 {
+	var h int32
+	for _, c := range utf16.Encode([]rune(s)) {
+		h = 31*h + int32(c)
+	}
+	return h
+}